@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -10,13 +11,19 @@ import (
 	"syscall"
 
 	"github.com/MicahParks/keyfunc/v2"
+	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/cors"
+	"flipo5/backend/internal/ai"
 	"flipo5/backend/internal/api"
+	"flipo5/backend/internal/billing"
 	"flipo5/backend/internal/cache"
 	"flipo5/backend/internal/config"
 	"flipo5/backend/internal/queue"
+	"flipo5/backend/internal/quota"
+	"flipo5/backend/internal/ratelimit"
 	"flipo5/backend/internal/replicate"
 	"flipo5/backend/internal/stream"
 	"flipo5/backend/internal/storage"
@@ -27,17 +34,30 @@ func main() {
 	_ = godotenv.Load()
 	cfg := config.Load()
 	ctx := context.Background()
+	roles, err := parseRoles(cfg.Roles)
+	if err != nil {
+		log.Fatalf("ROLES: %v", err)
+	}
+	log.Printf("roles: %v", roles)
 
 	db, err := store.NewDB(ctx, cfg.PGURL)
 	if err != nil {
 		log.Fatalf("db: %v", err)
 	}
 	defer db.Close()
-	if err := db.Migrate(ctx); err != nil {
-		log.Printf("migrate FAILED (non-fatal, check DATABASE_URL and schema): %v", err)
-	} else {
-		log.Print("migrate: ok")
+	if cfg.AutoMigrate {
+		applied, err := db.Migrate(ctx)
+		for _, m := range applied {
+			log.Printf("migrate: applied %s", m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+	}
+	if err := db.CheckMigrations(ctx); err != nil {
+		log.Fatalf("migrate: %v (run `go run ./cmd/migrate up`)", err)
 	}
+	log.Print("migrate: ok")
 
 	var redisOpt asynq.RedisConnOpt
 	if parsed, err := asynq.ParseRedisURI(cfg.Redis); err == nil {
@@ -65,10 +85,29 @@ func main() {
 		defer streamSub.Close()
 	}
 
-	repl, _ := replicate.New(cfg.ReplicateToken)
-	if repl == nil {
+	var replProvider ai.Provider
+	if repl, _ := replicate.New(cfg.ReplicateToken); repl != nil {
+		replProvider = repl
+	} else {
 		log.Print("replicate client not configured (set REPLICATE_API_TOKEN)")
 	}
+	oai := ai.NewOpenAI(cfg.OpenAIBaseURL, cfg.OpenAIAPIKey)
+	if oai != nil {
+		log.Printf("ai: OpenAI-compatible backend at %s (models prefixed %q)", cfg.OpenAIBaseURL, ai.OpenAIModelPrefix)
+	}
+	provider := ai.NewRouter(replProvider, oai)
+	for model, name := range cfg.ModelFormats {
+		if _, ok := ai.LookupAdapter(name); !ok {
+			log.Fatalf("MODEL_FORMATS: unknown format %q for %s", name, model)
+		}
+	}
+	embedder, err := ai.NewEmbedder(cfg.EmbeddingModel, cfg.OpenAIBaseURL, cfg.OpenAIAPIKey, cfg.EmbeddingDimensions)
+	if err != nil {
+		log.Fatalf("embeddings: %v", err)
+	}
+	if cfg.ReplicateWebhookURL != "" && cfg.ReplicateWebhookSecret != "" {
+		log.Printf("replicate: media jobs complete via webhook %s (polling reconciler as fallback)", cfg.ReplicateWebhookURL)
+	}
 
 	s3Store, err := storage.NewS3(ctx, storage.S3Config{
 		Endpoint:      cfg.S3Endpoint,
@@ -91,69 +130,150 @@ func main() {
 		defer apiCache.Close()
 		log.Print("cache: Redis enabled for threads/content")
 	}
+	if oai != nil && apiCache != nil {
+		// With ROLES split, cancelling a job in the API has to reach the worker running its generation.
+		oai.SetCancelStore(ai.NewRedisCancels(apiCache.Client()))
+	}
 
-	qHandlers := &queue.Handlers{DB: db, Cfg: cfg, Repl: repl, Store: s3Store, Asynq: asynqClient, Stream: streamPub, Cache: apiCache}
-	mux := asynq.NewServeMux()
-	qHandlers.Register(mux)
-	concurrency := cfg.AsynqConcurrency
-	if concurrency < 1 {
-		concurrency = 4
+	prices, err := billing.Load(cfg.BillingPrices)
+	if err != nil {
+		log.Fatalf("BILLING_PRICES: %v", err)
 	}
-	asynqSrv := asynq.NewServer(redisOpt, asynq.Config{Concurrency: concurrency})
-	log.Printf("asynq worker: concurrency=%d", concurrency)
-	go func() {
-		if err := asynqSrv.Run(mux); err != nil {
-			log.Printf("asynq: %v", err)
-		}
-	}()
-	defer asynqSrv.Shutdown()
-
-	scheduler := asynq.NewScheduler(redisOpt, nil)
-	if task, err := queue.NewCancelStaleJobsTask(); err == nil {
-		if _, err := scheduler.Register("@every 5m", task); err != nil {
-			log.Printf("scheduler: failed to register cancel_stale_jobs: %v", err)
-		} else {
-			log.Print("scheduler: cancel_stale_jobs every 5m")
-		}
+	var credits *billing.Table
+	if cfg.BillingCredits {
+		credits = prices
+		log.Print("billing: credits enforced at job creation")
+	}
+	plans, err := quota.Load(cfg.PlanQuotas)
+	if err != nil {
+		log.Fatalf("PLAN_QUOTAS: %v", err)
+	}
+	limits, err := ratelimit.Load(cfg.RateLimits, cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("RATE_LIMITS: %v", err)
+	}
+	var limiterRedis *redis.Client
+	if apiCache != nil {
+		limiterRedis = apiCache.Client()
 	}
-	go func() {
-		if err := scheduler.Run(); err != nil {
-			log.Printf("scheduler: %v", err)
+	limiter := ratelimit.New(limiterRedis, limits)
+
+	db.OnWebhookDeliveries = func(ids []uuid.UUID) { queue.EnqueueWebhookDeliveries(asynqClient, ids) }
+	if roles["worker"] {
+		qHandlers := &queue.Handlers{DB: db, Cfg: cfg, Repl: provider, Store: s3Store, Asynq: asynqClient, Stream: streamPub, Cache: apiCache, Billing: prices, Credits: credits, Plans: plans, Embedder: embedder}
+		mux := asynq.NewServeMux()
+		qHandlers.Register(mux)
+		concurrency := cfg.AsynqConcurrency
+		if concurrency < 1 {
+			concurrency = 4
 		}
-	}()
-	defer scheduler.Shutdown()
-
-	var jwks *keyfunc.JWKS
-	if cfg.SupabaseURL != "" {
-		jwksURL := cfg.SupabaseURL + "/auth/v1/.well-known/jwks.json"
-		var errJWKS error
-		jwks, errJWKS = keyfunc.Get(jwksURL, keyfunc.Options{})
-		if errJWKS != nil {
-			log.Printf("supabase JWKS: %v (auth will use legacy secret if set)", errJWKS)
-			jwks = nil
+		queues, err := queue.Queues(strings.Split(cfg.AsynqQueues, ","), cfg.AsynqQueueWeights)
+		if err != nil {
+			log.Fatalf("ASYNQ_QUEUES: %v", err)
 		}
+		asynqSrv := asynq.NewServer(redisOpt, asynq.Config{Concurrency: concurrency, Queues: queues, RetryDelayFunc: queue.RetryDelay})
+		log.Printf("asynq worker: concurrency=%d queues=%v", concurrency, queues)
+		go func() {
+			if err := asynqSrv.Run(mux); err != nil {
+				log.Printf("asynq: %v", err)
+			}
+		}()
+		defer asynqSrv.Shutdown()
+	}
+
+	if roles["scheduler"] {
+		scheduler := asynq.NewScheduler(redisOpt, nil)
+		registerPeriodic(scheduler, "@every 5m", "cancel_stale_jobs", queue.NewCancelStaleJobsTask)
+		registerPeriodic(scheduler, "@every 1m", "reconcile_predictions", queue.NewReconcilePredictionsTask)
+		registerPeriodic(scheduler, "@every 1m", "dispatch_webhooks", queue.NewDispatchWebhooksTask)
+		go func() {
+			if err := scheduler.Run(); err != nil {
+				log.Printf("scheduler: %v", err)
+			}
+		}()
+		defer scheduler.Shutdown()
 	}
-	srv := api.NewServer(db, asynqClient, s3Store, streamSub, apiCache, repl, cfg.ModelRemoveBg, cfg.ModelText, cfg.Redis, cfg.SupabaseJWTSecret, jwks, cfg.SupabaseURL, cfg.SupabaseServiceRole)
-	origins := buildCORSOrigins(cfg.CORSOrigins)
-	handler := cors.New(cors.Options{
-		AllowedOrigins:   origins,
-		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "Cache-Control", "Pragma"},
-		AllowCredentials: false,
-	}).Handler(srv.Routes())
-
-	httpSrv := &http.Server{Addr: ":" + cfg.Port, Handler: handler}
-	go func() {
-		log.Printf("api listening on :%s", cfg.Port)
-		if err := httpSrv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Printf("http: %v", err)
+
+	var httpSrv *http.Server
+	if roles["api"] {
+		var jwks *keyfunc.JWKS
+		if cfg.SupabaseURL != "" {
+			jwksURL := cfg.SupabaseURL + "/auth/v1/.well-known/jwks.json"
+			var errJWKS error
+			jwks, errJWKS = keyfunc.Get(jwksURL, keyfunc.Options{})
+			if errJWKS != nil {
+				log.Printf("supabase JWKS: %v (auth will use legacy secret if set)", errJWKS)
+				jwks = nil
+			}
 		}
-	}()
+		origins := buildCORSOrigins(cfg.CORSOrigins)
+		srv := api.NewServer(db, asynqClient, s3Store, streamSub, apiCache, provider, cfg.ModelRemoveBg, cfg.ModelText, cfg.Redis, cfg.SupabaseJWTSecret, jwks, cfg.SupabaseURL, cfg.SupabaseServiceRole, cfg.ReplicateWebhookSecret, credits, plans, cfg.WebhookAllowPrivate, limiter, origins)
+		// Cross-origin scripts only see the response headers in ExposedHeaders (rate limits, Retry-After).
+		handler := cors.New(cors.Options{
+			AllowedOrigins:   origins,
+			AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders:   []string{"Authorization", "Content-Type", "Cache-Control", "Pragma", "Last-Event-ID"},
+			ExposedHeaders:   []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
+			AllowCredentials: false,
+		}).Handler(srv.Routes())
+
+		httpSrv = &http.Server{Addr: ":" + cfg.Port, Handler: handler}
+		go func() {
+			log.Printf("api listening on :%s", cfg.Port)
+			if err := httpSrv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Printf("http: %v", err)
+			}
+		}()
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	_ = httpSrv.Shutdown(ctx)
+	if httpSrv != nil {
+		_ = httpSrv.Shutdown(ctx)
+	}
+}
+
+// processRoles are what one process can run (ROLES).
+var processRoles = []string{"api", "worker", "scheduler"}
+
+// parseRoles parses ROLES: comma-separated roles, empty = all.
+func parseRoles(s string) (map[string]bool, error) {
+	roles := map[string]bool{}
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		known := false
+		for _, p := range processRoles {
+			known = known || r == p
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown role %q (want %s)", r, strings.Join(processRoles, ", "))
+		}
+		roles[r] = true
+	}
+	if len(roles) == 0 {
+		for _, p := range processRoles {
+			roles[p] = true
+		}
+	}
+	return roles, nil
+}
+
+// registerPeriodic schedules a maintenance task. With several processes only one may run the scheduler
+// (ROLES), or every task would be enqueued once per process.
+func registerPeriodic(scheduler *asynq.Scheduler, spec, name string, newTask func() (*asynq.Task, error)) {
+	task, err := newTask()
+	if err != nil {
+		return
+	}
+	if _, err := scheduler.Register(spec, task); err != nil {
+		log.Printf("scheduler: failed to register %s: %v", name, err)
+		return
+	}
+	log.Printf("scheduler: %s %s", name, strings.TrimPrefix(spec, "@"))
 }
 
 // buildCORSOrigins parses CORS_ORIGINS (comma-separated). Empty => ["*"] for allow-all.
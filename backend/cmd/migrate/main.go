@@ -0,0 +1,69 @@
+// Command migrate applies and inspects database migrations (internal/store/migrations).
+//
+//	migrate up      apply pending and failed migrations
+//	migrate status  list migrations and their state; exits 1 when any is not applied
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+	"flipo5/backend/internal/config"
+	"flipo5/backend/internal/store"
+)
+
+func main() {
+	if len(os.Args) != 2 || (os.Args[1] != "up" && os.Args[1] != "status") {
+		fmt.Fprintln(os.Stderr, "usage: migrate up|status")
+		os.Exit(2)
+	}
+	_ = godotenv.Load()
+	cfg := config.Load()
+	ctx := context.Background()
+
+	db, err := store.NewDB(ctx, cfg.PGURL)
+	if err != nil {
+		log.Fatalf("db: %v", err)
+	}
+	defer db.Close()
+
+	switch os.Args[1] {
+	case "up":
+		applied, err := db.Migrate(ctx)
+		for _, m := range applied {
+			log.Printf("applied %s", m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		if len(applied) == 0 {
+			log.Print("nothing to apply")
+		}
+	case "status":
+		list, err := db.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatalf("status: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED AT\tERROR")
+		notApplied := 0
+		for _, m := range list {
+			at := "-"
+			if m.AppliedAt != nil {
+				at = m.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%03d\t%s\t%s\t%s\t%s\n", m.Version, m.Name, m.State, at, m.Error)
+			if m.State != store.MigrationApplied && m.State != store.MigrationMissing {
+				notApplied++
+			}
+		}
+		tw.Flush()
+		if notApplied > 0 {
+			os.Exit(1)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 # Deploy Flipo5 backend (Docker) to Hetzner
 # Rulează din repo root. Pe server: folder creat dacă nu există, git pull, build + up.
-# Migrările DB rulează automat la pornirea containerului (schema.sql + migrations/*.sql).
+# Migrările DB rulează automat la pornirea containerului (migrations/*.sql, fiecare o singură dată).
 # Usage: .\backend\deploy\deploy-hetzner.ps1 -Server "root@YOUR_IP" [-Message "commit msg"]
 # Or: $env:DEPLOY_SERVER="root@YOUR_IP"; $env:DEPLOY_PATH="~/backend/flipo5"; .\backend\deploy\deploy-hetzner.ps1
 
@@ -1,7 +1,7 @@
 #!/bin/bash
 # Deploy Flipo5 backend (Docker) to Hetzner
 # Rulează din repo root. Pe server: folder creat dacă nu există; clone la prima rulare, altfel pull; build + up.
-# Migrările DB rulează automat la pornirea containerului (schema.sql + migrations/*.sql).
+# Migrările DB rulează automat la pornirea containerului (migrations/*.sql, fiecare o singură dată).
 # Usage: ./backend/deploy/deploy-hetzner.sh [user@SERVER_IP] ["commit message"]
 # Or: DEPLOY_SERVER=root@IP DEPLOY_PATH=~/backend/flipo5 ./backend/deploy/deploy-hetzner.sh
 
@@ -0,0 +1,30 @@
+package ai
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const cancelKeyPrefix = "oai:cancel:"
+
+var _ CancelStore = (*RedisCancels)(nil)
+
+// RedisCancels is the CancelStore of processes that share a Redis. A cancellation is a key that expires
+// once the generation would have hit localPredictionMax anyway.
+type RedisCancels struct {
+	rdb *redis.Client
+}
+
+func NewRedisCancels(rdb *redis.Client) *RedisCancels {
+	return &RedisCancels{rdb: rdb}
+}
+
+func (r *RedisCancels) Cancel(ctx context.Context, id string) error {
+	return r.rdb.Set(ctx, cancelKeyPrefix+id, "1", localPredictionMax).Err()
+}
+
+func (r *RedisCancels) Cancelled(ctx context.Context, id string) (bool, error) {
+	n, err := r.rdb.Exists(ctx, cancelKeyPrefix+id).Result()
+	return n > 0, err
+}
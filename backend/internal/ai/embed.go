@@ -0,0 +1,211 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Embedder turns texts into vectors for retrieval. Vectors of one Model are comparable with each other only,
+// so stored vectors are tagged with Model() and re-indexed when the embedder changes.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Model() string
+	Dimensions() int
+}
+
+var _ Embedder = (*LocalEmbedder)(nil)
+var _ Embedder = (*OpenAIEmbedder)(nil)
+
+// DefaultLocalDimensions is the vector size of the local embedder when none is configured.
+const DefaultLocalDimensions = 512
+
+// NewEmbedder builds the embedder named by spec: "local" (default) or "openai:<model>", served by the
+// OpenAI-compatible backend at baseURL. dims 0 means the provider's default size.
+func NewEmbedder(spec, baseURL, apiKey string, dims int) (Embedder, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "" || spec == "local":
+		return NewLocalEmbedder(dims), nil
+	case IsOpenAIModel(spec):
+		e := NewOpenAIEmbedder(baseURL, apiKey, strings.TrimPrefix(spec, OpenAIModelPrefix), dims)
+		if e == nil {
+			return nil, fmt.Errorf("embedding model %q: %w (set OPENAI_BASE_URL)", spec, ErrNotConfigured)
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown embedding model %q (use \"local\" or \"openai:<model>\")", spec)
+}
+
+// LocalEmbedder is a deterministic, dependency-free embedder: words and their character trigrams are hashed
+// into a fixed number of signed buckets (feature hashing) and the vector is L2-normalized. It finds chunks
+// that share vocabulary with the question, not paraphrases, and is what tests and offline setups use.
+type LocalEmbedder struct {
+	dims int
+}
+
+func NewLocalEmbedder(dims int) *LocalEmbedder {
+	if dims <= 0 {
+		dims = DefaultLocalDimensions
+	}
+	return &LocalEmbedder{dims: dims}
+}
+
+func (e *LocalEmbedder) Model() string   { return fmt.Sprintf("local-hash-%d", e.dims) }
+func (e *LocalEmbedder) Dimensions() int { return e.dims }
+
+func (e *LocalEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		out[i] = e.vector(t)
+	}
+	return out, nil
+}
+
+func (e *LocalEmbedder) vector(text string) []float32 {
+	counts := map[string]float32{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(w)) < 2 || stopWords[w] {
+			continue
+		}
+		counts["w:"+w]++
+		r := []rune("<" + w + ">")
+		for j := 0; j+3 <= len(r); j++ {
+			counts["t:"+string(r[j:j+3])]++
+		}
+	}
+	features := make([]string, 0, len(counts))
+	for f := range counts {
+		features = append(features, f)
+	}
+	sort.Strings(features) // fixed summation order, so equal texts give bit-identical vectors
+	v := make([]float32, e.dims)
+	for _, feature := range features {
+		n := counts[feature]
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		weight := float32(1 + math.Log(float64(n)+1))
+		if strings.HasPrefix(feature, "t:") {
+			weight *= 0.25
+		}
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		v[sum%uint64(e.dims)] += weight
+	}
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm > 0 {
+		inv := float32(1 / math.Sqrt(norm))
+		for i := range v {
+			v[i] *= inv
+		}
+	}
+	return v
+}
+
+// stopWords are frequent English words that carry no topic; other languages rely on the trigrams.
+var stopWords = map[string]bool{
+	"the": true, "and": true, "or": true, "of": true, "to": true, "in": true, "on": true, "at": true, "is": true,
+	"are": true, "was": true, "were": true, "be": true, "it": true, "its": true, "this": true, "that": true,
+	"for": true, "with": true, "as": true, "by": true, "an": true, "from": true, "what": true, "which": true,
+	"who": true, "how": true, "do": true, "does": true, "did": true, "can": true, "me": true, "my": true,
+}
+
+// OpenAIEmbedder calls an OpenAI-compatible /embeddings endpoint (OpenAI, Ollama, vLLM, llama.cpp server).
+type OpenAIEmbedder struct {
+	baseURL string
+	apiKey  string
+	model   string
+	dims    int
+	hc      *http.Client
+}
+
+// NewOpenAIEmbedder returns nil when baseURL is empty. dims > 0 is sent as "dimensions" (models that
+// support shortening); 0 uses the model's own size.
+func NewOpenAIEmbedder(baseURL, apiKey, model string, dims int) *OpenAIEmbedder {
+	baseURL = strings.TrimSuffix(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		return nil
+	}
+	return &OpenAIEmbedder{baseURL: baseURL, apiKey: apiKey, model: model, dims: dims, hc: &http.Client{}}
+}
+
+func (e *OpenAIEmbedder) Model() string {
+	if e.dims > 0 {
+		return fmt.Sprintf("%s%s-%d", OpenAIModelPrefix, e.model, e.dims)
+	}
+	return OpenAIModelPrefix + e.model
+}
+
+func (e *OpenAIEmbedder) Dimensions() int { return e.dims }
+
+type embeddingRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(embeddingRequest{Model: e.model, Input: texts, Dimensions: e.dims})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/embeddings", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+	resp, err := e.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		return nil, fmt.Errorf("embeddings: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var out embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("embeddings: %w", err)
+	}
+	vecs := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(vecs) {
+			return nil, fmt.Errorf("embeddings: index %d out of range", d.Index)
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	for i, v := range vecs {
+		if len(v) == 0 {
+			return nil, fmt.Errorf("embeddings: no vector for input %d", i)
+		}
+	}
+	return vecs, nil
+}
@@ -0,0 +1,83 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func dot(a, b []float32) float32 {
+	var s float32
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+func TestLocalEmbedder(t *testing.T) {
+	e := NewLocalEmbedder(0)
+	if e.Dimensions() != DefaultLocalDimensions || e.Model() != "local-hash-512" {
+		t.Fatalf("unexpected defaults %d %s", e.Dimensions(), e.Model())
+	}
+	vecs, err := e.Embed(context.Background(), []string{
+		"What was the invoice total in March?",
+		"Invoice for March: total amount due 1,200 EUR.",
+		"The cat sat on the garden wall in the sun.",
+		"What was the invoice total in March?",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := dot(vecs[0], vecs[0]); n < 0.999 || n > 1.001 {
+		t.Fatalf("vector not normalized: %f", n)
+	}
+	for i := range vecs[0] {
+		if vecs[0][i] != vecs[3][i] {
+			t.Fatal("same text embedded differently")
+		}
+	}
+	if related, unrelated := dot(vecs[0], vecs[1]), dot(vecs[0], vecs[2]); related <= unrelated {
+		t.Fatalf("related %f should score above unrelated %f", related, unrelated)
+	}
+	empty, _ := e.Embed(context.Background(), []string{""})
+	if dot(empty[0], empty[0]) != 0 {
+		t.Fatal("empty text should embed to the zero vector")
+	}
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	var got embeddingRequest
+	srv := newTestOpenAI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		// Out of order on purpose: vectors are matched by index.
+		fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`)
+	})
+	e, err := NewEmbedder("openai:nomic-embed-text", srv.baseURL, "k", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Model() != "openai:nomic-embed-text-2" {
+		t.Fatalf("model %s", e.Model())
+	}
+	vecs, err := e.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Model != "nomic-embed-text" || got.Dimensions != 2 || len(got.Input) != 2 {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	if vecs[0][0] != 1 || vecs[1][1] != 1 {
+		t.Fatalf("vectors out of order: %v", vecs)
+	}
+	if _, err := NewEmbedder("openai:x", "", "", 0); err == nil {
+		t.Fatal("openai embedder without base URL should fail")
+	}
+	if _, err := NewEmbedder("bogus", "", "", 0); err == nil {
+		t.Fatal("unknown spec should fail")
+	}
+}
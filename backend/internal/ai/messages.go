@@ -0,0 +1,202 @@
+package ai
+
+import (
+	"strings"
+
+	repgo "github.com/replicate/replicate-go"
+)
+
+// Chat roles.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one turn of a chat conversation as handlers build it. Images are URLs attached to the turn.
+// An Adapter renders a conversation into the input of a particular model.
+type Message struct {
+	Role    string
+	Content string
+	Images  []string
+}
+
+// Adapter describes how a model family takes a conversation:
+//   - Messages: an OpenAI-style "messages" array (roles kept, images as content parts);
+//   - Template: the whole conversation rendered with the model's chat template into "prompt";
+//   - otherwise the system text goes to the System field (or the top of the prompt when empty) and the
+//     exchanges are written into "prompt" as "User: ... Assistant: ..." lines.
+//
+// MaxTokens and Images name the model's output limit and image inputs.
+type Adapter struct {
+	Name      string
+	Messages  bool
+	Template  string // "llama3" or "chatml"
+	System    string // e.g. "system_prompt", "system_instruction"
+	MaxTokens string // e.g. "max_tokens", "max_output_tokens"
+	Images    string // "images" (list) or "image" (first image only)
+	// Extra inputs sent as is, e.g. an identity prompt_template so Replicate does not wrap a templated prompt again.
+	Extra map[string]interface{}
+}
+
+var adapters = map[string]Adapter{
+	"messages":      {Name: "messages", Messages: true, MaxTokens: "max_tokens"},
+	"gemini":        {Name: "gemini", System: "system_instruction", MaxTokens: "max_output_tokens", Images: "images"},
+	"claude":        {Name: "claude", System: "system_prompt", MaxTokens: "max_tokens", Images: "image"},
+	"system_prompt": {Name: "system_prompt", System: "system_prompt", MaxTokens: "max_tokens", Images: "images"},
+	"llama3": {Name: "llama3", Template: "llama3", MaxTokens: "max_tokens", Images: "images",
+		Extra: map[string]interface{}{"prompt_template": "{prompt}"}},
+	"chatml": {Name: "chatml", Template: "chatml", MaxTokens: "max_tokens", Images: "images"},
+	"plain":  {Name: "plain", MaxTokens: "max_output_tokens", Images: "images"},
+}
+
+// modelFamilies picks the adapter by model identifier prefix when MODEL_FORMATS has no entry; the first match wins.
+var modelFamilies = []struct{ prefix, adapter string }{
+	{OpenAIModelPrefix, "messages"},
+	{"google/gemini", "gemini"},
+	{"anthropic/claude", "claude"},
+	{"meta/meta-llama-3", "llama3"},
+	{"meta/llama-4", "system_prompt"},
+	{"openai/", "system_prompt"},
+	{"deepseek-ai/", "system_prompt"},
+}
+
+// LookupAdapter returns the adapter with the given name ("messages", "gemini", "claude", "system_prompt",
+// "llama3", "chatml", "plain").
+func LookupAdapter(name string) (Adapter, bool) {
+	a, ok := adapters[name]
+	return a, ok
+}
+
+// AdapterFor returns the adapter for model: the overrides entry (model identifier, or model without
+// ":version"), else the model family, else "plain" (everything in one prompt, which every text model accepts).
+func AdapterFor(model string, overrides map[string]string) Adapter {
+	base, _, _ := strings.Cut(strings.TrimPrefix(model, OpenAIModelPrefix), ":")
+	for _, key := range []string{model, base} {
+		if a, ok := adapters[overrides[key]]; ok {
+			return a
+		}
+	}
+	for _, f := range modelFamilies {
+		if strings.HasPrefix(model, f.prefix) {
+			return adapters[f.adapter]
+		}
+	}
+	return adapters["plain"]
+}
+
+// Render builds the model input for a conversation. All system messages are merged into one, in order;
+// the last message is normally the user's question. maxTokens 0 leaves the model's default limit.
+func (a Adapter) Render(msgs []Message, maxTokens int) repgo.PredictionInput {
+	var system []string
+	var turns []Message
+	var images []string
+	for _, m := range msgs {
+		if m.Role == RoleSystem {
+			if s := strings.TrimSpace(m.Content); s != "" {
+				system = append(system, s)
+			}
+			continue
+		}
+		turns = append(turns, m)
+		images = append(images, m.Images...)
+	}
+	sys := strings.Join(system, "\n\n")
+
+	input := repgo.PredictionInput{}
+	for k, v := range a.Extra {
+		input[k] = v
+	}
+	if maxTokens > 0 && a.MaxTokens != "" {
+		input[a.MaxTokens] = maxTokens
+	}
+	switch {
+	case a.Messages:
+		input["messages"] = openAIMessages(sys, turns)
+		return input
+	case a.Template != "":
+		input["prompt"] = renderTemplate(a.Template, sys, turns)
+	case a.System != "":
+		if sys != "" {
+			input[a.System] = sys
+		}
+		input["prompt"] = transcript(turns)
+	default:
+		prompt := transcript(turns)
+		if len(turns) == 1 {
+			prompt = "User: " + prompt // same shape as with history, for models that only see this one string
+		}
+		if sys != "" {
+			prompt = sys + "\n\n" + prompt
+		}
+		input["prompt"] = prompt
+	}
+	if len(images) > 0 {
+		if a.Images == "image" {
+			input["image"] = images[0]
+		} else {
+			input["images"] = images
+		}
+	}
+	return input
+}
+
+// transcript writes the exchanges as labelled paragraphs; a lone user message is returned as is.
+func transcript(turns []Message) string {
+	if len(turns) == 1 && turns[0].Role == RoleUser {
+		return turns[0].Content
+	}
+	parts := make([]string, 0, len(turns))
+	for _, m := range turns {
+		label := "User: "
+		if m.Role == RoleAssistant {
+			label = "Assistant: "
+		}
+		parts = append(parts, label+m.Content)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func renderTemplate(name, system string, turns []Message) string {
+	var b strings.Builder
+	switch name {
+	case "chatml":
+		if system != "" {
+			b.WriteString("<|im_start|>system\n" + system + "<|im_end|>\n")
+		}
+		for _, m := range turns {
+			b.WriteString("<|im_start|>" + m.Role + "\n" + m.Content + "<|im_end|>\n")
+		}
+		b.WriteString("<|im_start|>assistant\n")
+	default: // llama3
+		b.WriteString("<|begin_of_text|>")
+		if system != "" {
+			b.WriteString("<|start_header_id|>system<|end_header_id|>\n\n" + system + "<|eot_id|>")
+		}
+		for _, m := range turns {
+			b.WriteString("<|start_header_id|>" + m.Role + "<|end_header_id|>\n\n" + m.Content + "<|eot_id|>")
+		}
+		b.WriteString("<|start_header_id|>assistant<|end_header_id|>\n\n")
+	}
+	return b.String()
+}
+
+// openAIMessages is the chat completions "messages" array; images become image_url content parts.
+func openAIMessages(system string, turns []Message) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(turns)+1)
+	if system != "" {
+		out = append(out, map[string]interface{}{"role": RoleSystem, "content": system})
+	}
+	for _, m := range turns {
+		if len(m.Images) == 0 {
+			out = append(out, map[string]interface{}{"role": m.Role, "content": m.Content})
+			continue
+		}
+		parts := []map[string]interface{}{{"type": "text", "text": m.Content}}
+		for _, u := range m.Images {
+			parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": map[string]string{"url": u}})
+		}
+		out = append(out, map[string]interface{}{"role": m.Role, "content": parts})
+	}
+	return out
+}
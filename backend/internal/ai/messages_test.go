@@ -0,0 +1,86 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+)
+
+var testConversation = []Message{
+	{Role: RoleSystem, Content: "Be brief."},
+	{Role: RoleSystem, Content: "Summary: they talked about cats."},
+	{Role: RoleUser, Content: "hi"},
+	{Role: RoleAssistant, Content: "hello"},
+	{Role: RoleUser, Content: "what is this?", Images: []string{"https://x/cat.png"}},
+}
+
+func TestAdapterFor(t *testing.T) {
+	cases := map[string]string{
+		"openai:llama3.1":                "messages",
+		"google/gemini-2.5-flash":        "gemini",
+		"anthropic/claude-4-sonnet":      "claude",
+		"meta/meta-llama-3-70b-instruct": "llama3",
+		"someone/custom-model:abc123":    "chatml", // override on the name without version
+		"google/gemini-2.5-pro":          "system_prompt",
+	}
+	overrides := map[string]string{"someone/custom-model": "chatml", "google/gemini-2.5-pro": "system_prompt", "x": "bogus"}
+	for model, want := range cases {
+		if got := AdapterFor(model, overrides).Name; got != want {
+			t.Errorf("%s: got %s want %s", model, got, want)
+		}
+	}
+	if got := AdapterFor("someone/custom-model", nil).Name; got != "plain" {
+		t.Errorf("unknown model: got %s", got)
+	}
+	if got := AdapterFor("x", overrides).Name; got != "plain" {
+		t.Errorf("unknown override should be ignored, got %s", got)
+	}
+}
+
+func TestAdapterRender(t *testing.T) {
+	a, _ := LookupAdapter("gemini")
+	in := a.Render(testConversation, 100)
+	if in["system_instruction"] != "Be brief.\n\nSummary: they talked about cats." || in["max_output_tokens"] != 100 {
+		t.Fatalf("gemini input %v", in)
+	}
+	if in["prompt"] != "User: hi\n\nAssistant: hello\n\nUser: what is this?" {
+		t.Fatalf("gemini prompt %q", in["prompt"])
+	}
+	if imgs, _ := in["images"].([]string); len(imgs) != 1 {
+		t.Fatalf("images %v", in["images"])
+	}
+
+	a, _ = LookupAdapter("claude")
+	in = a.Render(testConversation[4:], 0)
+	if in["prompt"] != "what is this?" || in["image"] != "https://x/cat.png" || in["max_tokens"] != nil {
+		t.Fatalf("claude input %v", in)
+	}
+
+	a, _ = LookupAdapter("messages")
+	in = a.Render(testConversation, 50)
+	msgs, _ := in["messages"].([]map[string]interface{})
+	if len(msgs) != 4 || msgs[0]["role"] != RoleSystem || msgs[2]["role"] != RoleAssistant || in["prompt"] != nil {
+		t.Fatalf("messages %v", msgs)
+	}
+	if parts, ok := msgs[3]["content"].([]map[string]interface{}); !ok || len(parts) != 2 {
+		t.Fatalf("image should be a content part: %v", msgs[3]["content"])
+	}
+	req := buildChatRequest("m", in, false)
+	if len(req.Messages) != 4 || req.MaxTokens != 50 {
+		t.Fatalf("openai request %+v", req)
+	}
+
+	a, _ = LookupAdapter("llama3")
+	in = a.Render(testConversation, 0)
+	p, _ := in["prompt"].(string)
+	if !strings.HasPrefix(p, "<|begin_of_text|><|start_header_id|>system<|end_header_id|>\n\nBe brief.") ||
+		!strings.HasSuffix(p, "what is this?<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n") ||
+		in["prompt_template"] != "{prompt}" {
+		t.Fatalf("llama3 input %v", in)
+	}
+
+	a, _ = LookupAdapter("plain")
+	in = a.Render([]Message{{Role: RoleSystem, Content: "sys"}, {Role: RoleUser, Content: "hi"}}, 10)
+	if in["prompt"] != "sys\n\nUser: hi" {
+		t.Fatalf("plain prompt %q", in["prompt"])
+	}
+}
@@ -0,0 +1,446 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	repgo "github.com/replicate/replicate-go"
+)
+
+const (
+	localPredictionPrefix = "oai-"
+	localStreamScheme     = "oai://"
+	localPredictionTTL    = 10 * time.Minute // keep finished predictions around for GetPrediction after the stream
+	localPredictionMax    = 10 * time.Minute // hard cap per generation, same order as the worker task timeout
+	localCancelPoll       = time.Second      // how often a generation checks the CancelStore
+	maxScanTokenSize      = 4 * 1024 * 1024
+)
+
+// OpenAI talks to any OpenAI-compatible /chat/completions endpoint (llama.cpp server, vLLM, Ollama).
+// Those servers have no prediction API, so streamed generations are tracked in-process and exposed
+// through the same create/get/cancel/stream calls as Replicate. IDs are only valid in this process, except
+// for CancelPrediction when a CancelStore is set.
+type OpenAI struct {
+	baseURL string
+	apiKey  string
+	hc      *http.Client
+	cancels CancelStore
+
+	mu    sync.Mutex
+	preds map[string]*localPrediction
+}
+
+// CancelStore records cancelled local predictions so another process can stop them: with ROLES split the API
+// cancels a job whose generation runs in a worker. Generations poll it while they run.
+type CancelStore interface {
+	Cancel(ctx context.Context, id string) error
+	Cancelled(ctx context.Context, id string) (bool, error)
+}
+
+// NewOpenAI returns nil when baseURL is empty (backend disabled). baseURL includes the version, e.g. http://localhost:11434/v1
+func NewOpenAI(baseURL, apiKey string) *OpenAI {
+	baseURL = strings.TrimSuffix(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		return nil
+	}
+	return &OpenAI{baseURL: baseURL, apiKey: apiKey, hc: &http.Client{}, preds: make(map[string]*localPrediction)}
+}
+
+// SetCancelStore shares cancellations with other processes. Call it before the first prediction.
+func (c *OpenAI) SetCancelStore(cs CancelStore) {
+	c.cancels = cs
+}
+
+type chatMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type chatRequest struct {
+	Model         string                 `json:"model"`
+	Messages      []chatMessage          `json:"messages"`
+	MaxTokens     int                    `json:"max_tokens,omitempty"`
+	Temperature   *float64               `json:"temperature,omitempty"`
+	Stream        bool                   `json:"stream,omitempty"`
+	StreamOptions map[string]interface{} `json:"stream_options,omitempty"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage"`
+}
+
+// buildChatRequest maps the Replicate-style input used by our handlers (prompt, system_prompt,
+// images, max_tokens / max_output_tokens, temperature) onto a chat completion request. A "messages"
+// input (Adapter "messages") is sent as is.
+func buildChatRequest(model string, input repgo.PredictionInput, stream bool) chatRequest {
+	req := chatRequest{Model: model, Stream: stream}
+	if msgs := inputMessages(input["messages"]); len(msgs) > 0 {
+		req.Messages = msgs
+	} else if s, _ := input["system_prompt"].(string); strings.TrimSpace(s) != "" {
+		req.Messages = append(req.Messages, chatMessage{Role: "system", Content: s})
+	}
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role == "system" {
+		prompt, _ := input["prompt"].(string)
+		images := inputStrings(input["images"])
+		if len(images) == 0 {
+			if s, _ := input["image"].(string); s != "" {
+				images = []string{s}
+			}
+		}
+		if len(images) > 0 {
+			parts := []map[string]interface{}{{"type": "text", "text": prompt}}
+			for _, u := range images {
+				parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": map[string]string{"url": u}})
+			}
+			req.Messages = append(req.Messages, chatMessage{Role: "user", Content: parts})
+		} else {
+			req.Messages = append(req.Messages, chatMessage{Role: "user", Content: prompt})
+		}
+	}
+	if n := inputInt(input["max_tokens"]); n > 0 {
+		req.MaxTokens = n
+	} else if n := inputInt(input["max_output_tokens"]); n > 0 {
+		req.MaxTokens = n
+	}
+	if t, ok := input["temperature"].(float64); ok {
+		req.Temperature = &t
+	}
+	if stream {
+		req.StreamOptions = map[string]interface{}{"include_usage": true}
+	}
+	return req
+}
+
+// inputMessages reads a "messages" input, as built by Adapter.Render or decoded from JSON.
+func inputMessages(v interface{}) []chatMessage {
+	var list []map[string]interface{}
+	switch x := v.(type) {
+	case []map[string]interface{}:
+		list = x
+	case []interface{}:
+		for _, e := range x {
+			if m, ok := e.(map[string]interface{}); ok {
+				list = append(list, m)
+			}
+		}
+	}
+	out := make([]chatMessage, 0, len(list))
+	for _, m := range list {
+		if role, _ := m["role"].(string); role != "" {
+			out = append(out, chatMessage{Role: role, Content: m["content"]})
+		}
+	}
+	return out
+}
+
+func inputStrings(v interface{}) []string {
+	switch x := v.(type) {
+	case []string:
+		return x
+	case []interface{}:
+		out := make([]string, 0, len(x))
+		for _, e := range x {
+			if s, ok := e.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func inputInt(v interface{}) int {
+	switch x := v.(type) {
+	case int:
+		return x
+	case int64:
+		return int(x)
+	case float64:
+		return int(x)
+	}
+	return 0
+}
+
+func (c *OpenAI) post(ctx context.Context, body chatRequest) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if body.Stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("openai: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// Run performs a blocking chat completion and returns the text (handlers normalize string output).
+func (c *OpenAI) Run(ctx context.Context, model string, input repgo.PredictionInput) (repgo.PredictionOutput, error) {
+	resp, err := c.post(ctx, buildChatRequest(model, input, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Choices) == 0 {
+		return nil, fmt.Errorf("openai: empty response")
+	}
+	return out.Choices[0].Message.Content, nil
+}
+
+// localPrediction is an in-flight streamed completion. notify is closed and replaced on every change.
+type localPrediction struct {
+	mu     sync.Mutex
+	pred   repgo.Prediction
+	chunks []string
+	done   bool
+	notify chan struct{}
+	cancel context.CancelFunc
+}
+
+func (lp *localPrediction) update(fn func()) {
+	lp.mu.Lock()
+	fn()
+	close(lp.notify)
+	lp.notify = make(chan struct{})
+	lp.mu.Unlock()
+}
+
+// CreatePredictionWithStream starts a streamed completion in the background and returns immediately,
+// like Replicate. The generation is not bound to ctx; use CancelPrediction to stop it.
+func (c *OpenAI) CreatePredictionWithStream(ctx context.Context, model string, input repgo.PredictionInput) (*repgo.Prediction, error) {
+	id := localPredictionPrefix + uuid.New().String()
+	genCtx, cancel := context.WithTimeout(context.Background(), localPredictionMax)
+	lp := &localPrediction{
+		pred: repgo.Prediction{
+			ID:        id,
+			Status:    repgo.Starting,
+			Model:     model,
+			Input:     input,
+			URLs:      map[string]string{"stream": localStreamScheme + id},
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+		notify: make(chan struct{}),
+		cancel: cancel,
+	}
+	c.mu.Lock()
+	c.preds[id] = lp
+	c.mu.Unlock()
+
+	go c.generate(genCtx, lp, buildChatRequest(model, input, true))
+	if c.cancels != nil {
+		go c.watchCancel(genCtx, lp, id)
+	}
+	snapshot := lp.snapshot()
+	return &snapshot, nil
+}
+
+// CreatePrediction is CreatePredictionWithStream without a consumer. Webhooks are not supported:
+// local predictions only live in this process, so callers poll GetPrediction instead.
+func (c *OpenAI) CreatePrediction(ctx context.Context, model string, input repgo.PredictionInput, webhook *repgo.Webhook) (*repgo.Prediction, error) {
+	return c.CreatePredictionWithStream(ctx, model, input)
+}
+
+func (c *OpenAI) generate(ctx context.Context, lp *localPrediction, body chatRequest) {
+	defer lp.cancel()
+	now := time.Now().UTC().Format(time.RFC3339)
+	lp.update(func() {
+		if lp.pred.Status == repgo.Starting {
+			lp.pred.Status = repgo.Processing
+		}
+		lp.pred.StartedAt = &now
+	})
+	var acc strings.Builder
+	var usage *chatUsage
+	err := func() error {
+		resp, err := c.post(ctx, body)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxScanTokenSize)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "[DONE]" {
+				return nil
+			}
+			var chunk chatResponse
+			if json.Unmarshal([]byte(data), &chunk) != nil {
+				continue
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+				continue
+			}
+			text := chunk.Choices[0].Delta.Content
+			acc.WriteString(text)
+			lp.update(func() { lp.chunks = append(lp.chunks, text) })
+		}
+		return scanner.Err()
+	}()
+	finished := time.Now().UTC().Format(time.RFC3339)
+	lp.update(func() {
+		lp.done = true
+		lp.pred.CompletedAt = &finished
+		if lp.pred.Status == repgo.Canceled {
+			return
+		}
+		if err != nil {
+			lp.pred.Status = repgo.Failed
+			lp.pred.Error = err.Error()
+			return
+		}
+		lp.pred.Status = repgo.Succeeded
+		lp.pred.Output = acc.String()
+		if usage != nil {
+			in, out := usage.PromptTokens, usage.CompletionTokens
+			lp.pred.Metrics = &repgo.PredictionMetrics{InputTokenCount: &in, OutputTokenCount: &out}
+		}
+	})
+	time.AfterFunc(localPredictionTTL, func() {
+		c.mu.Lock()
+		delete(c.preds, lp.pred.ID)
+		c.mu.Unlock()
+	})
+}
+
+func (lp *localPrediction) snapshot() repgo.Prediction {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	return lp.pred
+}
+
+func (c *OpenAI) lookup(id string) *localPrediction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.preds[id]
+}
+
+// watchCancel stops the generation when another process cancels it through the CancelStore.
+func (c *OpenAI) watchCancel(ctx context.Context, lp *localPrediction, id string) {
+	t := time.NewTicker(localCancelPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if cancelled, _ := c.cancels.Cancelled(ctx, id); cancelled {
+			lp.stop()
+			return
+		}
+	}
+}
+
+func (c *OpenAI) GetPrediction(ctx context.Context, id string) (*repgo.Prediction, error) {
+	lp := c.lookup(id)
+	if lp == nil {
+		return nil, fmt.Errorf("openai: prediction %s not found", id)
+	}
+	p := lp.snapshot()
+	return &p, nil
+}
+
+// CancelPrediction stops a generation of this process, or hands the cancellation to the process running it
+// through the CancelStore. Without a store an unknown ID is an error.
+func (c *OpenAI) CancelPrediction(ctx context.Context, id string) error {
+	if lp := c.lookup(id); lp != nil {
+		lp.stop()
+		return nil
+	}
+	if c.cancels == nil {
+		return fmt.Errorf("openai: prediction %s not found", id)
+	}
+	return c.cancels.Cancel(ctx, id)
+}
+
+// stop marks the prediction canceled unless it already ended and stops its request.
+func (lp *localPrediction) stop() {
+	lp.update(func() {
+		if !lp.done {
+			lp.pred.Status = repgo.Canceled
+		}
+	})
+	lp.cancel()
+}
+
+// StreamOutput replays chunks of a local prediction from the start, then follows it until done.
+func (c *OpenAI) StreamOutput(ctx context.Context, streamURL string, onOutput func(text string), onDone func()) error {
+	lp := c.lookup(strings.TrimPrefix(streamURL, localStreamScheme))
+	if lp == nil {
+		return fmt.Errorf("openai: unknown stream %s", streamURL)
+	}
+	next := 0
+	for {
+		lp.mu.Lock()
+		pending := lp.chunks[next:]
+		next = len(lp.chunks)
+		done := lp.done
+		notify := lp.notify
+		lp.mu.Unlock()
+		for _, text := range pending {
+			if onOutput != nil {
+				onOutput(text)
+			}
+		}
+		if done {
+			if onDone != nil {
+				onDone()
+			}
+			return nil
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	repgo "github.com/replicate/replicate-go"
+)
+
+func newTestOpenAI(t *testing.T, handler http.HandlerFunc) *OpenAI {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewOpenAI(srv.URL+"/v1", "test-key")
+}
+
+func TestOpenAIRun(t *testing.T) {
+	var got chatRequest
+	c := newTestOpenAI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("missing bearer token")
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"hello"}}]}`)
+	})
+	out, err := c.Run(context.Background(), "llama", repgo.PredictionInput{"system_prompt": "be brief", "prompt": "hi", "max_tokens": 10})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if out != "hello" {
+		t.Fatalf("unexpected output %v", out)
+	}
+	if got.Model != "llama" || len(got.Messages) != 2 || got.Messages[0].Role != "system" || got.MaxTokens != 10 {
+		t.Fatalf("unexpected request %+v", got)
+	}
+}
+
+func TestOpenAIStreamedPrediction(t *testing.T) {
+	c := newTestOpenAI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, part := range []string{"Hel", "lo"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", part)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+	router := NewRouter(nil, c)
+	pred, err := router.CreatePredictionWithStream(context.Background(), "openai:llama", repgo.PredictionInput{"prompt": "hi"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if !strings.HasPrefix(pred.ID, localPredictionPrefix) || pred.URLs["stream"] == "" {
+		t.Fatalf("unexpected prediction %+v", pred)
+	}
+	var acc strings.Builder
+	done := false
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := router.StreamOutput(ctx, pred.URLs["stream"], func(s string) { acc.WriteString(s) }, func() { done = true }); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if acc.String() != "Hello" || !done {
+		t.Fatalf("stream got %q done=%v", acc.String(), done)
+	}
+	final, err := router.GetPrediction(ctx, pred.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if final.Status != repgo.Succeeded || final.Output != "Hello" {
+		t.Fatalf("unexpected final prediction %+v", final)
+	}
+	if final.Metrics == nil || *final.Metrics.OutputTokenCount != 2 {
+		t.Fatalf("expected usage metrics")
+	}
+}
+
+func TestRouterWithoutReplicate(t *testing.T) {
+	router := NewRouter(nil, NewOpenAI("http://localhost:1/v1", ""))
+	if _, err := router.Run(context.Background(), "meta/llama", repgo.PredictionInput{}); err != ErrNotConfigured {
+		t.Fatalf("expected ErrNotConfigured, got %v", err)
+	}
+	if NewRouter(nil, nil) != nil {
+		t.Fatalf("expected nil router when nothing configured")
+	}
+}
+
+// memCancels is a CancelStore shared by two OpenAI clients, like the API and a worker sharing Redis.
+type memCancels struct{ ids sync.Map }
+
+func (m *memCancels) Cancel(ctx context.Context, id string) error {
+	m.ids.Store(id, true)
+	return nil
+}
+
+func (m *memCancels) Cancelled(ctx context.Context, id string) (bool, error) {
+	_, ok := m.ids.Load(id)
+	return ok, nil
+}
+
+func TestOpenAICancelFromOtherProcess(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}
+	worker, api := newTestOpenAI(t, handler), newTestOpenAI(t, handler)
+	if err := api.CancelPrediction(context.Background(), localPredictionPrefix+"unknown"); err == nil {
+		t.Fatal("cancelling an unknown prediction without a store succeeded")
+	}
+	cancels := &memCancels{}
+	worker.SetCancelStore(cancels)
+	api.SetCancelStore(cancels)
+
+	pred, err := worker.CreatePredictionWithStream(context.Background(), "llama", repgo.PredictionInput{"prompt": "hi"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := api.CancelPrediction(context.Background(), pred.ID); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := worker.StreamOutput(ctx, pred.URLs["stream"], nil, nil); err != nil {
+		t.Fatalf("generation not stopped: %v", err)
+	}
+	if final, _ := worker.GetPrediction(ctx, pred.ID); final.Status != repgo.Canceled {
+		t.Fatalf("status %s, want canceled", final.Status)
+	}
+}
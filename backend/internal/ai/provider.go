@@ -0,0 +1,131 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"flipo5/backend/internal/replicate"
+
+	repgo "github.com/replicate/replicate-go"
+)
+
+// Provider is what workers and API handlers need from a model backend.
+// Prediction types are Replicate's so handlers stay backend-agnostic without a second set of structs.
+type Provider interface {
+	Run(ctx context.Context, model string, input repgo.PredictionInput) (repgo.PredictionOutput, error)
+	CreatePredictionWithStream(ctx context.Context, model string, input repgo.PredictionInput) (*repgo.Prediction, error)
+	// CreatePrediction starts a prediction without waiting; webhook (may be nil) is notified when it completes.
+	CreatePrediction(ctx context.Context, model string, input repgo.PredictionInput, webhook *repgo.Webhook) (*repgo.Prediction, error)
+	GetPrediction(ctx context.Context, id string) (*repgo.Prediction, error)
+	CancelPrediction(ctx context.Context, id string) error
+	StreamOutput(ctx context.Context, streamURL string, onOutput func(text string), onDone func()) error
+}
+
+var _ Provider = (*replicate.Client)(nil)
+var _ Provider = (*OpenAI)(nil)
+
+// OpenAIModelPrefix marks a model identifier served by the OpenAI-compatible backend,
+// e.g. "openai:llama-3.1-8b-instruct". Replicate identifiers are always "owner/name[:version]".
+const OpenAIModelPrefix = "openai:"
+
+var ErrNotConfigured = errors.New("AI provider not configured")
+
+// IsOpenAIModel reports whether model should be routed to the OpenAI-compatible backend.
+func IsOpenAIModel(model string) bool {
+	return strings.HasPrefix(model, OpenAIModelPrefix)
+}
+
+// Router dispatches by model identifier: "openai:<name>" goes to OpenAI, everything else to Replicate.
+// Prediction IDs and stream URLs carry their own prefix so Get/Cancel/Stream find the right backend.
+type Router struct {
+	Replicate Provider
+	OpenAI    *OpenAI
+}
+
+// NewRouter returns nil when neither backend is configured so callers can keep their nil checks.
+func NewRouter(repl Provider, oai *OpenAI) Provider {
+	if repl == nil && oai == nil {
+		return nil
+	}
+	return &Router{Replicate: repl, OpenAI: oai}
+}
+
+func (r *Router) pick(model string) (Provider, string, error) {
+	if IsOpenAIModel(model) {
+		if r.OpenAI == nil {
+			return nil, "", errors.New("OPENAI_BASE_URL not set for model " + model)
+		}
+		return r.OpenAI, strings.TrimPrefix(model, OpenAIModelPrefix), nil
+	}
+	if r.Replicate == nil {
+		return nil, "", ErrNotConfigured
+	}
+	return r.Replicate, model, nil
+}
+
+func (r *Router) byID(id string) (Provider, error) {
+	if strings.HasPrefix(id, localPredictionPrefix) {
+		if r.OpenAI == nil {
+			return nil, ErrNotConfigured
+		}
+		return r.OpenAI, nil
+	}
+	if r.Replicate == nil {
+		return nil, ErrNotConfigured
+	}
+	return r.Replicate, nil
+}
+
+func (r *Router) Run(ctx context.Context, model string, input repgo.PredictionInput) (repgo.PredictionOutput, error) {
+	p, name, err := r.pick(model)
+	if err != nil {
+		return nil, err
+	}
+	return p.Run(ctx, name, input)
+}
+
+func (r *Router) CreatePredictionWithStream(ctx context.Context, model string, input repgo.PredictionInput) (*repgo.Prediction, error) {
+	p, name, err := r.pick(model)
+	if err != nil {
+		return nil, err
+	}
+	return p.CreatePredictionWithStream(ctx, name, input)
+}
+
+func (r *Router) CreatePrediction(ctx context.Context, model string, input repgo.PredictionInput, webhook *repgo.Webhook) (*repgo.Prediction, error) {
+	p, name, err := r.pick(model)
+	if err != nil {
+		return nil, err
+	}
+	return p.CreatePrediction(ctx, name, input, webhook)
+}
+
+func (r *Router) GetPrediction(ctx context.Context, id string) (*repgo.Prediction, error) {
+	p, err := r.byID(id)
+	if err != nil {
+		return nil, err
+	}
+	return p.GetPrediction(ctx, id)
+}
+
+func (r *Router) CancelPrediction(ctx context.Context, id string) error {
+	p, err := r.byID(id)
+	if err != nil {
+		return err
+	}
+	return p.CancelPrediction(ctx, id)
+}
+
+func (r *Router) StreamOutput(ctx context.Context, streamURL string, onOutput func(text string), onDone func()) error {
+	if strings.HasPrefix(streamURL, localStreamScheme) {
+		if r.OpenAI == nil {
+			return ErrNotConfigured
+		}
+		return r.OpenAI.StreamOutput(ctx, streamURL, onOutput, onDone)
+	}
+	if r.Replicate == nil {
+		return ErrNotConfigured
+	}
+	return r.Replicate.StreamOutput(ctx, streamURL, onOutput, onDone)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/quota"
+	"flipo5/backend/internal/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// jobRefusal is why a job was not created; /api and /v1 render it in their own error shapes.
+type jobRefusal struct {
+	Status     int
+	Code       string // v1 error code
+	Message    string
+	Details    map[string]interface{}
+	RetryAfter int // seconds; 0 = no Retry-After header
+}
+
+// newJob creates a job for the create handlers after checking the plan quota, holding its credit
+// reservation when credits are enforced.
+func (s *Server) newJob(ctx context.Context, userID uuid.UUID, jobType string, input interface{}, threadID *uuid.UUID) (uuid.UUID, *jobRefusal) {
+	return s.reserveJob(ctx, userID, jobType, input, func(reserve int) (uuid.UUID, error) {
+		return s.DB.CreateJobReserved(ctx, userID, jobType, input, threadID, reserve)
+	})
+}
+
+// reserveJob checks the plan quota and runs create with the credit reservation for jobType.
+func (s *Server) reserveJob(ctx context.Context, userID uuid.UUID, jobType string, input interface{}, create func(reserve int) (uuid.UUID, error)) (uuid.UUID, *jobRefusal) {
+	if ref := s.quotaRefusal(ctx, userID, jobType, input); ref != nil {
+		return uuid.Nil, ref
+	}
+	reserve := s.Credits.ReserveFor(jobType)
+	jobID, err := create(reserve)
+	if errors.Is(err, store.ErrInsufficientCredits) {
+		balance, _ := s.DB.GetCreditBalance(ctx, userID)
+		return uuid.Nil, &jobRefusal{
+			Status: http.StatusPaymentRequired, Code: "insufficient_credits", Message: "insufficient credits",
+			Details: map[string]interface{}{"required_cents": reserve, "balance_cents": balance},
+		}
+	}
+	if err != nil {
+		return uuid.Nil, &jobRefusal{Status: http.StatusInternalServerError, Code: "internal", Message: "create job"}
+	}
+	return jobID, nil
+}
+
+// createJob is newJob for the /api handlers: on refusal the response is already written
+// (429/402 for quotas, 402 with the required amount when the balance is too low).
+func (s *Server) createJob(ctx context.Context, w http.ResponseWriter, userID uuid.UUID, jobType string, input interface{}, threadID *uuid.UUID) (uuid.UUID, bool) {
+	jobID, ref := s.newJob(ctx, userID, jobType, input, threadID)
+	if ref != nil {
+		writeJobRefusal(w, ref)
+		return uuid.Nil, false
+	}
+	return jobID, true
+}
+
+// createAlternativeJob is createJob for a regenerated or edited message: the job is a sibling of the
+// given one in its thread and starts the thread's active branch.
+func (s *Server) createAlternativeJob(ctx context.Context, w http.ResponseWriter, userID uuid.UUID, input interface{}, sibling *store.Job) (uuid.UUID, bool) {
+	jobID, ref := s.reserveJob(ctx, userID, sibling.Type, input, func(reserve int) (uuid.UUID, error) {
+		return s.DB.CreateAlternativeJobReserved(ctx, userID, sibling.Type, input, sibling, reserve)
+	})
+	if ref != nil {
+		writeJobRefusal(w, ref)
+		return uuid.Nil, false
+	}
+	return jobID, true
+}
+
+func writeJobRefusal(w http.ResponseWriter, ref *jobRefusal) {
+	out := map[string]interface{}{"error": ref.Message}
+	for k, v := range ref.Details {
+		out[k] = v
+	}
+	if ref.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(ref.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ref.Status)
+	json.NewEncoder(w).Encode(out)
+}
+
+// getCredits returns the balance and the latest ledger entries (reserve/release/charge/refund/grant).
+func (s *Server) getCredits(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	balance, err := s.DB.GetCreditBalance(r.Context(), userID)
+	if err != nil {
+		http.Error(w, `{"error":"credits failed"}`, http.StatusInternalServerError)
+		return
+	}
+	ledger, _ := s.DB.ListLedger(r.Context(), userID, 50)
+	if ledger == nil {
+		ledger = []store.LedgerEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"balance_cents": balance,
+		"enforced":      s.Credits != nil,
+		"ledger":        ledger,
+	})
+}
+
+// adminSetPlan sets a user's plan: body {"plan": "pro"}. The plan must be defined (PLAN_QUOTAS or the
+// defaults); signup names ("premium", "creator") resolve to theirs.
+func (s *Server) adminSetPlan(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Plan string `json:"plan"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid body"}`, http.StatusBadRequest)
+		return
+	}
+	plan := quota.PlanName(req.Plan)
+	if _, ok := s.Plans[plan]; !ok {
+		http.Error(w, `{"error":"unknown plan"}`, http.StatusBadRequest)
+		return
+	}
+	found, err := s.DB.SetUserPlan(r.Context(), id, plan)
+	if err != nil {
+		http.Error(w, `{"error":"update failed"}`, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"plan": plan})
+}
+
+// adminGrantCredits adds (or with a negative amount, removes) credits: body {"amount_cents": 500}.
+func (s *Server) adminGrantCredits(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		AmountCents int `json:"amount_cents"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AmountCents == 0 {
+		http.Error(w, `{"error":"amount_cents required"}`, http.StatusBadRequest)
+		return
+	}
+	balance, err := s.DB.GrantCredits(r.Context(), id, req.AmountCents)
+	if err != nil {
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"balance_cents": balance})
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/queue"
+	"flipo5/backend/internal/store"
+)
+
+// Branching threads: regenerating or editing a chat message adds a sibling job (same parent) and makes its
+// branch the thread's active one. getThread returns the active branch with sibling counts; the branch
+// endpoint switches to another sibling.
+
+// threadChatJob loads a chat job of the user that belongs to a thread, writing the error response when it
+// cannot be branched from.
+func (s *Server) threadChatJob(w http.ResponseWriter, r *http.Request) (*store.Job, uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return nil, uuid.Nil, false
+	}
+	userID, _ := middleware.UserID(r.Context())
+	job, err := s.DB.GetJobForUser(r.Context(), id, userID)
+	if err != nil || job == nil {
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+		return nil, uuid.Nil, false
+	}
+	if job.Type != "chat" || job.ThreadID == nil {
+		http.Error(w, `{"error":"only chat messages in a thread can be regenerated or edited"}`, http.StatusBadRequest)
+		return nil, uuid.Nil, false
+	}
+	return job, userID, true
+}
+
+// regenerateJob asks the same question again as an alternative answer.
+func (s *Server) regenerateJob(w http.ResponseWriter, r *http.Request) {
+	job, userID, ok := s.threadChatJob(w, r)
+	if !ok {
+		return
+	}
+	var input map[string]interface{}
+	if err := json.Unmarshal(job.Input, &input); err != nil || input == nil {
+		http.Error(w, `{"error":"invalid job input"}`, http.StatusBadRequest)
+		return
+	}
+	s.startAlternative(w, r, userID, job, input)
+}
+
+// editJob asks an edited question in place of a message: body {"prompt": "...", "attachment_urls": [...],
+// "attachment_content_types": [...]}. Without attachment_urls the original attachments are kept.
+func (s *Server) editJob(w http.ResponseWriter, r *http.Request) {
+	job, userID, ok := s.threadChatJob(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Prompt                 string   `json:"prompt"`
+		AttachmentURLs         []string `json:"attachment_urls"`
+		AttachmentContentTypes []string `json:"attachment_content_types"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Prompt) == "" {
+		http.Error(w, `{"error":"prompt required"}`, http.StatusBadRequest)
+		return
+	}
+	var old map[string]interface{}
+	_ = json.Unmarshal(job.Input, &old)
+	input := map[string]interface{}{"prompt": req.Prompt}
+	if req.AttachmentURLs != nil {
+		if len(req.AttachmentURLs) > 0 {
+			input["attachment_urls"] = req.AttachmentURLs
+			if len(req.AttachmentContentTypes) > 0 {
+				input["attachment_content_types"] = req.AttachmentContentTypes
+			}
+		}
+	} else {
+		for _, k := range []string{"attachment_urls", "attachment_content_types"} {
+			if v, ok := old[k]; ok {
+				input[k] = v
+			}
+		}
+	}
+	s.startAlternative(w, r, userID, job, input)
+}
+
+func (s *Server) startAlternative(w http.ResponseWriter, r *http.Request, userID uuid.UUID, sibling *store.Job, input map[string]interface{}) {
+	ctx := r.Context()
+	setKeyScopes(ctx, input)
+	jobID, ok := s.createAlternativeJob(ctx, w, userID, input, sibling)
+	if !ok {
+		return
+	}
+	s.recordUserProfile(userID, "chat", nil)
+	prompt, _ := input["prompt"].(string)
+	task, _ := queue.NewChatTask(jobID, prompt)
+	if _, err := s.enqueue(ctx, task); err != nil {
+		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
+		return
+	}
+	s.invalidateThreadCache(ctx, *sibling.ThreadID, userID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"job_id": jobID.String(), "thread_id": sibling.ThreadID.String()})
+}
+
+// selectThreadBranch switches the thread to the branch through a job: body {"job_id": "..."}. The branch is
+// followed down to its newest message; responds like getThread.
+func (s *Server) selectThreadBranch(w http.ResponseWriter, r *http.Request) {
+	threadID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		JobID string `json:"job_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid body"}`, http.StatusBadRequest)
+		return
+	}
+	jobID, err := uuid.Parse(req.JobID)
+	if err != nil {
+		http.Error(w, `{"error":"job_id required"}`, http.StatusBadRequest)
+		return
+	}
+	userID, _ := middleware.UserID(r.Context())
+	ctx := r.Context()
+	if _, err := s.DB.SelectThreadBranch(ctx, threadID, userID, jobID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error":"select branch"}`, http.StatusInternalServerError)
+		return
+	}
+	s.invalidateThreadCache(ctx, threadID, userID)
+	s.getThread(w, r)
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/queue"
 	"flipo5/backend/internal/store"
 
 	"github.com/go-chi/chi/v5"
@@ -217,6 +218,12 @@ func (s *Server) addChatProjectFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"add file failed"}`, http.StatusInternalServerError)
 		return
 	}
+	if !strings.HasPrefix(f.ContentType, "image/") {
+		// Read and index documents now so the first message in the project does not wait for it.
+		if task, err := queue.NewExtractFileTask(userID, *f); err == nil {
+			_, _ = s.enqueue(r.Context(), task)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{"file": f})
@@ -0,0 +1,764 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"flipo5/backend/internal/events"
+	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/stream"
+)
+
+// WebSocket gateway (GET /api/ws): one connection carries the events of every job and thread the client
+// subscribed to, instead of an SSE stream per job. The connections of a process share one Redis subscription
+// holding only the channels they need: stream:{job} of subscribed jobs and of the active jobs of subscribed
+// threads, and user:{id}:jobs of users with thread or all_jobs subscriptions. The loop reading it never
+// waits: a job whose thread is unknown is looked up by a separate worker.
+
+const (
+	wsSendBuffer     = 256              // events queued per connection; a client that falls this far behind is dropped
+	wsPingInterval   = 25 * time.Second // keeps proxies from closing idle connections
+	wsReadTimeout    = 2*wsPingInterval + 10*time.Second
+	wsWriteTimeout   = 10 * time.Second
+	wsMaxMessage     = 64 << 10
+	wsMaxSubs        = 200   // jobs + threads per connection
+	wsThreadCacheMax = 10000 // job → thread entries kept, least recently used first out
+	wsLookupQueue    = 256   // thread lookups waiting for the worker; more are dropped
+)
+
+// wsEvent is a message to the client. Types: status (job state; the first one after subscribing to a job also
+// has its output so far), delta (new text of a running job; replace = the text is the whole output),
+// progress, output (a media job's output moved to our storage), title (a thread's new title), error (a
+// request that failed; job_id/thread_id say which), subscribed, unsubscribed and pong.
+type wsEvent struct {
+	Type     string          `json:"type"`
+	JobID    *uuid.UUID      `json:"job_id,omitempty"`
+	ThreadID *uuid.UUID      `json:"thread_id,omitempty"`
+	JobType  string          `json:"job_type,omitempty"`
+	Status   string          `json:"status,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	ID       string          `json:"id,omitempty"` // stream event ID, as in the job's SSE stream
+	Text     string          `json:"text,omitempty"`
+	Title    string          `json:"title,omitempty"`
+	Replace  bool            `json:"replace,omitempty"`
+	Output   json.RawMessage `json:"output,omitempty"`
+	Progress json.RawMessage `json:"progress,omitempty"`
+	Jobs     []uuid.UUID     `json:"jobs,omitempty"`
+	Threads  []uuid.UUID     `json:"threads,omitempty"`
+	AllJobs  bool            `json:"all_jobs,omitempty"`
+
+	snapshot bool // status read from the DB on subscribe: always sent
+}
+
+// terminal reports whether ev is the final status of its job.
+func (ev wsEvent) terminal() bool {
+	return ev.Type == "status" && (events.JobStatus{Status: ev.Status}).Terminal()
+}
+
+// wsRequest is a message from the client: subscribe, unsubscribe or ping. all_jobs is the status of every
+// job of the user (what GET /api/jobs/stream sends).
+type wsRequest struct {
+	Type    string   `json:"type"`
+	Jobs    []string `json:"jobs"`
+	Threads []string `json:"threads"`
+	AllJobs bool     `json:"all_jobs"`
+}
+
+type wsClient struct {
+	userID uuid.UUID
+	send   chan wsEvent
+	done   chan struct{}
+	once   sync.Once
+	code   int
+	reason string
+
+	// subscriptions, guarded by wsGateway.mu
+	jobs    map[uuid.UUID]bool
+	threads map[uuid.UUID]bool
+	allJobs bool
+}
+
+func newWSClient(userID uuid.UUID) *wsClient {
+	return &wsClient{
+		userID: userID, send: make(chan wsEvent, wsSendBuffer), done: make(chan struct{}),
+		jobs: map[uuid.UUID]bool{}, threads: map[uuid.UUID]bool{},
+	}
+}
+
+// stop ends the connection with a close code; the writer sends it.
+func (c *wsClient) stop(code int, reason string) {
+	c.once.Do(func() {
+		c.code, c.reason = code, reason
+		close(c.done)
+	})
+}
+
+// deliver queues ev without blocking. A full queue means the client does not keep up: it is disconnected
+// (1013, try again later) and resubscribes, rather than holding up every other connection.
+func (c *wsClient) deliver(ev wsEvent) {
+	select {
+	case c.send <- ev:
+	default:
+		c.stop(websocket.CloseTryAgainLater, "slow consumer")
+	}
+}
+
+// wsSubscription is what one subscribe request adds, after checking that everything is the user's.
+type wsSubscription struct {
+	jobs       []uuid.UUID
+	jobThreads []uuid.UUID // thread of each job, uuid.Nil when it has none
+	threads    []uuid.UUID
+	active     map[uuid.UUID][]uuid.UUID // pending and running jobs of each thread
+	allJobs    bool
+}
+
+// wsChannels is the Redis subscription (*redis.PubSub) the gateway adds channels to and removes them from.
+type wsChannels interface {
+	Subscribe(ctx context.Context, channels ...string) error
+	Unsubscribe(ctx context.Context, channels ...string) error
+}
+
+// wsLookup is an event of a job whose thread is not known yet, held until the lookup worker finds it.
+type wsLookup struct {
+	jobID uuid.UUID
+	ev    wsEvent
+}
+
+type wsGateway struct {
+	sub *stream.Subscriber
+	// threadOf returns the thread of a job (uuid.Nil when it has none); only the lookup worker calls it.
+	threadOf func(ctx context.Context, jobID uuid.UUID) (uuid.UUID, error)
+	start    sync.Once
+	lookups  chan wsLookup
+	resync   chan struct{}
+
+	mu          sync.Mutex
+	jobs        map[uuid.UUID]map[*wsClient]bool
+	threads     map[uuid.UUID]map[*wsClient]bool
+	users       map[uuid.UUID]map[*wsClient]bool // all_jobs subscribers by user
+	userThreads map[uuid.UUID]int                // thread subscriptions by user
+	attached    map[uuid.UUID]uuid.UUID          // active jobs of subscribed threads → their thread
+	threadJobs  map[uuid.UUID]map[uuid.UUID]bool // subscribed thread → its attached jobs
+	channels    map[string]int                   // Redis channels the connections need, by reference count
+	jobThread   *wsThreadCache
+
+	syncMu     sync.Mutex // serializes syncChannels
+	ps         wsChannels
+	subscribed map[string]bool // channels ps is subscribed to, guarded by syncMu
+}
+
+func newWSGateway(sub *stream.Subscriber, threadOf func(ctx context.Context, jobID uuid.UUID) (uuid.UUID, error)) *wsGateway {
+	return &wsGateway{
+		sub: sub, threadOf: threadOf,
+		lookups: make(chan wsLookup, wsLookupQueue), resync: make(chan struct{}, 1),
+		jobs: map[uuid.UUID]map[*wsClient]bool{}, threads: map[uuid.UUID]map[*wsClient]bool{},
+		users: map[uuid.UUID]map[*wsClient]bool{}, userThreads: map[uuid.UUID]int{},
+		attached: map[uuid.UUID]uuid.UUID{}, threadJobs: map[uuid.UUID]map[uuid.UUID]bool{},
+		channels: map[string]int{}, jobThread: newWSThreadCache(wsThreadCacheMax),
+		subscribed: map[string]bool{},
+	}
+}
+
+// run opens the Redis subscription and starts the reader, the channel sync and the lookup worker. The
+// subscription starts empty; syncChannels adds what the connections need. go-redis reconnects it.
+func (g *wsGateway) run() {
+	ps := g.sub.Subscribe(context.Background())
+	if ps == nil {
+		return
+	}
+	g.syncMu.Lock()
+	g.ps = ps
+	g.syncMu.Unlock()
+	go func() {
+		for range g.resync {
+			g.syncChannels(context.Background())
+		}
+	}()
+	go func() {
+		for l := range g.lookups {
+			g.resolve(l)
+		}
+	}()
+	go func() {
+		for msg := range ps.Channel() {
+			g.dispatch(msg.Channel, msg.Payload)
+		}
+	}()
+}
+
+// syncChannels subscribes the Redis connection to the channels the connections need and drops the others.
+// Failed changes stay pending until the next sync.
+func (g *wsGateway) syncChannels(ctx context.Context) {
+	g.syncMu.Lock()
+	defer g.syncMu.Unlock()
+	if g.ps == nil {
+		return
+	}
+	var add, drop []string
+	g.mu.Lock()
+	for ch := range g.channels {
+		if !g.subscribed[ch] {
+			add = append(add, ch)
+		}
+	}
+	for ch := range g.subscribed {
+		if g.channels[ch] == 0 {
+			drop = append(drop, ch)
+		}
+	}
+	g.mu.Unlock()
+	if len(add) > 0 {
+		if err := g.ps.Subscribe(ctx, add...); err != nil {
+			log.Printf("[ws] subscribe: %v", err)
+		} else {
+			for _, ch := range add {
+				g.subscribed[ch] = true
+			}
+		}
+	}
+	if len(drop) > 0 {
+		if err := g.ps.Unsubscribe(ctx, drop...); err != nil {
+			log.Printf("[ws] unsubscribe: %v", err)
+		} else {
+			for _, ch := range drop {
+				delete(g.subscribed, ch)
+			}
+		}
+	}
+}
+
+// requestSync asks the sync goroutine to apply channel changes; several requests collapse into one.
+func (g *wsGateway) requestSync() {
+	select {
+	case g.resync <- struct{}{}:
+	default:
+	}
+}
+
+// acquire and release count the users of a Redis channel; called with mu held.
+func (g *wsGateway) acquire(ch string) {
+	g.channels[ch]++
+	if g.channels[ch] == 1 {
+		g.requestSync()
+	}
+}
+
+func (g *wsGateway) release(ch string) {
+	if g.channels[ch]--; g.channels[ch] <= 0 {
+		delete(g.channels, ch)
+		g.requestSync()
+	}
+}
+
+// attach follows an active job of a subscribed thread (its deltas come only on the job's channel); detach
+// stops. Called with mu held.
+func (g *wsGateway) attach(jobID, threadID uuid.UUID) {
+	if _, ok := g.attached[jobID]; ok {
+		return
+	}
+	g.attached[jobID] = threadID
+	if g.threadJobs[threadID] == nil {
+		g.threadJobs[threadID] = map[uuid.UUID]bool{}
+	}
+	g.threadJobs[threadID][jobID] = true
+	g.acquire(stream.JobChannel(jobID))
+}
+
+func (g *wsGateway) detach(jobID uuid.UUID) {
+	threadID, ok := g.attached[jobID]
+	if !ok {
+		return
+	}
+	delete(g.attached, jobID)
+	delete(g.threadJobs[threadID], jobID)
+	if len(g.threadJobs[threadID]) == 0 {
+		delete(g.threadJobs, threadID)
+	}
+	g.release(stream.JobChannel(jobID))
+}
+
+func (g *wsGateway) subscribe(c *wsClient, s wsSubscription) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, id := range s.jobs {
+		g.jobThread.put(id, s.jobThreads[i])
+		if c.jobs[id] {
+			continue
+		}
+		c.jobs[id] = true
+		addClient(g.jobs, id, c)
+		g.acquire(stream.JobChannel(id))
+	}
+	for _, id := range s.threads {
+		for _, job := range s.active[id] {
+			g.jobThread.put(job, id)
+			g.attach(job, id)
+		}
+		if c.threads[id] {
+			continue
+		}
+		c.threads[id] = true
+		addClient(g.threads, id, c)
+		g.userThreads[c.userID]++
+		g.acquire(stream.UserChannel(c.userID))
+	}
+	if s.allJobs && !c.allJobs {
+		c.allJobs = true
+		addClient(g.users, c.userID, c)
+		g.acquire(stream.UserChannel(c.userID))
+	}
+}
+
+func (g *wsGateway) unsubscribe(c *wsClient, jobs, threads []uuid.UUID, allJobs bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, id := range jobs {
+		if !c.jobs[id] {
+			continue
+		}
+		delete(c.jobs, id)
+		removeClient(g.jobs, id, c)
+		g.release(stream.JobChannel(id))
+	}
+	for _, id := range threads {
+		if !c.threads[id] {
+			continue
+		}
+		delete(c.threads, id)
+		removeClient(g.threads, id, c)
+		if len(g.threads[id]) == 0 {
+			for job := range g.threadJobs[id] {
+				g.detach(job)
+			}
+		}
+		if g.userThreads[c.userID]--; g.userThreads[c.userID] <= 0 {
+			delete(g.userThreads, c.userID)
+		}
+		g.release(stream.UserChannel(c.userID))
+	}
+	if allJobs && c.allJobs {
+		c.allJobs = false
+		removeClient(g.users, c.userID, c)
+		g.release(stream.UserChannel(c.userID))
+	}
+}
+
+// remove drops every subscription of a closed connection.
+func (g *wsGateway) remove(c *wsClient) {
+	g.mu.Lock()
+	jobs, threads := mapKeys(c.jobs), mapKeys(c.threads)
+	g.mu.Unlock()
+	g.unsubscribe(c, jobs, threads, true)
+}
+
+func (g *wsGateway) subscriptions(c *wsClient) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(c.jobs) + len(c.threads)
+}
+
+func addClient(m map[uuid.UUID]map[*wsClient]bool, id uuid.UUID, c *wsClient) {
+	if m[id] == nil {
+		m[id] = map[*wsClient]bool{}
+	}
+	m[id][c] = true
+}
+
+func removeClient(m map[uuid.UUID]map[*wsClient]bool, id uuid.UUID, c *wsClient) {
+	delete(m[id], c)
+	if len(m[id]) == 0 {
+		delete(m, id)
+	}
+}
+
+func mapKeys(m map[uuid.UUID]bool) []uuid.UUID {
+	out := make([]uuid.UUID, 0, len(m))
+	for id := range m {
+		out = append(out, id)
+	}
+	return out
+}
+
+// dispatch turns a Redis message into an event and hands it to the subscribed connections: user:{id}:jobs
+// carries status changes, mirrored outputs and thread titles, stream:{jobID} the job's events. It runs on
+// the subscription's reader and never blocks.
+func (g *wsGateway) dispatch(channel, payload string) {
+	ev, err := events.Decode(payload)
+	if err != nil {
+		return
+	}
+	p, _ := ev.Payload()
+	var userID *uuid.UUID
+	if strings.HasPrefix(channel, "user:") {
+		id, err := uuid.Parse(strings.TrimSuffix(strings.TrimPrefix(channel, "user:"), ":jobs"))
+		if err != nil {
+			return
+		}
+		userID = &id
+	}
+	switch p := p.(type) {
+	case events.JobStatus:
+		g.route(p.JobID, userID, wsEvent{Type: "status", JobID: &p.JobID, ThreadID: p.ThreadID, JobType: p.JobType, Status: p.Status, Error: p.Error})
+	case events.JobDelta:
+		g.route(p.JobID, userID, wsEvent{Type: "delta", JobID: &p.JobID, ID: ev.ID, Text: p.Text, Replace: p.Replace})
+	case events.JobProgress:
+		g.route(p.JobID, userID, wsEvent{Type: "progress", JobID: &p.JobID, Progress: ev.Data})
+	case events.JobOutputMirrored:
+		g.route(p.JobID, userID, wsEvent{Type: "output", JobID: &p.JobID, Output: p.Output})
+	case events.ThreadTitleUpdated:
+		g.routeThread(p.ThreadID, userID, wsEvent{Type: "title", ThreadID: &p.ThreadID, Title: p.Title})
+	}
+}
+
+// route delivers ev to the job's subscribers, the subscribers of its thread and, for user channel events,
+// the user's all_jobs subscribers. The thread comes from the event, the jobs followed for thread
+// subscribers or the cache; when none knows it and the user has thread subscriptions, the lookup worker
+// finds it and delivers to the thread's subscribers later (or not at all when its queue is full).
+func (g *wsGateway) route(jobID uuid.UUID, userID *uuid.UUID, ev wsEvent) {
+	g.mu.Lock()
+	thread, known := g.attached[jobID]
+	if !known && ev.ThreadID != nil {
+		thread, known = *ev.ThreadID, true
+		g.jobThread.put(jobID, thread)
+	}
+	if !known {
+		thread, known = g.jobThread.get(jobID)
+	}
+	if !known && userID != nil && g.userThreads[*userID] > 0 {
+		select {
+		case g.lookups <- wsLookup{jobID: jobID, ev: ev}:
+		default:
+		}
+	}
+	if known && thread != uuid.Nil {
+		ev.ThreadID = &thread
+	} else {
+		ev.ThreadID = nil
+	}
+
+	targets := map[*wsClient]bool{}
+	for c := range g.jobs[jobID] {
+		targets[c] = true
+	}
+	if ev.ThreadID != nil {
+		g.followThreadJob(jobID, thread, ev, targets)
+	}
+	if userID != nil {
+		for c := range g.users[*userID] {
+			targets[c] = true
+		}
+	}
+	g.mu.Unlock()
+	for c := range targets {
+		c.deliver(ev)
+	}
+}
+
+// followThreadJob adds the thread's subscribers to targets and, while the job is active, follows its
+// channel for them. Called with mu held.
+func (g *wsGateway) followThreadJob(jobID, thread uuid.UUID, ev wsEvent, targets map[*wsClient]bool) {
+	for c := range g.threads[thread] {
+		targets[c] = true
+	}
+	switch {
+	case ev.terminal():
+		g.detach(jobID)
+	case len(g.threads[thread]) > 0:
+		g.attach(jobID, thread)
+	}
+}
+
+// resolve is the lookup worker's step: it finds the job's thread, caches it, and delivers the held event to
+// the thread's subscribers.
+func (g *wsGateway) resolve(l wsLookup) {
+	g.mu.Lock()
+	thread, known := g.jobThread.get(l.jobID)
+	g.mu.Unlock()
+	if !known {
+		if g.threadOf == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		t, err := g.threadOf(ctx, l.jobID)
+		cancel()
+		if err != nil {
+			return
+		}
+		thread = t
+	}
+	g.mu.Lock()
+	g.jobThread.put(l.jobID, thread)
+	if thread == uuid.Nil {
+		g.mu.Unlock()
+		return
+	}
+	ev := l.ev
+	ev.ThreadID = &thread
+	targets := map[*wsClient]bool{}
+	g.followThreadJob(l.jobID, thread, ev, targets)
+	g.mu.Unlock()
+	for c := range targets {
+		c.deliver(ev)
+	}
+}
+
+// routeThread delivers a thread event to the thread's subscribers and the user's all_jobs subscribers.
+func (g *wsGateway) routeThread(threadID uuid.UUID, userID *uuid.UUID, ev wsEvent) {
+	g.mu.Lock()
+	targets := map[*wsClient]bool{}
+	for c := range g.threads[threadID] {
+		targets[c] = true
+	}
+	if userID != nil {
+		for c := range g.users[*userID] {
+			targets[c] = true
+		}
+	}
+	g.mu.Unlock()
+	for c := range targets {
+		c.deliver(ev)
+	}
+}
+
+// wsThreadCache maps jobs to their thread (uuid.Nil: none), evicting the least recently used entry when
+// full. Not safe for concurrent use; the gateway holds mu.
+type wsThreadCache struct {
+	max   int
+	order *list.List // of wsThreadEntry, most recently used first
+	items map[uuid.UUID]*list.Element
+}
+
+type wsThreadEntry struct {
+	job, thread uuid.UUID
+}
+
+func newWSThreadCache(max int) *wsThreadCache {
+	return &wsThreadCache{max: max, order: list.New(), items: map[uuid.UUID]*list.Element{}}
+}
+
+func (c *wsThreadCache) get(job uuid.UUID) (uuid.UUID, bool) {
+	e, ok := c.items[job]
+	if !ok {
+		return uuid.Nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(wsThreadEntry).thread, true
+}
+
+func (c *wsThreadCache) put(job, thread uuid.UUID) {
+	if e, ok := c.items[job]; ok {
+		e.Value = wsThreadEntry{job, thread}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[job] = c.order.PushFront(wsThreadEntry{job, thread})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(wsThreadEntry).job)
+	}
+}
+
+// originAllowed reports whether a page at origin may open the WebSocket: an origin of the CORS allowlist
+// (CORS_ORIGINS; "*" allows any, and an entry may hold one "*" wildcard, as in rs/cors) or the API's own
+// host. Requests without Origin do not come from a browser page; the token authenticates them.
+func originAllowed(origin, host string, allowed []string) bool {
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, host) {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == "*" || a == origin {
+			return true
+		}
+		if i := strings.IndexByte(a, '*'); i >= 0 {
+			prefix, suffix := a[:i], a[i+1:]
+			if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jobsWebSocket is the gateway endpoint. Authenticate with ?token= (browsers cannot set headers on a
+// WebSocket), then send {"type":"subscribe","jobs":[...],"threads":[...],"all_jobs":true}. Pages of
+// origins outside the CORS allowlist are refused with 403.
+func (s *Server) jobsWebSocket(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	if s.Stream == nil || s.gateway == nil {
+		http.Error(w, `{"error":"streaming not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return originAllowed(r.Header.Get("Origin"), r.Host, s.allowedOrigins)
+		},
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn.SetReadLimit(wsMaxMessage)
+	_ = conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	})
+	s.gateway.start.Do(s.gateway.run)
+	c := newWSClient(userID)
+	defer s.gateway.remove(c)
+
+	go wsWriter(conn, c)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			c.stop(websocket.CloseNormalClosure, "")
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		var req wsRequest
+		if json.Unmarshal(data, &req) != nil {
+			c.deliver(wsEvent{Type: "error", Error: "invalid message"})
+			continue
+		}
+		switch req.Type {
+		case "subscribe":
+			s.wsSubscribe(r.Context(), c, req)
+		case "unsubscribe":
+			jobs, threads, bad := parseWSIDs(req)
+			if bad != "" {
+				c.deliver(wsEvent{Type: "error", Error: "invalid id " + bad})
+				continue
+			}
+			s.gateway.unsubscribe(c, jobs, threads, req.AllJobs)
+			c.deliver(wsEvent{Type: "unsubscribed", Jobs: jobs, Threads: threads, AllJobs: req.AllJobs})
+		case "ping":
+			c.deliver(wsEvent{Type: "pong"})
+		default:
+			c.deliver(wsEvent{Type: "error", Error: "unknown message type"})
+		}
+	}
+}
+
+// wsWriter is the connection's only writer: it sends the queued events and pings until the connection
+// stops, then the close frame. It skips a status a job already has.
+func wsWriter(conn *websocket.Conn, c *wsClient) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	defer conn.Close()
+	status := map[uuid.UUID]string{}
+	for {
+		select {
+		case <-c.done:
+			msg := websocket.FormatCloseMessage(c.code, c.reason)
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+			return
+		case ev := <-c.send:
+			if ev.Type == "status" && ev.JobID != nil {
+				if status[*ev.JobID] == ev.Status && !ev.snapshot {
+					continue
+				}
+				status[*ev.JobID] = ev.Status
+			}
+			b, _ := json.Marshal(ev)
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				c.stop(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				c.stop(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+func parseWSIDs(req wsRequest) (jobs, threads []uuid.UUID, bad string) {
+	for _, s := range req.Jobs {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return nil, nil, s
+		}
+		jobs = append(jobs, id)
+	}
+	for _, s := range req.Threads {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return nil, nil, s
+		}
+		threads = append(threads, id)
+	}
+	return jobs, threads, ""
+}
+
+// wsSubscribe checks that the jobs and threads are the user's, subscribes, acks, then sends each job's
+// current status and output so the client starts from the DB state. Unknown ids get an error event each.
+func (s *Server) wsSubscribe(ctx context.Context, c *wsClient, req wsRequest) {
+	ids, threadIDs, bad := parseWSIDs(req)
+	if bad != "" {
+		c.deliver(wsEvent{Type: "error", Error: "invalid id " + bad})
+		return
+	}
+	if s.gateway.subscriptions(c)+len(ids)+len(threadIDs) > wsMaxSubs {
+		c.deliver(wsEvent{Type: "error", Error: "too many subscriptions"})
+		return
+	}
+	sub := wsSubscription{active: map[uuid.UUID][]uuid.UUID{}, allJobs: req.AllJobs}
+	for _, id := range ids {
+		id := id
+		job, err := s.DB.GetJobForUser(ctx, id, c.userID)
+		if err != nil || job == nil {
+			c.deliver(wsEvent{Type: "error", JobID: &id, Error: "job not found"})
+			continue
+		}
+		thread := uuid.Nil
+		if job.ThreadID != nil {
+			thread = *job.ThreadID
+		}
+		sub.jobs, sub.jobThreads = append(sub.jobs, id), append(sub.jobThreads, thread)
+	}
+	for _, id := range threadIDs {
+		id := id
+		t, err := s.DB.GetThreadForUser(ctx, id, c.userID)
+		if err != nil || t == nil {
+			c.deliver(wsEvent{Type: "error", ThreadID: &id, Error: "thread not found"})
+			continue
+		}
+		sub.threads = append(sub.threads, id)
+		sub.active[id], _ = s.DB.ListActiveThreadJobIDs(ctx, id)
+	}
+	s.gateway.subscribe(c, sub)
+	// The Redis channels are subscribed before the snapshot is read, so nothing falls between the snapshot
+	// and the live events: an event published before the read comes first and the snapshot, which already
+	// contains it, replaces it.
+	s.gateway.syncChannels(ctx)
+	c.deliver(wsEvent{Type: "subscribed", Jobs: sub.jobs, Threads: sub.threads, AllJobs: req.AllJobs})
+	for _, id := range sub.jobs {
+		id := id
+		job, err := s.DB.GetJob(ctx, id)
+		if err != nil || job == nil {
+			continue
+		}
+		ev := wsEvent{Type: "status", JobID: &id, ThreadID: job.ThreadID, JobType: job.Type, Status: job.Status, Output: job.Output, snapshot: true}
+		if job.Error != nil {
+			ev.Error = *job.Error
+		}
+		c.deliver(ev)
+	}
+}
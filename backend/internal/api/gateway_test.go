@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"flipo5/backend/internal/events"
+	"flipo5/backend/internal/stream"
+)
+
+func TestGatewayEvents(t *testing.T) {
+	user, job, thread := uuid.New(), uuid.New(), uuid.New()
+	g := newWSGateway(nil, nil)
+	c := newWSClient(user)
+	g.subscribe(c, wsSubscription{jobs: []uuid.UUID{job}, jobThreads: []uuid.UUID{uuid.Nil}, threads: []uuid.UUID{thread}, allJobs: true})
+	userChannel := stream.UserChannel(user)
+	publish := func(channel string, p events.Payload) {
+		ev := events.New(p)
+		ev.ID = "1-0"
+		g.dispatch(channel, ev.Encode())
+	}
+	publish("stream:"+job.String(), events.JobDelta{JobID: job, Text: `say "hi"`})
+	publish("stream:"+job.String(), events.JobDelta{JobID: job, Text: "Hello", Replace: true})
+	publish("stream:"+job.String(), events.JobStatus{JobID: job, Status: "failed", Error: `bad "quote"`})
+	publish("stream:"+job.String(), events.JobProgress{JobID: job, Percent: 40})
+	publish(userChannel, events.JobOutputMirrored{JobID: job, Output: []byte(`{"output":"https://cdn/x.png"}`)})
+	publish(userChannel, events.ThreadTitleUpdated{ThreadID: thread, Title: "Cats"})
+	g.dispatch("stream:"+job.String(), `{"v":2,"type":"job.delta","data":{}}`)
+	g.dispatch("stream:"+job.String(), `{"output":"legacy"}`)
+
+	var got []string
+	for _, ev := range drain(c) {
+		switch ev.Type {
+		case "delta":
+			got = append(got, fmt.Sprintf("delta %s %v %s", ev.Text, ev.Replace, ev.ID))
+		case "status":
+			got = append(got, fmt.Sprintf("status %s %s", ev.Status, ev.Error))
+		case "progress":
+			got = append(got, "progress "+string(ev.Progress))
+		case "output":
+			got = append(got, "output "+string(ev.Output))
+		case "title":
+			got = append(got, "title "+ev.Title)
+		}
+	}
+	want := []string{
+		`delta say "hi" false 1-0`,
+		"delta Hello true 1-0",
+		`status failed bad "quote"`,
+		fmt.Sprintf(`progress {"job_id":"%s","percent":40}`, job),
+		`output {"output":"https://cdn/x.png"}`,
+		"title Cats",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got  %q\nwant %q", got, want)
+	}
+}
+
+func drain(c *wsClient) []wsEvent {
+	var out []wsEvent
+	for {
+		select {
+		case ev := <-c.send:
+			out = append(out, ev)
+		default:
+			return out
+		}
+	}
+}
+
+func TestGatewayRouting(t *testing.T) {
+	user, thread, job, other := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	lookups := 0
+	g := newWSGateway(nil, func(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+		lookups++
+		if id == other {
+			return thread, nil
+		}
+		return uuid.Nil, nil
+	})
+	byJob, byThread, byUser := newWSClient(user), newWSClient(user), newWSClient(user)
+	g.subscribe(byJob, wsSubscription{jobs: []uuid.UUID{job}, jobThreads: []uuid.UUID{thread}})
+	g.subscribe(byThread, wsSubscription{threads: []uuid.UUID{thread}})
+	g.subscribe(byUser, wsSubscription{allJobs: true})
+
+	g.dispatch("stream:"+job.String(), events.New(events.JobDelta{JobID: job, Text: "Hi"}).Encode())
+	g.dispatch("stream:"+uuid.NewString(), events.New(events.JobDelta{JobID: uuid.New(), Text: "nope"}).Encode())
+	g.dispatch(stream.UserChannel(user), events.New(events.JobStatus{JobID: job, JobType: "chat", Status: "completed"}).Encode())
+	g.dispatch(stream.UserChannel(uuid.New()), events.New(events.JobStatus{JobID: other, JobType: "image", Status: "running"}).Encode())
+
+	for name, c := range map[string]*wsClient{"job": byJob, "thread": byThread} {
+		evs := drain(c)
+		if len(evs) != 2 || evs[0].Type != "delta" || evs[0].Text != "Hi" || evs[1].Type != "status" || evs[1].Status != "completed" {
+			t.Fatalf("%s subscriber got %+v", name, evs)
+		}
+		if evs[0].ThreadID == nil || *evs[0].ThreadID != thread {
+			t.Fatalf("%s subscriber: no thread id", name)
+		}
+	}
+	if evs := drain(byUser); len(evs) != 1 || evs[0].Status != "completed" || evs[0].JobType != "chat" {
+		t.Fatalf("all_jobs subscriber got %+v", evs)
+	}
+	if lookups != 0 || len(g.lookups) != 0 {
+		t.Fatalf("thread lookups = %d, want none (the subscribed job's thread is known)", lookups)
+	}
+
+	// A new job of the thread announced with its thread: its channel is followed for the thread's subscribers
+	// until it ends.
+	next := uuid.New()
+	g.dispatch(stream.UserChannel(user), events.New(events.JobStatus{JobID: next, ThreadID: &thread, Status: "running"}).Encode())
+	g.dispatch("stream:"+next.String(), events.New(events.JobDelta{JobID: next, Text: "Yo"}).Encode())
+	if evs := drain(byThread); len(evs) != 2 || evs[1].Type != "delta" || evs[1].Text != "Yo" {
+		t.Fatalf("thread subscriber got %+v", evs)
+	}
+	if g.channels[stream.JobChannel(next)] != 1 {
+		t.Fatalf("new job of the thread not followed: %v", g.channels)
+	}
+	g.dispatch(stream.UserChannel(user), events.New(events.JobStatus{JobID: next, ThreadID: &thread, Status: "completed"}).Encode())
+	if _, ok := g.channels[stream.JobChannel(next)]; ok {
+		t.Fatal("ended job still followed")
+	}
+	drain(byThread)
+	drain(byUser)
+
+	// Without a thread in the event the reader does not wait: the lookup worker finds it, then the thread's
+	// subscribers get the event.
+	g.dispatch(stream.UserChannel(user), events.New(events.JobStatus{JobID: other, JobType: "image", Status: "running"}).Encode())
+	if evs := drain(byThread); len(evs) != 0 || len(g.lookups) != 1 {
+		t.Fatalf("thread subscriber got %+v before the lookup", evs)
+	}
+	g.resolve(<-g.lookups)
+	if evs := drain(byThread); len(evs) != 1 || evs[0].ThreadID == nil || *evs[0].ThreadID != thread || lookups != 1 {
+		t.Fatalf("after lookup: %+v (lookups %d)", evs, lookups)
+	}
+
+	g.remove(byJob)
+	g.remove(byThread)
+	g.remove(byUser)
+	g.dispatch("stream:"+job.String(), events.New(events.JobDelta{JobID: job, Text: "!"}).Encode())
+	if len(drain(byJob)) != 0 || len(drain(byThread)) != 0 || len(g.jobs) != 0 || len(g.threads) != 0 {
+		t.Fatal("removed connections still subscribed")
+	}
+	if len(g.channels) != 0 || len(g.attached) != 0 || len(g.userThreads) != 0 {
+		t.Fatalf("channels left after the last connection: %v", g.channels)
+	}
+}
+
+type fakeChannels struct {
+	subscribed map[string]bool
+}
+
+func (f *fakeChannels) Subscribe(ctx context.Context, channels ...string) error {
+	for _, ch := range channels {
+		f.subscribed[ch] = true
+	}
+	return nil
+}
+
+func (f *fakeChannels) Unsubscribe(ctx context.Context, channels ...string) error {
+	for _, ch := range channels {
+		delete(f.subscribed, ch)
+	}
+	return nil
+}
+
+// The Redis subscription holds the channels of the connections' subscriptions only, not every user's.
+func TestGatewayChannels(t *testing.T) {
+	user, job, thread, running := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	g := newWSGateway(nil, nil)
+	ps := &fakeChannels{subscribed: map[string]bool{}}
+	g.ps = ps
+	a, b := newWSClient(user), newWSClient(user)
+	g.subscribe(a, wsSubscription{jobs: []uuid.UUID{job}, jobThreads: []uuid.UUID{uuid.Nil}})
+	g.subscribe(b, wsSubscription{jobs: []uuid.UUID{job}, jobThreads: []uuid.UUID{uuid.Nil}, threads: []uuid.UUID{thread},
+		active: map[uuid.UUID][]uuid.UUID{thread: {running}}})
+	g.syncChannels(context.Background())
+	want := fmt.Sprint(map[string]bool{stream.JobChannel(job): true, stream.JobChannel(running): true, stream.UserChannel(user): true})
+	if got := fmt.Sprint(ps.subscribed); got != want {
+		t.Fatalf("subscribed %s, want %s", got, want)
+	}
+	g.remove(b)
+	g.syncChannels(context.Background())
+	if got, want := fmt.Sprint(ps.subscribed), fmt.Sprint(map[string]bool{stream.JobChannel(job): true}); got != want {
+		t.Fatalf("after one connection closed: %s, want %s", got, want)
+	}
+	g.remove(a)
+	g.syncChannels(context.Background())
+	if len(ps.subscribed) != 0 {
+		t.Fatalf("after all connections closed: %v", ps.subscribed)
+	}
+}
+
+func TestWSThreadCache(t *testing.T) {
+	a, b, c, thread := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	cache := newWSThreadCache(2)
+	cache.put(a, thread)
+	cache.put(b, uuid.Nil)
+	cache.get(a) // b is now the least recently used
+	cache.put(c, thread)
+	if _, ok := cache.get(b); ok {
+		t.Fatal("least recently used entry kept")
+	}
+	if got, ok := cache.get(a); !ok || got != thread {
+		t.Fatal("recently used entry evicted")
+	}
+	if _, ok := cache.get(c); !ok || len(cache.items) != 2 {
+		t.Fatalf("cache has %d entries", len(cache.items))
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://app.flipo5.com", "https://*.vercel.app"}
+	cases := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true}, // not a browser page
+		{"https://app.flipo5.com", true},
+		{"https://APP.flipo5.com", true},
+		{"https://preview-1.vercel.app", true},
+		{"https://api.flipo5.com", true}, // same host as the API
+		{"https://evil.example", false},
+		{"https://app.flipo5.com.evil.example", false},
+		{"null", false},
+	}
+	for _, c := range cases {
+		if got := originAllowed(c.origin, "api.flipo5.com", allowed); got != c.want {
+			t.Errorf("originAllowed(%q) = %v, want %v", c.origin, got, c.want)
+		}
+	}
+	if !originAllowed("https://evil.example", "api.flipo5.com", []string{"*"}) {
+		t.Error(`"*" must allow any origin`)
+	}
+}
+
+func TestGatewaySlowConsumer(t *testing.T) {
+	job := uuid.New()
+	g := newWSGateway(nil, nil)
+	c := newWSClient(uuid.New())
+	g.subscribe(c, wsSubscription{jobs: []uuid.UUID{job}, jobThreads: []uuid.UUID{uuid.Nil}})
+	for i := 0; i <= wsSendBuffer; i++ {
+		g.dispatch("stream:"+job.String(), events.New(events.JobDelta{JobID: job, Text: "x"}).Encode())
+	}
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("a client that does not keep up must be stopped")
+	}
+	if c.code != websocket.CloseTryAgainLater {
+		t.Fatalf("close code %d", c.code)
+	}
+}
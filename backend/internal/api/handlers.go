@@ -17,10 +17,14 @@ import (
 	"sync"
 	"time"
 
+	"flipo5/backend/internal/ai"
+	"flipo5/backend/internal/billing"
 	"flipo5/backend/internal/cache"
+	"flipo5/backend/internal/events"
 	"flipo5/backend/internal/middleware"
 	"flipo5/backend/internal/queue"
-	"flipo5/backend/internal/replicate"
+	"flipo5/backend/internal/quota"
+	"flipo5/backend/internal/ratelimit"
 	"flipo5/backend/internal/storage"
 	"flipo5/backend/internal/store"
 	"flipo5/backend/internal/stream"
@@ -35,31 +39,45 @@ import (
 )
 
 type Server struct {
-	DB                  *store.DB
-	Asynq               *asynq.Client
-	Store               *storage.Store
-	Stream              *stream.Subscriber
-	Cache               *cache.Redis
-	Repl                *replicate.Client
-	ModelRemoveBg       string
-	ModelText           string
-	redisURL            string
-	supabaseJWTSecret   string
-	jwks                *keyfunc.JWKS
-	supabaseURL         string
-	supabaseServiceRole string
+	DB                     *store.DB
+	Asynq                  *asynq.Client
+	Store                  *storage.Store
+	Stream                 *stream.Subscriber
+	Cache                  *cache.Redis
+	Repl                   ai.Provider
+	ModelRemoveBg          string
+	ModelText              string
+	redisURL               string
+	supabaseJWTSecret      string
+	jwks                   *keyfunc.JWKS
+	supabaseURL            string
+	supabaseServiceRole    string
+	replicateWebhookSecret string
+	Credits                *billing.Table     // non-nil = job creation holds credits (BILLING_CREDITS)
+	Plans                  quota.Plans        // per-plan job limits; nil = unlimited
+	webhookAllowPrivate    bool               // WEBHOOK_ALLOW_PRIVATE: accept http and private endpoint URLs
+	allowedOrigins         []string           // CORS_ORIGINS; WebSocket upgrades from other pages are refused
+	Limiter                *ratelimit.Limiter // request limits (Redis); nil = in-process defaults
+	gateway                *wsGateway         // WebSocket fan-out, see gateway.go
 }
 
 // NewServer builds the API server.
-func NewServer(db *store.DB, asynq *asynq.Client, store *storage.Store, streamSub *stream.Subscriber, cache *cache.Redis, repl *replicate.Client, modelRemoveBg, modelText string, redisURL, supabaseJWTSecret string, jwks *keyfunc.JWKS, supabaseURL, supabaseServiceRole string) *Server {
+func NewServer(db *store.DB, asynq *asynq.Client, store *storage.Store, streamSub *stream.Subscriber, cache *cache.Redis, repl ai.Provider, modelRemoveBg, modelText string, redisURL, supabaseJWTSecret string, jwks *keyfunc.JWKS, supabaseURL, supabaseServiceRole, replicateWebhookSecret string, credits *billing.Table, plans quota.Plans, webhookAllowPrivate bool, limiter *ratelimit.Limiter, allowedOrigins []string) *Server {
 	return &Server{
 		DB: db, Asynq: asynq, Store: store, Stream: streamSub, Cache: cache,
 		Repl: repl, ModelRemoveBg: modelRemoveBg, ModelText: modelText,
 		redisURL: redisURL, supabaseJWTSecret: supabaseJWTSecret, jwks: jwks,
 		supabaseURL: supabaseURL, supabaseServiceRole: supabaseServiceRole,
+		replicateWebhookSecret: replicateWebhookSecret, Credits: credits, Plans: plans,
+		webhookAllowPrivate: webhookAllowPrivate, Limiter: limiter, allowedOrigins: allowedOrigins,
 	}
 }
 
+// enqueue queues a user's task on its workload queue, boosted for the user's plan (see queue.PlanQueue).
+func (s *Server) enqueue(ctx context.Context, task *asynq.Task) (*asynq.TaskInfo, error) {
+	return s.Asynq.Enqueue(task, queue.PlanQueue(task.Type(), middleware.Plan(ctx)))
+}
+
 // recordUserProfile updates the user learning profile in the background (job type counts, last used, languages/categories).
 func (s *Server) recordUserProfile(userID uuid.UUID, jobType string, extra map[string]interface{}) {
 	go func() {
@@ -68,22 +86,49 @@ func (s *Server) recordUserProfile(userID uuid.UUID, jobType string, extra map[s
 }
 
 func (s *Server) Routes() http.Handler {
+	if s.Limiter == nil {
+		s.Limiter = ratelimit.New(nil, nil)
+	}
+	if s.gateway == nil {
+		s.gateway = newWSGateway(s.Stream, func(ctx context.Context, jobID uuid.UUID) (uuid.UUID, error) {
+			job, err := s.DB.GetJob(ctx, jobID)
+			if err != nil || job == nil || job.ThreadID == nil {
+				return uuid.Nil, err
+			}
+			return *job.ThreadID, nil
+		})
+	}
 	r := chi.NewRouter()
 	r.Use(chimw.Compress(5)) // gzip JSON/text responses for speed
 	r.Get("/health", s.health)
 	r.Get("/health/ready", s.healthReady)
+	r.Post("/webhooks/replicate", s.replicateWebhook) // authenticated by signature, not by user
+	r.Mount("/v1", s.v1Router())                      // public API, see v1.go
 
 	// Public, rate-limited by IP (no auth = no UserID)
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.RateLimitByIP(120)) // Permissive for launch; lower later (e.g. 30)
+		r.Use(middleware.RateLimitByIP(s.Limiter))
 		r.Get("/api/check-email", s.checkEmail)
+		r.Get("/s/{token}", s.viewShare) // public share links, see shares.go
 	})
 	r.Route("/api", func(r chi.Router) {
 		r.Use(middleware.SupabaseAuth(s.supabaseJWTSecret, s.jwks, s.DB))
-		r.Use(middleware.RateLimit(2000))                                         // Permissive for launch; lower later (e.g. 300)
-		r.Use(middleware.RateLimitJobCreation(120, "/api/seo", "/api/translate")) // Permissive for launch; lower later (e.g. 20)
+		r.Use(middleware.RateLimit(s.Limiter)) // per user and plan, plus route rules (RATE_LIMITS)
 		r.Get("/me", s.me)
 		r.Patch("/me", s.patchMe)
+		r.Get("/me/credits", s.getCredits)
+		r.Get("/me/usage", s.getUsage)
+		r.Get("/me/api-keys", s.listAPIKeys)
+		r.Post("/me/api-keys", s.createAPIKey)
+		r.Delete("/me/api-keys/{id}", s.revokeAPIKey)
+		r.Get("/shares", s.listShares)
+		r.Post("/shares", s.createShare)
+		r.Delete("/shares/{id}", s.revokeShare)
+		r.Get("/webhooks", s.listWebhookEndpoints)
+		r.Post("/webhooks", s.createWebhookEndpoint)
+		r.Delete("/webhooks/{id}", s.deleteWebhookEndpoint)
+		r.Get("/webhooks/{id}/deliveries", s.listWebhookDeliveries)
+		r.Post("/webhooks/deliveries/{id}/replay", s.replayWebhookDelivery)
 		r.Post("/chat", s.createChat)
 		r.Post("/image", s.createImage)
 		r.Post("/image-inpaint", s.createImageInpaint)
@@ -94,14 +139,21 @@ func (s *Server) Routes() http.Handler {
 		r.Get("/threads", s.listThreads)
 		r.Get("/threads/{id}", s.getThread)
 		r.Patch("/threads/{id}", s.patchThread)
+		r.Post("/threads/{id}/branch", s.selectThreadBranch)
+		r.Get("/threads/{id}/export", s.exportThread)
+		r.Post("/threads/import", s.importThreads)
 		r.Get("/jobs", s.listJobs)
 		r.Get("/content", s.listContent)
+		r.Get("/search", s.search)
 		r.Post("/content/from-url", s.addContentFromURL)
 		r.Get("/jobs/{id}", s.getJob)
 		r.Patch("/jobs/{id}/feedback", s.setJobFeedback)
 		r.Post("/jobs/{id}/cancel", s.cancelJob)
 		r.Post("/jobs/{id}/retry", s.retryJob)
+		r.Post("/jobs/{id}/regenerate", s.regenerateJob)
+		r.Post("/jobs/{id}/edit", s.editJob)
 		r.Get("/jobs/stream", s.streamAllJobs)
+		r.Get("/ws", s.jobsWebSocket) // WebSocket gateway, see gateway.go
 		r.Post("/seo", s.createSEO)
 		r.Post("/outline", s.createOutline)
 		r.Post("/translate", s.createTranslate)
@@ -165,6 +217,8 @@ func (s *Server) Routes() http.Handler {
 			r.Get("/stats", s.adminStats)
 			r.Get("/users", s.adminListUsers)
 			r.Get("/users/{id}", s.adminGetUser)
+			r.Post("/users/{id}/credits", s.adminGrantCredits)
+			r.Post("/users/{id}/plan", s.adminSetPlan)
 			r.Get("/jobs", s.adminListJobs)
 		})
 	})
@@ -346,14 +400,16 @@ func (s *Server) patchMe(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"invalid body"}`, http.StatusBadRequest)
 		return
 	}
+	// Users may only move themselves to free; paid plans are set by billing (adminSetPlan).
 	var planVal *string
 	if body.Plan != nil {
 		p := strings.TrimSpace(*body.Plan)
-		if p != "" && p != "free" && p != "premium" && p != "creator" {
-			http.Error(w, `{"error":"invalid plan"}`, http.StatusBadRequest)
+		if p != "" && quota.PlanName(p) != "free" {
+			http.Error(w, `{"error":"plan changes go through billing"}`, http.StatusForbidden)
 			return
 		}
 		if p != "" {
+			p = "free"
 			planVal = &p
 		}
 	}
@@ -405,6 +461,16 @@ func (s *Server) patchMe(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
+// setKeyScopes records on a chat job's input the scopes of the API key asking for it, or removes them for a
+// session, so the reply's tools are held to the key (queue.InputKeyScopes).
+func setKeyScopes(ctx context.Context, input map[string]interface{}) {
+	if scopes, isKey := middleware.KeyScopes(ctx); isKey {
+		input[queue.InputKeyScopes] = append([]string{}, scopes...)
+	} else {
+		delete(input, queue.InputKeyScopes)
+	}
+}
+
 func (s *Server) createChat(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Prompt                 string   `json:"prompt"`
@@ -468,14 +534,14 @@ func (s *Server) createChat(w http.ResponseWriter, r *http.Request) {
 			input["attachment_content_types"] = req.AttachmentContentTypes
 		}
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "chat", input, threadID)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	setKeyScopes(ctx, input)
+	jobID, ok := s.createJob(ctx, w, userID, "chat", input, threadID)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "chat", nil)
 	task, _ := queue.NewChatTask(jobID, req.Prompt)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -748,10 +814,20 @@ func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
 
 // ensureThread returns threadID for job. If threadID param is valid, uses it; otherwise creates new (normal or ephemeral).
 func (s *Server) ensureThread(ctx context.Context, w http.ResponseWriter, userID uuid.UUID, threadIDParam string, incognito bool) *uuid.UUID {
+	id, err := s.resolveThread(ctx, userID, threadIDParam, incognito)
+	if err != nil {
+		http.Error(w, `{"error":"create thread"}`, http.StatusInternalServerError)
+		return nil
+	}
+	return id
+}
+
+// resolveThread returns the user's thread threadIDParam, or a new thread when it is empty or not theirs.
+func (s *Server) resolveThread(ctx context.Context, userID uuid.UUID, threadIDParam string, incognito bool) (*uuid.UUID, error) {
 	if threadIDParam != "" {
 		if id, err := uuid.Parse(threadIDParam); err == nil {
 			if t, _ := s.DB.GetThreadForUser(ctx, id, userID); t != nil {
-				return &id
+				return &id, nil
 			}
 		}
 	}
@@ -759,10 +835,9 @@ func (s *Server) ensureThread(ctx context.Context, w http.ResponseWriter, userID
 	id, err := s.DB.CreateThread(ctx, userID, ephemeral)
 	if err != nil {
 		log.Printf("create thread failed: %v", err)
-		http.Error(w, `{"error":"create thread"}`, http.StatusInternalServerError)
-		return nil
+		return nil, err
 	}
-	return &id
+	return &id, nil
 }
 
 func (s *Server) createImage(w http.ResponseWriter, r *http.Request) {
@@ -823,14 +898,13 @@ func (s *Server) createImage(w http.ResponseWriter, r *http.Request) {
 	if strings.TrimSpace(req.ProductID) != "" {
 		input["product_id"] = strings.TrimSpace(req.ProductID)
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "image", input, threadID)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "image", input, threadID)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "image", nil)
 	task, _ := queue.NewImageTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -882,14 +956,13 @@ func (s *Server) createLogo(w http.ResponseWriter, r *http.Request) {
 		"aspect_ratio":    req.AspectRatio,
 		"output_format":   req.OutputFormat,
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "logo", input, nil)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "logo", input, nil)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "logo", nil)
 	task, _ := queue.NewLogoTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -948,14 +1021,13 @@ func (s *Server) createImageInpaint(w http.ResponseWriter, r *http.Request) {
 	if req.Guidance >= 1.5 && req.Guidance <= 100 {
 		input["guidance"] = req.Guidance
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "image", input, nil)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "image", input, nil)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "image", nil)
 	task, _ := queue.NewImageTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -1023,14 +1095,13 @@ func (s *Server) createVideo(w http.ResponseWriter, r *http.Request) {
 			input["video"] = req.Video
 		}
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "video", input, threadID)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "video", input, threadID)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "video", nil)
 	task, _ := queue.NewVideoTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -1100,14 +1171,13 @@ func (s *Server) createUpscale(w http.ResponseWriter, r *http.Request) {
 	if req.FaceEnhancementStrength != nil {
 		input["face_enhancement_strength"] = *req.FaceEnhancementStrength
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "upscale", input, nil)
-	if err != nil {
-		log.Printf("[createUpscale] CreateJob failed: %v", err)
+	jobID, ok := s.createJob(ctx, w, userID, "upscale", input, nil)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "upscale", nil)
 	task, _ := queue.NewUpscaleTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -1234,7 +1304,7 @@ func (s *Server) getThread(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	var thread *store.Thread
-	var jobs []store.Job
+	var jobs []store.ThreadJob
 	var threadErr, jobsErr error
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -1245,7 +1315,7 @@ func (s *Server) getThread(w http.ResponseWriter, r *http.Request) {
 	}()
 	go func() {
 		defer wg.Done()
-		j, e := s.DB.ListJobsByThread(ctx, id, userID)
+		j, e := s.DB.ListThreadBranch(ctx, id, userID)
 		jobs, jobsErr = j, e
 	}()
 	wg.Wait()
@@ -1258,7 +1328,7 @@ func (s *Server) getThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if jobs == nil {
-		jobs = []store.Job{}
+		jobs = []store.ThreadJob{}
 	}
 	out := map[string]interface{}{"thread": thread, "jobs": jobs}
 	if s.Cache != nil {
@@ -1444,7 +1514,9 @@ func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if job.ReplicateID != nil && *job.ReplicateID != "" && s.Repl != nil {
-		_ = s.Repl.CancelPrediction(r.Context(), *job.ReplicateID)
+		if err := s.Repl.CancelPrediction(r.Context(), *job.ReplicateID); err != nil {
+			log.Printf("cancel job %s: cancel prediction %s: %v", job.ID, *job.ReplicateID, err)
+		}
 	}
 	if err := s.DB.SetJobCancelled(r.Context(), id, userID, "Cancelled by user"); err != nil {
 		http.Error(w, `{"error":"cancel failed"}`, http.StatusInternalServerError)
@@ -1479,6 +1551,10 @@ func (s *Server) retryJob(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"only failed jobs can be retried"}`, http.StatusBadRequest)
 		return
 	}
+	if scope := middleware.JobScope(job.Type); !middleware.KeyHasScope(r.Context(), scope) {
+		http.Error(w, `{"error":"api key lacks scope","scope":"`+scope+`"}`, http.StatusForbidden)
+		return
+	}
 	ctx := r.Context()
 	var input map[string]interface{}
 	if len(job.Input) > 0 {
@@ -1490,9 +1566,18 @@ func (s *Server) retryJob(w http.ResponseWriter, r *http.Request) {
 	if input == nil {
 		input = make(map[string]interface{})
 	}
-	newJobID, err := s.DB.CreateJob(ctx, userID, job.Type, input, job.ThreadID)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	if job.Type == "chat" {
+		setKeyScopes(ctx, input)
+	}
+	// In a thread the retry is an alternative to the failed message, so the failed branch stays reachable.
+	var newJobID uuid.UUID
+	var ok bool
+	if job.ThreadID != nil {
+		newJobID, ok = s.createAlternativeJob(ctx, w, userID, input, job)
+	} else {
+		newJobID, ok = s.createJob(ctx, w, userID, job.Type, input, nil)
+	}
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, job.Type, nil)
@@ -1515,7 +1600,7 @@ func (s *Server) retryJob(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -1598,14 +1683,13 @@ func (s *Server) createSEO(w http.ResponseWriter, r *http.Request) {
 		"title":       title,
 		"language":    lang,
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "seo", input, nil)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "seo", input, nil)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "seo", nil)
 	task, _ := queue.NewSEOTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -1633,14 +1717,13 @@ func (s *Server) createOutline(w http.ResponseWriter, r *http.Request) {
 		"language":   req.Language,
 		"word_count": req.WordCount,
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "outline", input, nil)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "outline", input, nil)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "outline", nil)
 	task, _ := queue.NewOutlineTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -1759,13 +1842,12 @@ func (s *Server) createProductDescriptionImprove(w http.ResponseWriter, r *http.
 	if u := strings.TrimSpace(req.ProductURL); u != "" {
 		input["product_url"] = u
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "product_description", input, nil)
-	if err != nil {
-		http.Error(w, `{"error":"create job failed"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "product_description", input, nil)
+	if !ok {
 		return
 	}
 	task, _ := queue.NewProductDescriptionTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue failed"}`, http.StatusInternalServerError)
 		return
 	}
@@ -1794,14 +1876,13 @@ func (s *Server) createProductSceneImprove(w http.ResponseWriter, r *http.Reques
 	if id := strings.TrimSpace(req.ProductID); id != "" {
 		input["product_id"] = id
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "product_scene_improve", input, nil)
-	if err != nil {
-		http.Error(w, `{"error":"create job failed"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "product_scene_improve", input, nil)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "product_scene_improve", nil)
 	task, _ := queue.NewProductSceneImproveTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue failed"}`, http.StatusInternalServerError)
 		return
 	}
@@ -1924,14 +2005,13 @@ func (s *Server) createProductScore(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx := r.Context()
 	input := map[string]interface{}{"product_id": productID.String()}
-	jobID, err := s.DB.CreateJob(ctx, userID, "product_score", input, nil)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "product_score", input, nil)
+	if !ok {
 		return
 	}
 	s.recordUserProfile(userID, "product_score", nil)
 	task, _ := queue.NewProductScoreTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -2055,9 +2135,8 @@ func (s *Server) createTranslate(w http.ResponseWriter, r *http.Request) {
 	if req.ItemID != "" {
 		input["item_id"] = req.ItemID
 	}
-	jobID, err := s.DB.CreateJob(ctx, userID, "translate", input, nil)
-	if err != nil {
-		http.Error(w, `{"error":"create job"}`, http.StatusInternalServerError)
+	jobID, ok := s.createJob(ctx, w, userID, "translate", input, nil)
+	if !ok {
 		return
 	}
 	itemIDStr := strings.TrimSpace(req.ItemID)
@@ -2067,7 +2146,7 @@ func (s *Server) createTranslate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	task, _ := queue.NewTranslateTask(jobID)
-	if _, err := s.Asynq.Enqueue(task); err != nil {
+	if _, err := s.enqueue(r.Context(), task); err != nil {
 		http.Error(w, `{"error":"enqueue"}`, http.StatusInternalServerError)
 		return
 	}
@@ -2472,8 +2551,7 @@ func (s *Server) streamAllJobs(w http.ResponseWriter, r *http.Request) {
 	flusher.Flush()
 
 	// Subscribe to user-specific job updates channel
-	userJobsChannel := fmt.Sprintf("user:%s:jobs", userID.String())
-	pubsub := s.Stream.SubscribeRaw(ctx, userJobsChannel)
+	pubsub := s.Stream.SubscribeRaw(ctx, stream.UserChannel(userID))
 	if pubsub == nil {
 		http.Error(w, `{"error":"subscription failed"}`, http.StatusInternalServerError)
 		return
@@ -2490,12 +2568,46 @@ func (s *Server) streamAllJobs(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			// Forward job update to client
-			fmt.Fprintf(w, "data: %s\n\n", msg.Payload)
-			flusher.Flush()
+			if update := jobListUpdate(msg.Payload); update != nil {
+				b, _ := json.Marshal(update)
+				fmt.Fprintf(w, "data: %s\n\n", b)
+				flusher.Flush()
+			}
 		}
 	}
 }
 
+// jobListUpdate is the job list stream's message for a user channel event: {"event", "jobId", "status",
+// "type"} for job status changes, {"event", "jobId", "status", "progress"} for progress, {"event", "jobId"} for
+// mirrored outputs, {"event", "threadId", "title"} for thread titles. Other events are not sent.
+func jobListUpdate(payload string) map[string]interface{} {
+	ev, err := events.Decode(payload)
+	if err != nil {
+		return nil
+	}
+	p, _ := ev.Payload()
+	switch p := p.(type) {
+	case events.JobStatus:
+		return map[string]interface{}{"event": ev.Type, "jobId": p.JobID, "status": p.Status, "type": p.JobType}
+	case events.JobProgress:
+		return map[string]interface{}{"event": ev.Type, "jobId": p.JobID, "status": "running", "progress": ev.Data}
+	case events.JobOutputMirrored:
+		return map[string]interface{}{"event": ev.Type, "jobId": p.JobID}
+	case events.ThreadTitleUpdated:
+		return map[string]interface{}{"event": ev.Type, "threadId": p.ThreadID, "title": p.Title}
+	}
+	return nil
+}
+
+// sseHeartbeat is how long a job stream may stay quiet before a comment is sent (proxies drop idle
+// connections) and the job is checked in the DB, for jobs that end without a final event.
+const sseHeartbeat = 5 * time.Second
+
+// jobStreamSSE streams a job's output as server-sent events. The first event is the output so far
+// ({"output", "status"}); then each job event is an SSE event with an id: and only the new text ({"delta"}),
+// the whole output when it was replaced, a status change ({"status"}) or progress ({"progress"}). A client
+// that reconnects with Last-Event-ID (or ?last_event_id=) gets the events after that one replayed. The last
+// event has the final output and status.
 func (s *Server) jobStreamSSE(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	jobID, err := uuid.Parse(idStr)
@@ -2521,9 +2633,11 @@ func (s *Server) jobStreamSSE(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	sendSSE := func(output string, status string) {
-		payload := map[string]string{"output": output, "status": status}
+	sendSSE := func(id string, payload map[string]interface{}) {
 		b, _ := json.Marshal(payload)
+		if id != "" {
+			w.Write([]byte("id: " + id + "\n"))
+		}
 		w.Write([]byte("data: " + string(b) + "\n\n"))
 		flusher.Flush()
 	}
@@ -2540,60 +2654,59 @@ func (s *Server) jobStreamSSE(w http.ResponseWriter, r *http.Request) {
 		}
 		return ""
 	}
-	sendSSE(outputText(job), job.Status)
-	if job.Status == "completed" || job.Status == "failed" {
-		return
+	terminal := func(j *store.Job) bool {
+		return j.Status == "completed" || j.Status == "failed" || j.Status == "cancelled"
 	}
 	ctx := r.Context()
-	// Redis Pub/Sub: real-time stream when available
+	after := lastEventID(r)
+	// A new client gets the output from the events from the start; the DB only has it when nothing was
+	// streamed (the worker writes the DB after publishing).
+	if terminal(job) || after == "" && !s.Stream.HasEvents(ctx, jobID) {
+		sendSSE("", map[string]interface{}{"output": outputText(job), "status": job.Status})
+		if terminal(job) {
+			return
+		}
+	}
+	if after == "" {
+		after = "0"
+	}
 	if s.Stream != nil {
-		type streamMsg struct {
-			output string
-			done   bool
-		}
-		ch := make(chan streamMsg, 64)
-		go func() {
-			_ = s.Stream.Subscribe(ctx, jobID, func(output string, done bool) {
-				select {
-				case ch <- streamMsg{output, done}:
-				default:
-				}
-			})
-			close(ch)
-		}()
-		ticker := time.NewTicker(250 * time.Millisecond)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case msg, ok := <-ch:
-				if !ok {
-					job, _ = s.DB.GetJobForUser(r.Context(), jobID, userID)
-					if job != nil {
-						sendSSE(outputText(job), job.Status)
-					}
-					return
-				}
-				status := "running"
-				if msg.done {
-					status = "completed"
+		_ = s.Stream.Events(ctx, jobID, after, sseHeartbeat, func(ev events.Event) bool {
+			if ev.Done {
+				// The final event is the job's end status: the job row has the output.
+				if next, _ := s.DB.GetJobForUser(ctx, jobID, userID); next != nil {
+					sendSSE(ev.ID, map[string]interface{}{"output": outputText(next), "status": next.Status})
 				}
-				sendSSE(msg.output, status)
-				if msg.done {
-					return
-				}
-			case <-ticker.C:
-				next, err := s.DB.GetJobForUser(r.Context(), jobID, userID)
-				if err != nil || next == nil {
-					return
-				}
-				sendSSE(outputText(next), next.Status)
-				if next.Status == "completed" || next.Status == "failed" {
-					return
+				return true
+			}
+			p, _ := ev.Payload()
+			switch p := p.(type) {
+			case events.JobDelta:
+				if p.Replace {
+					sendSSE(ev.ID, map[string]interface{}{"output": p.Text, "status": "running"})
+				} else {
+					sendSSE(ev.ID, map[string]interface{}{"delta": p.Text, "status": "running"})
 				}
+			case events.JobStatus:
+				sendSSE(ev.ID, map[string]interface{}{"status": p.Status})
+			case events.JobProgress:
+				sendSSE(ev.ID, map[string]interface{}{"progress": p, "status": "running"})
 			}
-		}
+			return true
+		}, func() bool {
+			w.Write([]byte(": ping\n\n"))
+			flusher.Flush()
+			next, err := s.DB.GetJobForUser(ctx, jobID, userID)
+			if err != nil || next == nil {
+				return false
+			}
+			if terminal(next) {
+				sendSSE("", map[string]interface{}{"output": outputText(next), "status": next.Status})
+				return false
+			}
+			return true
+		})
+		return
 	}
 	// Fallback: poll DB only
 	ticker := time.NewTicker(200 * time.Millisecond)
@@ -2607,14 +2720,28 @@ func (s *Server) jobStreamSSE(w http.ResponseWriter, r *http.Request) {
 			if err != nil || next == nil {
 				return
 			}
-			sendSSE(outputText(next), next.Status)
-			if next.Status == "completed" || next.Status == "failed" {
+			sendSSE("", map[string]interface{}{"output": outputText(next), "status": next.Status})
+			if terminal(next) {
 				return
 			}
 		}
 	}
 }
 
+// lastEventID is the stream ID a reconnecting client saw last: the Last-Event-ID header EventSource sends,
+// or ?last_event_id= for clients that reconnect by hand. Anything but a Redis stream ID ("ms-seq") is ignored.
+func lastEventID(r *http.Request) string {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("last_event_id")
+	}
+	ms, seq, ok := strings.Cut(strings.TrimSpace(id), "-")
+	if !ok || ms == "" || seq == "" || strings.Trim(ms+seq, "0123456789") != "" {
+		return ""
+	}
+	return ms + "-" + seq
+}
+
 // --- Edit Studio (projects) ---
 
 func (s *Server) listProjects(w http.ResponseWriter, r *http.Request) {
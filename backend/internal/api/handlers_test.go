@@ -0,0 +1,21 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A user cannot give themselves a paid plan; the request is refused before anything is written.
+func TestPatchMeRejectsPaidPlan(t *testing.T) {
+	s := &Server{}
+	for _, plan := range []string{"premium", "creator", "pro", "Business", "enterprise"} {
+		req := httptest.NewRequest(http.MethodPatch, "/api/me", strings.NewReader(`{"plan":"`+plan+`"}`))
+		rec := httptest.NewRecorder()
+		s.patchMe(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("plan %q: status %d, want 403", plan, rec.Code)
+		}
+	}
+}
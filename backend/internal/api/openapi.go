@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// The OpenAPI 3 document of the /v1 API, generated from v1Routes and the Go types of their bodies.
+
+var (
+	openAPIOnce  sync.Once
+	openAPIBytes []byte
+
+	uuidType       = reflect.TypeOf(uuid.UUID{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	pathParamRe    = regexp.MustCompile(`\{([^}]+)\}`)
+)
+
+func openAPIJSON() []byte {
+	openAPIOnce.Do(func() {
+		openAPIBytes, _ = json.Marshal(buildOpenAPI(v1Routes()))
+	})
+	return openAPIBytes
+}
+
+func buildOpenAPI(routes []v1Route) map[string]interface{} {
+	g := &schemaGen{schemas: map[string]interface{}{}}
+	paths := map[string]interface{}{}
+	for _, rt := range routes {
+		item, _ := paths[rt.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[rt.Path] = item
+		}
+		item[strings.ToLower(rt.Method)] = g.operation(rt)
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Flipo5 API",
+			"version": "1",
+			"description": "Jobs are asynchronous: the create endpoints answer 202 with a job_id, poll GET /v1/jobs/{id} " +
+				"until status is completed, failed or cancelled. Errors always have the shape {\"error\":{\"code\",\"message\"}}.",
+		},
+		"paths":    paths,
+		"security": []interface{}{map[string]interface{}{"bearerAuth": []string{}}},
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(g.schema(reflect.TypeOf(ErrorResponse{}))),
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Supabase access token or personal API key (fl5_...)",
+				},
+			},
+		},
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func (g *schemaGen) operation(rt v1Route) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": rt.ID,
+		"summary":     rt.Summary,
+	}
+	var params []interface{}
+	for _, m := range pathParamRe.FindAllStringSubmatch(rt.Path, -1) {
+		params = append(params, map[string]interface{}{
+			"name": m[1], "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "string", "format": "uuid"},
+		})
+	}
+	for _, q := range rt.Query {
+		params = append(params, map[string]interface{}{
+			"name": q.Name, "in": "query", "description": q.Doc,
+			"schema": map[string]interface{}{"type": q.Type},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if rt.Request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(g.schema(reflect.TypeOf(rt.Request))),
+		}
+	}
+	errRef := map[string]interface{}{"$ref": "#/components/responses/Error"}
+	responses := map[string]interface{}{
+		strconv.Itoa(rt.Status): map[string]interface{}{
+			"description": http.StatusText(rt.Status),
+			"content":     jsonContent(g.schema(reflect.TypeOf(rt.Response))),
+		},
+		"default": errRef,
+	}
+	if rt.Request != nil {
+		responses["400"] = errRef
+	}
+	if rt.Scope == "" {
+		op["security"] = []interface{}{}
+	} else {
+		op["x-api-key-scope"] = rt.Scope
+		responses["401"] = errRef
+		responses["403"] = errRef
+	}
+	for _, code := range rt.Errors {
+		responses[strconv.Itoa(code)] = errRef
+	}
+	op["responses"] = responses
+	return op
+}
+
+// schemaGen turns Go types into JSON Schema; named structs become components/schemas entries.
+type schemaGen struct {
+	schemas map[string]interface{}
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = nil // placeholder against recursion
+			g.schemas[t.Name()] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{} // interface{}: any JSON value
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		s := g.schema(f.Type)
+		if enum := f.Tag.Get("enum"); enum != "" {
+			s["enum"] = enumValues(f.Type, enum)
+		}
+		if doc := f.Tag.Get("doc"); doc != "" {
+			if _, ok := s["$ref"]; ok {
+				s = map[string]interface{}{"allOf": []interface{}{s}}
+			}
+			s["description"] = doc
+		}
+		props[name] = s
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	out := map[string]interface{}{"type": "object", "properties": props, "additionalProperties": false}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+func enumValues(t reflect.Type, list string) []interface{} {
+	var out []interface{}
+	for _, v := range strings.Split(list, ",") {
+		if k := t.Kind(); k >= reflect.Int && k <= reflect.Int64 {
+			n, _ := strconv.Atoi(v)
+			out = append(out, n)
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
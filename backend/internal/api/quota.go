@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/quota"
+
+	"github.com/google/uuid"
+)
+
+// planUsage loads the user's plan and what they used of it in the current day and month.
+func (s *Server) planUsage(ctx context.Context, userID uuid.UUID, now time.Time) (string, quota.Plan, quota.Usage, error) {
+	var planName string
+	if u, err := s.DB.UserByID(ctx, userID); err != nil {
+		return "", quota.Plan{}, quota.Usage{}, err
+	} else if u != nil {
+		planName = u.Plan
+	}
+	name, plan := s.Plans.For(planName)
+	dayStart, monthStart, _, _ := quota.Windows(now)
+	rows, err := s.DB.GetJobUsage(ctx, userID, dayStart, monthStart)
+	if err != nil {
+		return "", quota.Plan{}, quota.Usage{}, err
+	}
+	usage := quota.Usage{Day: map[string]int{}, Month: map[string]int{}}
+	for _, r := range rows {
+		usage.Add(r.Type, r.DayJobs, r.MonthJobs, r.DaySeconds, r.MonthSeconds)
+	}
+	return name, plan, usage, nil
+}
+
+// quotaRefusal refuses a new job that would exceed the user's plan: 429 with Retry-After for a daily limit
+// (it resets at midnight UTC), 402 for a monthly one (upgrade or wait).
+// Counting errors let the job through; limits are a product boundary, not a safety one.
+func (s *Server) quotaRefusal(ctx context.Context, userID uuid.UUID, jobType string, input interface{}) *jobRefusal {
+	metric := quota.Metric(jobType)
+	if s.Plans == nil || metric == "" {
+		return nil
+	}
+	now := time.Now()
+	planName, plan, usage, err := s.planUsage(ctx, userID, now)
+	if err != nil {
+		log.Printf("[quota] usage for %s: %v", userID, err)
+		return nil
+	}
+	exceeded := plan.Check(metric, quota.Amount(jobType, input), usage)
+	if exceeded == nil {
+		return nil
+	}
+	_, _, dayReset, monthReset := quota.Windows(now)
+	ref := &jobRefusal{Status: http.StatusPaymentRequired, Code: "quota_exceeded", Message: "quota exceeded"}
+	resetsAt := monthReset
+	if exceeded.Period == "daily" {
+		ref.Status, resetsAt = http.StatusTooManyRequests, dayReset
+		ref.RetryAfter = int(resetsAt.Sub(now).Seconds()) + 1
+	}
+	ref.Details = map[string]interface{}{
+		"plan": planName, "metric": exceeded.Metric, "period": exceeded.Period,
+		"limit": exceeded.Limit, "used": exceeded.Used, "remaining": exceeded.Remaining, "requested": exceeded.Requested,
+		"resets_at": resetsAt.Format(time.RFC3339),
+	}
+	return ref
+}
+
+func newUsagePeriod(limit, used int) UsagePeriod {
+	p := UsagePeriod{Used: used}
+	if limit != quota.Unlimited {
+		remaining := quota.Remaining(limit, used)
+		p.Limit, p.Remaining = &limit, &remaining
+	}
+	return p
+}
+
+// usageSummary is the user's consumption per plan metric for the current UTC day and month.
+func (s *Server) usageSummary(ctx context.Context, userID uuid.UUID) (*Usage, error) {
+	now := time.Now()
+	planName, plan, usage, err := s.planUsage(ctx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	_, _, dayReset, monthReset := quota.Windows(now)
+	out := &Usage{
+		Plan:            planName,
+		Metrics:         make(map[string]UsageMetric, len(quota.Metrics)),
+		DailyResetsAt:   dayReset.Format(time.RFC3339),
+		MonthlyResetsAt: monthReset.Format(time.RFC3339),
+	}
+	for _, m := range quota.Metrics {
+		l, ok := plan.Limits[m]
+		if !ok {
+			l = quota.Limit{Daily: quota.Unlimited, Monthly: quota.Unlimited}
+		}
+		out.Metrics[m] = UsageMetric{Daily: newUsagePeriod(l.Daily, usage.Day[m]), Monthly: newUsagePeriod(l.Monthly, usage.Month[m])}
+	}
+	return out, nil
+}
+
+func (s *Server) getUsage(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	if s.Plans == nil {
+		http.Error(w, `{"error":"quotas not configured"}`, http.StatusNotFound)
+		return
+	}
+	usage, err := s.usageSummary(r.Context(), userID)
+	if err != nil {
+		http.Error(w, `{"error":"usage failed"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
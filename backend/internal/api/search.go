@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/store"
+)
+
+const maxSearchQueryLen = 200
+
+// search is full-text search over the user's chat messages, thread titles, files and translations:
+// GET /api/search?q=invoice%20vat&type=chat,file&page=1&limit=20. q takes web search syntax ("quoted phrase",
+// or, -word). Results are ranked, with a highlighted snippet (HTML, matches in <mark>).
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, `{"error":"q required"}`, http.StatusBadRequest)
+		return
+	}
+	if len([]rune(q)) > maxSearchQueryLen {
+		http.Error(w, `{"error":"q too long"}`, http.StatusBadRequest)
+		return
+	}
+	var kinds []string
+	for _, k := range strings.Split(r.URL.Query().Get("type"), ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		if !slices.Contains(store.SearchKinds, k) {
+			http.Error(w, `{"error":"type must be chat, thread, file or translation"}`, http.StatusBadRequest)
+			return
+		}
+		kinds = append(kinds, k)
+	}
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		if v, err := strconv.Atoi(p); err == nil && v > 0 {
+			page = v
+		}
+	}
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 50 {
+			limit = v
+		}
+	}
+	results, total, err := s.DB.SearchDocuments(r.Context(), userID, q, kinds, (page-1)*limit, limit)
+	if err != nil {
+		http.Error(w, `{"error":"search failed"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results, "total": total, "page": page, "limit": limit})
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/store"
+	"flipo5/backend/internal/transcript"
+)
+
+const (
+	maxActiveShares  = 100
+	defaultShareDays = 30
+	maxShareDays     = 365
+)
+
+// newShareToken is 128 random bits, URL-safe.
+func newShareToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (s *Server) listShares(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	list, err := s.DB.ListShares(r.Context(), userID)
+	if err != nil {
+		http.Error(w, `{"error":"list failed"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"shares": list})
+}
+
+// createShare makes a public link: body {"thread_id": "..."} or {"job_id": "..."}, optional "expires_in_days"
+// (1-365, default 30) and "include_attachments" (default false). The link is /s/{token}.
+func (s *Server) createShare(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	var req struct {
+		ThreadID           string `json:"thread_id"`
+		JobID              string `json:"job_id"`
+		ExpiresInDays      *int   `json:"expires_in_days"`
+		IncludeAttachments bool   `json:"include_attachments"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid body"}`, http.StatusBadRequest)
+		return
+	}
+	if (req.ThreadID == "") == (req.JobID == "") {
+		http.Error(w, `{"error":"thread_id or job_id required"}`, http.StatusBadRequest)
+		return
+	}
+	days := defaultShareDays
+	if req.ExpiresInDays != nil {
+		days = *req.ExpiresInDays
+	}
+	if days < 1 || days > maxShareDays {
+		http.Error(w, `{"error":"expires_in_days must be 1-365"}`, http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	var threadID, jobID *uuid.UUID
+	if req.ThreadID != "" {
+		id, err := uuid.Parse(req.ThreadID)
+		if err != nil {
+			http.Error(w, `{"error":"invalid thread_id"}`, http.StatusBadRequest)
+			return
+		}
+		t, _ := s.DB.GetThreadForUser(ctx, id, userID)
+		if t == nil {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		if ephemeral, _ := s.DB.IsThreadEphemeral(ctx, id); ephemeral {
+			http.Error(w, `{"error":"incognito threads cannot be shared"}`, http.StatusBadRequest)
+			return
+		}
+		threadID = &id
+	} else {
+		id, err := uuid.Parse(req.JobID)
+		if err != nil {
+			http.Error(w, `{"error":"invalid job_id"}`, http.StatusBadRequest)
+			return
+		}
+		job, _ := s.DB.GetJobForUser(ctx, id, userID)
+		if job == nil {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		if job.Status != "completed" {
+			http.Error(w, `{"error":"only completed jobs can be shared"}`, http.StatusBadRequest)
+			return
+		}
+		jobID = &id
+	}
+	if n, err := s.DB.CountActiveShares(ctx, userID); err != nil || n >= maxActiveShares {
+		http.Error(w, `{"error":"share limit reached"}`, http.StatusConflict)
+		return
+	}
+	token, err := newShareToken()
+	if err != nil {
+		http.Error(w, `{"error":"create failed"}`, http.StatusInternalServerError)
+		return
+	}
+	expires := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	share, err := s.DB.CreateShare(ctx, userID, token, threadID, jobID, req.IncludeAttachments, &expires)
+	if err != nil {
+		http.Error(w, `{"error":"create failed"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"share": share, "path": "/s/" + token})
+}
+
+func (s *Server) revokeShare(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	if err := s.DB.RevokeShare(r.Context(), id, userID); err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error":"revoke failed"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
+
+// viewShare is the public, unauthenticated GET /s/{token}: the shared thread (active branch) or job, read-only.
+// Only completed jobs are shown, with prompts, replies and media; no ids, emails or costs, and attachments only
+// when the owner opted in. Unknown, revoked and expired tokens all answer 404.
+func (s *Server) viewShare(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	w.Header().Set("Cache-Control", "no-store") // every view is counted and revocation is immediate
+	w.Header().Set("X-Robots-Tag", "noindex")
+	if len(token) < 16 || len(token) > 64 {
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+		return
+	}
+	ctx := r.Context()
+	share, err := s.DB.ViewShare(ctx, token)
+	if err != nil {
+		http.Error(w, `{"error":"share failed"}`, http.StatusInternalServerError)
+		return
+	}
+	if share == nil {
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+		return
+	}
+	var thread store.Thread
+	var jobs []store.Job
+	kind := "thread"
+	if share.ThreadID != nil {
+		t, err := s.DB.GetThreadForUser(ctx, *share.ThreadID, share.UserID)
+		if err != nil || t == nil {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		thread = *t
+		branch, err := s.DB.ListThreadBranch(ctx, *share.ThreadID, share.UserID)
+		if err != nil {
+			http.Error(w, `{"error":"share failed"}`, http.StatusInternalServerError)
+			return
+		}
+		for _, j := range branch {
+			if j.Status == "completed" {
+				jobs = append(jobs, j.Job)
+			}
+		}
+	} else {
+		job, err := s.DB.GetJobForUser(ctx, *share.JobID, share.UserID)
+		if err != nil || job == nil || job.Status != "completed" {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		kind = "job"
+		if job.Name != nil {
+			thread.Title = *job.Name
+		}
+		thread.CreatedAt = job.CreatedAt
+		jobs = []store.Job{*job}
+	}
+	t := transcript.FromJobs(&thread, jobs, time.Now())
+	if !share.IncludeAttachments {
+		for i := range t.Messages {
+			t.Messages[i].Attachments = nil
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":       kind,
+		"title":      t.Title,
+		"created_at": t.CreatedAt,
+		"messages":   t.Messages,
+		"views":      share.Views,
+		"expires_at": share.ExpiresAt,
+	})
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateShareValidation(t *testing.T) {
+	s := &Server{}
+	for _, body := range []string{
+		`{}`,
+		`{"thread_id":"` + strings.Repeat("a", 8) + `","job_id":"x"}`,
+		`{"thread_id":"00000000-0000-0000-0000-000000000001","expires_in_days":0}`,
+		`{"job_id":"00000000-0000-0000-0000-000000000001","expires_in_days":400}`,
+		`{"thread_id":"nope"}`,
+	} {
+		rec := httptest.NewRecorder()
+		s.createShare(rec, httptest.NewRequest(http.MethodPost, "/api/shares", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", body, rec.Code)
+		}
+	}
+}
+
+func TestViewShareIsPublicAndUncached(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&Server{}).Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/s/short", nil))
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("got %d cache-control %q", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	tok, err := newShareToken()
+	if err != nil || len(tok) != 22 || strings.ContainsAny(tok, "+/=") {
+		t.Fatalf("token %q %v", tok, err)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"flipo5/backend/internal/events"
+)
+
+func TestLastEventID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/jobs/x/stream", nil)
+	r.Header.Set("Last-Event-ID", "1712345678901-3")
+	if got := lastEventID(r); got != "1712345678901-3" {
+		t.Fatalf("header: got %q", got)
+	}
+	r = httptest.NewRequest("GET", "/api/jobs/x/stream?last_event_id=1712345678901-0", nil)
+	if got := lastEventID(r); got != "1712345678901-0" {
+		t.Fatalf("query: got %q", got)
+	}
+	for _, bad := range []string{"", "$", "0", "12-", "-1", "12-3-4", "12-x", "+"} {
+		r = httptest.NewRequest("GET", "/api/jobs/x/stream", nil)
+		r.Header.Set("Last-Event-ID", bad)
+		if got := lastEventID(r); got != "" {
+			t.Errorf("%q: got %q", bad, got)
+		}
+	}
+}
+
+func TestJobListUpdate(t *testing.T) {
+	job := uuid.New()
+	got := jobListUpdate(events.New(events.JobStatus{JobID: job, JobType: "image", Status: "completed"}).Encode())
+	b, _ := json.Marshal(got)
+	if want := fmt.Sprintf(`{"event":"job.status","jobId":"%s","status":"completed","type":"image"}`, job); string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+	got = jobListUpdate(events.New(events.JobProgress{JobID: job, Percent: 45, Step: 9, Steps: 20}).Encode())
+	b, _ = json.Marshal(got)
+	if want := fmt.Sprintf(`{"event":"job.progress","jobId":"%[1]s","progress":{"job_id":"%[1]s","percent":45,"step":9,"steps":20},"status":"running"}`, job); string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+	if got := jobListUpdate(events.New(events.JobDelta{JobID: job, Text: "x"}).Encode()); got != nil {
+		t.Fatalf("delta must not reach the job list: %v", got)
+	}
+	if got := jobListUpdate(`{"jobId":"x","status":"running"}`); got != nil {
+		t.Fatalf("unversioned message: %v", got)
+	}
+}
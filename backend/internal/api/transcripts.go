@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/store"
+	"flipo5/backend/internal/transcript"
+)
+
+const (
+	maxImportBytes    = 64 << 20 // ChatGPT exports of long-time users are large
+	maxImportThreads  = 1000
+	maxImportMessages = 2000 // per thread
+)
+
+var exportTypes = map[string]string{
+	"md":   "text/markdown; charset=utf-8",
+	"json": "application/json",
+	"html": "text/html; charset=utf-8",
+	"pdf":  "application/pdf",
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^\p{L}\p{N}._-]+`)
+
+// exportThread downloads the thread's active branch: GET /api/threads/{id}/export?format=md|json|html|pdf
+// (default md). Every job is included: chat text, image and video URLs, attachments and timestamps.
+func (s *Server) exportThread(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "md"
+	}
+	contentType, ok := exportTypes[format]
+	if !ok {
+		http.Error(w, `{"error":"format must be md, json, html or pdf"}`, http.StatusBadRequest)
+		return
+	}
+	userID, _ := middleware.UserID(r.Context())
+	ctx := r.Context()
+	thread, err := s.DB.GetThreadForUser(ctx, id, userID)
+	if err != nil || thread == nil {
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+		return
+	}
+	branch, err := s.DB.ListThreadBranch(ctx, id, userID)
+	if err != nil {
+		http.Error(w, `{"error":"list jobs"}`, http.StatusInternalServerError)
+		return
+	}
+	jobs := make([]store.Job, len(branch))
+	for i, j := range branch {
+		jobs[i] = j.Job
+	}
+	t := transcript.FromJobs(thread, jobs, time.Now())
+	var body []byte
+	switch format {
+	case "json":
+		body, _ = json.MarshalIndent(t, "", "  ")
+	case "html":
+		body = transcript.HTML(t)
+	case "pdf":
+		body = transcript.PDF(t)
+	default:
+		body = transcript.Markdown(t)
+	}
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(thread.Title, "-"), "-")
+	if name == "" {
+		name = "conversation"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	w.Write(body)
+}
+
+// importThreads recreates threads from an export: POST /api/threads/import with the file as the body or as
+// the "file" field of a multipart form. Accepts our JSON export (one thread or a list) and ChatGPT's
+// conversations.json. Completed chat messages become completed chat jobs; everything else is skipped.
+func (s *Server) importThreads(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	defer r.Body.Close()
+	var src io.Reader = r.Body
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "multipart/form-data" {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, `{"error":"file required"}`, http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		src = f
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		http.Error(w, `{"error":"file too large or unreadable"}`, http.StatusRequestEntityTooLarge)
+		return
+	}
+	threads, source, err := transcript.Parse(data)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	userID, _ := middleware.UserID(r.Context())
+	ctx := r.Context()
+	type imported struct {
+		ID       uuid.UUID `json:"id"`
+		Title    string    `json:"title"`
+		Messages int       `json:"messages"`
+	}
+	out := []imported{}
+	skipped := 0
+	if len(threads) > maxImportThreads {
+		skipped = len(threads) - maxImportThreads
+		threads = threads[:maxImportThreads]
+	}
+	for _, t := range threads {
+		msgs := t.Messages
+		if len(msgs) > maxImportMessages {
+			msgs = msgs[len(msgs)-maxImportMessages:]
+		}
+		chats := make([]store.ImportedChat, len(msgs))
+		for i, m := range msgs {
+			at, _ := transcript.Time(m.CreatedAt)
+			chats[i] = store.ImportedChat{Prompt: m.Prompt, Output: m.Output, Attachments: m.Attachments, CreatedAt: at}
+		}
+		createdAt, _ := transcript.Time(t.CreatedAt)
+		threadID, err := s.DB.ImportThread(ctx, userID, t.Title, source, createdAt, chats)
+		if err != nil {
+			log.Printf("import thread %q: %v", t.Title, err)
+			skipped++
+			continue
+		}
+		out = append(out, imported{ID: threadID, Title: t.Title, Messages: len(chats)})
+	}
+	if len(out) > 0 {
+		s.invalidateThreadCache(ctx, out[0].ID, userID) // clears the thread lists
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"threads": out, "imported": len(out), "skipped": skipped, "source": source})
+}
@@ -0,0 +1,424 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"flipo5/backend/internal/auth"
+	"flipo5/backend/internal/extract"
+	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/queue"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+)
+
+// v1Route is one operation of the public /v1 API. The router and the OpenAPI document are both built from
+// v1Routes, so a route cannot be served without being documented (see TestV1RoutesDocumented).
+type v1Route struct {
+	Method   string
+	Path     string // chi pattern, e.g. /v1/jobs/{id}
+	ID       string // OpenAPI operationId
+	Summary  string
+	Scope    string // API key scope (auth.Scope*); "" = public, no authentication
+	Query    []v1Query
+	Request  interface{} // zero value of the JSON request body; nil = no body
+	Response interface{} // zero value of the success body
+	Status   int         // success status
+	Errors   []int       // error statuses besides the defaults (401/403 for authenticated routes, 400 with a body)
+	Handler  func(*Server, http.ResponseWriter, *http.Request)
+}
+
+type v1Query struct {
+	Name, Type, Doc string
+}
+
+func v1Routes() []v1Route {
+	return []v1Route{
+		{Method: "GET", Path: "/v1/openapi.json", ID: "getOpenAPI", Summary: "This OpenAPI document",
+			Response: map[string]interface{}{}, Status: http.StatusOK, Handler: (*Server).v1OpenAPI},
+		{Method: "GET", Path: "/v1/me", ID: "getMe", Summary: "Current user", Scope: auth.ScopeRead,
+			Response: Me{}, Status: http.StatusOK, Handler: (*Server).v1Me},
+		{Method: "GET", Path: "/v1/usage", ID: "getUsage", Summary: "Plan quota consumption for the current day and month", Scope: auth.ScopeRead,
+			Response: Usage{}, Status: http.StatusOK, Handler: (*Server).v1Usage},
+		{Method: "GET", Path: "/v1/threads", ID: "listThreads", Summary: "Recent chat threads", Scope: auth.ScopeRead,
+			Query:    []v1Query{{Name: "limit", Type: "integer", Doc: "1-100, default 50"}},
+			Response: ThreadList{}, Status: http.StatusOK, Handler: (*Server).v1ListThreads},
+		{Method: "GET", Path: "/v1/jobs", ID: "listJobs", Summary: "Recent jobs, newest first", Scope: auth.ScopeRead,
+			Query:    []v1Query{{Name: "limit", Type: "integer", Doc: "1-100, default 50"}},
+			Response: JobList{}, Status: http.StatusOK, Handler: (*Server).v1ListJobs},
+		{Method: "GET", Path: "/v1/jobs/{id}", ID: "getJob", Summary: "Job status and output", Scope: auth.ScopeRead,
+			Response: Job{}, Status: http.StatusOK, Errors: []int{http.StatusNotFound}, Handler: (*Server).v1GetJob},
+		{Method: "POST", Path: "/v1/jobs/{id}/cancel", ID: "cancelJob", Summary: "Cancel a pending or running job", Scope: auth.ScopeWrite,
+			Response: Job{}, Status: http.StatusOK, Errors: []int{http.StatusNotFound, http.StatusConflict}, Handler: (*Server).v1CancelJob},
+		{Method: "POST", Path: "/v1/chat", ID: "createChat", Summary: "Send a chat message", Scope: auth.ScopeChat,
+			Request: ChatRequest{}, Response: JobCreated{}, Status: http.StatusAccepted, Errors: jobCreateErrors, Handler: (*Server).v1CreateChat},
+		{Method: "POST", Path: "/v1/images", ID: "createImage", Summary: "Generate images", Scope: auth.ScopeImage,
+			Request: ImageRequest{}, Response: JobCreated{}, Status: http.StatusAccepted, Errors: jobCreateErrors, Handler: (*Server).v1CreateImage},
+		{Method: "POST", Path: "/v1/videos", ID: "createVideo", Summary: "Generate a video", Scope: auth.ScopeVideo,
+			Request: VideoRequest{}, Response: JobCreated{}, Status: http.StatusAccepted, Errors: jobCreateErrors, Handler: (*Server).v1CreateVideo},
+		{Method: "POST", Path: "/v1/upscales", ID: "createUpscale", Summary: "Upscale an image", Scope: auth.ScopeImage,
+			Request: UpscaleRequest{}, Response: JobCreated{}, Status: http.StatusAccepted, Errors: jobCreateErrors, Handler: (*Server).v1CreateUpscale},
+	}
+}
+
+// jobCreateErrors: quota (402 monthly, 429 daily) and credits (402).
+var jobCreateErrors = []int{http.StatusPaymentRequired, http.StatusTooManyRequests}
+
+// v1Router serves v1Routes: public ones directly, the rest behind the same auth as /api.
+func (s *Server) v1Router() http.Handler {
+	r := chi.NewRouter()
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeV1Error(w, http.StatusNotFound, "not_found", "no such endpoint")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeV1Error(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+	})
+	handle := func(r chi.Router, rt v1Route) {
+		h := rt.Handler
+		r.MethodFunc(rt.Method, strings.TrimPrefix(rt.Path, "/v1"), func(w http.ResponseWriter, req *http.Request) { h(s, w, req) })
+	}
+	for _, rt := range v1Routes() {
+		if rt.Scope == "" {
+			handle(r, rt)
+		}
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.SupabaseAuth(s.supabaseJWTSecret, s.jwks, s.DB))
+		r.Use(middleware.RateLimit(s.Limiter))
+		for _, rt := range v1Routes() {
+			if rt.Scope != "" {
+				handle(r, rt)
+			}
+		}
+	})
+	return r
+}
+
+func writeV1Error(w http.ResponseWriter, status int, code, message string) {
+	writeV1JSON(w, status, ErrorResponse{Error: ErrorBody{Code: code, Message: message}})
+}
+
+func writeV1Refusal(w http.ResponseWriter, ref *jobRefusal) {
+	if ref.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(ref.RetryAfter))
+	}
+	writeV1JSON(w, ref.Status, ErrorResponse{Error: ErrorBody{Code: ref.Code, Message: ref.Message, Details: ref.Details}})
+}
+
+func writeV1JSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeV1 reads a JSON body strictly (unknown fields are an error) and validates it.
+func decodeV1(w http.ResponseWriter, r *http.Request, v interface{ validate() error }) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeV1Error(w, http.StatusBadRequest, "invalid_request", "invalid JSON body: "+err.Error())
+		return false
+	}
+	if err := v.validate(); err != nil {
+		writeV1Error(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return false
+	}
+	return true
+}
+
+func v1Limit(r *http.Request) int {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	return limit
+}
+
+// uploadURL turns an uploads/ storage key into its public URL; other values are returned unchanged.
+func (s *Server) uploadURL(u string) string {
+	if strings.HasPrefix(u, "uploads/") && s.Store != nil {
+		return s.Store.URL(u)
+	}
+	return u
+}
+
+func (s *Server) v1OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON())
+}
+
+func (s *Server) v1Me(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	u, err := s.DB.UserByID(r.Context(), userID)
+	if err != nil || u == nil {
+		writeV1Error(w, http.StatusNotFound, "not_found", "user not found")
+		return
+	}
+	credits, _ := s.DB.GetCreditBalance(r.Context(), userID)
+	plan := u.Plan
+	if s.Plans != nil {
+		plan, _ = s.Plans.For(u.Plan)
+	} else if plan == "" {
+		plan = "free"
+	}
+	writeV1JSON(w, http.StatusOK, Me{ID: u.ID, Email: u.Email, Plan: plan, CreditCents: credits, CreatedAt: u.CreatedAt})
+}
+
+func (s *Server) v1Usage(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	if s.Plans == nil {
+		writeV1Error(w, http.StatusNotFound, "not_found", "quotas not configured")
+		return
+	}
+	usage, err := s.usageSummary(r.Context(), userID)
+	if err != nil {
+		writeV1Error(w, http.StatusInternalServerError, "internal", "usage failed")
+		return
+	}
+	writeV1JSON(w, http.StatusOK, usage)
+}
+
+func (s *Server) v1ListThreads(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	threads, err := s.DB.ListThreads(r.Context(), userID, v1Limit(r), false)
+	if err != nil {
+		writeV1Error(w, http.StatusInternalServerError, "internal", "list threads failed")
+		return
+	}
+	out := ThreadList{Threads: make([]Thread, 0, len(threads))}
+	for _, t := range threads {
+		out.Threads = append(out.Threads, Thread{ID: t.ID, Title: t.Title, CreatedAt: t.CreatedAt, UpdatedAt: t.UpdatedAt})
+	}
+	writeV1JSON(w, http.StatusOK, out)
+}
+
+func (s *Server) v1ListJobs(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	jobs, err := s.DB.ListJobs(r.Context(), userID, v1Limit(r))
+	if err != nil {
+		writeV1Error(w, http.StatusInternalServerError, "internal", "list jobs failed")
+		return
+	}
+	out := JobList{Jobs: make([]Job, 0, len(jobs))}
+	for i := range jobs {
+		out.Jobs = append(out.Jobs, toJob(&jobs[i]))
+	}
+	writeV1JSON(w, http.StatusOK, out)
+}
+
+func (s *Server) v1GetJob(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeV1Error(w, http.StatusBadRequest, "invalid_request", "invalid job id")
+		return
+	}
+	job, err := s.DB.GetJobForUser(r.Context(), id, userID)
+	if err != nil || job == nil {
+		writeV1Error(w, http.StatusNotFound, "not_found", "job not found")
+		return
+	}
+	writeV1JSON(w, http.StatusOK, toJob(job))
+}
+
+func (s *Server) v1CancelJob(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeV1Error(w, http.StatusBadRequest, "invalid_request", "invalid job id")
+		return
+	}
+	job, err := s.DB.GetJobForUser(r.Context(), id, userID)
+	if err != nil || job == nil {
+		writeV1Error(w, http.StatusNotFound, "not_found", "job not found")
+		return
+	}
+	if job.Status != "pending" && job.Status != "running" {
+		writeV1Error(w, http.StatusConflict, "not_cancellable", "job already "+job.Status)
+		return
+	}
+	if job.ReplicateID != nil && *job.ReplicateID != "" && s.Repl != nil {
+		if err := s.Repl.CancelPrediction(r.Context(), *job.ReplicateID); err != nil {
+			log.Printf("v1 cancel job %s: cancel prediction %s: %v", job.ID, *job.ReplicateID, err)
+		}
+	}
+	if err := s.DB.SetJobCancelled(r.Context(), id, userID, "Cancelled by user"); err != nil {
+		writeV1Error(w, http.StatusInternalServerError, "internal", "cancel failed")
+		return
+	}
+	if job, _ = s.DB.GetJobForUser(r.Context(), id, userID); job == nil {
+		writeV1Error(w, http.StatusNotFound, "not_found", "job not found")
+		return
+	}
+	writeV1JSON(w, http.StatusOK, toJob(job))
+}
+
+// v1SubmitJob creates the job, enqueues its task and answers 202 with JobCreated.
+func (s *Server) v1SubmitJob(ctx context.Context, w http.ResponseWriter, userID uuid.UUID, jobType string, input map[string]interface{}, threadID *uuid.UUID, newTask func(uuid.UUID) (*asynq.Task, error)) {
+	jobID, ref := s.newJob(ctx, userID, jobType, input, threadID)
+	if ref != nil {
+		writeV1Refusal(w, ref)
+		return
+	}
+	s.recordUserProfile(userID, jobType, nil)
+	task, err := newTask(jobID)
+	if err == nil {
+		_, err = s.enqueue(ctx, task)
+	}
+	if err != nil {
+		writeV1Error(w, http.StatusInternalServerError, "internal", "enqueue failed")
+		return
+	}
+	if threadID != nil {
+		s.invalidateThreadCache(ctx, *threadID, userID)
+	}
+	s.invalidateContentCache(ctx, userID)
+	writeV1JSON(w, http.StatusAccepted, JobCreated{JobID: jobID, ThreadID: threadID})
+}
+
+// v1Thread resolves the request's thread (a new one when empty); false when the response is written.
+func (s *Server) v1Thread(ctx context.Context, w http.ResponseWriter, userID uuid.UUID, threadID string) (*uuid.UUID, bool) {
+	id, err := s.resolveThread(ctx, userID, threadID, false)
+	if err != nil {
+		writeV1Error(w, http.StatusInternalServerError, "internal", "create thread failed")
+		return nil, false
+	}
+	return id, true
+}
+
+func (s *Server) v1CreateChat(w http.ResponseWriter, r *http.Request) {
+	var req ChatRequest
+	if !decodeV1(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	userID, _ := middleware.UserID(ctx)
+	threadID, ok := s.v1Thread(ctx, w, userID, req.ThreadID)
+	if !ok {
+		return
+	}
+	input := s.v1ChatInput(req)
+	setKeyScopes(ctx, input)
+	s.v1SubmitJob(ctx, w, userID, "chat", input, threadID, func(id uuid.UUID) (*asynq.Task, error) {
+		return queue.NewChatTask(id, req.Prompt)
+	})
+}
+
+// v1ChatInput is the chat job input of a request, shaped like /api/chat's: attachment_content_types is
+// parallel to attachment_urls, and entries the client left out are taken from the file extension.
+// Non-image attachments are read as documents by the worker.
+func (s *Server) v1ChatInput(req ChatRequest) map[string]interface{} {
+	input := map[string]interface{}{"prompt": req.Prompt}
+	if len(req.AttachmentURLs) == 0 {
+		return input
+	}
+	urls := make([]string, len(req.AttachmentURLs))
+	types := make([]string, len(req.AttachmentURLs))
+	for i, u := range req.AttachmentURLs {
+		urls[i] = s.uploadURL(u)
+		if i < len(req.AttachmentContentTypes) {
+			types[i] = strings.TrimSpace(req.AttachmentContentTypes[i])
+		}
+		if types[i] == "" {
+			types[i] = attachmentContentType(u)
+		}
+	}
+	input["attachment_urls"] = urls
+	input["attachment_content_types"] = types
+	return input
+}
+
+// attachmentContentType guesses a content type from the URL's file extension; "" when unknown, which the
+// worker treats as an image. Documents the system has no type for (e.g. .docx) are octet-stream: the worker
+// recognizes them by name (extract.Kind).
+func attachmentContentType(u string) string {
+	p := u
+	if parsed, err := url.Parse(u); err == nil {
+		p = parsed.Path
+	}
+	ct := mime.TypeByExtension(strings.ToLower(path.Ext(p)))
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	if ct == "" && extract.Kind("", p) != "" {
+		ct = "application/octet-stream"
+	}
+	return ct
+}
+
+func (s *Server) v1CreateImage(w http.ResponseWriter, r *http.Request) {
+	var req ImageRequest
+	if !decodeV1(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	userID, _ := middleware.UserID(ctx)
+	threadID, ok := s.v1Thread(ctx, w, userID, req.ThreadID)
+	if !ok {
+		return
+	}
+	input := map[string]interface{}{
+		"prompt":                      req.Prompt,
+		"size":                        req.Size,
+		"aspect_ratio":                req.AspectRatio,
+		"max_images":                  req.MaxImages,
+		"sequential_image_generation": "auto",
+	}
+	if len(req.ImageInput) > 0 {
+		urls := make([]string, len(req.ImageInput))
+		for i, u := range req.ImageInput {
+			urls[i] = s.uploadURL(u)
+		}
+		input["image_input"] = urls
+	}
+	s.v1SubmitJob(ctx, w, userID, "image", input, threadID, queue.NewImageTask)
+}
+
+func (s *Server) v1CreateVideo(w http.ResponseWriter, r *http.Request) {
+	var req VideoRequest
+	if !decodeV1(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	userID, _ := middleware.UserID(ctx)
+	threadID, ok := s.v1Thread(ctx, w, userID, req.ThreadID)
+	if !ok {
+		return
+	}
+	input := map[string]interface{}{
+		"prompt":       req.Prompt,
+		"duration":     req.Duration,
+		"aspect_ratio": req.AspectRatio,
+		"resolution":   req.Resolution,
+		"video_model":  "1",
+	}
+	if req.Image != "" {
+		input["image"] = s.uploadURL(req.Image)
+	}
+	s.v1SubmitJob(ctx, w, userID, "video", input, threadID, queue.NewVideoTask)
+}
+
+func (s *Server) v1CreateUpscale(w http.ResponseWriter, r *http.Request) {
+	var req UpscaleRequest
+	if !decodeV1(w, r, &req) {
+		return
+	}
+	imageURL := s.uploadURL(req.ImageURL)
+	if !strings.HasPrefix(imageURL, "https://") {
+		writeV1Error(w, http.StatusBadRequest, "invalid_request", "uploads/ keys need public storage (S3_PUBLIC_URL)")
+		return
+	}
+	ctx := r.Context()
+	userID, _ := middleware.UserID(ctx)
+	input := map[string]interface{}{"image_url": imageURL, "scale": req.Scale}
+	s.v1SubmitJob(ctx, w, userID, "upscale", input, nil, queue.NewUpscaleTask)
+}
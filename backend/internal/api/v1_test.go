@@ -0,0 +1,353 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"flipo5/backend/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func openAPIDoc(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPIJSON(), &doc); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return doc
+}
+
+// Every /v1 route the router serves is in the OpenAPI document, and every documented operation is served.
+func TestV1RoutesDocumented(t *testing.T) {
+	served := map[string]bool{}
+	err := chi.Walk((&Server{}).Routes().(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/v1/") {
+			served[method+" "+route] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := map[string]bool{}
+	for path, item := range openAPIDoc(t)["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	if len(served) == 0 {
+		t.Fatal("no /v1 routes registered")
+	}
+	for op := range served {
+		if !documented[op] {
+			t.Errorf("%s is served but not in openapi.json", op)
+		}
+	}
+	for op := range documented {
+		if !served[op] {
+			t.Errorf("%s is documented but not served", op)
+		}
+	}
+}
+
+// The scope a route documents is the one the auth middleware enforces for API keys.
+func TestV1RouteScopes(t *testing.T) {
+	for _, rt := range v1Routes() {
+		if rt.Scope == "" {
+			continue
+		}
+		path := pathParamRe.ReplaceAllString(rt.Path, uuid.Nil.String())
+		scope, ok := middleware.RequiredScope(rt.Method, path)
+		if !ok || scope != rt.Scope {
+			t.Errorf("%s %s: documented scope %q, middleware requires %q (ok=%v)", rt.Method, rt.Path, rt.Scope, scope, ok)
+		}
+	}
+}
+
+func TestV1SchemasMatchTypes(t *testing.T) {
+	doc := openAPIDoc(t)
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	// all $refs resolve
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if ref != "#/components/responses/Error" && schemas[name] == nil {
+					t.Errorf("unresolved $ref %s", ref)
+				}
+			}
+			for _, c := range v {
+				walk(c)
+			}
+		case []interface{}:
+			for _, c := range v {
+				walk(c)
+			}
+		}
+	}
+	walk(doc)
+
+	// a fully populated value of each body type validates against its generated schema
+	gen := &schemaGen{schemas: map[string]interface{}{}}
+	types := []interface{}{ErrorResponse{}}
+	for _, rt := range v1Routes() {
+		types = append(types, rt.Response)
+		if rt.Request != nil {
+			types = append(types, rt.Request)
+		}
+	}
+	for _, v := range types {
+		typ := reflect.TypeOf(v)
+		raw, err := json.Marshal(sample(typ).Interface())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded interface{}
+		json.Unmarshal(raw, &decoded)
+		schema := roundTrip(gen.schema(typ))
+		if err := validateSchema(decoded, schema, roundTrip(gen.schemas)); err != nil {
+			t.Errorf("%s: %v\n%s", typ, err, raw)
+		}
+	}
+}
+
+func TestV1ErrorEnvelope(t *testing.T) {
+	h := (&Server{}).Routes()
+	cases := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{"GET", "/v1/jobs", http.StatusUnauthorized, "unauthorized"},
+		{"POST", "/v1/chat", http.StatusUnauthorized, "unauthorized"},
+		{"GET", "/v1/does-not-exist", http.StatusNotFound, "not_found"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(c.method, c.path, nil))
+		assertV1Error(t, rec, c.status, c.code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/openapi.json", nil))
+	if rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+		t.Errorf("GET /v1/openapi.json: %d", rec.Code)
+	}
+}
+
+func TestV1InvalidRequest(t *testing.T) {
+	bodies := []string{
+		`not json`,
+		`{"prompt":"a cat","bogus":1}`,
+		`{"prompt":"  "}`,
+		`{"prompt":"a cat","size":"8K"}`,
+		`{"prompt":"a cat","max_images":16}`,
+	}
+	for _, body := range bodies {
+		rec := httptest.NewRecorder()
+		(&Server{}).v1CreateImage(rec, httptest.NewRequest("POST", "/v1/images", strings.NewReader(body)))
+		assertV1Error(t, rec, http.StatusBadRequest, "invalid_request")
+	}
+}
+
+func TestV1RequestDefaults(t *testing.T) {
+	img := ImageRequest{Prompt: "a cat"}
+	if err := img.validate(); err != nil || img.Size != "2K" || img.MaxImages != 4 || img.AspectRatio != "match_input_image" {
+		t.Errorf("image defaults: %+v, %v", img, err)
+	}
+	vid := VideoRequest{Prompt: "waves"}
+	if err := vid.validate(); err != nil || vid.Duration != 5 || vid.Resolution != "720p" {
+		t.Errorf("video defaults: %+v, %v", vid, err)
+	}
+	up := UpscaleRequest{ImageURL: "http://example.com/a.png"}
+	if err := up.validate(); err == nil {
+		t.Error("upscale accepted a plain http url")
+	}
+}
+
+// A /v1/chat document attachment reaches the worker with its content type, as from /api/chat.
+func TestV1ChatDocumentAttachment(t *testing.T) {
+	body := `{"prompt":"summarize","attachment_urls":["https://cdn.example.com/uploads/u/report.pdf?v=2","https://cdn.example.com/a.png","https://cdn.example.com/notes.docx","https://cdn.example.com/photo"],"attachment_content_types":["","image/png"]}`
+	var req ChatRequest
+	rec := httptest.NewRecorder()
+	if !decodeV1(rec, httptest.NewRequest("POST", "/v1/chat", strings.NewReader(body)), &req) {
+		t.Fatalf("decode: %s", rec.Body)
+	}
+	input := (&Server{}).v1ChatInput(req)
+	got, _ := input["attachment_content_types"].([]string)
+	if len(got) != 4 || got[0] != "application/pdf" || got[1] != "image/png" || got[3] != "" {
+		t.Fatalf("attachment_content_types = %q", got)
+	}
+	// .docx is octet-stream unless the system's mime table knows it; either way it is read as a document
+	if got[2] == "" || strings.HasPrefix(got[2], "image/") {
+		t.Errorf("docx attachment typed %q", got[2])
+	}
+	if urls, _ := input["attachment_urls"].([]string); len(urls) != 4 {
+		t.Errorf("attachment_urls = %q", urls)
+	}
+
+	rec = httptest.NewRecorder()
+	tooMany := `{"prompt":"x","attachment_urls":["https://cdn.example.com/a.pdf"],"attachment_content_types":["application/pdf","image/png"]}`
+	(&Server{}).v1CreateChat(rec, httptest.NewRequest("POST", "/v1/chat", strings.NewReader(tooMany)))
+	assertV1Error(t, rec, http.StatusBadRequest, "invalid_request")
+}
+
+func assertV1Error(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var body ErrorResponse
+	if rec.Code != status {
+		t.Errorf("status %d, want %d: %s", rec.Code, status, rec.Body)
+		return
+	}
+	dec := json.NewDecoder(rec.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil || body.Error.Code != code || body.Error.Message == "" {
+		t.Errorf("want error envelope with code %q, got %+v (%v)", code, body, err)
+	}
+}
+
+// sample returns a value of t with every field, slice element, map entry and pointer filled in.
+func sample(t reflect.Type) reflect.Value {
+	if t == rawMessageType {
+		return reflect.ValueOf(json.RawMessage(`{"output":"x"}`))
+	}
+	if t == uuidType {
+		return reflect.ValueOf(uuid.New())
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(t.Elem())
+		p.Elem().Set(sample(t.Elem()))
+		v.Set(p)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Slice:
+		v.Set(reflect.Append(reflect.MakeSlice(t, 0, 1), sample(t.Elem())))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		v.SetMapIndex(sample(t.Key()), sample(t.Elem()))
+	case reflect.Interface:
+		v.Set(reflect.ValueOf("x"))
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				f := sample(t.Field(i).Type)
+				if enum := t.Field(i).Tag.Get("enum"); enum != "" {
+					f = sampleEnum(t.Field(i).Type, enum)
+				}
+				v.Field(i).Set(f)
+			}
+		}
+	}
+	return v
+}
+
+func sampleEnum(t reflect.Type, list string) reflect.Value {
+	first := enumValues(t, list)[0]
+	return reflect.ValueOf(first).Convert(t)
+}
+
+func roundTrip(v interface{}) map[string]interface{} {
+	raw, _ := json.Marshal(v)
+	var out map[string]interface{}
+	json.Unmarshal(raw, &out)
+	return out
+}
+
+// validateSchema checks a decoded JSON value against the subset of JSON Schema the generator emits.
+func validateSchema(v interface{}, schema, schemas map[string]interface{}) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		return validateSchema(v, schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{}), schemas)
+	}
+	if v == nil && schema["nullable"] == true {
+		return nil
+	}
+	for _, sub := range asSlice(schema["allOf"]) {
+		if err := validateSchema(v, sub.(map[string]interface{}), schemas); err != nil {
+			return err
+		}
+	}
+	if enum := asSlice(schema["enum"]); enum != nil {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, v)
+		}
+		if !found {
+			return fmt.Errorf("%v not in enum %v", v, enum)
+		}
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("want object, got %T", v)
+		}
+		for _, name := range asSlice(schema["required"]) {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("missing required %q", name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub, ok := props[k].(map[string]interface{})
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("undocumented property %q", k)
+				}
+				sub, _ = schema["additionalProperties"].(map[string]interface{})
+			}
+			if err := validateSchema(obj[k], sub, schemas); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("want array, got %T", v)
+		}
+		for i, item := range arr {
+			if err := validateSchema(item, schema["items"].(map[string]interface{}), schemas); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("want string, got %T", v)
+		}
+	case "integer", "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("want number, got %T", v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("want boolean, got %T", v)
+		}
+	}
+	return nil
+}
+
+func asSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
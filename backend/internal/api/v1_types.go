@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"flipo5/backend/internal/store"
+
+	"github.com/google/uuid"
+)
+
+// Request and response bodies of the /v1 API. The OpenAPI document is generated from these types:
+// json tags name the fields (omitempty = optional), `doc` describes them and `enum` lists allowed values.
+
+// ErrorResponse is the body of every non-2xx /v1 response.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    string                 `json:"code" doc:"Stable machine-readable code, e.g. invalid_request, not_found, quota_exceeded"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty" doc:"Extra fields for quota and credit errors (limit, used, remaining, resets_at, ...)"`
+}
+
+type ChatRequest struct {
+	Prompt                 string   `json:"prompt"`
+	ThreadID               string   `json:"thread_id,omitempty" doc:"Continue this thread; a new thread is created when empty"`
+	AttachmentURLs         []string `json:"attachment_urls,omitempty" doc:"Image or document URLs, or uploads/ keys, sent with the message"`
+	AttachmentContentTypes []string `json:"attachment_content_types,omitempty" doc:"Content type of each attachment, e.g. image/jpeg or application/pdf; taken from the file extension when missing"`
+}
+
+type ImageRequest struct {
+	Prompt      string   `json:"prompt"`
+	ThreadID    string   `json:"thread_id,omitempty"`
+	Size        string   `json:"size,omitempty" enum:"2K,4K,HD" doc:"Default 2K; HD uses the edit model"`
+	AspectRatio string   `json:"aspect_ratio,omitempty" doc:"e.g. 16:9; default match_input_image"`
+	MaxImages   int      `json:"max_images,omitempty" doc:"1-15, default 4"`
+	ImageInput  []string `json:"image_input,omitempty" doc:"Reference image URLs or uploads/ keys (max 14)"`
+}
+
+type VideoRequest struct {
+	Prompt      string `json:"prompt"`
+	ThreadID    string `json:"thread_id,omitempty"`
+	Duration    int    `json:"duration,omitempty" doc:"Seconds, 1-15, default 5"`
+	AspectRatio string `json:"aspect_ratio,omitempty" doc:"Default 16:9"`
+	Resolution  string `json:"resolution,omitempty" enum:"720p,480p" doc:"Default 720p"`
+	Image       string `json:"image,omitempty" doc:"Start image URL or uploads/ key"`
+}
+
+type UpscaleRequest struct {
+	ImageURL string `json:"image_url" doc:"https URL or uploads/ key"`
+	Scale    int    `json:"scale,omitempty" enum:"2,4" doc:"Default 2"`
+}
+
+// JobCreated is returned (202) by the job-creating endpoints; poll GET /v1/jobs/{id} for the result.
+type JobCreated struct {
+	JobID    uuid.UUID  `json:"job_id"`
+	ThreadID *uuid.UUID `json:"thread_id,omitempty"`
+}
+
+type Job struct {
+	ID        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	Status    string          `json:"status" enum:"pending,running,completed,failed,cancelled"`
+	ThreadID  *uuid.UUID      `json:"thread_id,omitempty"`
+	Input     json.RawMessage `json:"input" doc:"Job input as submitted"`
+	Output    json.RawMessage `json:"output" doc:"Result when completed, e.g. {\"output\": \"text\"} or {\"output\": [\"https://...\"]}"`
+	Error     *string         `json:"error,omitempty"`
+	CostCents int             `json:"cost_cents"`
+	CreatedAt string          `json:"created_at"`
+	UpdatedAt string          `json:"updated_at"`
+}
+
+type JobList struct {
+	Jobs []Job `json:"jobs"`
+}
+
+type Thread struct {
+	ID        uuid.UUID `json:"id"`
+	Title     string    `json:"title"`
+	CreatedAt string    `json:"created_at"`
+	UpdatedAt string    `json:"updated_at"`
+}
+
+type ThreadList struct {
+	Threads []Thread `json:"threads"`
+}
+
+type Me struct {
+	ID          uuid.UUID `json:"id"`
+	Email       string    `json:"email"`
+	Plan        string    `json:"plan" doc:"free, pro or business"`
+	CreditCents int       `json:"credit_cents"`
+	CreatedAt   string    `json:"created_at"`
+}
+
+// Usage is also the body of GET /api/me/usage.
+type Usage struct {
+	Plan            string                 `json:"plan"`
+	Metrics         map[string]UsageMetric `json:"metrics" doc:"By metric: chat_messages, images, video_seconds, upscales, seo_articles"`
+	DailyResetsAt   string                 `json:"daily_resets_at"`
+	MonthlyResetsAt string                 `json:"monthly_resets_at"`
+}
+
+type UsageMetric struct {
+	Daily   UsagePeriod `json:"daily"`
+	Monthly UsagePeriod `json:"monthly"`
+}
+
+type UsagePeriod struct {
+	Limit     *int `json:"limit" doc:"null = unlimited"`
+	Used      int  `json:"used"`
+	Remaining *int `json:"remaining" doc:"null = unlimited"`
+}
+
+func toJob(j *store.Job) Job {
+	return Job{
+		ID: j.ID, Type: j.Type, Status: j.Status, ThreadID: j.ThreadID, Input: j.Input, Output: j.Output,
+		Error: j.Error, CostCents: j.CostCents, CreatedAt: j.CreatedAt, UpdatedAt: j.UpdatedAt,
+	}
+}
+
+// validate checks a request and applies defaults; the error message is returned to the client as-is.
+
+func (r *ChatRequest) validate() error {
+	if strings.TrimSpace(r.Prompt) == "" {
+		return fmt.Errorf("prompt required")
+	}
+	if len(r.AttachmentContentTypes) > len(r.AttachmentURLs) {
+		return fmt.Errorf("more attachment_content_types than attachment_urls")
+	}
+	return nil
+}
+
+func (r *ImageRequest) validate() error {
+	if strings.TrimSpace(r.Prompt) == "" {
+		return fmt.Errorf("prompt required")
+	}
+	switch r.Size {
+	case "":
+		r.Size = "2K"
+	case "2K", "4K", "HD":
+	default:
+		return fmt.Errorf("size must be 2K, 4K or HD")
+	}
+	if r.AspectRatio == "" {
+		r.AspectRatio = "match_input_image"
+	}
+	if r.MaxImages == 0 {
+		r.MaxImages = 4
+	}
+	if r.MaxImages < 1 || r.MaxImages > 15 {
+		return fmt.Errorf("max_images must be 1-15")
+	}
+	if len(r.ImageInput) > 14 {
+		return fmt.Errorf("at most 14 image_input")
+	}
+	return nil
+}
+
+func (r *VideoRequest) validate() error {
+	if strings.TrimSpace(r.Prompt) == "" {
+		return fmt.Errorf("prompt required")
+	}
+	if r.Duration == 0 {
+		r.Duration = 5
+	}
+	if r.Duration < 1 || r.Duration > 15 {
+		return fmt.Errorf("duration must be 1-15")
+	}
+	switch r.Resolution {
+	case "":
+		r.Resolution = "720p"
+	case "720p", "480p":
+	default:
+		return fmt.Errorf("resolution must be 720p or 480p")
+	}
+	if r.AspectRatio == "" {
+		r.AspectRatio = "16:9"
+	}
+	return nil
+}
+
+func (r *UpscaleRequest) validate() error {
+	if r.ImageURL == "" {
+		return fmt.Errorf("image_url required")
+	}
+	if !strings.HasPrefix(r.ImageURL, "https://") && !strings.HasPrefix(r.ImageURL, "uploads/") {
+		return fmt.Errorf("image_url must be https or uploads/ key")
+	}
+	switch r.Scale {
+	case 0:
+		r.Scale = 2
+	case 2, 4:
+	default:
+		return fmt.Errorf("scale must be 2 or 4")
+	}
+	return nil
+}
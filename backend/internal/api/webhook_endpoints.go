@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"flipo5/backend/internal/middleware"
+	"flipo5/backend/internal/store"
+	"flipo5/backend/internal/webhook"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// User webhook endpoints for job events (not the Replicate callback, see webhooks.go).
+
+const maxWebhookEndpointsPerUser = 10
+
+func (s *Server) listWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	list, err := s.DB.ListWebhookEndpoints(r.Context(), userID)
+	if err != nil {
+		http.Error(w, `{"error":"list failed"}`, http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []store.WebhookEndpoint{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"endpoints": list, "events": webhook.Events})
+}
+
+// createWebhookEndpoint returns the signing secret once ("secret"). Events default to all job events.
+func (s *Server) createWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid body"}`, http.StatusBadRequest)
+		return
+	}
+	req.URL = strings.TrimSpace(req.URL)
+	if len(req.URL) > 2048 {
+		http.Error(w, `{"error":"url too long"}`, http.StatusBadRequest)
+		return
+	}
+	if err := webhook.ValidateURL(req.URL, s.webhookAllowPrivate); err != nil {
+		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = webhook.Events
+	}
+	events := make([]string, 0, len(req.Events))
+	seen := make(map[string]bool)
+	for _, e := range req.Events {
+		if !webhook.ValidEvent(e) {
+			http.Error(w, `{"error":"invalid event"}`, http.StatusBadRequest)
+			return
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+	if n, err := s.DB.CountWebhookEndpoints(r.Context(), userID); err != nil || n >= maxWebhookEndpointsPerUser {
+		http.Error(w, `{"error":"webhook endpoint limit reached"}`, http.StatusConflict)
+		return
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		http.Error(w, `{"error":"create failed"}`, http.StatusInternalServerError)
+		return
+	}
+	e, err := s.DB.CreateWebhookEndpoint(r.Context(), userID, req.URL, secret, events)
+	if err != nil {
+		http.Error(w, `{"error":"create failed"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"endpoint": e, "secret": secret})
+}
+
+func (s *Server) deleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	if err := s.DB.DeleteWebhookEndpoint(r.Context(), id, userID); err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error":"delete failed"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
+
+// listWebhookDeliveries is the endpoint's delivery log: status, attempts, last response code and error.
+func (s *Server) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	list, err := s.DB.ListWebhookDeliveries(r.Context(), id, userID, limit)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error":"list failed"}`, http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []store.WebhookDelivery{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": list})
+}
+
+// replayWebhookDelivery sends a delivery's event again (same event id) as a new delivery.
+func (s *Server) replayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.UserID(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	d, err := s.DB.ReplayWebhookDelivery(r.Context(), id, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		http.Error(w, `{"error":"replay failed"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"delivery": d})
+}
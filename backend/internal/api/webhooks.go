@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"flipo5/backend/internal/queue"
+	"flipo5/backend/internal/replicate"
+
+	"github.com/hibiken/asynq"
+)
+
+const maxWebhookBody = 1 << 20
+
+// replicateWebhook receives completed predictions from Replicate. It verifies the signature and hands the
+// prediction to the worker (finalize_prediction), which updates the job, mirrors media to R2 and publishes SSE.
+// Replicate retries on non-2xx, so only a failed enqueue returns 5xx. Log updates of running predictions
+// enqueue prediction_progress; progress is best effort, so its enqueue errors are only logged.
+func (s *Server) replicateWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, `{"error":"invalid body"}`, http.StatusBadRequest)
+		return
+	}
+	if err := replicate.VerifyWebhook(s.replicateWebhookSecret, r.Header, body, time.Now()); err != nil {
+		http.Error(w, `{"error":"invalid signature"}`, http.StatusUnauthorized)
+		return
+	}
+	var pred struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &pred); err != nil || pred.ID == "" {
+		http.Error(w, `{"error":"invalid prediction"}`, http.StatusBadRequest)
+		return
+	}
+	switch pred.Status {
+	case "succeeded", "failed", "canceled":
+		task, _ := queue.NewFinalizePredictionTask(pred.ID)
+		if _, err := s.Asynq.Enqueue(task); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			log.Printf("replicate webhook: enqueue finalize %s: %v", pred.ID, err)
+			http.Error(w, `{"error":"enqueue failed"}`, http.StatusServiceUnavailable)
+			return
+		}
+	case "starting", "processing":
+		task, _ := queue.NewPredictionProgressTask(pred.ID)
+		if _, err := s.Asynq.Enqueue(task); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			log.Printf("replicate webhook: enqueue progress %s: %v", pred.ID, err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"ok":true}`))
+}
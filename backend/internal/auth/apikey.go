@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix starts every personal API key, so the middleware can tell keys from Supabase JWTs.
+const APIKeyPrefix = "fl5_"
+
+// API key scopes. A key may only call the routes its scopes allow (see middleware.RequiredScope).
+const (
+	ScopeRead  = "read"  // GET endpoints: jobs, threads, content, streams
+	ScopeChat  = "chat"  // POST /api/chat, regenerate/edit, thread import
+	ScopeImage = "image" // image, inpaint, logo, upscale
+	ScopeVideo = "video" // POST /api/video
+	ScopeText  = "text"  // SEO, outline, translate, product text jobs
+	ScopeWrite = "write" // other changes: threads, files, projects, cancel/retry (retry also needs the job's scope)
+)
+
+var APIKeyScopes = []string{ScopeRead, ScopeChat, ScopeImage, ScopeVideo, ScopeText, ScopeWrite}
+
+func ValidScope(s string) bool {
+	for _, v := range APIKeyScopes {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIKey returns a random key (shown to the user once), its display prefix and the hash to store.
+func NewAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:len(APIKeyPrefix)+8], HashAPIKey(key), nil
+}
+
+// HashAPIKey is SHA-256: keys carry 256 random bits, so a slow password hash adds nothing.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(key) || !strings.HasPrefix(key, prefix) || len(prefix) != len(APIKeyPrefix)+8 {
+		t.Fatalf("key %q prefix %q", key, prefix)
+	}
+	if hash != HashAPIKey(key) || strings.Contains(hash, key) {
+		t.Fatal("hash must be deterministic and not contain the key")
+	}
+	other, _, _, _ := NewAPIKey()
+	if other == key {
+		t.Fatal("keys must be random")
+	}
+	if IsAPIKey("eyJhbGciOi.payload.sig") {
+		t.Fatal("JWT taken for an API key")
+	}
+}
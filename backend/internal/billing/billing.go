@@ -0,0 +1,157 @@
+// Package billing prices predictions and sizes the credit hold taken when a job is created.
+// Amounts are integer cents, like jobs.cost_cents and cost_ledger.amount_cents.
+package billing
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+
+	repgo "github.com/replicate/replicate-go"
+)
+
+// Price is what one prediction of a model costs the user. All parts are added up.
+type Price struct {
+	PerSecond        float64 `json:"per_second"`          // cents per second of metrics.predict_time
+	PerMInputTokens  float64 `json:"per_m_input_tokens"`  // cents per 1M input tokens
+	PerMOutputTokens float64 `json:"per_m_output_tokens"` // cents per 1M output tokens
+	PerOutput        float64 `json:"per_output"`          // cents per output file (image, video)
+}
+
+// Table maps model identifiers ("owner/name", version ignored; "openai:<name>") to prices.
+type Table struct {
+	Models  map[string]Price `json:"models"`
+	Default Price            `json:"default"` // models missing from Models
+	Reserve map[string]int   `json:"reserve"` // cents held per job type at creation, settled on completion
+}
+
+// Default is based on Replicate's public pricing for the models in config defaults.
+func Default() *Table {
+	return &Table{
+		Models: map[string]Price{
+			"meta/meta-llama-3-70b-instruct":  {PerMInputTokens: 65, PerMOutputTokens: 275},
+			"meta/meta-llama-3-8b-instruct":   {PerMInputTokens: 5, PerMOutputTokens: 25},
+			"google/gemini-2.5-flash":         {PerMInputTokens: 30, PerMOutputTokens: 250},
+			"bytedance/seedream-4.5":          {PerOutput: 4},
+			"google/nano-banana":              {PerOutput: 3.9},
+			"black-forest-labs/flux-fill-pro": {PerOutput: 5},
+			"xai/grok-imagine-video":          {PerOutput: 50},
+			"kwaivgi/kling-v2.5-turbo-pro":    {PerOutput: 35},
+			"topazlabs/image-upscale":         {PerOutput: 5},
+			"bria/remove-background":          {PerOutput: 1.8},
+		},
+		Default: Price{PerSecond: 0.14}, // ~ Nvidia L40S public hardware rate
+		Reserve: map[string]int{
+			"chat":                  2,
+			"seo":                   5,
+			"outline":               3,
+			"translate":             5,
+			"image":                 16,
+			"logo":                  12,
+			"video":                 70,
+			"upscale":               10,
+			"product_score":         3,
+			"product_description":   2,
+			"product_scene_improve": 2,
+		},
+	}
+}
+
+// Load returns Default with the JSON overrides in raw (same shape as Table) merged on top. Empty raw = Default.
+func Load(raw string) (*Table, error) {
+	t := Default()
+	if strings.TrimSpace(raw) == "" {
+		return t, nil
+	}
+	var o Table
+	if err := json.Unmarshal([]byte(raw), &o); err != nil {
+		return nil, err
+	}
+	for k, v := range o.Models {
+		t.Models[k] = v
+	}
+	for k, v := range o.Reserve {
+		t.Reserve[k] = v
+	}
+	if o.Default != (Price{}) {
+		t.Default = o.Default
+	}
+	return t, nil
+}
+
+// Price returns the price of model; "owner/name:version" uses the "owner/name" entry.
+func (t *Table) Price(model string) Price {
+	if p, ok := t.Models[model]; ok {
+		return p
+	}
+	if i := strings.Index(model, ":"); i > 0 && !strings.HasPrefix(model, "openai:") {
+		if p, ok := t.Models[model[:i]]; ok {
+			return p
+		}
+	}
+	return t.Default
+}
+
+// Cost prices one prediction from its metrics and number of output files, rounded up to whole cents.
+// A nil table (billing off) costs nothing.
+func (t *Table) Cost(model string, m *repgo.PredictionMetrics, outputs int) int {
+	if t == nil {
+		return 0
+	}
+	p := t.Price(model)
+	var c float64
+	if m != nil {
+		if m.PredictTime != nil {
+			c += *m.PredictTime * p.PerSecond
+		}
+		if m.InputTokenCount != nil {
+			c += float64(*m.InputTokenCount) * p.PerMInputTokens / 1e6
+		}
+		if m.OutputTokenCount != nil {
+			c += float64(*m.OutputTokenCount) * p.PerMOutputTokens / 1e6
+		}
+	}
+	c += float64(outputs) * p.PerOutput
+	return int(math.Ceil(c - 1e-9))
+}
+
+// PredictionCost prices a finished prediction; output files are counted only for per-output models
+// (text models stream their output as a string array).
+func (t *Table) PredictionCost(model string, pred *repgo.Prediction) int {
+	if t == nil || pred == nil {
+		return 0
+	}
+	if model == "" {
+		model = pred.Model
+	}
+	outputs := 0
+	if t.Price(model).PerOutput > 0 {
+		outputs = OutputCount(pred.Output)
+	}
+	return t.Cost(model, pred.Metrics, outputs)
+}
+
+// ReserveFor is the credit hold for a new job of jobType (0 for unknown types or a nil table).
+func (t *Table) ReserveFor(jobType string) int {
+	if t == nil {
+		return 0
+	}
+	return t.Reserve[jobType]
+}
+
+// OutputCount counts output files: a URL string, a list of URLs, or {"output": ...} as stored on jobs.
+func OutputCount(out interface{}) int {
+	switch v := out.(type) {
+	case string:
+		if v != "" {
+			return 1
+		}
+	case []interface{}:
+		return len(v)
+	case []string:
+		return len(v)
+	case map[string]interface{}:
+		return OutputCount(v["output"])
+	}
+	return 0
+}
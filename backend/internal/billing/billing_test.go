@@ -0,0 +1,57 @@
+package billing
+
+import (
+	"testing"
+
+	repgo "github.com/replicate/replicate-go"
+)
+
+func TestPredictionCost(t *testing.T) {
+	table := Default()
+	secs := 12.5
+	in, out := 2_000_000, 1_000_000
+	cases := []struct {
+		name  string
+		model string
+		pred  *repgo.Prediction
+		want  int
+	}{
+		{"tokens", "meta/meta-llama-3-70b-instruct", &repgo.Prediction{
+			Output:  []interface{}{"a", "b", "c"}, // streamed text is not counted as files
+			Metrics: &repgo.PredictionMetrics{InputTokenCount: &in, OutputTokenCount: &out},
+		}, 130 + 275},
+		{"per image, version pinned", "bytedance/seedream-4.5:abc123", &repgo.Prediction{
+			Output: []interface{}{"https://x/1.jpg", "https://x/2.jpg", "https://x/3.jpg"},
+		}, 12},
+		{"fractional cents round up", "google/nano-banana", &repgo.Prediction{Output: "https://x/1.jpg"}, 4},
+		{"unknown model by predict time", "acme/custom", &repgo.Prediction{
+			Metrics: &repgo.PredictionMetrics{PredictTime: &secs},
+		}, 2},
+		{"model from prediction", "", &repgo.Prediction{Model: "topazlabs/image-upscale", Output: map[string]interface{}{"output": "https://x/up.jpg"}}, 5},
+	}
+	for _, c := range cases {
+		if got := table.PredictionCost(c.model, c.pred); got != c.want {
+			t.Errorf("%s: got %d cents, want %d", c.name, got, c.want)
+		}
+	}
+	var off *Table
+	if off.PredictionCost("google/nano-banana", &repgo.Prediction{Output: "u"}) != 0 || off.ReserveFor("image") != 0 {
+		t.Fatal("nil table must not charge")
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	table, err := Load(`{"models":{"acme/custom":{"per_output":7}},"reserve":{"image":30}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Price("acme/custom").PerOutput != 7 || table.ReserveFor("image") != 30 || table.ReserveFor("video") != 70 {
+		t.Fatalf("overrides not merged: %+v", table)
+	}
+	if table.Price("google/nano-banana").PerOutput != 3.9 {
+		t.Fatal("defaults lost")
+	}
+	if _, err := Load(`{`); err == nil {
+		t.Fatal("expected JSON error")
+	}
+}
@@ -66,6 +66,11 @@ func (r *Redis) DeleteByPrefix(ctx context.Context, prefix string) error {
 	return nil
 }
 
+// Client is the underlying connection, for packages that need more than get/set (e.g. the rate limiter).
+func (r *Redis) Client() *redis.Client {
+	return r.client
+}
+
 func (r *Redis) Close() error {
 	return r.client.Close()
 }
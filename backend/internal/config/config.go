@@ -15,8 +15,19 @@ type Config struct {
 	Redis string
 
 	AsynqConcurrency int // worker concurrency (default 8)
+	// What this process runs, comma-separated: api (HTTP server), worker (Asynq tasks), scheduler (periodic
+	// tasks; run exactly one). Empty = all three.
+	Roles string
+	// Workloads this process's worker consumes, comma-separated (interactive, images, video, batch,
+	// maintenance); empty = all. Weights override the default weight of a workload ("video=4").
+	AsynqQueues       string
+	AsynqQueueWeights map[string]int
 
 	ReplicateToken    string
+	// Webhook-driven completion for image/video/upscale: full public URL of POST /webhooks/replicate and the
+	// signing secret ("whsec_...", GET https://api.replicate.com/v1/webhooks/default/secret). Both empty = workers poll.
+	ReplicateWebhookURL    string
+	ReplicateWebhookSecret string
 	SupabaseJWTSecret   string // legacy; used only if SupabaseURL not set
 	SupabaseURL         string // e.g. https://xxx.supabase.co — for JWKS verification (new signing keys)
 	SupabaseServiceRole string // for admin API (check-email)
@@ -40,6 +51,54 @@ type Config struct {
 	ModelRemoveBg  string // bria/remove-background for studio remove background
 	ModelUpscale   string // topazlabs/image-upscale for upscaling
 
+	// Per job type text models; empty = ModelText. "openai:<name>" routes to OpenAIBaseURL instead of Replicate.
+	ModelChat      string
+	ModelSEO       string
+	ModelTranslate string
+	// ModelFormats picks how chat conversations are sent to a model ("model=adapter,...", see ai.AdapterFor);
+	// models not listed use their family's adapter.
+	ModelFormats map[string]string
+
+	// ChatDocumentTokens is the prompt budget for text extracted from chat attachments and project files.
+	ChatDocumentTokens int
+	// ChatContextTokens is the budget for conversation history in the chat prompt (rolling summary plus recent
+	// messages); ChatContextTokensByModel overrides it per model ("model=tokens,..."), see ContextTokens.
+	ChatContextTokens        int
+	ChatContextTokensByModel map[string]int
+	// Retrieval over chat project files: EmbeddingModel is "local" (built-in hashing embedder) or
+	// "openai:<model>" on OpenAIBaseURL; EmbeddingDimensions 0 = model default; ChatRAGTopK chunks per question.
+	EmbeddingModel      string
+	EmbeddingDimensions int
+	ChatRAGTopK         int
+	// ChatTools are the tools chat replies may call (comma-separated names, see queue/tools.go; empty or
+	// "none" = no tools, the default); ChatToolSteps is how many rounds of tool calls one reply may make before it must answer.
+	ChatTools     string
+	ChatToolSteps int
+
+	// OpenAI-compatible endpoint for self-hosted text models (llama.cpp server, vLLM, Ollama), e.g. http://localhost:11434/v1
+	OpenAIBaseURL string
+	OpenAIAPIKey  string
+
+	// Billing: BillingPrices is JSON merged over billing.Default() ({"models":{...},"reserve":{...}}).
+	// Costs are always recorded; BillingCredits makes job creation hold credits and refuse with 402 when short.
+	BillingPrices  string
+	BillingCredits bool
+
+	// PlanQuotas is JSON replacing plans of quota.Default() ({"pro":{"limits":{"images":{"daily":100,"monthly":2000}}}}).
+	PlanQuotas string
+
+	// WebhookAllowPrivate lets user webhook endpoints use http and private/loopback addresses (local development only).
+	WebhookAllowPrivate bool
+
+	// AutoMigrate applies pending migrations at startup (under an advisory lock). Either way the API refuses to
+	// start while a migration is pending or failed; with AUTO_MIGRATE=false run `migrate up` before deploying.
+	AutoMigrate bool
+
+	// RateLimits is JSON merged over ratelimit.Default() ({"user":{"limit":2000,"window":"1m"},"plans":{...},"ip":{...},"routes":[...]}).
+	// TrustedProxies (comma-separated IPs/CIDRs) are the load balancers allowed to set X-Forwarded-For.
+	RateLimits     string
+	TrustedProxies string
+
 	// CORS: comma-separated origins, e.g. "http://localhost:3000,https://app.example.com". Empty = allow "*"
 	CORSOrigins string
 }
@@ -52,7 +111,12 @@ func Load() *Config {
 		PGURL:            getEnv("DATABASE_URL", "postgres://localhost/flipo5?sslmode=disable"),
 		Redis:             getEnv("REDIS_URL", "redis://localhost:6379"),
 		AsynqConcurrency:  getEnvInt("ASYNQ_CONCURRENCY", 8),
+		Roles:             getEnv("ROLES", ""),
+		AsynqQueues:       getEnv("ASYNQ_QUEUES", ""),
+		AsynqQueueWeights: getEnvIntMap("ASYNQ_QUEUE_WEIGHTS"),
 		ReplicateToken:   getEnv("REPLICATE_API_TOKEN", ""),
+		ReplicateWebhookURL:    getEnv("REPLICATE_WEBHOOK_URL", ""),
+		ReplicateWebhookSecret: getEnv("REPLICATE_WEBHOOK_SECRET", ""),
 		SupabaseJWTSecret:   getEnv("SUPABASE_JWT_SECRET", ""),
 		SupabaseURL:         strings.TrimSuffix(strings.TrimSpace(trimQuotes(getEnv("SUPABASE_URL", ""))), "/"),
 		SupabaseServiceRole: strings.TrimSpace(trimQuotes(getEnv("SUPABASE_SERVICE_ROLE_KEY", ""))),
@@ -71,8 +135,54 @@ func Load() *Config {
 		ModelVideo2:    getEnv("REPLICATE_MODEL_VIDEO_2", "kwaivgi/kling-v2.5-turbo-pro"),
 		ModelRemoveBg:  getEnv("REPLICATE_MODEL_REMOVE_BG", "bria/remove-background"),
 		ModelUpscale:   getEnv("REPLICATE_MODEL_UPSCALE", "topazlabs/image-upscale"),
-		CORSOrigins:    strings.TrimSpace(getEnv("CORS_ORIGINS", "http://localhost:3000,http://127.0.0.1:3000")),
+		ModelChat:      getEnv("MODEL_CHAT", ""),
+		ModelSEO:       getEnv("MODEL_SEO", ""),
+		ModelTranslate: getEnv("MODEL_TRANSLATE", ""),
+		ModelFormats:   getEnvMap("MODEL_FORMATS"),
+		OpenAIBaseURL:  getEnv("OPENAI_BASE_URL", ""),
+		OpenAIAPIKey:   getEnv("OPENAI_API_KEY", ""),
+		BillingPrices:  getEnv("BILLING_PRICES", ""),
+		BillingCredits: getEnvBool("BILLING_CREDITS", false),
+		PlanQuotas:     getEnv("PLAN_QUOTAS", ""),
+		WebhookAllowPrivate:      getEnvBool("WEBHOOK_ALLOW_PRIVATE", false),
+		ChatDocumentTokens:       getEnvInt("CHAT_DOCUMENT_TOKENS", 12000),
+		ChatContextTokens:        getEnvInt("CHAT_CONTEXT_TOKENS", 6000),
+		ChatContextTokensByModel: getEnvIntMap("CHAT_CONTEXT_TOKENS_BY_MODEL"),
+		EmbeddingModel:           getEnv("EMBEDDING_MODEL", "local"),
+		EmbeddingDimensions:      getEnvInt("EMBEDDING_DIMENSIONS", 0),
+		ChatRAGTopK:              getEnvInt("CHAT_RAG_TOP_K", 6),
+		ChatTools:                getEnv("CHAT_TOOLS", ""),
+		ChatToolSteps:            getEnvInt("CHAT_TOOL_STEPS", 3),
+		AutoMigrate:              getEnvBool("AUTO_MIGRATE", true),
+		RateLimits:               getEnv("RATE_LIMITS", ""),
+		TrustedProxies:           getEnv("TRUSTED_PROXIES", ""),
+		CORSOrigins:              strings.TrimSpace(getEnv("CORS_ORIGINS", "http://localhost:3000,http://127.0.0.1:3000")),
+	}
+}
+
+// TextModel returns the text model for a job type ("chat", "seo", "translate"), falling back to ModelText.
+func (c *Config) TextModel(jobType string) string {
+	var m string
+	switch jobType {
+	case "chat":
+		m = c.ModelChat
+	case "seo":
+		m = c.ModelSEO
+	case "translate":
+		m = c.ModelTranslate
 	}
+	if m == "" {
+		m = c.ModelText
+	}
+	return m
+}
+
+// ContextTokens is the conversation history budget for a chat model.
+func (c *Config) ContextTokens(model string) int {
+	if n, ok := c.ChatContextTokensByModel[model]; ok && n > 0 {
+		return n
+	}
+	return c.ChatContextTokens
 }
 
 func getEnv(k, defaultV string) string {
@@ -107,6 +217,30 @@ func getEnvInt(k string, defaultV int) int {
 	return defaultV
 }
 
+// getEnvMap parses "key=value,key2=value2"; keys may contain ':' and '/' (model identifiers).
+func getEnvMap(k string) map[string]string {
+	out := map[string]string{}
+	for _, item := range strings.Split(getEnv(k, ""), ",") {
+		i := strings.LastIndex(item, "=")
+		if i <= 0 {
+			continue
+		}
+		out[strings.TrimSpace(item[:i])] = strings.TrimSpace(item[i+1:])
+	}
+	return out
+}
+
+// getEnvIntMap is getEnvMap with integer values; bad entries are skipped.
+func getEnvIntMap(k string) map[string]int {
+	out := map[string]int{}
+	for key, v := range getEnvMap(k) {
+		if n, err := strconv.Atoi(v); err == nil {
+			out[key] = n
+		}
+	}
+	return out
+}
+
 func getEnvBool(k string, defaultV bool) bool {
 	if v := os.Getenv(k); v != "" {
 		return v == "1" || v == "true" || v == "yes"
@@ -0,0 +1,163 @@
+// Package events is the schema of the real-time messages workers publish on Redis: job events on the job's
+// stream (stream:{jobID}, replayable) and job and thread events on the user's channel (user:{id}:jobs).
+// Every message is an Event envelope with a schema version and a type; its data is the payload of that
+// type, encoded with encoding/json (never built by hand).
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Version is the envelope version this code writes. Readers reject newer versions.
+const Version = 1
+
+// Event types.
+const (
+	TypeJobStatus          = "job.status"
+	TypeJobDelta           = "job.delta"
+	TypeJobProgress        = "job.progress"
+	TypeJobOutputMirrored  = "job.output_mirrored"
+	TypeThreadTitleUpdated = "thread.title_updated"
+)
+
+// Payload is the data of an event; each event type has its own.
+type Payload interface {
+	EventType() string
+}
+
+// JobStatus is a job state change: pending, running, completed, failed or cancelled. Error is set for
+// failed jobs; ThreadID for jobs in a thread.
+type JobStatus struct {
+	JobID    uuid.UUID  `json:"job_id"`
+	JobType  string     `json:"job_type,omitempty"`
+	ThreadID *uuid.UUID `json:"thread_id,omitempty"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Terminal reports whether the job ended; it is then the last event of the job's stream.
+func (s JobStatus) Terminal() bool {
+	return s.Status == "completed" || s.Status == "failed" || s.Status == "cancelled"
+}
+
+// JobDelta is output text of a running job: appended to what came before, or the whole text so far when
+// Replace is set.
+type JobDelta struct {
+	JobID   uuid.UUID `json:"job_id"`
+	Text    string    `json:"text"`
+	Replace bool      `json:"replace,omitempty"`
+}
+
+// JobProgress is how far a running job is. What the model does not report is zero.
+type JobProgress struct {
+	JobID      uuid.UUID `json:"job_id"`
+	Percent    float64   `json:"percent,omitempty"`
+	Step       int       `json:"step,omitempty"`
+	Steps      int       `json:"steps,omitempty"`
+	ETASeconds int       `json:"eta_seconds,omitempty"`
+}
+
+// JobOutputMirrored is a completed media job whose output now points at our storage instead of the
+// provider's expiring URLs. Output is the job's new output.
+type JobOutputMirrored struct {
+	JobID  uuid.UUID       `json:"job_id"`
+	Output json.RawMessage `json:"output"`
+}
+
+// ThreadTitleUpdated is a thread's new title.
+type ThreadTitleUpdated struct {
+	ThreadID uuid.UUID `json:"thread_id"`
+	Title    string    `json:"title"`
+}
+
+func (JobStatus) EventType() string          { return TypeJobStatus }
+func (JobDelta) EventType() string           { return TypeJobDelta }
+func (JobProgress) EventType() string        { return TypeJobProgress }
+func (JobOutputMirrored) EventType() string  { return TypeJobOutputMirrored }
+func (ThreadTitleUpdated) EventType() string { return TypeThreadTitleUpdated }
+
+// Event is the envelope on the wire. ID is the entry ID in the job's stream (set once stored); Done marks
+// the last event of a job stream.
+type Event struct {
+	V    int             `json:"v"`
+	Type string          `json:"type"`
+	ID   string          `json:"id,omitempty"`
+	Done bool            `json:"done,omitempty"`
+	Data json.RawMessage `json:"data"`
+}
+
+// New wraps a payload. A terminal job status is marked Done.
+func New(p Payload) Event {
+	data, _ := json.Marshal(p) // payload types always encode
+	ev := Event{V: Version, Type: p.EventType(), Data: data}
+	if s, ok := p.(JobStatus); ok {
+		ev.Done = s.Terminal()
+	}
+	return ev
+}
+
+// Encode is the event's JSON.
+func (e Event) Encode() string {
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+var (
+	// ErrVersion is returned by Decode for an envelope newer than Version (or without one).
+	ErrVersion = errors.New("unsupported event version")
+	// ErrUnknownType is returned by Decode for an event type this code does not know.
+	ErrUnknownType = errors.New("unknown event type")
+)
+
+// Decode parses an encoded event and checks its version and type.
+func Decode(s string) (Event, error) {
+	var e Event
+	if err := json.Unmarshal([]byte(s), &e); err != nil {
+		return Event{}, err
+	}
+	if e.V < 1 || e.V > Version {
+		return Event{}, fmt.Errorf("%w: %d", ErrVersion, e.V)
+	}
+	if _, err := e.Payload(); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+// Payload decodes the event's data into the payload type of the event (a value, e.g. JobDelta).
+func (e Event) Payload() (Payload, error) {
+	var p Payload
+	var err error
+	switch e.Type {
+	case TypeJobStatus:
+		var v JobStatus
+		err = json.Unmarshal(e.Data, &v)
+		p = v
+	case TypeJobDelta:
+		var v JobDelta
+		err = json.Unmarshal(e.Data, &v)
+		p = v
+	case TypeJobProgress:
+		var v JobProgress
+		err = json.Unmarshal(e.Data, &v)
+		p = v
+	case TypeJobOutputMirrored:
+		var v JobOutputMirrored
+		err = json.Unmarshal(e.Data, &v)
+		p = v
+	case TypeThreadTitleUpdated:
+		var v ThreadTitleUpdated
+		err = json.Unmarshal(e.Data, &v)
+		p = v
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, e.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
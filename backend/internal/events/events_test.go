@@ -0,0 +1,42 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRoundTrip(t *testing.T) {
+	job := uuid.New()
+	in := JobStatus{JobID: job, JobType: "chat", Status: "failed", Error: `model said "no" \ twice`}
+	ev, err := Decode(New(in).Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.V != Version || ev.Type != TypeJobStatus || !ev.Done {
+		t.Fatalf("envelope %+v", ev)
+	}
+	p, err := ev.Payload()
+	if err != nil || p != in {
+		t.Fatalf("payload %+v %v", p, err)
+	}
+	if New(JobStatus{JobID: job, Status: "running"}).Done || New(JobDelta{JobID: job, Text: "x"}).Done {
+		t.Fatal("only a terminal status ends a job stream")
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	for in, want := range map[string]error{
+		`{"v":2,"type":"job.delta","data":{}}`:   ErrVersion,
+		`{"output":"old format"}`:                ErrVersion,
+		`{"v":1,"type":"job.unknown","data":{}}`: ErrUnknownType,
+	} {
+		if _, err := Decode(in); !errors.Is(err, want) {
+			t.Errorf("Decode(%s) = %v, want %v", in, err, want)
+		}
+	}
+	if _, err := Decode(`{"v":1,"type":"job.delta","data":"text"}`); err == nil {
+		t.Error("data of the wrong shape must not decode")
+	}
+}
@@ -0,0 +1,77 @@
+package extract
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Tokens estimates the model tokens of s: about four bytes of English or three runes of other scripts per token.
+func Tokens(s string) int {
+	if s == "" {
+		return 0
+	}
+	runes := utf8.RuneCountInString(s)
+	n := len(s) / 4
+	if r := runes / 3; runes < len(s) && r > n {
+		n = r
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// Chunks splits text into pieces of at most maxTokens (by Tokens), breaking at paragraphs, then lines,
+// then words, so a chunk only ends mid-sentence when a single paragraph is too long.
+func Chunks(text string, maxTokens int) []string {
+	if maxTokens <= 0 {
+		maxTokens = 1
+	}
+	var out []string
+	var cur strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			out = append(out, s)
+		}
+		cur.Reset()
+	}
+	add := func(piece, sep string) {
+		if cur.Len() > 0 && Tokens(cur.String()+sep+piece) > maxTokens {
+			flush()
+		}
+		if cur.Len() > 0 {
+			cur.WriteString(sep)
+		}
+		cur.WriteString(piece)
+	}
+	for _, para := range strings.Split(text, "\n\n") {
+		if Tokens(para) <= maxTokens {
+			add(para, "\n\n")
+			continue
+		}
+		flush()
+		for _, line := range strings.Split(para, "\n") {
+			if Tokens(line) <= maxTokens {
+				add(line, "\n")
+				continue
+			}
+			for _, word := range strings.Fields(line) {
+				for Tokens(word) > maxTokens { // e.g. a base64 blob
+					cut := maxTokens * 3
+					for cut > 0 && !utf8.RuneStart(word[cut]) {
+						cut--
+					}
+					if cut == 0 {
+						break
+					}
+					add(word[:cut], " ")
+					word = word[cut:]
+				}
+				add(word, " ")
+			}
+		}
+		flush()
+	}
+	flush()
+	return out
+}
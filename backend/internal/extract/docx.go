@@ -0,0 +1,94 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// docxText reads the main document part of a Word file: paragraphs become lines, tabs and breaks are kept,
+// table cells are separated by " | ".
+func docxText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("not a DOCX file: %w", err)
+	}
+	var doc *zip.File
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			doc = f
+			break
+		}
+	}
+	if doc == nil {
+		return "", fmt.Errorf("not a DOCX file: word/document.xml missing")
+	}
+	rc, err := doc.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	dec := xml.NewDecoder(io.LimitReader(rc, maxInputBytes*4))
+	var b strings.Builder
+	inText := false
+	inCell := 0
+	space := false // paragraph break inside a table cell, written before the next text
+	cellStart := true
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				b.WriteByte('\t')
+			case "br", "cr":
+				b.WriteByte('\n')
+			case "tc":
+				if !cellStart {
+					b.WriteString(" | ")
+				}
+				cellStart = false
+				space = false
+				inCell++
+			case "tr":
+				cellStart = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if inCell > 0 {
+					space = true
+				} else {
+					b.WriteByte('\n')
+				}
+			case "tc":
+				inCell--
+			case "tr":
+				b.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				if space {
+					b.WriteByte(' ')
+					space = false
+				}
+				b.Write(t)
+			}
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,206 @@
+// Package extract turns uploaded documents (PDF, DOCX, plain text/Markdown, CSV, HTML) into plain text for
+// the chat prompt, and splits that text into chunks that fit a token budget. Everything is pure Go.
+package extract
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Limits on input and output size.
+const (
+	maxInputBytes = 25 << 20 // larger files are refused
+	MaxChars      = 400_000  // extracted text is cut here (~100k tokens)
+)
+
+// Kinds of documents Text understands.
+const (
+	KindPDF  = "pdf"
+	KindDOCX = "docx"
+	KindText = "text"
+	KindCSV  = "csv"
+	KindHTML = "html"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported document type")
+	ErrTooLarge    = errors.New("document too large")
+	ErrNoText      = errors.New("no text found (scanned PDF or image-only document?)")
+)
+
+// Kind returns the document kind from the content type and file name, or "" when it is not supported.
+func Kind(contentType, name string) string {
+	ct := strings.ToLower(strings.TrimSpace(contentType))
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = strings.TrimSpace(ct[:i])
+	}
+	switch ct {
+	case "application/pdf":
+		return KindPDF
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return KindDOCX
+	case "text/csv", "text/tab-separated-values":
+		return KindCSV
+	case "text/html", "application/xhtml+xml":
+		return KindHTML
+	case "application/json", "application/xml", "text/xml", "application/x-yaml", "text/markdown":
+		return KindText
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".pdf":
+		return KindPDF
+	case ".docx":
+		return KindDOCX
+	case ".csv", ".tsv":
+		return KindCSV
+	case ".html", ".htm":
+		return KindHTML
+	case ".txt", ".md", ".markdown", ".json", ".log", ".xml", ".yaml", ".yml":
+		return KindText
+	}
+	if strings.HasPrefix(ct, "text/") {
+		return KindText
+	}
+	return ""
+}
+
+// Text extracts the text of a document of the given kind (see Kind). The result is normalized: valid UTF-8,
+// no NUL or control characters, at most one blank line in a row, cut at MaxChars.
+func Text(kind string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxInputBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxInputBytes {
+		return "", ErrTooLarge
+	}
+	var text string
+	switch kind {
+	case KindPDF:
+		text, err = pdfText(data)
+	case KindDOCX:
+		text, err = docxText(data)
+	case KindText:
+		text = decodeText(data)
+	case KindCSV:
+		text, err = csvText(decodeText(data))
+	case KindHTML:
+		text = HTML(decodeText(data))
+	default:
+		return "", ErrUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+	text = normalize(text)
+	if strings.TrimSpace(text) == "" {
+		return "", ErrNoText
+	}
+	return text, nil
+}
+
+// decodeText reads UTF-8 (with or without BOM) or UTF-16 with BOM; anything else that is not valid UTF-8 is
+// taken as Windows-1252.
+func decodeText(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		b = b[3:]
+	case len(b) >= 2 && (b[0] == 0xFF && b[1] == 0xFE || b[0] == 0xFE && b[1] == 0xFF):
+		le := b[0] == 0xFF
+		u := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			if le {
+				u = append(u, uint16(b[i])|uint16(b[i+1])<<8)
+			} else {
+				u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+			}
+		}
+		return string(utf16.Decode(u))
+	}
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return string(winAnsi(b))
+}
+
+// csvText renders rows as "a | b | c" lines. The delimiter (comma, semicolon or tab) is guessed from the
+// first line.
+func csvText(s string) (string, error) {
+	first, _, _ := strings.Cut(s, "\n")
+	delim, best := ',', strings.Count(first, ",")
+	for _, d := range []rune{';', '\t'} {
+		if n := strings.Count(first, string(d)); n > best {
+			delim, best = d, n
+		}
+	}
+	r := csv.NewReader(strings.NewReader(s))
+	r.Comma = delim
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	var b strings.Builder
+	for b.Len() < MaxChars {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		for i := range rec {
+			rec[i] = strings.Join(strings.Fields(rec[i]), " ")
+		}
+		b.WriteString(strings.Join(rec, " | "))
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// normalize cleans extracted text for storage (Postgres TEXT rejects NUL) and for the prompt.
+func normalize(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	var b strings.Builder
+	b.Grow(len(s))
+	blank := 0
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRightFunc(strings.Map(func(r rune) rune {
+			switch {
+			case r == '\t':
+				return r
+			case r == '\r' || r == '\f' || r == '\v':
+				return ' '
+			case unicode.IsControl(r), r == utf8.RuneError, r == '\uFEFF':
+				return -1
+			}
+			return r
+		}, line), unicode.IsSpace)
+		if line == "" {
+			blank++
+			continue
+		}
+		if b.Len() > 0 {
+			if blank > 0 {
+				b.WriteString("\n\n")
+			} else {
+				b.WriteByte('\n')
+			}
+		}
+		blank = 0
+		b.WriteString(line)
+		if b.Len() >= MaxChars {
+			break
+		}
+	}
+	out := b.String()
+	if len(out) > MaxChars {
+		out = strings.ToValidUTF8(out[:MaxChars], "")
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF writes objs as "N 0 obj" in order (1-based); a []byte value becomes a stream.
+func buildPDF(objs ...interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n%\xe2\xe3\xcf\xd3\n")
+	for i, o := range objs {
+		fmt.Fprintf(&b, "%d 0 obj\n", i+1)
+		switch v := o.(type) {
+		case string:
+			b.WriteString(v)
+		case [2]interface{}: // dict, stream body
+			body := v[1].([]byte)
+			fmt.Fprintf(&b, "%s\nstream\n", strings.Replace(v[0].(string), "LEN", fmt.Sprint(len(body)), 1))
+			b.Write(body)
+			b.WriteString("\nendstream")
+		}
+		b.WriteString("\nendobj\n")
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func deflate(s string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func TestPDF(t *testing.T) {
+	cmap := `/CIDInit /ProcSet findresource begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar
+<0001> <0048>
+<0002> <0069>
+endbfchar
+1 beginbfrange
+<0010> <0012> <0430>
+endbfrange
+endcmap`
+	pdf := buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 5 0 R] /Count 2 /Resources << /Font << /F1 7 0 R /F2 8 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+		[2]interface{}{"<< /Length LEN >>", []byte("BT /F1 12 Tf 72 700 Td (Hello \\(world\\)) Tj 0 -14 Td [(Sec) -20 (ond) -300 (line)] TJ ET")},
+		"<< /Type /Page /Parent 2 0 R /Contents [6 0 R] >>",
+		[2]interface{}{"<< /Length LEN /Filter /FlateDecode >>", deflate("BT /F2 10 Tf <00010002> Tj T* <001000110012> Tj /F1 10 Tf T* (Caf\\351 \\223ok\\224) Tj ET")},
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Type /Font /Subtype /Type0 /BaseFont /Foo /ToUnicode 9 0 R >>",
+		[2]interface{}{"<< /Length LEN /Filter /FlateDecode >>", deflate(cmap)},
+	)
+	got, err := Text(KindPDF, bytes.NewReader(pdf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Hello (world)\nSecond line\n\nHi\nабв\nCafé “ok”"
+	if got != want {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+
+	if _, err := Text(KindPDF, strings.NewReader("not a pdf")); err == nil {
+		t.Fatal("garbage accepted as PDF")
+	}
+	enc := append(buildPDF("<< /Type /Catalog >>"), []byte("trailer\n<< /Root 1 0 R /Encrypt 2 0 R >>\n")...)
+	if _, err := Text(KindPDF, bytes.NewReader(enc)); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Fatalf("encrypted PDF: %v", err)
+	}
+	image := buildPDF("<< /Type /Catalog /Pages 2 0 R >>", "<< /Type /Pages /Kids [3 0 R] >>", "<< /Type /Page >>")
+	if _, err := Text(KindPDF, bytes.NewReader(image)); err != ErrNoText {
+		t.Fatalf("image-only PDF: %v", err)
+	}
+}
+
+func TestDOCX(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("word/document.xml")
+	w.Write([]byte(`<?xml version="1.0"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>Title</w:t></w:r></w:p>
+<w:p><w:r><w:t xml:space="preserve">Two </w:t></w:r><w:r><w:t>runs</w:t><w:tab/><w:t>tabbed</w:t></w:r></w:p>
+<w:tbl><w:tr><w:tc><w:p><w:r><w:t>a</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>b</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+</w:body></w:document>`))
+	zw.Close()
+	got, err := Text(KindDOCX, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Title\nTwo runs\ttabbed\na | b"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestTextKinds(t *testing.T) {
+	cases := []struct {
+		kind, in, want string
+	}{
+		{KindText, "\xef\xbb\xbf# Notes\r\n\r\n\r\n\r\nline\x00 two  ", "# Notes\n\nline two"},
+		{KindText, "na\xefve", "naïve"},
+		{KindText, "\xff\xfeh\x00i\x00", "hi"},
+		{KindCSV, "name;price\n\"Big, red\";3\n", "name | price\nBig, red | 3"},
+		{KindCSV, "a,b\n1,2\n", "a | b\n1 | 2"},
+		{KindHTML, "<html><head><style>p{}</style><title>T</title></head><body><script>x<y</script><p>Fish &amp; chips</p><div>a<b>b</b></div><!-- c --></body></html>", "T\n\nFish & chips\n\na b"},
+	}
+	for _, c := range cases {
+		got, err := Text(c.kind, strings.NewReader(c.in))
+		if err != nil || got != c.want {
+			t.Errorf("%s %q: got %q (%v), want %q", c.kind, c.in, got, err, c.want)
+		}
+	}
+	if _, err := Text("", strings.NewReader("x")); err != ErrUnsupported {
+		t.Errorf("unknown kind: %v", err)
+	}
+}
+
+func TestKind(t *testing.T) {
+	cases := map[[2]string]string{
+		{"application/pdf", "x"}:                   KindPDF,
+		{"application/octet-stream", "Report.PDF"}: KindPDF,
+		{"text/markdown; charset=utf-8", ""}:       KindText,
+		{"text/plain", "notes"}:                    KindText,
+		{"", "data.tsv"}:                           KindCSV,
+		{"image/png", "a.png"}:                     "",
+		{"application/msword", "old.doc"}:          "",
+	}
+	for in, want := range cases {
+		if got := Kind(in[0], in[1]); got != want {
+			t.Errorf("Kind(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	var paras []string
+	for i := 0; i < 40; i++ {
+		paras = append(paras, strings.Repeat(fmt.Sprintf("word%d ", i), 30))
+	}
+	paras = append(paras, strings.Repeat("x", 5000))
+	text := strings.Join(paras, "\n\n")
+	chunks := Chunks(text, 200)
+	if len(chunks) < 5 {
+		t.Fatalf("got %d chunks", len(chunks))
+	}
+	for i, c := range chunks {
+		if n := Tokens(c); n > 200 {
+			t.Errorf("chunk %d has %d tokens", i, n)
+		}
+	}
+	joined := strings.Join(chunks, " ")
+	if strings.Count(joined, "word39") != 30 || strings.Count(joined, "x") != 5000 {
+		t.Fatal("chunks lost text")
+	}
+	if Tokens("") != 0 || Tokens("abcdefgh") != 2 || Tokens("привет мир") < 3 {
+		t.Fatalf("Tokens estimates: %d %d %d", Tokens(""), Tokens("abcdefgh"), Tokens("привет мир"))
+	}
+}
@@ -0,0 +1,88 @@
+package extract
+
+import (
+	"html"
+	"strings"
+)
+
+// blockTags end a line of text.
+var blockTags = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "section": true, "article": true, "blockquote": true, "pre": true, "table": true,
+	"ul": true, "ol": true, "dt": true, "dd": true, "hr": true, "title": true,
+}
+
+// HTML returns the visible text of an HTML document: script, style, noscript, template and svg elements
+// (plus any in drop, e.g. "nav") are removed, block elements become line breaks, entities are decoded.
+func HTML(s string, drop ...string) string {
+	skip := map[string]bool{"script": true, "style": true, "noscript": true, "template": true, "svg": true}
+	for _, t := range drop {
+		skip[t] = true
+	}
+	lower := asciiLower(s) // same byte offsets as s
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		lt := strings.IndexByte(s[i:], '<')
+		if lt < 0 {
+			b.WriteString(s[i:])
+			break
+		}
+		b.WriteString(s[i : i+lt])
+		i += lt
+		if strings.HasPrefix(s[i:], "<!--") {
+			end := strings.Index(s[i:], "-->")
+			if end < 0 {
+				break
+			}
+			i += end + 3
+			continue
+		}
+		gt := strings.IndexByte(s[i:], '>')
+		if gt < 0 {
+			break
+		}
+		name, closing := tagName(lower[i+1 : i+gt])
+		i += gt + 1
+		if skip[name] && !closing {
+			end := strings.Index(lower[i:], "</"+name)
+			if end < 0 {
+				break
+			}
+			i += end
+			continue
+		}
+		if blockTags[name] {
+			b.WriteByte('\n')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	text := html.UnescapeString(b.String())
+	lines := strings.Split(text, "\n")
+	for k, line := range lines {
+		lines[k] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func tagName(tag string) (name string, closing bool) {
+	tag = strings.TrimSpace(tag)
+	if strings.HasPrefix(tag, "/") {
+		closing, tag = true, tag[1:]
+	}
+	end := strings.IndexAny(tag, " \t\r\n/>")
+	if end >= 0 {
+		tag = tag[:end]
+	}
+	return tag, closing
+}
+
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,1009 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PDF text extraction: a small reader for the parts of the format that carry text. Objects are found by
+// scanning for "N G obj" (so broken xref tables do not matter) plus object streams; pages are walked from
+// the catalog; content streams are interpreted for the text operators, decoding strings through the
+// font's ToUnicode map when there is one and as WinAnsi otherwise. Encrypted PDFs are refused.
+
+const maxPDFDepth = 32 // nesting of page trees and form XObjects
+
+type pdfRef struct{ num, gen int }
+
+type pdfName string
+
+type pdfDict map[pdfName]interface{}
+
+type pdfStream struct {
+	dict pdfDict
+	raw  []byte
+}
+
+type pdfDoc struct {
+	objs  map[int]interface{}
+	fonts map[uintptr]*pdfFont // by font dictionary identity
+}
+
+type pdfFont struct {
+	cmap     map[string]string // code bytes -> text, from ToUnicode
+	codeLens []int             // code lengths in bytes, longest first
+	twoByte  bool              // Type0 font without a usable ToUnicode map
+}
+
+func pdfText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF-")) {
+		return "", fmt.Errorf("not a PDF")
+	}
+	d := &pdfDoc{objs: map[int]interface{}{}, fonts: map[uintptr]*pdfFont{}}
+	d.scanObjects(data)
+	if len(d.objs) == 0 {
+		return "", fmt.Errorf("no PDF objects found")
+	}
+	if d.encrypted(data) {
+		return "", fmt.Errorf("encrypted PDF")
+	}
+	pages := d.pages()
+	var out strings.Builder
+	for i, p := range pages {
+		if i > 0 {
+			out.WriteString("\n\n")
+		}
+		d.pageText(&out, p)
+	}
+	return out.String(), nil
+}
+
+// scanObjects indexes every "N G obj" in the file, then the objects packed in object streams.
+func (d *pdfDoc) scanObjects(data []byte) {
+	for i := 0; ; {
+		j := bytes.Index(data[i:], []byte("obj"))
+		if j < 0 {
+			break
+		}
+		at := i + j
+		i = at + 3
+		num, _, ok := objHeader(data, at)
+		if !ok {
+			continue
+		}
+		p := &pdfParser{data: data, pos: at + 3}
+		v := p.value()
+		if dict, ok := v.(pdfDict); ok {
+			if s, ok := p.stream(dict, d); ok {
+				v = s
+			}
+		}
+		d.objs[num] = v
+		if p.pos > i {
+			i = p.pos
+		}
+	}
+	var objStms []*pdfStream
+	for _, v := range d.objs {
+		if s, ok := v.(*pdfStream); ok && s.dict["Type"] == pdfName("ObjStm") {
+			objStms = append(objStms, s)
+		}
+	}
+	for _, s := range objStms {
+		body, err := d.decode(s)
+		if err != nil {
+			continue
+		}
+		n, _ := d.resolve(s.dict["N"]).(int)
+		first, _ := d.resolve(s.dict["First"]).(int)
+		if first <= 0 || first > len(body) {
+			continue
+		}
+		hp := &pdfParser{data: body[:first]}
+		for k := 0; k < n; k++ {
+			num, ok1 := hp.value().(int)
+			off, ok2 := hp.value().(int)
+			if !ok1 || !ok2 || first+off >= len(body) {
+				break
+			}
+			if _, exists := d.objs[num]; exists {
+				continue // a direct definition wins
+			}
+			op := &pdfParser{data: body, pos: first + off}
+			d.objs[num] = op.value()
+		}
+	}
+}
+
+// objHeader reads "N G" before the "obj" keyword at pos.
+func objHeader(data []byte, pos int) (num, gen int, ok bool) {
+	if pos+3 < len(data) && !isDelimOrSpace(data[pos+3]) {
+		return 0, 0, false
+	}
+	end := pos
+	readInt := func() (int, bool) {
+		for end > 0 && isSpace(data[end-1]) {
+			end--
+		}
+		start := end
+		for start > 0 && data[start-1] >= '0' && data[start-1] <= '9' {
+			start--
+		}
+		if start == end {
+			return 0, false
+		}
+		n, err := strconv.Atoi(string(data[start:end]))
+		end = start
+		return n, err == nil
+	}
+	if gen, ok = readInt(); !ok {
+		return 0, 0, false
+	}
+	if num, ok = readInt(); !ok {
+		return 0, 0, false
+	}
+	return num, gen, end == 0 || isDelimOrSpace(data[end-1])
+}
+
+func (d *pdfDoc) encrypted(data []byte) bool {
+	i := bytes.LastIndex(data, []byte("trailer"))
+	if i >= 0 {
+		p := &pdfParser{data: data, pos: i + len("trailer")}
+		if t, ok := p.value().(pdfDict); ok {
+			return t["Encrypt"] != nil
+		}
+	}
+	for _, v := range d.objs {
+		if s, ok := v.(*pdfStream); ok && s.dict["Type"] == pdfName("XRef") && s.dict["Encrypt"] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *pdfDoc) resolve(v interface{}) interface{} {
+	for i := 0; i < 8; i++ {
+		r, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = d.objs[r.num]
+	}
+	return nil
+}
+
+func (d *pdfDoc) dict(v interface{}) pdfDict {
+	switch x := d.resolve(v).(type) {
+	case pdfDict:
+		return x
+	case *pdfStream:
+		return x.dict
+	}
+	return nil
+}
+
+// page is a page dictionary with its inherited resources.
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages returns the pages in document order, from the catalog's page tree; when that cannot be walked,
+// every /Type /Page object in object-number order.
+func (d *pdfDoc) pages() []pdfPage {
+	var root pdfDict
+	for _, v := range d.objs {
+		if dd := d.dict(v); dd != nil && dd["Type"] == pdfName("Catalog") {
+			root = dd
+			break
+		}
+	}
+	var out []pdfPage
+	if root != nil {
+		d.walkPages(d.dict(root["Pages"]), nil, 0, &out)
+	}
+	if len(out) > 0 {
+		return out
+	}
+	nums := make([]int, 0, len(d.objs))
+	for n := range d.objs {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	for _, n := range nums {
+		if dd, ok := d.objs[n].(pdfDict); ok && dd["Type"] == pdfName("Page") {
+			out = append(out, pdfPage{dict: dd, resources: d.dict(dd["Resources"])})
+		}
+	}
+	return out
+}
+
+func (d *pdfDoc) walkPages(node pdfDict, res pdfDict, depth int, out *[]pdfPage) {
+	if node == nil || depth > maxPDFDepth {
+		return
+	}
+	if r := d.dict(node["Resources"]); r != nil {
+		res = r
+	}
+	if node["Type"] == pdfName("Page") || node["Kids"] == nil {
+		*out = append(*out, pdfPage{dict: node, resources: res})
+		return
+	}
+	kids, _ := d.resolve(node["Kids"]).([]interface{})
+	for _, k := range kids {
+		d.walkPages(d.dict(k), res, depth+1, out)
+	}
+}
+
+func (d *pdfDoc) pageText(out *strings.Builder, p pdfPage) {
+	var content []byte
+	switch c := d.resolve(p.dict["Contents"]).(type) {
+	case *pdfStream:
+		content, _ = d.decode(c)
+	case []interface{}:
+		for _, part := range c {
+			if s, ok := d.resolve(part).(*pdfStream); ok {
+				b, _ := d.decode(s)
+				content = append(append(content, b...), '\n')
+			}
+		}
+	}
+	t := &textRun{doc: d, out: out}
+	t.run(content, p.resources, 0)
+}
+
+// decode applies the stream's filters. Unsupported filters (images) return an error.
+func (d *pdfDoc) decode(s *pdfStream) ([]byte, error) {
+	var filters []interface{}
+	switch f := d.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []interface{}{f}
+	case []interface{}:
+		filters = f
+	}
+	var parms []interface{}
+	switch p := d.resolve(s.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		parms = []interface{}{p}
+	case []interface{}:
+		parms = p
+	}
+	b := s.raw
+	for i, f := range filters {
+		var parm pdfDict
+		if i < len(parms) {
+			parm = d.dict(parms[i])
+		}
+		var err error
+		switch d.resolve(f) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			b, err = inflate(b)
+			if err == nil {
+				b, err = unpredict(b, parm, d)
+			}
+		case pdfName("ASCIIHexDecode"), pdfName("AHx"):
+			b, err = asciiHex(b)
+		case pdfName("ASCII85Decode"), pdfName("A85"):
+			b, err = ascii85Decode(b)
+		default:
+			return nil, fmt.Errorf("unsupported filter %v", f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func inflate(b []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, maxInputBytes*4))
+	if len(out) > 0 && (err == io.ErrUnexpectedEOF || err == zlib.ErrChecksum) {
+		return out, nil // truncated streams are common; keep what decoded
+	}
+	return out, err
+}
+
+// unpredict reverses PNG predictors (used by object and xref streams).
+func unpredict(b []byte, parm pdfDict, d *pdfDoc) ([]byte, error) {
+	if parm == nil {
+		return b, nil
+	}
+	pred, _ := d.resolve(parm["Predictor"]).(int)
+	if pred < 10 {
+		return b, nil
+	}
+	cols, _ := d.resolve(parm["Columns"]).(int)
+	if cols <= 0 {
+		cols = 1
+	}
+	row := cols + 1
+	prev := make([]byte, cols)
+	out := make([]byte, 0, len(b))
+	for i := 0; i+row <= len(b); i += row {
+		typ, cur := b[i], append([]byte(nil), b[i+1:i+row]...)
+		for j := range cur {
+			var left, upLeft byte
+			if j > 0 {
+				left, upLeft = cur[j-1], prev[j-1]
+			}
+			switch typ {
+			case 1:
+				cur[j] += left
+			case 2:
+				cur[j] += prev[j]
+			case 3:
+				cur[j] += byte((int(left) + int(prev[j])) / 2)
+			case 4:
+				cur[j] += paeth(left, prev[j], upLeft)
+			}
+		}
+		out = append(out, cur...)
+		prev = cur
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func asciiHex(b []byte) ([]byte, error) {
+	var clean []byte
+	for _, c := range b {
+		if c == '>' {
+			break
+		}
+		if !isSpace(c) {
+			clean = append(clean, c)
+		}
+	}
+	if len(clean)%2 == 1 {
+		clean = append(clean, '0')
+	}
+	out := make([]byte, len(clean)/2)
+	_, err := hex.Decode(out, clean)
+	return out, err
+}
+
+func ascii85Decode(b []byte) ([]byte, error) {
+	b = bytes.TrimPrefix(bytes.TrimSpace(b), []byte("<~"))
+	if i := bytes.Index(b, []byte("~>")); i >= 0 {
+		b = b[:i]
+	}
+	out := make([]byte, len(b))
+	n, _, err := ascii85.Decode(out, b, true)
+	return out[:n], err
+}
+
+// font returns the decoder for a font dictionary, parsing its ToUnicode map once.
+func (d *pdfDoc) font(fd pdfDict) *pdfFont {
+	if fd == nil {
+		return &pdfFont{}
+	}
+	id := reflect.ValueOf(fd).Pointer()
+	if f, ok := d.fonts[id]; ok {
+		return f
+	}
+	f := &pdfFont{twoByte: fd["Subtype"] == pdfName("Type0")}
+	if s, ok := d.resolve(fd["ToUnicode"]).(*pdfStream); ok {
+		if b, err := d.decode(s); err == nil {
+			f.cmap, f.codeLens = parseCMap(b)
+		}
+	}
+	d.fonts[id] = f
+	return f
+}
+
+// parseCMap reads bfchar and bfrange entries of a ToUnicode CMap.
+func parseCMap(b []byte) (map[string]string, []int) {
+	m := map[string]string{}
+	lens := map[int]bool{}
+	p := &pdfParser{data: b}
+	var stack []interface{}
+	for {
+		tok, ok := p.token()
+		if !ok {
+			break
+		}
+		switch tok {
+		case "beginbfchar", "beginbfrange", "begincodespacerange":
+			stack = stack[:0]
+			continue
+		case "endcodespacerange":
+			for i := 0; i+1 < len(stack); i += 2 {
+				if lo, ok := stack[i].(pdfHex); ok {
+					lens[len(lo)] = true
+				}
+			}
+			stack = stack[:0]
+			continue
+		case "endbfchar":
+			for i := 0; i+1 < len(stack); i += 2 {
+				src, ok1 := stack[i].(pdfHex)
+				dst, ok2 := stack[i+1].(pdfHex)
+				if ok1 && ok2 {
+					m[string(src)] = utf16BE([]byte(dst))
+					lens[len(src)] = true
+				}
+			}
+			stack = stack[:0]
+			continue
+		case "endbfrange":
+			for i := 0; i+2 < len(stack); i += 3 {
+				lo, ok1 := stack[i].(pdfHex)
+				hi, ok2 := stack[i+1].(pdfHex)
+				if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+					continue
+				}
+				lens[len(lo)] = true
+				from, to := beInt(lo), beInt(hi)
+				if to < from || to-from > 0xFFFF {
+					continue
+				}
+				switch dst := stack[i+2].(type) {
+				case pdfHex:
+					base := []byte(dst)
+					for c := from; c <= to; c++ {
+						cur := append([]byte(nil), base...)
+						if len(cur) > 0 {
+							cur[len(cur)-1] += byte(c - from) // only the last byte varies (CMap rule)
+						}
+						m[string(beBytes(c, len(lo)))] = utf16BE(cur)
+					}
+				case []interface{}:
+					for k, v := range dst {
+						if h, ok := v.(pdfHex); ok && from+k <= to {
+							m[string(beBytes(from+k, len(lo)))] = utf16BE([]byte(h))
+						}
+					}
+				}
+			}
+			stack = stack[:0]
+			continue
+		}
+		p.pos -= len(tok)
+		stack = append(stack, p.value())
+	}
+	var out []int
+	for l := range lens {
+		out = append(out, l)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(out)))
+	return m, out
+}
+
+func beInt(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+func beBytes(n, size int) []byte {
+	b := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		b[i] = byte(n)
+		n >>= 8
+	}
+	return b
+}
+
+func utf16BE(b []byte) string {
+	if len(b)%2 == 1 {
+		return string(winAnsi(b))
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(u))
+}
+
+// decodeString turns a shown string into text.
+func (f *pdfFont) decodeString(s []byte) string {
+	if f.cmap != nil {
+		var b strings.Builder
+		for i := 0; i < len(s); {
+			matched := false
+			for _, l := range f.codeLens {
+				if i+l <= len(s) {
+					if t, ok := f.cmap[string(s[i:i+l])]; ok {
+						b.WriteString(t)
+						i += l
+						matched = true
+						break
+					}
+				}
+			}
+			if !matched {
+				if f.twoByte {
+					i += 2
+				} else {
+					b.WriteString(string(winAnsi(s[i : i+1])))
+					i++
+				}
+			}
+		}
+		return b.String()
+	}
+	if f.twoByte {
+		return "" // CIDs without a ToUnicode map cannot be mapped to text
+	}
+	if len(s) >= 2 && s[0] == 0xFE && s[1] == 0xFF {
+		return utf16BE(s[2:])
+	}
+	return string(winAnsi(s))
+}
+
+// winAnsi maps WinAnsiEncoding bytes to runes (Latin-1 plus the 0x80-0x9F punctuation).
+func winAnsi(b []byte) []rune {
+	out := make([]rune, 0, len(b))
+	for _, c := range b {
+		if c >= 0x80 && c <= 0x9F {
+			if r := winAnsiHigh[c-0x80]; r != 0 {
+				out = append(out, r)
+			}
+			continue
+		}
+		out = append(out, rune(c))
+	}
+	return out
+}
+
+var winAnsiHigh = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// textRun interprets a content stream, writing shown text with line breaks where the text moves down.
+type textRun struct {
+	doc    *pdfDoc
+	out    *strings.Builder
+	font   *pdfFont
+	lastY  float64
+	haveY  bool
+	inLine bool // text was written since the last line break
+}
+
+func (t *textRun) run(content []byte, res pdfDict, depth int) {
+	if depth > maxPDFDepth {
+		return
+	}
+	fonts := t.doc.dict(res["Font"])
+	p := &pdfParser{data: content}
+	var args []interface{}
+	for {
+		tok, ok := p.token()
+		if !ok {
+			return
+		}
+		if isOperand(tok) {
+			p.pos -= len(tok)
+			args = append(args, p.value())
+			continue
+		}
+		switch tok {
+		case "BI": // inline image: skip to EI
+			if i := bytes.Index(content[p.pos:], []byte("EI")); i >= 0 {
+				p.pos += i + 2
+			} else {
+				return
+			}
+		case "BT":
+			t.haveY = false
+		case "Tf":
+			if len(args) >= 1 {
+				if name, ok := args[0].(pdfName); ok {
+					t.font = t.doc.font(t.doc.dict(fonts[name]))
+				}
+			}
+		case "Td", "TD":
+			if len(args) >= 2 {
+				tx, ty := num(args[0]), num(args[1])
+				if ty != 0 {
+					t.newline()
+				} else if tx > 0 {
+					t.space()
+				}
+			}
+		case "Tm":
+			if len(args) >= 6 {
+				y := num(args[5])
+				if t.haveY && y != t.lastY {
+					t.newline()
+				} else if t.haveY {
+					t.space()
+				}
+				t.lastY, t.haveY = y, true
+			}
+		case "T*":
+			t.newline()
+		case "Tj":
+			if len(args) >= 1 {
+				t.show(args[len(args)-1])
+			}
+		case "'", "\"":
+			t.newline()
+			if len(args) >= 1 {
+				t.show(args[len(args)-1])
+			}
+		case "TJ":
+			if len(args) >= 1 {
+				arr, _ := args[len(args)-1].([]interface{})
+				for _, el := range arr {
+					if n, ok := el.(int); ok && n < -200 {
+						t.space()
+					} else if f, ok := el.(float64); ok && f < -200 {
+						t.space()
+					} else {
+						t.show(el)
+					}
+				}
+			}
+		case "Do":
+			if len(args) >= 1 {
+				name, _ := args[0].(pdfName)
+				xobjs := t.doc.dict(res["XObject"])
+				if s, ok := t.doc.resolve(xobjs[name]).(*pdfStream); ok && s.dict["Subtype"] == pdfName("Form") {
+					if b, err := t.doc.decode(s); err == nil {
+						sub := t.doc.dict(s.dict["Resources"])
+						if sub == nil {
+							sub = res
+						}
+						saved := t.font
+						t.run(b, sub, depth+1)
+						t.font = saved
+					}
+				}
+			}
+		}
+		args = args[:0]
+	}
+}
+
+func (t *textRun) show(v interface{}) {
+	var s []byte
+	switch x := v.(type) {
+	case pdfString:
+		s = []byte(x)
+	case pdfHex:
+		s = []byte(x)
+	default:
+		return
+	}
+	f := t.font
+	if f == nil {
+		f = &pdfFont{}
+	}
+	if text := f.decodeString(s); text != "" {
+		t.out.WriteString(text)
+		t.inLine = true
+	}
+}
+
+func (t *textRun) newline() {
+	if t.inLine {
+		t.out.WriteByte('\n')
+		t.inLine = false
+	}
+}
+
+func (t *textRun) space() {
+	if !t.inLine {
+		return
+	}
+	if s := t.out.String(); !strings.HasSuffix(s, " ") {
+		t.out.WriteByte(' ')
+	}
+}
+
+func num(v interface{}) float64 {
+	switch x := v.(type) {
+	case int:
+		return float64(x)
+	case float64:
+		return x
+	}
+	return 0
+}
+
+func isOperand(tok string) bool {
+	switch c := tok[0]; {
+	case c == '/' || c == '(' || c == '<' || c == '[' || c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return true
+	}
+	return tok == "true" || tok == "false" || tok == "null"
+}
+
+// pdfString is a literal string, pdfHex a hex string (kept apart so CMaps can tell codes from names).
+type pdfString []byte
+type pdfHex []byte
+
+// pdfParser reads PDF tokens and values from data starting at pos.
+type pdfParser struct {
+	data []byte
+	pos  int
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func isDelimOrSpace(c byte) bool { return isSpace(c) || isDelim(c) }
+
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '%' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// token returns the next token's text without interpreting it (strings and dicts return their opener only).
+func (p *pdfParser) token() (string, bool) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return "", false
+	}
+	start := p.pos
+	c := p.data[p.pos]
+	switch {
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<', c == '>' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '>':
+		p.pos += 2
+	case c == '/':
+		p.pos++
+		for p.pos < len(p.data) && !isDelimOrSpace(p.data[p.pos]) {
+			p.pos++
+		}
+	case isDelim(c):
+		p.pos++
+	default:
+		for p.pos < len(p.data) && !isDelimOrSpace(p.data[p.pos]) {
+			p.pos++
+		}
+	}
+	return string(p.data[start:p.pos]), true
+}
+
+// value parses one value. Unknown input yields nil and always makes progress.
+func (p *pdfParser) value() interface{} {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil
+	}
+	c := p.data[p.pos]
+	switch {
+	case c == '(':
+		return p.literal()
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		p.pos += 2
+		d := pdfDict{}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return d
+			}
+			if p.data[p.pos] == '>' {
+				p.pos += 2
+				return d
+			}
+			k, ok := p.value().(pdfName)
+			if !ok {
+				continue
+			}
+			d[k] = p.value()
+		}
+	case c == '<':
+		p.pos++
+		end := bytes.IndexByte(p.data[p.pos:], '>')
+		if end < 0 {
+			end = len(p.data) - p.pos
+		}
+		b, _ := asciiHex(p.data[p.pos : p.pos+end])
+		p.pos += end + 1
+		return pdfHex(b)
+	case c == '[':
+		p.pos++
+		var arr []interface{}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return arr
+			}
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return arr
+			}
+			before := p.pos
+			arr = append(arr, p.value())
+			if p.pos == before {
+				p.pos++
+			}
+		}
+	case c == '/':
+		tok, _ := p.token()
+		return pdfName(unescapeName(tok[1:]))
+	}
+	tok, _ := p.token()
+	if tok == "" {
+		p.pos++
+		return nil
+	}
+	switch tok {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if n, err := strconv.Atoi(tok); err == nil {
+		// "N G R" is a reference
+		save := p.pos
+		g, ok1 := p.token()
+		r, ok2 := p.token()
+		if gen, err := strconv.Atoi(g); ok1 && ok2 && err == nil && r == "R" {
+			return pdfRef{num: n, gen: gen}
+		}
+		p.pos = save
+		return n
+	}
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return f
+	}
+	return tok // operator or unknown keyword
+}
+
+func unescapeName(s string) string {
+	if !strings.Contains(s, "#") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func (p *pdfParser) literal() pdfString {
+	p.pos++ // (
+	var out []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				return out
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for k := 0; k < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; k++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// stream reads the stream body that follows dict, if any.
+func (p *pdfParser) stream(dict pdfDict, d *pdfDoc) (*pdfStream, bool) {
+	save := p.pos
+	tok, ok := p.token()
+	if !ok || tok != "stream" {
+		p.pos = save
+		return nil, false
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+	end := -1
+	if n, ok := dict["Length"].(int); ok && n >= 0 && start+n <= len(p.data) {
+		rest := bytes.TrimLeft(p.data[start+n:min(start+n+32, len(p.data))], "\r\n \t")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			end = start + n
+		}
+	}
+	if end < 0 {
+		i := bytes.Index(p.data[start:], []byte("endstream"))
+		if i < 0 {
+			return nil, false
+		}
+		end = start + i
+		for end > start && (p.data[end-1] == '\n' || p.data[end-1] == '\r') {
+			end--
+		}
+	}
+	p.pos = end
+	if i := bytes.Index(p.data[end:], []byte("endstream")); i >= 0 {
+		p.pos = end + i + len("endstream")
+	}
+	return &pdfStream{dict: dict, raw: p.data[start:end]}, true
+}
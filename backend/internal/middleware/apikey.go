@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"flipo5/backend/internal/auth"
+
+	"github.com/google/uuid"
+)
+
+const apiKeyIDKey contextKey = "api_key_id"
+const apiKeyScopesKey contextKey = "api_key_scopes"
+
+func withAPIKeyID(ctx context.Context, id uuid.UUID) context.Context {
+	return context.WithValue(ctx, apiKeyIDKey, id)
+}
+
+func withAPIKeyScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, apiKeyScopesKey, scopes)
+}
+
+// APIKeyID returns the key that authenticated the request; ok is false for Supabase sessions.
+func APIKeyID(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(apiKeyIDKey).(uuid.UUID)
+	return id, ok
+}
+
+// KeyHasScope reports whether the request may use scope: always for Supabase sessions, for API keys only
+// when the key has it. For handlers whose scope depends on the resource (retrying a job needs the scope of
+// the job's type).
+func KeyHasScope(ctx context.Context, scope string) bool {
+	scopes, isKey := KeyScopes(ctx)
+	return !isKey || hasScope(scopes, scope)
+}
+
+// KeyScopes returns the scopes of the API key that authenticated the request; ok is false for Supabase
+// sessions, which are not limited.
+func KeyScopes(ctx context.Context) ([]string, bool) {
+	if _, ok := APIKeyID(ctx); !ok {
+		return nil, false
+	}
+	scopes, _ := ctx.Value(apiKeyScopesKey).([]string)
+	return scopes, true
+}
+
+// JobScope is the scope that creates jobs of a type.
+func JobScope(jobType string) string {
+	switch jobType {
+	case "chat":
+		return auth.ScopeChat
+	case "image", "logo", "upscale":
+		return auth.ScopeImage
+	case "video":
+		return auth.ScopeVideo
+	}
+	return auth.ScopeText
+}
+
+// scopeByPath maps job-creating POST routes to the scope they need; other non-GET routes need ScopeWrite.
+var scopeByPath = map[string]string{
+	"/api/chat":                         auth.ScopeChat,
+	"/api/image":                        auth.ScopeImage,
+	"/api/image-inpaint":                auth.ScopeImage,
+	"/api/logo":                         auth.ScopeImage,
+	"/api/upscale":                      auth.ScopeImage,
+	"/api/prompt-variants":              auth.ScopeImage,
+	"/api/vectorize":                    auth.ScopeImage,
+	"/api/video":                        auth.ScopeVideo,
+	"/api/seo":                          auth.ScopeText,
+	"/api/outline":                      auth.ScopeText,
+	"/api/translate":                    auth.ScopeText,
+	"/api/products/improve-description": auth.ScopeText,
+	"/api/products/improve-scene":       auth.ScopeText,
+	"/api/threads/import":               auth.ScopeChat, // creates chat jobs
+	"/v1/chat":                          auth.ScopeChat,
+	"/v1/images":                        auth.ScopeImage,
+	"/v1/upscales":                      auth.ScopeImage,
+	"/v1/videos":                        auth.ScopeVideo,
+}
+
+// RequiredScope returns the API key scope a request needs. ok is false for routes keys can never use:
+// key management (a key must not mint or revoke keys) and admin. Regenerating and editing a message run a
+// new chat job; retry needs write here and the failed job's scope in the handler (KeyHasScope).
+func RequiredScope(method, path string) (scope string, ok bool) {
+	if strings.HasPrefix(path, "/api/me/api-keys") || strings.HasPrefix(path, "/api/admin") {
+		return "", false
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return auth.ScopeRead, true
+	}
+	if method == http.MethodPost {
+		if s, ok := scopeByPath[path]; ok {
+			return s, true
+		}
+		if strings.HasPrefix(path, "/api/products/") && strings.HasSuffix(path, "/score") {
+			return auth.ScopeText, true
+		}
+		if strings.HasPrefix(path, "/api/jobs/") && (strings.HasSuffix(path, "/regenerate") || strings.HasSuffix(path, "/edit")) {
+			return auth.ScopeChat, true
+		}
+	}
+	return auth.ScopeWrite, true
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"flipo5/backend/internal/auth"
+
+	"github.com/google/uuid"
+)
+
+func TestRequiredScope(t *testing.T) {
+	cases := []struct {
+		method, path string
+		scope        string
+		ok           bool
+	}{
+		{"GET", "/api/jobs/123", auth.ScopeRead, true},
+		{"POST", "/api/chat", auth.ScopeChat, true},
+		{"POST", "/api/upscale", auth.ScopeImage, true},
+		{"POST", "/api/video", auth.ScopeVideo, true},
+		{"POST", "/api/products/abc/score", auth.ScopeText, true},
+		{"POST", "/api/jobs/123/cancel", auth.ScopeWrite, true},
+		{"POST", "/api/jobs/123/retry", auth.ScopeWrite, true},
+		{"POST", "/api/jobs/123/regenerate", auth.ScopeChat, true},
+		{"POST", "/api/jobs/123/edit", auth.ScopeChat, true},
+		{"POST", "/api/threads/import", auth.ScopeChat, true},
+		{"DELETE", "/api/files/1", auth.ScopeWrite, true},
+		{"GET", "/api/me/api-keys", "", false},
+		{"POST", "/api/me/api-keys", "", false},
+		{"GET", "/api/admin/users", "", false},
+		{"POST", "/v1/chat", auth.ScopeChat, true},
+		{"POST", "/v1/videos", auth.ScopeVideo, true},
+		{"POST", "/v1/jobs/123/cancel", auth.ScopeWrite, true},
+		{"GET", "/v1/jobs", auth.ScopeRead, true},
+	}
+	for _, c := range cases {
+		scope, ok := RequiredScope(c.method, c.path)
+		if scope != c.scope || ok != c.ok {
+			t.Errorf("%s %s: got (%q, %v), want (%q, %v)", c.method, c.path, scope, ok, c.scope, c.ok)
+		}
+	}
+}
+
+// Retrying a job needs the scope of the job's type as well.
+func TestKeyHasScope(t *testing.T) {
+	session := context.Background()
+	key := withAPIKeyScopes(withAPIKeyID(session, uuid.New()), []string{auth.ScopeWrite, auth.ScopeChat})
+	cases := []struct {
+		ctx     context.Context
+		jobType string
+		want    bool
+	}{
+		{session, "video", true},
+		{key, "chat", true},
+		{key, "video", false},
+		{key, "upscale", false},
+		{key, "seo", false},
+	}
+	for _, c := range cases {
+		if got := KeyHasScope(c.ctx, JobScope(c.jobType)); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.jobType, got, c.want)
+		}
+	}
+}
+
+// Chat jobs carry the key's scopes so their tools can be held to them.
+func TestKeyScopes(t *testing.T) {
+	if scopes, ok := KeyScopes(context.Background()); ok || scopes != nil {
+		t.Fatalf("session: got (%v, %v)", scopes, ok)
+	}
+	key := withAPIKeyScopes(withAPIKeyID(context.Background(), uuid.New()), []string{auth.ScopeChat})
+	if scopes, ok := KeyScopes(key); !ok || len(scopes) != 1 || scopes[0] != auth.ScopeChat {
+		t.Fatalf("key: got (%v, %v)", scopes, ok)
+	}
+}
@@ -10,6 +10,7 @@ type contextKey string
 
 const userIDKey contextKey = "user_id"
 const emailKey contextKey = "email"
+const planKey contextKey = "plan"
 
 func withUserID(ctx context.Context, id uuid.UUID) context.Context {
 	return context.WithValue(ctx, userIDKey, id)
@@ -19,6 +20,10 @@ func withEmail(ctx context.Context, email string) context.Context {
 	return context.WithValue(ctx, emailKey, email)
 }
 
+func withPlan(ctx context.Context, plan string) context.Context {
+	return context.WithValue(ctx, planKey, plan)
+}
+
 func UserID(ctx context.Context) (uuid.UUID, bool) {
 	id, ok := ctx.Value(userIDKey).(uuid.UUID)
 	return id, ok
@@ -28,3 +33,9 @@ func Email(ctx context.Context) string {
 	e, _ := ctx.Value(emailKey).(string)
 	return e
 }
+
+// Plan is the authenticated user's users.plan value as loaded by the auth middleware ("" when unset).
+func Plan(ctx context.Context) string {
+	p, _ := ctx.Value(planKey).(string)
+	return p
+}
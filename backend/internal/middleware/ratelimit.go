@@ -1,178 +1,77 @@
 package middleware
 
 import (
+	"math"
 	"net/http"
-	"strings"
-	"sync"
+	"strconv"
 	"time"
+
+	"flipo5/backend/internal/ratelimit"
 )
 
-// RateLimitJobCreation limits N requests per minute per user for expensive job creation (SEO, translate).
-// Only applies when r.URL.Path is in paths (e.g. "/api/seo", "/api/translate"). Use after auth.
-func RateLimitJobCreation(requestsPerMinute int, paths ...string) func(next http.Handler) http.Handler {
-	pathSet := make(map[string]struct{})
-	for _, p := range paths {
-		pathSet[p] = struct{}{}
-	}
-	type entry struct {
-		count int
-		start time.Time
-	}
-	var mu sync.Mutex
-	m := make(map[string]entry)
-	var lastCleanup time.Time
-	cleanup := func() {
-		now := time.Now()
-		if now.Sub(lastCleanup) < 2*time.Minute {
-			return
-		}
-		lastCleanup = now
-		cutoff := now.Add(-2 * time.Minute)
-		for k, e := range m {
-			if e.start.Before(cutoff) {
-				delete(m, k)
-			}
-		}
-	}
+// RateLimit limits authenticated requests per user: the general bucket (per plan) plus any route rule
+// matching the request, all shared across replicas through Redis. Use after auth.
+func RateLimit(l *ratelimit.Limiter) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if _, ok := pathSet[r.URL.Path]; !ok {
-				next.ServeHTTP(w, r)
-				return
-			}
 			id, ok := UserID(r.Context())
 			if !ok {
 				next.ServeHTTP(w, r)
 				return
 			}
-			key := "job:" + id.String()
-			mu.Lock()
-			cleanup()
-			e := m[key]
-			now := time.Now()
-			if now.Sub(e.start) > time.Minute {
-				e = entry{count: 1, start: now}
-			} else {
-				e.count++
-			}
-			m[key] = e
-			mu.Unlock()
-			if e.count > requestsPerMinute {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusTooManyRequests)
-				w.Write([]byte(`{"error":"rate limit exceeded. Try again in a minute."}`))
-				return
+			buckets := l.Rules.ForUser(r.Method, r.URL.Path, Plan(r.Context()))
+			if allow(w, r, l, id.String(), buckets) {
+				next.ServeHTTP(w, r)
 			}
-			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// RateLimitByIP limits N requests per minute per client IP. Use for public routes (no auth).
-func RateLimitByIP(requestsPerMinute int) func(next http.Handler) http.Handler {
-	type entry struct {
-		count int
-		start time.Time
-	}
-	var mu sync.Mutex
-	m := make(map[string]entry)
-	var lastCleanup time.Time
-
-	cleanup := func() {
-		now := time.Now()
-		if now.Sub(lastCleanup) < 2*time.Minute {
-			return
-		}
-		lastCleanup = now
-		cutoff := now.Add(-2 * time.Minute)
-		for k, e := range m {
-			if e.start.Before(cutoff) {
-				delete(m, k)
-			}
-		}
-	}
-
+// RateLimitByIP limits requests per client IP (see ratelimit.Rules.ClientIP). Use for public routes (no auth).
+func RateLimitByIP(l *ratelimit.Limiter) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := r.RemoteAddr
-			if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
-				ip = strings.TrimSpace(strings.Split(fwd, ",")[0])
-			}
-			key := "ip:" + ip
-			mu.Lock()
-			cleanup()
-			e := m[key]
-			now := time.Now()
-			if now.Sub(e.start) > time.Minute {
-				e = entry{count: 1, start: now}
-			} else {
-				e.count++
+			var buckets []ratelimit.Bucket
+			if l.Rules.IP.Limit > 0 {
+				buckets = []ratelimit.Bucket{{Name: "ip", Policy: l.Rules.IP}}
 			}
-			m[key] = e
-			mu.Unlock()
-			if e.count > requestsPerMinute {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusTooManyRequests)
-				w.Write([]byte(`{"error":"rate limit exceeded"}`))
-				return
+			if allow(w, r, l, l.Rules.ClientIP(r), buckets) {
+				next.ServeHTTP(w, r)
 			}
-			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// RateLimit limits N requests per minute per user (by UserID from ctx).
-// Runs periodic cleanup to avoid unbounded map growth.
-// SSE connections and streaming endpoints need higher limits.
-func RateLimit(requestsPerMinute int) func(next http.Handler) http.Handler {
-	type entry struct {
-		count int
-		start time.Time
-	}
-	var mu sync.Mutex
-	m := make(map[string]entry)
-	var lastCleanup time.Time
-
-	cleanup := func() {
-		now := time.Now()
-		if now.Sub(lastCleanup) < 2*time.Minute {
-			return
+// allow takes one request from each bucket of subject and sets the RateLimit-* headers of the most
+// constrained one. When a bucket is empty it writes 429 with Retry-After and returns false.
+func allow(w http.ResponseWriter, r *http.Request, l *ratelimit.Limiter, subject string, buckets []ratelimit.Bucket) bool {
+	var tightest *ratelimit.Result
+	var policy ratelimit.Policy
+	for _, b := range buckets {
+		res := l.Allow(r.Context(), b.Name+":"+subject, b.Policy)
+		if tightest == nil || !res.Allowed || (tightest.Allowed && res.Remaining < tightest.Remaining) {
+			tightest, policy = &res, b.Policy
 		}
-		lastCleanup = now
-		cutoff := now.Add(-2 * time.Minute)
-		for k, e := range m {
-			if e.start.Before(cutoff) {
-				delete(m, k)
-			}
+		if !res.Allowed {
+			break
 		}
 	}
-
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			id, ok := UserID(r.Context())
-			if !ok {
-				next.ServeHTTP(w, r)
-				return
-			}
-			key := id.String()
-			mu.Lock()
-			cleanup()
-			e := m[key]
-			now := time.Now()
-			if now.Sub(e.start) > time.Minute {
-				e = entry{count: 1, start: now}
-			} else {
-				e.count++
-			}
-			m[key] = e
-			mu.Unlock()
-			if e.count > requestsPerMinute {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusTooManyRequests)
-				w.Write([]byte(`{"error":"rate limit exceeded"}`))
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
+	if tightest == nil {
+		return true
+	}
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.Reset)))
+	h.Set("RateLimit-Policy", policy.Header())
+	if tightest.Allowed {
+		return true
 	}
+	h.Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+	writeError(w, r, http.StatusTooManyRequests, "rate_limited", "rate limit exceeded")
+	return false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
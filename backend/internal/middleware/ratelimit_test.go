@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"flipo5/backend/internal/ratelimit"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	rules := ratelimit.Default()
+	rules.User = ratelimit.Policy{Limit: 2, Window: time.Minute}
+	h := RateLimit(ratelimit.New(nil, rules))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ctx := withUserID(context.Background(), uuid.New())
+
+	for i, want := range []int{200, 200, 429} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/jobs", nil).WithContext(ctx))
+		if rec.Code != want {
+			t.Fatalf("request %d: status %d, want %d", i, rec.Code, want)
+		}
+		if rec.Header().Get("RateLimit-Limit") != "2" || rec.Header().Get("RateLimit-Policy") != "2;w=60" {
+			t.Fatalf("request %d: headers %v", i, rec.Header())
+		}
+		if want == 429 && (rec.Header().Get("Retry-After") != "30" || rec.Header().Get("RateLimit-Remaining") != "0") {
+			t.Fatalf("429 headers %v", rec.Header())
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 	"strings"
@@ -12,23 +13,34 @@ import (
 )
 
 // SupabaseAuth verifies Supabase JWT (JWKS or legacy secret), syncs user to DB, sets user ID in context.
+// A personal API key ("fl5_...", Authorization header only) is accepted instead when its scopes allow the route.
 func SupabaseAuth(secret string, jwks *keyfunc.JWKS, db *store.DB) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			raw := r.Header.Get("Authorization")
+			fromQuery := false
 			if raw == "" && r.Method == http.MethodGet && r.URL.Query().Get("token") != "" {
 				raw = "Bearer " + r.URL.Query().Get("token")
+				fromQuery = true
 			}
 			if raw == "" {
-				http.Error(w, `{"error":"missing authorization"}`, http.StatusUnauthorized)
+				writeError(w, r, http.StatusUnauthorized, "unauthorized", "missing authorization")
 				return
 			}
 			const prefix = "Bearer "
 			if !strings.HasPrefix(raw, prefix) {
-				http.Error(w, `{"error":"invalid authorization"}`, http.StatusUnauthorized)
+				writeError(w, r, http.StatusUnauthorized, "unauthorized", "invalid authorization")
 				return
 			}
 			token := strings.TrimPrefix(raw, prefix)
+			if auth.IsAPIKey(token) {
+				if fromQuery {
+					writeError(w, r, http.StatusUnauthorized, "unauthorized", "api keys must be sent in the Authorization header")
+					return
+				}
+				apiKeyAuth(db, token, next, w, r)
+				return
+			}
 			var userID uuid.UUID
 			var email string
 			var err error
@@ -39,17 +51,67 @@ func SupabaseAuth(secret string, jwks *keyfunc.JWKS, db *store.DB) func(next htt
 			}
 			if err != nil {
 				log.Printf("supabase auth: token verify failed: %v (use SUPABASE_URL for JWKS or SUPABASE_JWT_SECRET)", err)
-				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+				writeError(w, r, http.StatusUnauthorized, "unauthorized", "invalid token")
 				return
 			}
-			if err := db.UpsertUser(r.Context(), userID, email); err != nil {
+			plan, err := db.UpsertUserPlan(r.Context(), userID, email)
+			if err != nil {
 				log.Printf("supabase auth: UpsertUser failed: %v", err)
-				http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+				writeError(w, r, http.StatusInternalServerError, "internal", "db error")
 				return
 			}
 			ctx := withUserID(r.Context(), userID)
 			ctx = withEmail(ctx, email)
+			ctx = withPlan(ctx, plan)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+func apiKeyAuth(db *store.DB, key string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	owner, err := db.APIKeyOwnerByHash(r.Context(), auth.HashAPIKey(key))
+	if err != nil {
+		log.Printf("api key auth: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "internal", "db error")
+		return
+	}
+	if owner == nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "invalid api key")
+		return
+	}
+	scope, ok := RequiredScope(r.Method, r.URL.Path)
+	if !ok {
+		writeError(w, r, http.StatusForbidden, "forbidden", "not available with api keys")
+		return
+	}
+	if !hasScope(owner.Scopes, scope) {
+		if isV1(r) {
+			writeError(w, r, http.StatusForbidden, "forbidden", "api key lacks scope "+scope)
+		} else {
+			http.Error(w, `{"error":"api key lacks scope","scope":"`+scope+`"}`, http.StatusForbidden)
+		}
+		return
+	}
+	ctx := withUserID(r.Context(), owner.UserID)
+	ctx = withEmail(ctx, owner.Email)
+	ctx = withPlan(ctx, owner.Plan)
+	ctx = withAPIKeyID(ctx, owner.KeyID)
+	ctx = withAPIKeyScopes(ctx, owner.Scopes)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func isV1(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/v1/")
+}
+
+// writeError writes an error in the shape of the API the request targets:
+// {"error":{"code":...,"message":...}} under /v1, {"error":"..."} under /api.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if !isV1(r) {
+		http.Error(w, `{"error":"`+message+`"}`, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"code": code, "message": message}})
+}
@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"flipo5/backend/internal/extract"
+)
+
+func TestSelectChunks(t *testing.T) {
+	chunks := []string{
+		"Annual report 2024. Summary of the year.",
+		strings.Repeat("Revenue grew in every region. ", 10),
+		strings.Repeat("Office locations and staff. ", 10),
+		strings.Repeat("Refund policy: refunds within 30 days. ", 10),
+	}
+	per := extract.Tokens(chunks[3])
+	got := selectChunks(chunks, "What is the refund policy?", extract.Tokens(chunks[0])+per+10)
+	if want := []int{0, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := selectChunks(chunks, "anything", 1); !reflect.DeepEqual(got, []int{0}) {
+		t.Fatalf("first chunk must always be kept, got %v", got)
+	}
+}
+
+func TestQuestionTerms(t *testing.T) {
+	got := questionTerms("What's the Q3 revenue, and the REVENUE trend in Zürich?")
+	want := []string{"what", "the", "revenue", "and", "trend", "zürich"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -13,9 +13,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 	repgo "github.com/replicate/replicate-go"
+	"flipo5/backend/internal/ai"
+	"flipo5/backend/internal/billing"
 	"flipo5/backend/internal/cache"
 	"flipo5/backend/internal/config"
-	"flipo5/backend/internal/replicate"
+	"flipo5/backend/internal/events"
+	"flipo5/backend/internal/extract"
+	"flipo5/backend/internal/quota"
 	"flipo5/backend/internal/stream"
 	"flipo5/backend/internal/storage"
 	"flipo5/backend/internal/store"
@@ -54,101 +58,21 @@ func (h *Handlers) invalidateJobCaches(ctx context.Context, job *store.Job) {
 	}
 }
 
-// Context strategy (research-based): user questions = topic anchor; full assistant replies = token-heavy.
-// We send: (1) list of user questions = what was discussed; (2) last 2 full exchanges = immediate follow-up.
-const maxUserQuestions = 12       // older: only user prompts (topics)
-const maxRecentFullExchanges = 2 // last N: full user+assistant for "explică mai simplu" etc
-const maxUserQuestionLen = 120   // truncate very long user prompts in topics list
-const maxRecentOutputLen = 800   // truncate assistant in recent exchanges (enough for follow-up)
-
-
-// buildChatContext: older exchanges = user questions only; last 2 = full. Saves tokens, keeps context.
-func buildChatContext(db *store.DB, ctx context.Context, threadID *uuid.UUID, userID, currentJobID uuid.UUID) string {
-	if threadID == nil {
-		return ""
-	}
-	jobs, err := db.ListJobsByThread(ctx, *threadID, userID)
-	if err != nil || len(jobs) == 0 {
-		return ""
-	}
-	var completed []store.Job
-	for _, j := range jobs {
-		if j.ID == currentJobID || j.Type != "chat" || j.Status != "completed" {
-			continue
-		}
-		completed = append(completed, j)
-	}
-	if len(completed) == 0 {
-		return ""
-	}
-	split := len(completed) - maxRecentFullExchanges
-	if split < 0 {
-		split = 0
-	}
-	older := completed[:split]
-	recent := completed[split:]
-
-	var parts []string
-	// Older: user questions only (what was discussed)
-	if len(older) > 0 {
-		var questions []string
-		start := 0
-		if len(older) > maxUserQuestions {
-			start = len(older) - maxUserQuestions
-		}
-		for _, j := range older[start:] {
-			var input map[string]interface{}
-			if len(j.Input) > 0 {
-				_ = json.Unmarshal(j.Input, &input)
-			}
-			if q, _ := input["prompt"].(string); q != "" {
-				q = strings.TrimSpace(q)
-				if len(q) > maxUserQuestionLen {
-					q = q[:maxUserQuestionLen] + "..."
-				}
-				questions = append(questions, "- "+q)
-			}
-		}
-		if len(questions) > 0 {
-			parts = append(parts, "Earlier in this conversation, the user asked about:\n"+strings.Join(questions, "\n"))
-		}
-	}
-	// Recent: full exchanges (follow-up like "explică mai simplu" needs prior answer)
-	for i := range recent {
-		j := &recent[i]
-		var input map[string]interface{}
-		if len(j.Input) > 0 {
-			_ = json.Unmarshal(j.Input, &input)
-		}
-		userMsg, _ := input["prompt"].(string)
-		if userMsg == "" {
-			continue
-		}
-		var output map[string]interface{}
-		assistantMsg := ""
-		if len(j.Output) > 0 {
-			_ = json.Unmarshal(j.Output, &output)
-			assistantMsg, _ = output["output"].(string)
-		}
-		if len(assistantMsg) > maxRecentOutputLen {
-			assistantMsg = strings.TrimSpace(assistantMsg[:maxRecentOutputLen]) + "..."
-		}
-		parts = append(parts, "User: "+userMsg+"\n\nAssistant: "+assistantMsg)
-	}
-	if len(parts) == 0 {
-		return ""
-	}
-	return strings.Join(parts, "\n\n")
-}
-
 type Handlers struct {
-	DB     *store.DB
-	Cfg    *config.Config
-	Repl   *replicate.Client
-	Store  *storage.Store
-	Asynq  *asynq.Client
-	Stream *stream.Publisher // Redis pub/sub for real-time SSE
-	Cache  *cache.Redis     // for cache invalidation when jobs complete
+	DB      *store.DB
+	Cfg     *config.Config
+	Repl    ai.Provider
+	Store   *storage.Store
+	Asynq   *asynq.Client
+	Stream  *stream.Publisher // Redis pub/sub for real-time SSE
+	Cache   *cache.Redis      // for cache invalidation when jobs complete
+	Billing *billing.Table    // prices completed jobs into cost_cents/cost_ledger; nil = no cost tracking
+	// Credits and Plans check jobs the worker creates itself (chat tools), like the API does at job creation:
+	// credit holds (nil = not enforced) and plan quotas (nil = unlimited).
+	Credits *billing.Table
+	Plans   quota.Plans
+	// Embedder indexes chat project files for retrieval; nil = project files go into the prompt whole (documentContext)
+	Embedder ai.Embedder
 }
 
 func (h *Handlers) ChatHandler(ctx context.Context, t *asynq.Task) error {
@@ -156,21 +80,23 @@ func (h *Handlers) ChatHandler(ctx context.Context, t *asynq.Task) error {
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
 	if h.Repl == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Replicate not configured", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "Replicate not configured", "", 0)
 		return nil
 	}
-	model := h.Cfg.ModelText
+	model := h.Cfg.TextModel("chat")
 	if model == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_TEXT not set", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_TEXT not set", "", 0)
 		return nil
 	}
 	job, err := h.DB.GetJob(ctx, p.JobID)
 	if err != nil || job == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "job not found", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "job not found", "", 0)
 		return nil
 	}
+	// Announces the job to its thread's WebSocket subscribers before the first delta.
+	h.publishJobStatus(ctx, job, "running", "")
 	u, _ := h.DB.UserByID(ctx, job.UserID)
 	userName := ""
 	if u != nil && strings.TrimSpace(u.FullName) != "" {
@@ -232,11 +158,14 @@ Voice and style:
 	// Apply chat project (Grok-style projects): prepend custom instructions and
 	// split source files by kind. The text model on Replicate only accepts images
 	// on the `images` input (sending PDFs/docs returns E006), so we forward images
-	// and list non-image files by name/type so the model knows they exist.
+	// and add the text of the other files to the prompt (see documentContext).
 	var projectImageURLs []string
-	var projectDocFiles []string
+	var projectID *uuid.UUID
+	var projectDocs []store.ChatProjectFile
+	var docs []chatDocument
 	if job.ThreadID != nil {
 		if pid, _ := h.DB.GetThreadProjectID(ctx, *job.ThreadID); pid != nil {
+			projectID = pid
 			if proj, _ := h.DB.GetChatProject(ctx, *pid, job.UserID); proj != nil {
 				if strings.TrimSpace(proj.Instructions) != "" {
 					system += "\n\nProject instructions (apply to every reply in this conversation): " + strings.TrimSpace(proj.Instructions)
@@ -247,60 +176,29 @@ Voice and style:
 						if strings.HasPrefix(fileURL, "uploads/") && h.Store != nil {
 							fileURL = h.Store.URL(fileURL)
 						}
-						name := f.FileName
-						if name == "" {
-							name = "file"
-						}
 						if strings.HasPrefix(f.ContentType, "image/") {
 							if fileURL != "" {
 								projectImageURLs = append(projectImageURLs, fileURL)
 							}
 						} else {
-							label := name
-							if f.ContentType != "" {
-								label += " (" + f.ContentType + ")"
-							}
-							projectDocFiles = append(projectDocFiles, label)
+							projectDocs = append(projectDocs, f)
 						}
 					}
 					if len(projectImageURLs) > 0 {
 						system += "\n\nThe user attached project reference images to this conversation. Look at them, remember them across turns, and ground your answers in what they show."
 					}
-					if len(projectDocFiles) > 0 {
-						var b strings.Builder
-						b.WriteString("\n\nProject reference documents listed (you cannot read their content directly yet; if asked, explain this and suggest the user pastes the relevant excerpt):")
-						for _, label := range projectDocFiles {
-							b.WriteString("\n- ")
-							b.WriteString(label)
-						}
-						system += b.String()
-					}
 				}
 			}
 		}
 	}
 
-	// Conversation context: previous exchanges in this thread (so AI remembers follow-ups)
-	contextBlock := buildChatContext(h.DB, ctx, job.ThreadID, job.UserID, p.JobID)
-	prompt := system + "\n\n"
-	if contextBlock != "" {
-		prompt += contextBlock + "\n\n"
-	}
-	prompt += "User: " + p.Prompt
-	input := repgo.PredictionInput{
-		"prompt":            prompt,
-		"max_output_tokens": 16384,
-	}
+	// Only image URLs are accepted by the text model. PDFs/docs cause E006 "invalid input",
+	// so their extracted text goes into the prompt instead.
 	var jobInput map[string]interface{}
 	if len(job.Input) > 0 {
 		_ = json.Unmarshal(job.Input, &jobInput)
 	}
-	// Only image URLs are accepted by the text model. PDFs/docs cause E006 "invalid input".
-	// TODO(roadmap): extract text from PDFs server-side (e.g. github.com/ledongthuc/pdf)
-	//   and append it to the system prompt so chat attachments + project files become
-	//   readable. Applies to both chat messages and chat_project_files.
 	images := make([]string, 0, len(projectImageURLs)+4)
-	hasNonImage := len(projectDocFiles) > 0
 	images = append(images, projectImageURLs...)
 	if urls, ok := jobInput["attachment_urls"].([]interface{}); ok && len(urls) > 0 {
 		types, _ := jobInput["attachment_content_types"].([]interface{})
@@ -310,154 +208,280 @@ Voice and style:
 				continue
 			}
 			if i < len(types) {
-				if ct, ok := types[i].(string); ok && !strings.HasPrefix(ct, "image/") {
-					hasNonImage = true
+				if ct, ok := types[i].(string); ok && ct != "" && !strings.HasPrefix(ct, "image/") {
+					docs = append(docs, chatDocument{ContentType: ct, URL: urlStr})
 					continue
 				}
 			}
 			images = append(images, urlStr)
 		}
 	}
-	if len(images) > 0 {
-		input["images"] = images
+	// Project files are searched (top-k chunks, cited); if that is not possible they are handled like
+	// attachments, whose text goes into the prompt within the document budget.
+	var citations []citation
+	var unread []string
+	if len(projectDocs) > 0 {
+		block, cites, failed, err := h.projectSources(ctx, job.UserID, *projectID, projectDocs, p.Prompt)
+		if err == nil {
+			if block != "" {
+				system += "\n\n" + block
+			}
+			citations, unread = cites, failed
+		} else {
+			if !errors.Is(err, errRetrievalOff) {
+				log.Printf("[ChatHandler] project retrieval for job %s: %v", p.JobID, err)
+			}
+			for _, f := range projectDocs {
+				docs = append(docs, chatDocument{Name: f.FileName, ContentType: f.ContentType, URL: f.FileURL})
+			}
+		}
+	}
+	if len(docs) > 0 {
+		block, failed := h.documentContext(ctx, job.UserID, docs, p.Prompt, h.Cfg.ChatDocumentTokens)
+		if block != "" {
+			system += "\n\n" + block
+		}
+		unread = append(unread, failed...)
 	}
-	if hasNonImage {
-		input["prompt"] = prompt + "\n\n[Non-image files (e.g. PDFs, docs) cannot be read directly by the text model. If the user asks about their content, explain this and suggest they paste the relevant text or upload an image/screenshot.]"
+	if len(unread) > 0 {
+		system += "\n\nThese files could not be read: " + strings.Join(unread, "; ") +
+			". If the user asks about them, say so and suggest pasting the relevant text or uploading a screenshot."
 	}
-	// Prefer streaming: create prediction with stream, then consume stream and update job output per chunk
+
+	// Tools the model may call (tools.go) are described in the system prompt and run between passes.
+	tools := h.chatTools()
+	if len(tools) > 0 {
+		system += "\n\n" + toolInstructions(tools)
+	}
+
+	// Conversation: system prompt, summary and latest exchanges of this thread within the model's budget
+	// (memory.go), then the question. The model's adapter renders it to the input that model expects.
+	msgs := []ai.Message{{Role: ai.RoleSystem, Content: system}}
+	msgs = append(msgs, h.chatContext(ctx, job, model)...)
+	msgs = append(msgs, ai.Message{Role: ai.RoleUser, Content: p.Prompt, Images: images})
+	adapter := ai.AdapterFor(model, h.Cfg.ModelFormats)
+
+	// One pass per model call. A pass that calls tools gets their results and the reply continues in the
+	// next one, for up to ChatToolSteps rounds; the reply is the text of all passes without the calls.
+	var done chatProgress
+	var predID string
+	for step := 1; ; step++ {
+		pass, ended, err := h.chatPass(ctx, p.JobID, model, adapter.Render(msgs, 16384), done, len(tools) > 0)
+		if ended {
+			return err
+		}
+		done.Cost += pass.Cost
+		predID = pass.PredID
+		text, reqs := pass.Text, []toolRequest(nil)
+		if len(tools) > 0 {
+			text, reqs = parseToolCalls(pass.Text)
+		}
+		done.Reply = strings.TrimSpace(done.prefix() + text)
+		if len(reqs) == 0 || step > h.Cfg.ChatToolSteps {
+			break
+		}
+		if len(reqs) > maxToolCallsPerStep {
+			reqs = reqs[:maxToolCallsPerStep]
+		}
+		round := make([]toolCall, 0, len(reqs))
+		for _, req := range reqs {
+			call := h.runTool(ctx, job, tools, req, fmt.Sprintf("call_%d", len(done.Calls)+1))
+			done.Cost += call.cost
+			done.Calls = append(done.Calls, call)
+			round = append(round, call)
+		}
+		// The user may have cancelled while the tools ran.
+		if cur, _ := h.DB.GetJob(context.WithoutCancel(ctx), p.JobID); cur != nil && cur.Status == "cancelled" {
+			return nil
+		}
+		_ = h.DB.UpdateJobOutput(ctx, p.JobID, done.output(done.Reply))
+		msgs = append(msgs,
+			ai.Message{Role: ai.RoleAssistant, Content: strings.TrimSpace(pass.Text)},
+			ai.Message{Role: ai.RoleUser, Content: toolResults(round, step == h.Cfg.ChatToolSteps)})
+	}
+	final := done.output(done.Reply)
+	if len(citations) > 0 {
+		final["citations"] = citations
+	}
+	// The whole reply goes out before the status that ends the stream.
+	if h.Stream != nil {
+		_ = h.Stream.PublishJob(ctx, p.JobID, events.JobDelta{JobID: p.JobID, Text: done.Reply, Replace: true})
+	}
+	if _, changed := h.finishJob(ctx, job, "completed", final, "", predID, done.Cost); !changed {
+		return nil
+	}
+	if job.ThreadID != nil && h.Asynq != nil {
+		if task, err := NewSummarizeThreadTask(*job.ThreadID); err == nil {
+			_, _ = h.Asynq.Enqueue(task, asynq.ProcessIn(10*time.Minute), asynq.Unique(10*time.Minute))
+		}
+		h.enqueueThreadSummary(*job.ThreadID)
+	}
+	return nil
+}
+
+// chatPassResult is the outcome of one model call of a chat reply.
+type chatPassResult struct {
+	Text   string
+	PredID string
+	Cost   int
+}
+
+// chatProgress is what a chat reply did before the current pass. A pass that fails ends the job with it:
+// the output keeps the earlier text and tool calls, and the earlier passes and tools are charged.
+type chatProgress struct {
+	Reply string     // text of the earlier passes, without tool calls
+	Calls []toolCall // tool calls of the earlier rounds
+	Cost  int        // cents of the earlier passes and tool calls
+}
+
+// prefix is the reply so far as the next pass's text continues it.
+func (p chatProgress) prefix() string {
+	if p.Reply == "" {
+		return ""
+	}
+	return p.Reply + "\n\n"
+}
+
+// output is the job output for the reply text so far.
+func (p chatProgress) output(text string) map[string]interface{} {
+	out := map[string]interface{}{"output": text}
+	if len(p.Calls) > 0 {
+		out["tool_calls"] = p.Calls
+	}
+	return out
+}
+
+// partial is the job output before a pass: nil before the first one.
+func (p chatProgress) partial() interface{} {
+	if p.Reply == "" && len(p.Calls) == 0 {
+		return nil
+	}
+	return p.output(p.Reply)
+}
+
+// chatPass runs one model call of a chat reply. When the model streams, the job output and stream show
+// the reply so far plus the text as it arrives, without any tool call the model starts writing
+// (hideCalls); the final text comes from the prediction since the stream can lose chunks. Models without
+// a stream are polled. ended means the job was failed here (with done's output and cost) and the handler
+// returns err.
+func (h *Handlers) chatPass(ctx context.Context, jobID uuid.UUID, model string, input map[string]interface{}, done chatProgress, hideCalls bool) (chatPassResult, bool, error) {
 	pred, err := h.Repl.CreatePredictionWithStream(ctx, model, input)
 	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		if h.Stream != nil {
-			errMsg := jobErrorMsg(err)
-			_ = h.Stream.Publish(ctx, p.JobID, fmt.Sprintf(`{"status":"failed","error":"%s"}`, errMsg), true)
-		}
-		return err
+		h.finishJobByID(ctx, jobID, "failed", done.partial(), jobErrorMsg(err), "", done.Cost)
+		return chatPassResult{}, true, err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, pred.ID)
+	_, _ = h.DB.UpdateJobStatus(ctx, jobID, "running", done.partial(), "", done.Cost, pred.ID)
 	streamURL := ""
 	if pred.URLs != nil {
 		streamURL = pred.URLs["stream"]
 	}
-	if streamURL != "" {
-		var acc strings.Builder
-		h.Repl.StreamOutput(ctx, streamURL, func(text string) {
-			acc.WriteString(text)
-			out := acc.String()
-			_ = h.DB.UpdateJobOutput(ctx, p.JobID, map[string]interface{}{"output": out})
-			if h.Stream != nil {
-				_ = h.Stream.Publish(ctx, p.JobID, out, false)
-			}
-		}, func() {})
-		// Use GetPrediction final output - Replicate returns complete output; stream can lose chunks
-		finalOutput := acc.String()
-		var lastPred *repgo.Prediction
-		for i := 0; i < 5; i++ {
-			select {
-			case <-ctx.Done():
-				_ = h.Repl.CancelPrediction(context.Background(), pred.ID)
-				_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, ErrMsgServerUnavailable, 0, pred.ID)
-				return nil
-			default:
-			}
-			predState, err := h.Repl.GetPrediction(ctx, pred.ID)
-			if err != nil {
-				if i < 4 {
-					time.Sleep(500 * time.Millisecond)
-				}
-				continue
-			}
-			lastPred = predState
-			if predState.Status == "failed" || predState.Status == "canceled" {
-				_ = h.Repl.CancelPrediction(ctx, pred.ID)
-				errMsg := ""
-				if predState.Error != nil {
-					if s, ok := predState.Error.(string); ok {
-						errMsg = s
-					} else {
-						errMsg = fmt.Sprintf("%v", predState.Error)
-					}
-				}
-				if errMsg == "" {
-					errMsg = "Prediction failed"
-				}
-				_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, errMsg, 0, pred.ID)
-				return nil
-			}
-			if predState.Status != "succeeded" {
-				if i < 4 {
-					time.Sleep(500 * time.Millisecond)
-				}
-				continue
-			}
-			if out := normalizeChatOutput(predState.Output); out != nil {
-				if m, ok := out.(map[string]interface{}); ok {
-					if s, _ := m["output"].(string); s != "" {
-						finalOutput = s // API = source of truth, stream can truncate
-					}
-				}
-			}
-			break
+	if streamURL == "" {
+		return h.pollChatPass(ctx, jobID, model, pred.ID, done)
+	}
+	prefix := done.prefix()
+	var acc strings.Builder
+	shown := ""
+	h.Repl.StreamOutput(ctx, streamURL, func(text string) {
+		acc.WriteString(text)
+		out := acc.String()
+		if hideCalls {
+			out = visibleReply(out)
 		}
-		if lastPred != nil && (lastPred.Status == "failed" || lastPred.Status == "canceled") {
-			return nil // already updated above
+		if out = prefix + out; out == shown {
+			return
 		}
-		final := map[string]interface{}{"output": finalOutput}
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", final, "", 0, pred.ID)
+		// Publish before the DB write: a client that connects in between reads the events, not the row.
+		// A pass starts with the whole output (the reply so far is in it), then sends what is new.
 		if h.Stream != nil {
-			_ = h.Stream.Publish(ctx, p.JobID, finalOutput, true)
-			// Also publish to user-specific channel for streamAllJobs (chat jobs)
-			if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				updateMsg := fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"chat"}`, p.JobID.String())
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, updateMsg)
-			}
+			if shown != "" && strings.HasPrefix(out, shown) {
+				_ = h.Stream.PublishDelta(ctx, jobID, out[len(shown):])
+			} else {
+				_ = h.Stream.PublishJob(ctx, jobID, events.JobDelta{JobID: jobID, Text: out, Replace: true})
+			}
+		}
+		shown = out
+		_ = h.DB.UpdateJobOutput(ctx, jobID, map[string]interface{}{"output": out})
+	}, func() {})
+	// Use GetPrediction final output - Replicate returns complete output; stream can lose chunks
+	finalOutput := acc.String()
+	var lastPred *repgo.Prediction
+	for i := 0; i < 5; i++ {
+		select {
+		case <-ctx.Done():
+			_ = h.Repl.CancelPrediction(context.Background(), pred.ID)
+			h.finishJobByID(ctx, jobID, "failed", done.partial(), ErrMsgServerUnavailable, pred.ID, done.Cost)
+			return chatPassResult{}, true, nil
+		default:
 		}
-		if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-			h.invalidateJobCaches(ctx, job)
+		predState, err := h.Repl.GetPrediction(ctx, pred.ID)
+		if err != nil {
+			if i < 4 {
+				time.Sleep(500 * time.Millisecond)
+			}
+			continue
 		}
-	} else {
-		// Fallback: model doesn't support stream; poll until done
-		jobID := p.JobID
-		for {
-			select {
-			case <-ctx.Done():
-				_ = h.Repl.CancelPrediction(context.Background(), pred.ID)
-				_ = h.DB.UpdateJobStatus(ctx, jobID, "failed", nil, ErrMsgServerUnavailable, 0, pred.ID)
-				return nil
-			default:
+		lastPred = predState
+		if predState.Status == "failed" || predState.Status == "canceled" {
+			_ = h.Repl.CancelPrediction(ctx, pred.ID)
+			errMsg := ""
+			if predState.Error != nil {
+				if s, ok := predState.Error.(string); ok {
+					errMsg = s
+				} else {
+					errMsg = fmt.Sprintf("%v", predState.Error)
+				}
 			}
-			predState, err := h.Repl.GetPrediction(ctx, pred.ID)
-			if err != nil {
-				_ = h.DB.UpdateJobStatus(ctx, jobID, "failed", nil, jobErrorMsg(err), 0, pred.ID)
-				return err
+			if errMsg == "" {
+				errMsg = "Prediction failed"
 			}
-			switch predState.Status {
-			case "succeeded":
-				normalized := normalizeChatOutput(predState.Output)
-				_ = h.DB.UpdateJobStatus(ctx, jobID, "completed", normalized, "", 0, pred.ID)
-				goto done
-			case "failed", "canceled":
-				_ = h.Repl.CancelPrediction(ctx, pred.ID)
-				errMsg := ""
-				if predState.Error != nil {
-					if s, ok := predState.Error.(string); ok {
-						errMsg = s
-					}
-				}
-				_ = h.DB.UpdateJobStatus(ctx, jobID, "failed", nil, errMsg, 0, pred.ID)
-				return nil
+			h.finishJobByID(ctx, jobID, "failed", done.partial(), errMsg, pred.ID, done.Cost)
+			return chatPassResult{}, true, nil
+		}
+		if predState.Status != "succeeded" {
+			if i < 4 {
+				time.Sleep(500 * time.Millisecond)
 			}
-			time.Sleep(2 * time.Second)
+			continue
+		}
+		if s := predictionText(predState.Output); s != "" {
+			finalOutput = s // API = source of truth, stream can truncate
 		}
-	done:
+		break
 	}
-	if job.ThreadID != nil && h.Asynq != nil {
-		if task, err := NewSummarizeThreadTask(*job.ThreadID); err == nil {
-			_, _ = h.Asynq.Enqueue(task, asynq.Queue("default"), asynq.ProcessIn(10*time.Minute), asynq.Unique(10*time.Minute))
+	return chatPassResult{Text: finalOutput, PredID: pred.ID, Cost: h.Billing.PredictionCost(model, lastPred)}, false, nil
+}
+
+// pollChatPass is chatPass for a model that does not stream: poll until done.
+func (h *Handlers) pollChatPass(ctx context.Context, jobID uuid.UUID, model, predID string, done chatProgress) (chatPassResult, bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			_ = h.Repl.CancelPrediction(context.Background(), predID)
+			h.finishJobByID(ctx, jobID, "failed", done.partial(), ErrMsgServerUnavailable, predID, done.Cost)
+			return chatPassResult{}, true, nil
+		default:
+		}
+		predState, err := h.Repl.GetPrediction(ctx, predID)
+		if err != nil {
+			h.finishJobByID(ctx, jobID, "failed", done.partial(), jobErrorMsg(err), predID, done.Cost)
+			return chatPassResult{}, true, err
+		}
+		switch predState.Status {
+		case "succeeded":
+			return chatPassResult{Text: predictionText(predState.Output), PredID: predID, Cost: h.Billing.PredictionCost(model, predState)}, false, nil
+		case "failed", "canceled":
+			_ = h.Repl.CancelPrediction(ctx, predID)
+			errMsg := ""
+			if predState.Error != nil {
+				if s, ok := predState.Error.(string); ok {
+					errMsg = s
+				}
+			}
+			h.finishJobByID(ctx, jobID, "failed", done.partial(), errMsg, predID, done.Cost)
+			return chatPassResult{}, true, nil
 		}
+		time.Sleep(2 * time.Second)
 	}
-	return nil
 }
 
 func normalizeChatOutput(out repgo.PredictionOutput) repgo.PredictionOutput {
@@ -508,23 +532,15 @@ func (h *Handlers) ImageHandler(ctx context.Context, t *asynq.Task) error {
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
-		if h.Stream != nil {
-			_ = h.Stream.Publish(ctx, p.JobID, `{"status":"running"}`, false)
-			// Also publish to user-specific channel for streamAllJobs
-			if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				updateMsg := fmt.Sprintf(`{"jobId":"%s","status":"running","type":"%s"}`, p.JobID.String(), job.Type)
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, updateMsg)
-			}
-		}
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	h.publishJobStatusByID(ctx, p.JobID, "running", "")
 	if h.Repl == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Replicate not configured", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "Replicate not configured", "", 0)
 		return nil
 	}
 	job, err := h.DB.GetJob(ctx, p.JobID)
 	if err != nil || job == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "job not found", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "job not found", "", 0)
 		return nil
 	}
 	var jobInput map[string]interface{}
@@ -536,7 +552,7 @@ func (h *Handlers) ImageHandler(ctx context.Context, t *asynq.Task) error {
 	}
 	prompt, _ := jobInput["prompt"].(string)
 	if prompt == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "prompt required", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "prompt required", "", 0)
 		return nil
 	}
 	size, _ := jobInput["size"].(string)
@@ -555,7 +571,7 @@ func (h *Handlers) ImageHandler(ctx context.Context, t *asynq.Task) error {
 		if imageURL != "" && maskURL != "" {
 			model := h.Cfg.ModelFluxFill
 			if model == "" {
-				_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_FLUX_FILL not set", 0, "")
+				h.finishJob(ctx, job, "failed", nil, "REPLICATE_MODEL_FLUX_FILL not set", "", 0)
 				return nil
 			}
 			steps := 50
@@ -576,32 +592,14 @@ func (h *Handlers) ImageHandler(ctx context.Context, t *asynq.Task) error {
 				"safety_tolerance": 2,
 				"prompt_upsampling": false,
 			}
-			out, err := h.Repl.Run(ctx, model, input)
-			if err != nil {
-				_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-				return err
-			}
-			outNormalized := normalizeNanoBananaOutput(out) // single URL
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", outNormalized, "", 0, "")
-			if h.Stream != nil {
-				_ = h.Stream.Publish(ctx, p.JobID, `{"status":"completed"}`, true)
-				if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-					userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-					_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"image"}`, p.JobID.String()))
-				}
-			}
-			if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-				h.invalidateJobCaches(ctx, job)
-			}
-			go mirrorMediaToR2(h, p.JobID, outNormalized, "image")
-			return nil
+			return h.submitMediaPrediction(ctx, job, model, input)
 		}
 	}
 
 	if size == "HD" {
 		model := h.Cfg.ModelImageHD
 		if model == "" {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_IMAGE_HD not set", 0, "")
+			h.finishJob(ctx, job, "failed", nil, "REPLICATE_MODEL_IMAGE_HD not set", "", 0)
 			return nil
 		}
 		input := repgo.PredictionInput{
@@ -620,34 +618,12 @@ func (h *Handlers) ImageHandler(ctx context.Context, t *asynq.Task) error {
 				input["image_input"] = imgUrls
 			}
 		}
-		out, err := h.Repl.Run(ctx, model, input)
-		if err != nil {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-			return err
-		}
-		// nano-banana returns single URL string; normalize to {"output": "url"} for r2mirror
-		outNormalized := normalizeNanoBananaOutput(out)
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", outNormalized, "", 0, "")
-		if h.Stream != nil {
-			_ = h.Stream.Publish(ctx, p.JobID, `{"status":"completed"}`, true)
-			// Also publish to user-specific channel for streamAllJobs
-			if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				updateMsg := fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"image"}`, p.JobID.String())
-				log.Printf("[ImageHandler] Publishing job update: %s -> %s", userJobsChannel, updateMsg)
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, updateMsg)
-			}
-		}
-		if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-			h.invalidateJobCaches(ctx, job)
-		}
-		go mirrorMediaToR2(h, p.JobID, outNormalized, "image")
-		return nil
+		return h.submitMediaPrediction(ctx, job, model, input)
 	}
 
 	model := h.Cfg.ModelImage
 	if model == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_IMAGE not set", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "REPLICATE_MODEL_IMAGE not set", "", 0)
 		return nil
 	}
 	input := make(repgo.PredictionInput)
@@ -666,34 +642,7 @@ func (h *Handlers) ImageHandler(ctx context.Context, t *asynq.Task) error {
 	if input["sequential_image_generation"] == nil || input["sequential_image_generation"] == "" {
 		input["sequential_image_generation"] = "disabled"
 	}
-	out, err := h.Repl.Run(ctx, model, input)
-	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		if h.Stream != nil {
-			errMsg := jobErrorMsg(err)
-			_ = h.Stream.Publish(ctx, p.JobID, fmt.Sprintf(`{"status":"failed","error":"%s"}`, errMsg), true)
-		}
-		return err
-	}
-	// Seedream returns array directly; r2mirror expects {"output": [...]}
-	if arr, ok := out.([]interface{}); ok {
-		out = map[string]interface{}{"output": arr}
-	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", out, "", 0, "")
-	if h.Stream != nil {
-		_ = h.Stream.Publish(ctx, p.JobID, `{"status":"completed"}`, true)
-		// Also publish to user-specific channel for streamAllJobs
-		if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-			userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-			updateMsg := fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"image"}`, p.JobID.String())
-			_ = h.Stream.PublishRaw(ctx, userJobsChannel, updateMsg)
-		}
-	}
-	if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-		h.invalidateJobCaches(ctx, job)
-	}
-	go mirrorMediaToR2(h, p.JobID, out, "image")
-	return nil
+	return h.submitMediaPrediction(ctx, job, model, input)
 }
 
 // normalizeNanoBananaOutput: nano-banana returns single URL string; wrap as {"output": "url"} for r2mirror.
@@ -712,20 +661,15 @@ func (h *Handlers) LogoHandler(ctx context.Context, t *asynq.Task) error {
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
-	if h.Stream != nil {
-		if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-			userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-			_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"running","type":"logo"}`, p.JobID.String()))
-		}
-	}
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	h.publishJobStatusByID(ctx, p.JobID, "running", "")
 	if h.Repl == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Replicate not configured", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "Replicate not configured", "", 0)
 		return nil
 	}
 	model := h.Cfg.ModelImageHD
 	if model == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Logo model not configured", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "Logo model not configured", "", 0)
 		return nil
 	}
 	job, err := h.DB.GetJob(ctx, p.JobID)
@@ -738,7 +682,7 @@ func (h *Handlers) LogoHandler(ctx context.Context, t *asynq.Task) error {
 	}
 	prompt, _ := jobInput["prompt"].(string)
 	if prompt == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "prompt required", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "prompt required", "", 0)
 		return nil
 	}
 	aspectRatio, _ := jobInput["aspect_ratio"].(string)
@@ -795,7 +739,7 @@ func (h *Handlers) LogoHandler(ctx context.Context, t *asynq.Task) error {
 	for i := 0; i < 3; i++ {
 		out, err := h.Repl.Run(ctx, model, replInput)
 		if err != nil {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
+			h.finishJob(ctx, job, "failed", nil, jobErrorMsg(err), "", 0)
 			return nil
 		}
 		normalized := normalizeNanoBananaOutput(out)
@@ -806,21 +750,13 @@ func (h *Handlers) LogoHandler(ctx context.Context, t *asynq.Task) error {
 		}
 	}
 	if len(urls) == 0 {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "No logo output", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "No logo output", "", 0)
 		return nil
 	}
 	outNormalized := map[string]interface{}{"output": urls}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", outNormalized, "", 0, "")
-	if h.Stream != nil {
-		if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-			userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-			_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"logo"}`, p.JobID.String()))
-		}
-	}
-	if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-		h.invalidateJobCaches(ctx, job)
+	if _, changed := h.finishJob(ctx, job, "completed", outNormalized, "", "", h.Billing.Cost(model, nil, len(urls))); changed {
+		go mirrorMediaToR2(h, p.JobID, outNormalized, "image")
 	}
-	go mirrorMediaToR2(h, p.JobID, outNormalized, "image")
 	return nil
 }
 
@@ -829,21 +765,10 @@ func (h *Handlers) VideoHandler(ctx context.Context, t *asynq.Task) error {
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
-		if h.Stream != nil {
-			_ = h.Stream.Publish(ctx, p.JobID, `{"status":"running"}`, false)
-			// Also publish to user-specific channel for streamAllJobs
-			if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				updateMsg := fmt.Sprintf(`{"jobId":"%s","status":"running","type":"%s"}`, p.JobID.String(), job.Type)
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, updateMsg)
-			}
-		}
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	h.publishJobStatusByID(ctx, p.JobID, "running", "")
 	if h.Repl == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Replicate not configured", 0, "")
-		if h.Stream != nil {
-			_ = h.Stream.Publish(ctx, p.JobID, `{"status":"failed","error":"Replicate not configured"}`, true)
-		}
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "Replicate not configured", "", 0)
 		return nil
 	}
 	job, err := h.DB.GetJob(ctx, p.JobID)
@@ -863,7 +788,7 @@ func (h *Handlers) VideoHandler(ctx context.Context, t *asynq.Task) error {
 	if videoModel == "2" {
 		model = h.Cfg.ModelVideo2
 		if model == "" {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_VIDEO_2 not set", 0, "")
+			h.finishJob(ctx, job, "failed", nil, "REPLICATE_MODEL_VIDEO_2 not set", "", 0)
 			return nil
 		}
 		dur := 5 // Kling only supports 5 or 10 seconds
@@ -888,7 +813,7 @@ func (h *Handlers) VideoHandler(ctx context.Context, t *asynq.Task) error {
 	} else {
 		model = h.Cfg.ModelVideo
 		if model == "" {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_VIDEO not set", 0, "")
+			h.finishJob(ctx, job, "failed", nil, "REPLICATE_MODEL_VIDEO not set", "", 0)
 			return nil
 		}
 		input = make(repgo.PredictionInput)
@@ -905,34 +830,7 @@ func (h *Handlers) VideoHandler(ctx context.Context, t *asynq.Task) error {
 			input["resolution"] = "720p"
 		}
 	}
-	out, err := h.Repl.Run(ctx, model, input)
-	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		if h.Stream != nil {
-			errMsg := jobErrorMsg(err)
-			_ = h.Stream.Publish(ctx, p.JobID, fmt.Sprintf(`{"status":"failed","error":"%s"}`, errMsg), true)
-		}
-		return err
-	}
-	outNormalized := out
-	if s, ok := out.(string); ok && s != "" {
-		outNormalized = map[string]interface{}{"output": s}
-	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", outNormalized, "", 0, "")
-	if h.Stream != nil {
-		_ = h.Stream.Publish(ctx, p.JobID, `{"status":"completed"}`, true)
-		// Also publish to user-specific channel for streamAllJobs
-		if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-			userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-			updateMsg := fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"video"}`, p.JobID.String())
-			_ = h.Stream.PublishRaw(ctx, userJobsChannel, updateMsg)
-		}
-	}
-	if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-		h.invalidateJobCaches(ctx, job)
-	}
-	go mirrorMediaToR2(h, p.JobID, outNormalized, "video")
-	return nil
+	return h.submitMediaPrediction(ctx, job, model, input)
 }
 
 func (h *Handlers) UpscaleHandler(ctx context.Context, t *asynq.Task) error {
@@ -940,21 +838,15 @@ func (h *Handlers) UpscaleHandler(ctx context.Context, t *asynq.Task) error {
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
-	if h.Stream != nil {
-		if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-			_ = h.Stream.Publish(ctx, p.JobID, `{"status":"running"}`, false)
-			userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-			_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"running","type":"upscale"}`, p.JobID.String()))
-		}
-	}
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	h.publishJobStatusByID(ctx, p.JobID, "running", "")
 	if h.Repl == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Replicate not configured", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "Replicate not configured", "", 0)
 		return nil
 	}
 	model := h.Cfg.ModelUpscale
 	if model == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_UPSCALE not set", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_UPSCALE not set", "", 0)
 		return nil
 	}
 	job, err := h.DB.GetJob(ctx, p.JobID)
@@ -967,7 +859,7 @@ func (h *Handlers) UpscaleHandler(ctx context.Context, t *asynq.Task) error {
 	}
 	imageURL, _ := jobInput["image_url"].(string)
 	if imageURL == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "missing image_url", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "missing image_url", "", 0)
 		return nil
 	}
 	scale := 2
@@ -1014,28 +906,7 @@ func (h *Handlers) UpscaleHandler(ctx context.Context, t *asynq.Task) error {
 		input["face_enhancement_creativity"] = faceCreativity
 		input["face_enhancement_strength"] = faceStrength
 	}
-	out, err := h.Repl.Run(ctx, model, input)
-	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		if h.Stream != nil {
-			_ = h.Stream.Publish(ctx, p.JobID, fmt.Sprintf(`{"status":"failed","error":"%s"}`, jobErrorMsg(err)), true)
-		}
-		return err
-	}
-	outNormalized := normalizeNanoBananaOutput(out)
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", outNormalized, "", 0, "")
-	if h.Stream != nil {
-		_ = h.Stream.Publish(ctx, p.JobID, `{"status":"completed"}`, true)
-		if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-			userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-			_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"upscale"}`, p.JobID.String()))
-		}
-	}
-	if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-		h.invalidateJobCaches(ctx, job)
-	}
-	go mirrorMediaToR2(h, p.JobID, outNormalized, "image")
-	return nil
+	return h.submitMediaPrediction(ctx, job, model, input)
 }
 
 func (h *Handlers) CancelStaleJobsHandler(ctx context.Context, t *asynq.Task) error {
@@ -1043,11 +914,12 @@ func (h *Handlers) CancelStaleJobsHandler(ctx context.Context, t *asynq.Task) er
 	if err != nil || len(jobs) == 0 {
 		return err
 	}
-	for _, j := range jobs {
+	for i := range jobs {
+		j := &jobs[i]
 		if j.ReplicateID != nil && *j.ReplicateID != "" && h.Repl != nil {
 			_ = h.Repl.CancelPrediction(ctx, *j.ReplicateID)
 		}
-		_ = h.DB.UpdateJobStatus(ctx, j.ID, "failed", nil, "Job cancelled (timeout)", 0, "")
+		h.finishJob(ctx, j, "failed", nil, "Job cancelled (timeout)", "", 0)
 	}
 	return nil
 }
@@ -1061,11 +933,12 @@ func (h *Handlers) SummarizeThreadHandler(ctx context.Context, t *asynq.Task) er
 	if err != nil || thread == nil {
 		return nil
 	}
-	// ListJobsByThread requires userID - get from thread
-	jobs, err := h.DB.ListJobsByThread(ctx, p.ThreadID, thread.UserID)
-	if err != nil || len(jobs) == 0 {
+	// Title from the active branch; ListThreadBranch requires userID - get from thread
+	branch, err := h.DB.ListThreadBranch(ctx, p.ThreadID, thread.UserID)
+	if err != nil || len(branch) == 0 {
 		return nil
 	}
+	jobs := branchJobs(branch)
 	// Build short context from last 1–2 chat exchanges (prompt + output)
 	var parts []string
 	n := len(jobs)
@@ -1082,19 +955,13 @@ func (h *Handlers) SummarizeThreadHandler(ctx context.Context, t *asynq.Task) er
 			_ = json.Unmarshal(j.Input, &input)
 		}
 		if p, _ := input["prompt"].(string); p != "" {
-			if len(p) > 200 {
-				p = p[:200] + "..."
-			}
-			parts = append(parts, "User: "+p)
+			parts = append(parts, "User: "+truncateTokens(p, 50))
 		}
 		if len(j.Output) > 0 {
 			var out map[string]interface{}
 			_ = json.Unmarshal(j.Output, &out)
 			if s, _ := out["output"].(string); s != "" {
-				if len(s) > 300 {
-					s = s[:300] + "..."
-				}
-				parts = append(parts, "Assistant: "+s)
+				parts = append(parts, "Assistant: "+truncateTokens(s, 75))
 			}
 		}
 	}
@@ -1115,25 +982,29 @@ func (h *Handlers) SummarizeThreadHandler(ctx context.Context, t *asynq.Task) er
 		if v, ok := m["output"].(string); ok && len(strings.TrimSpace(v)) > 0 {
 			title = strings.TrimSpace(v)
 			if len(title) > 80 {
-				title = title[:80]
+				title = strings.ToValidUTF8(title[:80], "")
 			}
 		}
 	}
-	if title != "" {
-		_ = h.DB.UpdateThreadTitle(ctx, p.ThreadID, title)
+	if title != "" && h.DB.UpdateThreadTitle(ctx, p.ThreadID, title) == nil && h.Stream != nil {
+		_ = h.Stream.PublishUser(ctx, thread.UserID, events.ThreadTitleUpdated{ThreadID: p.ThreadID, Title: title})
 	}
 	return nil
 }
 
 // fetchPageText fetches a URL and returns stripped plain text (max ~6000 chars).
 func fetchPageText(ctx context.Context, rawURL string) (string, error) {
+	return fetchPageTextWith(ctx, &http.Client{Timeout: 15 * time.Second}, rawURL)
+}
+
+// fetchPageTextWith is fetchPageText over the given client (the fetch_url tool's refuses private addresses).
+func fetchPageTextWith(ctx context.Context, client *http.Client, rawURL string) (string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
 		return "", err
 	}
 	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Flipo5SEO/1.0)")
 	req.Header.Set("Accept", "text/html,application/xhtml+xml")
-	client := &http.Client{Timeout: 15 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
@@ -1154,64 +1025,11 @@ func fetchPageText(ctx context.Context, rawURL string) (string, error) {
 			break
 		}
 	}
-	html := string(body)
-	// Remove <script> and <style> blocks
-	for _, tag := range []string{"script", "style", "noscript", "nav", "footer", "header"} {
-		for {
-			open := strings.Index(strings.ToLower(html), "<"+tag)
-			if open < 0 {
-				break
-			}
-			close := strings.Index(strings.ToLower(html[open:]), "</"+tag+">")
-			if close < 0 {
-				break
-			}
-			html = html[:open] + " " + html[open+close+len("</"+tag+">"):]
-		}
-	}
-	// Strip remaining HTML tags
-	inTag := false
-	var sb strings.Builder
-	for _, ch := range html {
-		if ch == '<' {
-			inTag = true
-			sb.WriteRune(' ')
-			continue
-		}
-		if ch == '>' {
-			inTag = false
-			continue
-		}
-		if !inTag {
-			sb.WriteRune(ch)
-		}
-	}
-	text := sb.String()
-	// Decode common entities
-	replacer := strings.NewReplacer(
-		"&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`,
-		"&apos;", "'", "&#39;", "'", "&nbsp;", " ", "&hellip;", "...",
-	)
-	text = replacer.Replace(text)
-	// Normalize whitespace
-	var clean strings.Builder
-	prevSpace := false
-	for _, ch := range text {
-		isSpace := ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
-		if isSpace {
-			if !prevSpace {
-				clean.WriteRune('\n')
-			}
-			prevSpace = true
-		} else {
-			clean.WriteRune(ch)
-			prevSpace = false
-		}
-	}
-	result := strings.TrimSpace(clean.String())
+	// Visible text without page chrome, one line per block
+	result := strings.TrimSpace(extract.HTML(string(body), "nav", "footer", "header"))
 	// Limit to ~6000 chars for AI prompt
 	if len(result) > 6000 {
-		result = result[:6000] + "…"
+		result = strings.ToValidUTF8(result[:6000], "") + "…"
 	}
 	return result, nil
 }
@@ -1221,19 +1039,19 @@ func (h *Handlers) SEOHandler(ctx context.Context, t *asynq.Task) error {
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
 	if h.Repl == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Replicate not configured", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "Replicate not configured", "", 0)
 		return nil
 	}
-	model := h.Cfg.ModelText
+	model := h.Cfg.TextModel("seo")
 	if model == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_TEXT not set", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "REPLICATE_MODEL_TEXT not set", "", 0)
 		return nil
 	}
 	job, err := h.DB.GetJob(ctx, p.JobID)
 	if err != nil || job == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "job not found", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "job not found", "", 0)
 		return nil
 	}
 	var jobInput map[string]interface{}
@@ -1271,7 +1089,7 @@ func (h *Handlers) SEOHandler(ctx context.Context, t *asynq.Task) error {
 		userContent += "Additional content to optimize:\n" + sourceText
 	}
 	if userContent == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "no source text or URL provided", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "no source text or URL provided", "", 0)
 		return nil
 	}
 	_ = fetchedURL // used for logging only
@@ -1314,67 +1132,23 @@ Tone: Authoritative, trustworthy, conversion-focused.`
 		"max_tokens":    maxTokens,
 	}
 
-	pred, err := h.Repl.CreatePredictionWithStream(ctx, model, input)
-	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		return nil
-	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, pred.ID)
-
-	// Poll until done (SEO doesn't use streaming)
-	for i := 0; i < 60; i++ {
-		select {
-		case <-ctx.Done():
-			_ = h.Repl.CancelPrediction(context.Background(), pred.ID)
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, ErrMsgServerUnavailable, 0, pred.ID)
-			return nil
-		default:
-		}
-		state, err := h.Repl.GetPrediction(ctx, pred.ID)
-		if err != nil {
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		if state.Status == "failed" || state.Status == "canceled" {
-			errMsg := "Prediction failed"
-			if state.Error != nil {
-				if s, ok := state.Error.(string); ok {
-					errMsg = s
-				}
-			}
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, errMsg, 0, pred.ID)
-			return nil
-		}
-		if state.Status == "succeeded" {
-			out := normalizeChatOutput(state.Output)
-			outText := ""
-			if m, ok := out.(map[string]interface{}); ok {
-				outText, _ = m["output"].(string)
-			}
-			final := map[string]interface{}{"output": outText}
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", final, "", 0, pred.ID)
+	return h.runTextPrediction(ctx, job, predictionRun{
+		Model:    model,
+		Input:    input,
+		Polls:    60,
+		Interval: 3 * time.Second, // SEO doesn't use streaming
+		Complete: func(ctx context.Context, text string) (map[string]interface{}, string) {
 			// Save as user file automatically
-			if outText != "" {
+			if text != "" {
 				title, _ := jobInput["title"].(string)
 				if title == "" {
 					title = "SEO Article"
 				}
-				_, _ = h.DB.CreateUserFile(ctx, job.UserID, title, outText, "seo")
-			}
-			if h.Stream != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				updateMsg := fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"seo"}`, p.JobID.String())
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, updateMsg)
-			}
-			if job, _ := h.DB.GetJob(ctx, p.JobID); job != nil {
-				h.invalidateJobCaches(ctx, job)
+				_, _ = h.DB.CreateUserFile(ctx, job.UserID, title, text, "seo")
 			}
-			return nil
-		}
-		time.Sleep(3 * time.Second)
-	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "timeout", 0, pred.ID)
-	return nil
+			return map[string]interface{}{"output": text}, ""
+		},
+	})
 }
 
 func (h *Handlers) OutlineHandler(ctx context.Context, t *asynq.Task) error {
@@ -1382,14 +1156,14 @@ func (h *Handlers) OutlineHandler(ctx context.Context, t *asynq.Task) error {
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
 	if h.Repl == nil || h.Cfg.ModelText == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "AI not configured", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "AI not configured", "", 0)
 		return nil
 	}
 	job, err := h.DB.GetJob(ctx, p.JobID)
 	if err != nil || job == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "job not found", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "job not found", "", 0)
 		return nil
 	}
 	var jobInput map[string]interface{}
@@ -1407,7 +1181,7 @@ func (h *Handlers) OutlineHandler(ctx context.Context, t *asynq.Task) error {
 		wordCount = "1500"
 	}
 	if topic == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "topic required", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "topic required", "", 0)
 		return nil
 	}
 	audienceLine := ""
@@ -1440,54 +1214,22 @@ Tone: Authoritative yet engaging.`
 		"prompt":        "Create a detailed blog outline for: " + topic,
 		"max_tokens":    3000,
 	}
-	pred, err := h.Repl.CreatePredictionWithStream(ctx, h.Cfg.ModelText, input)
-	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		return nil
-	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, pred.ID)
-	for i := 0; i < 40; i++ {
-		select {
-		case <-ctx.Done():
-			_ = h.Repl.CancelPrediction(context.Background(), pred.ID)
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, ErrMsgServerUnavailable, 0, pred.ID)
-			return nil
-		default:
-		}
-		state, err := h.Repl.GetPrediction(ctx, pred.ID)
-		if err != nil {
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		if state.Status == "failed" || state.Status == "canceled" {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Prediction failed", 0, pred.ID)
-			return nil
-		}
-		if state.Status == "succeeded" {
-			out := normalizeChatOutput(state.Output)
-			outText := ""
-			if m, ok := out.(map[string]interface{}); ok {
-				outText, _ = m["output"].(string)
-			}
-			final := map[string]interface{}{"output": outText}
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", final, "", 0, pred.ID)
-			if outText != "" {
+	return h.runTextPrediction(ctx, job, predictionRun{
+		Model:    h.Cfg.ModelText,
+		Input:    input,
+		Polls:    40,
+		Interval: 3 * time.Second,
+		Complete: func(ctx context.Context, text string) (map[string]interface{}, string) {
+			if text != "" {
 				name := "Outline – " + topic
 				if len(name) > 80 {
 					name = name[:80]
 				}
-				_, _ = h.DB.CreateUserFile(ctx, job.UserID, name, outText, "text")
-			}
-			if h.Stream != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"outline"}`, p.JobID.String()))
+				_, _ = h.DB.CreateUserFile(ctx, job.UserID, name, text, "text")
 			}
-			return nil
-		}
-		time.Sleep(3 * time.Second)
-	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "timeout", 0, pred.ID)
-	return nil
+			return map[string]interface{}{"output": text}, ""
+		},
+	})
 }
 
 func (h *Handlers) TranslateHandler(ctx context.Context, t *asynq.Task) error {
@@ -1495,14 +1237,15 @@ func (h *Handlers) TranslateHandler(ctx context.Context, t *asynq.Task) error {
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
-	if h.Repl == nil || h.Cfg.ModelText == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "AI not configured", 0, "")
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	model := h.Cfg.TextModel("translate")
+	if h.Repl == nil || model == "" {
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "AI not configured", "", 0)
 		return nil
 	}
 	job, err := h.DB.GetJob(ctx, p.JobID)
 	if err != nil || job == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "job not found", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "job not found", "", 0)
 		return nil
 	}
 	var jobInput map[string]interface{}
@@ -1548,12 +1291,12 @@ func (h *Handlers) TranslateHandler(ctx context.Context, t *asynq.Task) error {
 	// Replicate needs fetchable https URLs; if still a key, public URL is not configured.
 	for _, u := range sourceImages {
 		if u != "" && !strings.HasPrefix(u, "https://") {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Image URL not public: set S3_PUBLIC_URL or CLOUDFLARE_R2_PUBLIC_URL for uploads", 0, "")
+			h.finishJob(ctx, job, "failed", nil, "Image URL not public: set S3_PUBLIC_URL or CLOUDFLARE_R2_PUBLIC_URL for uploads", "", 0)
 			return nil
 		}
 	}
 	if sourceAudio != "" && !strings.HasPrefix(sourceAudio, "https://") {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Audio URL not public: set S3_PUBLIC_URL or CLOUDFLARE_R2_PUBLIC_URL for uploads", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "Audio URL not public: set S3_PUBLIC_URL or CLOUDFLARE_R2_PUBLIC_URL for uploads", "", 0)
 		return nil
 	}
 
@@ -1563,7 +1306,7 @@ func (h *Handlers) TranslateHandler(ctx context.Context, t *asynq.Task) error {
 		fetched, fetchErr := fetchPageText(fetchCtx, sourceURL)
 		cancel()
 		if fetchErr != nil {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Failed to fetch URL: "+fetchErr.Error(), 0, "")
+			h.finishJob(ctx, job, "failed", nil, "Failed to fetch URL: "+fetchErr.Error(), "", 0)
 			return nil
 		}
 		textToTranslate = fetched
@@ -1593,90 +1336,53 @@ func (h *Handlers) TranslateHandler(ctx context.Context, t *asynq.Task) error {
 		input["system_instruction"] = input["system_prompt"]
 	} else {
 		if textToTranslate == "" {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "No text to translate (provide source_url, source_text, source_images or source_audio)", 0, "")
+			h.finishJob(ctx, job, "failed", nil, "No text to translate (provide source_url, source_text, source_images or source_audio)", "", 0)
 			return nil
 		}
 		if len(textToTranslate) > 50000 {
 			textToTranslate = textToTranslate[:50000] + "\n[... truncated]"
 		}
-		systemPrompt := "You are a professional translator. Translate the user's text accurately. Preserve paragraphs, line breaks, and structure. Output ONLY the translation, no explanations or notes. If the source language is 'auto', detect it. Do not add any preamble."
-		prompt = fmt.Sprintf("Translate from %s to %s:\n\n%s", sourceLang, targetLang, textToTranslate)
-		input = map[string]interface{}{
-			"system_prompt": systemPrompt,
-			"prompt":        prompt,
-			"max_tokens":    8000,
-		}
+		input = textTranslationInput(sourceLang, targetLang, textToTranslate)
 	}
 
-	pred, err := h.Repl.CreatePredictionWithStream(ctx, h.Cfg.ModelText, input)
-	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		return nil
-	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, pred.ID)
-	for i := 0; i < 50; i++ {
-		select {
-		case <-ctx.Done():
-			_ = h.Repl.CancelPrediction(context.Background(), pred.ID)
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, ErrMsgServerUnavailable, 0, pred.ID)
-			return nil
-		default:
-		}
-		state, err := h.Repl.GetPrediction(ctx, pred.ID)
-		if err != nil {
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		if state.Status == "failed" || state.Status == "canceled" {
-			errMsg := "Prediction failed"
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, errMsg, 0, pred.ID)
-			itemIDStr, _ := jobInput["item_id"].(string)
-			if itemIDStr != "" {
-				if itemID, err := uuid.Parse(itemIDStr); err == nil {
-					_ = h.DB.UpdateTranslationItemAfterJob(ctx, itemID, p.JobID, "failed", nil, &errMsg)
-				}
-			}
-			return nil
-		}
-		if state.Status == "succeeded" {
-			out := normalizeChatOutput(state.Output)
-			outText := ""
-			if m, ok := out.(map[string]interface{}); ok {
-				outText, _ = m["output"].(string)
-			}
-			outText = strings.TrimSpace(outText)
-			final := map[string]interface{}{"output": outText}
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", final, "", 0, pred.ID)
-			itemIDStr, _ := jobInput["item_id"].(string)
-			if itemIDStr != "" {
-				if itemID, err := uuid.Parse(itemIDStr); err == nil {
-					_ = h.DB.UpdateTranslationItemAfterJob(ctx, itemID, p.JobID, "completed", &outText, nil)
-				}
-			}
-			if outText != "" && itemIDStr == "" {
+	itemIDStr, _ := jobInput["item_id"].(string)
+	itemID, itemErr := uuid.Parse(itemIDStr)
+	hasItem := itemIDStr != "" && itemErr == nil
+	return h.runTextPrediction(ctx, job, predictionRun{
+		Model:    model,
+		Input:    input,
+		Polls:    50,
+		Interval: 3 * time.Second,
+		Complete: func(ctx context.Context, text string) (map[string]interface{}, string) {
+			text = strings.TrimSpace(text)
+			if hasItem {
+				_ = h.DB.UpdateTranslationItemAfterJob(ctx, itemID, p.JobID, "completed", &text, nil)
+			}
+			if text != "" && itemIDStr == "" {
 				name := "Translation – " + targetLang
 				if len(name) > 80 {
 					name = name[:80]
 				}
-				_, _ = h.DB.CreateUserFile(ctx, job.UserID, name, outText, "text")
+				_, _ = h.DB.CreateUserFile(ctx, job.UserID, name, text, "text")
 			}
-			if h.Stream != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"translate"}`, p.JobID.String()))
+			return map[string]interface{}{"output": text}, ""
+		},
+		Failed: func(ctx context.Context, errMsg string) {
+			if hasItem {
+				_ = h.DB.UpdateTranslationItemAfterJob(ctx, itemID, p.JobID, "failed", nil, &errMsg)
 			}
-			return nil
-		}
-		time.Sleep(3 * time.Second)
-	}
-	errMsg := "timeout"
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, errMsg, 0, pred.ID)
-	itemIDStr, _ := jobInput["item_id"].(string)
-	if itemIDStr != "" {
-		if itemID, err := uuid.Parse(itemIDStr); err == nil {
-			_ = h.DB.UpdateTranslationItemAfterJob(ctx, itemID, p.JobID, "failed", nil, &errMsg)
-		}
+		},
+	})
+}
+
+// textTranslationInput is the model input that translates text from sourceLang ("auto" = detect) to targetLang.
+func textTranslationInput(sourceLang, targetLang, text string) map[string]interface{} {
+	systemPrompt := "You are a professional translator. Translate the user's text accurately. Preserve paragraphs, line breaks, and structure. Output ONLY the translation, no explanations or notes. If the source language is 'auto', detect it. Do not add any preamble."
+	return map[string]interface{}{
+		"system_prompt": systemPrompt,
+		"prompt":        fmt.Sprintf("Translate from %s to %s:\n\n%s", sourceLang, targetLang, text),
+		"max_tokens":    8000,
 	}
-	return nil
 }
 
 func (h *Handlers) ProductScoreHandler(ctx context.Context, t *asynq.Task) error {
@@ -1684,10 +1390,10 @@ func (h *Handlers) ProductScoreHandler(ctx context.Context, t *asynq.Task) error
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
 	job, err := h.DB.GetJob(ctx, p.JobID)
 	if err != nil || job == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "job not found", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "job not found", "", 0)
 		return nil
 	}
 	var jobInput map[string]interface{}
@@ -1696,22 +1402,22 @@ func (h *Handlers) ProductScoreHandler(ctx context.Context, t *asynq.Task) error
 	}
 	productIDStr, _ := jobInput["product_id"].(string)
 	if productIDStr == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "product_id required", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "product_id required", "", 0)
 		return nil
 	}
 	productID, err := uuid.Parse(productIDStr)
 	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "invalid product_id", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "invalid product_id", "", 0)
 		return nil
 	}
 	product, err := h.DB.GetProduct(ctx, productID, job.UserID)
 	if err != nil || product == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "product not found", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "product not found", "", 0)
 		return nil
 	}
 	photos, err := h.DB.ListProductPhotos(ctx, productID)
 	if err != nil || len(photos) == 0 {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "no photos to score", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "no photos to score", "", 0)
 		return nil
 	}
 	var imageURLs []string
@@ -1723,7 +1429,7 @@ func (h *Handlers) ProductScoreHandler(ctx context.Context, t *asynq.Task) error
 		imageURLs = append(imageURLs, u)
 	}
 	if h.Repl == nil || h.Cfg.ModelText == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "AI not configured", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "AI not configured", "", 0)
 		return nil
 	}
 	prompt := fmt.Sprintf("You have %d product photos. For each image rate 1-10: how clear and suitable is this product photo for generating new marketing images (visibility of product, lighting, framing). Reply with ONLY a JSON array of numbers, one per image in the same order, e.g. [7, 6, 8]. No other text.", len(imageURLs))
@@ -1733,99 +1439,68 @@ func (h *Handlers) ProductScoreHandler(ctx context.Context, t *asynq.Task) error
 		"max_tokens":    200,
 		"system_prompt": "You are a product photo quality rater. Output only a JSON array of numbers 1-10.",
 	}
-	pred, err := h.Repl.CreatePredictionWithStream(ctx, h.Cfg.ModelText, input)
-	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		return nil
-	}
-	for i := 0; i < 30; i++ {
-		select {
-		case <-ctx.Done():
-			_ = h.Repl.CancelPrediction(context.Background(), pred.ID)
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, ErrMsgServerUnavailable, 0, pred.ID)
-			return nil
-		default:
-		}
-		state, err := h.Repl.GetPrediction(ctx, pred.ID)
-		if err != nil {
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		if state.Status == "failed" || state.Status == "canceled" {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Scoring failed", 0, pred.ID)
-			return nil
-		}
-		if state.Status == "succeeded" {
-			out := normalizeChatOutput(state.Output)
-			outText := ""
-			if m, ok := out.(map[string]interface{}); ok {
-				outText, _ = m["output"].(string)
-			}
-			outText = strings.TrimSpace(outText)
+	sceneCost := 0
+	return h.runTextPrediction(ctx, job, predictionRun{
+		Model:     h.Cfg.ModelText,
+		Input:     input,
+		Polls:     30,
+		Interval:  2 * time.Second,
+		FailMsg:   "Scoring failed",
+		ExtraCost: func() int { return sceneCost },
+		Complete: func(ctx context.Context, text string) (map[string]interface{}, string) {
 			// Parse JSON array: [7, 6, 8] (may be wrapped in markdown code block)
-			scores := parseScoreArray(outText)
+			scores := parseScoreArray(strings.TrimSpace(text))
 			if len(scores) == 0 || len(scores) != len(photos) {
-				_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Could not parse scores (expected "+fmt.Sprint(len(photos))+" numbers)", 0, pred.ID)
-				return nil
+				return nil, "Could not parse scores (expected " + fmt.Sprint(len(photos)) + " numbers)"
 			}
 			if err := h.DB.UpdateProductPhotoScores(ctx, productID, scores); err != nil {
-				_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Failed to save scores", 0, pred.ID)
-				return nil
+				return nil, "Failed to save scores"
 			}
 			output := map[string]interface{}{"scores": scores}
-			// Generate and save 10 scene suggestions in same job (no extra API call later)
-			productContext := "Product: " + product.Name
-			if product.Category != "" {
-				productContext += ", category: " + product.Category
-			}
-			if product.Description != "" {
-				productContext += ". Description: " + product.Description
-			}
-			scenePrompt := "For this product suggest exactly 10 specific scene descriptions for product photography. Each scene should be one short line, suitable for generating marketing images. " + productContext + ". Return ONLY a JSON array of exactly 10 strings, e.g. [\"scene 1\", \"scene 2\", ...]. No other text, no markdown."
-			sceneInput := map[string]interface{}{
-				"prompt":        scenePrompt,
-				"max_tokens":    800,
-				"system_prompt": "You are a product photography director. Output only a JSON array of 10 scene description strings.",
-			}
-			if pred2, err := h.Repl.CreatePredictionWithStream(ctx, h.Cfg.ModelText, sceneInput); err == nil {
-				for i := 0; i < 25; i++ {
-					state, err := h.Repl.GetPrediction(ctx, pred2.ID)
-					if err != nil {
-						time.Sleep(2 * time.Second)
-						continue
-					}
-					if state.Status == "succeeded" {
-						out := normalizeChatOutput(state.Output)
-						outText := ""
-						if m, ok := out.(map[string]interface{}); ok {
-							outText, _ = m["output"].(string)
-						}
-						scenes := parseScenesArray(strings.TrimSpace(outText))
-						if len(scenes) > 0 {
-							if len(scenes) > 10 {
-								scenes = scenes[:10]
-							}
-							output["scenes"] = scenes
-						}
-						break
-					}
-					if state.Status == "failed" || state.Status == "canceled" {
-						break
-					}
-					time.Sleep(2 * time.Second)
-				}
-			}
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", output, "", 0, pred.ID)
-			if h.Stream != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"product_score"}`, p.JobID.String()))
-			}
-			return nil
-		}
-		time.Sleep(2 * time.Second)
+			scenes, cost := h.suggestProductScenes(ctx, product)
+			sceneCost = cost
+			if len(scenes) > 0 {
+				output["scenes"] = scenes
+			}
+			return output, ""
+		},
+	})
+}
+
+// suggestProductScenes generates 10 scene suggestions in the scoring job (no extra API call later), and
+// returns them with the prediction's cost in cents, which the job is charged with the scoring.
+// Best effort: no scenes when the prediction fails, times out or ctx ends (the prediction is then cancelled).
+func (h *Handlers) suggestProductScenes(ctx context.Context, product *store.Product) ([]string, int) {
+	productContext := "Product: " + product.Name
+	if product.Category != "" {
+		productContext += ", category: " + product.Category
+	}
+	if product.Description != "" {
+		productContext += ". Description: " + product.Description
+	}
+	scenePrompt := "For this product suggest exactly 10 specific scene descriptions for product photography. Each scene should be one short line, suitable for generating marketing images. " + productContext + ". Return ONLY a JSON array of exactly 10 strings, e.g. [\"scene 1\", \"scene 2\", ...]. No other text, no markdown."
+	sceneInput := map[string]interface{}{
+		"prompt":        scenePrompt,
+		"max_tokens":    800,
+		"system_prompt": "You are a product photography director. Output only a JSON array of 10 scene description strings.",
+	}
+	pred, err := h.Repl.CreatePredictionWithStream(ctx, h.Cfg.ModelText, sceneInput)
+	if err != nil {
+		return nil, 0
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "timeout", 0, pred.ID)
-	return nil
+	state, err := h.awaitPrediction(ctx, pred.ID, 25, 2*time.Second, nil)
+	if err != nil {
+		return nil, 0
+	}
+	cost := h.Billing.PredictionCost(h.Cfg.ModelText, state)
+	if state.Status != repgo.Succeeded {
+		return nil, cost
+	}
+	scenes := parseScenesArray(strings.TrimSpace(predictionText(state.Output)))
+	if len(scenes) > 10 {
+		scenes = scenes[:10]
+	}
+	return scenes, cost
 }
 
 func (h *Handlers) ProductDescriptionHandler(ctx context.Context, t *asynq.Task) error {
@@ -1833,10 +1508,10 @@ func (h *Handlers) ProductDescriptionHandler(ctx context.Context, t *asynq.Task)
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
 	job, err := h.DB.GetJob(ctx, p.JobID)
 	if err != nil || job == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "job not found", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "job not found", "", 0)
 		return nil
 	}
 	var jobInput map[string]interface{}
@@ -1846,13 +1521,13 @@ func (h *Handlers) ProductDescriptionHandler(ctx context.Context, t *asynq.Task)
 	description, _ := jobInput["description"].(string)
 	description = strings.TrimSpace(description)
 	if description == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "description required", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "description required", "", 0)
 		return nil
 	}
 	productURL, _ := jobInput["product_url"].(string)
 	productURL = strings.TrimSpace(productURL)
 	if h.Repl == nil || h.Cfg.ModelText == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "AI not configured", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "AI not configured", "", 0)
 		return nil
 	}
 	prompt := "Improve the following product description for marketing. Make it clear, compelling and professional. Return only the improved description text, no preamble or explanation.\n\nCurrent description:\n" + description
@@ -1864,50 +1539,20 @@ func (h *Handlers) ProductDescriptionHandler(ctx context.Context, t *asynq.Task)
 		"max_tokens":    1000,
 		"system_prompt": "You are a product copywriter. Output only the improved description, nothing else.",
 	}
-	pred, err := h.Repl.CreatePredictionWithStream(ctx, h.Cfg.ModelText, input)
-	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		return nil
-	}
-	for i := 0; i < 30; i++ {
-		select {
-		case <-ctx.Done():
-			_ = h.Repl.CancelPrediction(context.Background(), pred.ID)
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, ErrMsgServerUnavailable, 0, pred.ID)
-			return nil
-		default:
-		}
-		state, err := h.Repl.GetPrediction(ctx, pred.ID)
-		if err != nil {
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		if state.Status == "failed" || state.Status == "canceled" {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Description improve failed", 0, pred.ID)
-			return nil
-		}
-		if state.Status == "succeeded" {
-			out := normalizeChatOutput(state.Output)
-			outText := ""
-			if m, ok := out.(map[string]interface{}); ok {
-				outText, _ = m["output"].(string)
-			}
-			outText = strings.TrimSpace(outText)
-			if outText == "" {
-				_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Empty result", 0, pred.ID)
-				return nil
-			}
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", map[string]interface{}{"output": outText}, "", 0, pred.ID)
-			if h.Stream != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"product_description"}`, p.JobID.String()))
-			}
-			return nil
-		}
-		time.Sleep(2 * time.Second)
-	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "timeout", 0, pred.ID)
-	return nil
+	return h.runTextPrediction(ctx, job, predictionRun{
+		Model:    h.Cfg.ModelText,
+		Input:    input,
+		Polls:    30,
+		Interval: 2 * time.Second,
+		FailMsg:  "Description improve failed",
+		Complete: func(ctx context.Context, text string) (map[string]interface{}, string) {
+			text = strings.TrimSpace(text)
+			if text == "" {
+				return nil, "Empty result"
+			}
+			return map[string]interface{}{"output": text}, ""
+		},
+	})
 }
 
 func (h *Handlers) ProductSceneImproveHandler(ctx context.Context, t *asynq.Task) error {
@@ -1915,10 +1560,10 @@ func (h *Handlers) ProductSceneImproveHandler(ctx context.Context, t *asynq.Task
 	if err := json.Unmarshal(t.Payload(), &p); err != nil {
 		return err
 	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
+	_, _ = h.DB.UpdateJobStatus(ctx, p.JobID, "running", nil, "", 0, "")
 	job, err := h.DB.GetJob(ctx, p.JobID)
 	if err != nil || job == nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "job not found", 0, "")
+		h.finishJobByID(ctx, p.JobID, "failed", nil, "job not found", "", 0)
 		return nil
 	}
 	var jobInput map[string]interface{}
@@ -1928,7 +1573,7 @@ func (h *Handlers) ProductSceneImproveHandler(ctx context.Context, t *asynq.Task
 	scenePrompt, _ := jobInput["scene_prompt"].(string)
 	scenePrompt = strings.TrimSpace(scenePrompt)
 	if scenePrompt == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "scene_prompt required", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "scene_prompt required", "", 0)
 		return nil
 	}
 	productIDStr, _ := jobInput["product_id"].(string)
@@ -1944,7 +1589,7 @@ func (h *Handlers) ProductSceneImproveHandler(ctx context.Context, t *asynq.Task
 		}
 	}
 	if h.Repl == nil || h.Cfg.ModelText == "" {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "AI not configured", 0, "")
+		h.finishJob(ctx, job, "failed", nil, "AI not configured", "", 0)
 		return nil
 	}
 	prompt := "Improve this scene description for product photography. Make it more specific and compelling for marketing images. Return only the improved scene description, no preamble.\n\n"
@@ -1958,50 +1603,20 @@ func (h *Handlers) ProductSceneImproveHandler(ctx context.Context, t *asynq.Task
 		"max_tokens":    500,
 		"system_prompt": "You are a product photography director. Output only the improved scene description.",
 	}
-	pred, err := h.Repl.CreatePredictionWithStream(ctx, h.Cfg.ModelText, input)
-	if err != nil {
-		_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, jobErrorMsg(err), 0, "")
-		return nil
-	}
-	for i := 0; i < 30; i++ {
-		select {
-		case <-ctx.Done():
-			_ = h.Repl.CancelPrediction(context.Background(), pred.ID)
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, ErrMsgServerUnavailable, 0, pred.ID)
-			return nil
-		default:
-		}
-		state, err := h.Repl.GetPrediction(ctx, pred.ID)
-		if err != nil {
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		if state.Status == "failed" || state.Status == "canceled" {
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Scene improve failed", 0, pred.ID)
-			return nil
-		}
-		if state.Status == "succeeded" {
-			out := normalizeChatOutput(state.Output)
-			outText := ""
-			if m, ok := out.(map[string]interface{}); ok {
-				outText, _ = m["output"].(string)
-			}
-			outText = strings.TrimSpace(outText)
-			if outText == "" {
-				_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "Empty result", 0, pred.ID)
-				return nil
-			}
-			_ = h.DB.UpdateJobStatus(ctx, p.JobID, "completed", map[string]interface{}{"output": outText}, "", 0, pred.ID)
-			if h.Stream != nil {
-				userJobsChannel := fmt.Sprintf("user:%s:jobs", job.UserID.String())
-				_ = h.Stream.PublishRaw(ctx, userJobsChannel, fmt.Sprintf(`{"jobId":"%s","status":"completed","type":"product_scene_improve"}`, p.JobID.String()))
-			}
-			return nil
-		}
-		time.Sleep(2 * time.Second)
-	}
-	_ = h.DB.UpdateJobStatus(ctx, p.JobID, "failed", nil, "timeout", 0, pred.ID)
-	return nil
+	return h.runTextPrediction(ctx, job, predictionRun{
+		Model:    h.Cfg.ModelText,
+		Input:    input,
+		Polls:    30,
+		Interval: 2 * time.Second,
+		FailMsg:  "Scene improve failed",
+		Complete: func(ctx context.Context, text string) (map[string]interface{}, string) {
+			text = strings.TrimSpace(text)
+			if text == "" {
+				return nil, "Empty result"
+			}
+			return map[string]interface{}{"output": text}, ""
+		},
+	})
 }
 
 // parseScenesArray extracts []string from AI output (JSON array of strings, may be wrapped in markdown).
@@ -2061,5 +1676,12 @@ func (h *Handlers) Register(mux *asynq.ServeMux) {
 	mux.HandleFunc(TypeProductDescription, h.ProductDescriptionHandler)
 	mux.HandleFunc(TypeProductSceneImprove, h.ProductSceneImproveHandler)
 	mux.HandleFunc(TypeSummarizeThread, h.SummarizeThreadHandler)
+	mux.HandleFunc(TypeThreadSummary, h.ThreadSummaryHandler)
 	mux.HandleFunc(TypeCancelStaleJobs, h.CancelStaleJobsHandler)
+	mux.HandleFunc(TypeFinalizePrediction, h.FinalizePredictionHandler)
+	mux.HandleFunc(TypePredictionProgress, h.PredictionProgressHandler)
+	mux.HandleFunc(TypeReconcilePredictions, h.ReconcilePredictionsHandler)
+	mux.HandleFunc(TypeWebhookDelivery, h.WebhookDeliveryHandler)
+	mux.HandleFunc(TypeDispatchWebhooks, h.DispatchWebhooksHandler)
+	mux.HandleFunc(TypeExtractFile, h.ExtractFileHandler)
 }
@@ -0,0 +1,729 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"flipo5/backend/internal/billing"
+	"flipo5/backend/internal/config"
+	"flipo5/backend/internal/replicate"
+	"flipo5/backend/internal/replicate/replicatetest"
+	"flipo5/backend/internal/store"
+	"flipo5/backend/internal/webhook"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	repgo "github.com/replicate/replicate-go"
+)
+
+// Handler tests need Postgres (TEST_DATABASE_URL, migrated on start); Replicate is the in-process fake.
+// Locally they are skipped without a database; in CI (CI set, as GitHub Actions does) a missing database
+// fails the run, so the handlers are never silently untested.
+
+type handlerEnv struct {
+	h      *Handlers
+	fake   *replicatetest.Server
+	db     *store.DB
+	userID uuid.UUID
+}
+
+func newHandlerEnv(t *testing.T) *handlerEnv {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_DATABASE_URL must be set in CI")
+		}
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	db, err := store.NewDB(ctx, dsn)
+	if err != nil {
+		t.Fatalf("db: %v", err)
+	}
+	t.Cleanup(db.Close)
+	if _, err := db.Migrate(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	fake := replicatetest.NewServer()
+	t.Cleanup(fake.Close)
+	repl, err := replicate.New(replicatetest.Token, repgo.WithBaseURL(fake.URL))
+	if err != nil {
+		t.Fatalf("replicate: %v", err)
+	}
+	userID := uuid.New()
+	if err := db.UpsertUser(ctx, userID, userID.String()+"@test.local"); err != nil {
+		t.Fatalf("user: %v", err)
+	}
+	cfg := &config.Config{
+		ModelText:    "test/llm",
+		ModelImage:   "test/image",
+		ModelImageHD: "test/image-hd",
+		ModelVideo:   "test/video",
+	}
+	return &handlerEnv{h: &Handlers{DB: db, Cfg: cfg, Repl: repl}, fake: fake, db: db, userID: userID}
+}
+
+// run creates a job, runs handler on it with the given deadline and returns the stored job.
+func (e *handlerEnv) run(t *testing.T, jobType string, input map[string]interface{}, timeout time.Duration, newTask func(uuid.UUID) (*asynq.Task, error), handler func(context.Context, *asynq.Task) error) (*store.Job, error) {
+	t.Helper()
+	jobID, err := e.db.CreateJob(context.Background(), e.userID, jobType, input, nil)
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	task, err := newTask(jobID)
+	if err != nil {
+		t.Fatalf("task: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	herr := handler(ctx, task)
+	job, err := e.db.GetJob(context.Background(), jobID)
+	if err != nil || job == nil {
+		t.Fatalf("get job: %v", err)
+	}
+	return job, herr
+}
+
+func jobOutputText(t *testing.T, job *store.Job) string {
+	t.Helper()
+	var out map[string]interface{}
+	_ = json.Unmarshal(job.Output, &out)
+	s, _ := out["output"].(string)
+	return s
+}
+
+func jobError(job *store.Job) string {
+	if job.Error == nil {
+		return ""
+	}
+	return *job.Error
+}
+
+func chatTask(prompt string) func(uuid.UUID) (*asynq.Task, error) {
+	return func(id uuid.UUID) (*asynq.Task, error) { return NewChatTask(id, prompt) }
+}
+
+func TestChatHandler(t *testing.T) {
+	e := newHandlerEnv(t)
+	input := map[string]interface{}{"prompt": "hi"}
+
+	t.Run("success", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Chunks: []string{"Hel", "lo"}, Output: []string{"Hel", "lo"}})
+		job, _ := e.run(t, "chat", input, 10*time.Second, chatTask("hi"), e.h.ChatHandler)
+		if job.Status != "completed" || jobOutputText(t, job) != "Hello" {
+			t.Fatalf("got status=%s output=%q", job.Status, jobOutputText(t, job))
+		}
+		in := e.fake.Inputs("test/llm")
+		if p, _ := in[len(in)-1]["prompt"].(string); !strings.HasSuffix(p, "User: hi") {
+			t.Fatalf("prompt not sent: %q", p)
+		}
+	})
+
+	t.Run("stream truncation uses final prediction output", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Chunks: []string{"Hello ", "wor"}, Truncate: 1, Output: []string{"Hello ", "world"}})
+		job, _ := e.run(t, "chat", input, 10*time.Second, chatTask("hi"), e.h.ChatHandler)
+		if job.Status != "completed" || jobOutputText(t, job) != "Hello world" {
+			t.Fatalf("got status=%s output=%q", job.Status, jobOutputText(t, job))
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Chunks: []string{"par"}, Error: "model crashed"})
+		job, _ := e.run(t, "chat", input, 10*time.Second, chatTask("hi"), e.h.ChatHandler)
+		if job.Status != "failed" || jobError(job) != "model crashed" {
+			t.Fatalf("got status=%s error=%q", job.Status, jobError(job))
+		}
+	})
+
+	t.Run("canceled upstream", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Chunks: []string{"par"}, Canceled: true})
+		job, _ := e.run(t, "chat", input, 10*time.Second, chatTask("hi"), e.h.ChatHandler)
+		if job.Status != "failed" {
+			t.Fatalf("got status=%s", job.Status)
+		}
+	})
+
+	t.Run("deadline cancels prediction", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Chunks: []string{"par"}, Hang: true})
+		before := len(e.fake.Canceled())
+		job, _ := e.run(t, "chat", input, 300*time.Millisecond, chatTask("hi"), e.h.ChatHandler)
+		if job.Status == "completed" {
+			t.Fatalf("hung prediction must not complete")
+		}
+		if len(e.fake.Canceled()) != before+1 {
+			t.Fatalf("prediction was not cancelled upstream")
+		}
+	})
+}
+
+func TestChatToolCalls(t *testing.T) {
+	e := newHandlerEnv(t)
+	e.h.Cfg.ChatTools, e.h.Cfg.ChatToolSteps = "search_my_files", 1
+	if _, err := e.db.CreateUserFile(context.Background(), e.userID, "Fox notes", "The red fox lives in the forest.", "text"); err != nil {
+		t.Fatalf("file: %v", err)
+	}
+	// The fake model calls the tool on every pass: one round runs, the next pass is told to answer and
+	// its call is ignored.
+	reply := `Checking. <tool_call>{"name": "search_my_files", "arguments": {"query": "fox"}}</tool_call>`
+	e.fake.Handle("test/llm", replicatetest.Model{Chunks: []string{reply}, Output: []string{reply}})
+	job, err := e.run(t, "chat", map[string]interface{}{"prompt": "what do my notes say about foxes?"}, 10*time.Second, chatTask("what do my notes say about foxes?"), e.h.ChatHandler)
+	if err != nil || job.Status != "completed" {
+		t.Fatalf("got status=%s err=%v", job.Status, err)
+	}
+	if got := jobOutputText(t, job); got != "Checking.\n\nChecking." {
+		t.Fatalf("output %q", got)
+	}
+	var out struct {
+		ToolCalls []toolCall `json:"tool_calls"`
+	}
+	_ = json.Unmarshal(job.Output, &out)
+	if len(out.ToolCalls) != 1 || out.ToolCalls[0].Name != "search_my_files" || out.ToolCalls[0].Status != "completed" {
+		t.Fatalf("tool calls %s", job.Output)
+	}
+	in := e.fake.Inputs("test/llm")
+	last, _ := in[len(in)-1]["prompt"].(string)
+	if !strings.Contains(last, `<tool_result name="search_my_files">`) || !strings.Contains(last, "red fox lives") {
+		t.Fatalf("tool result not sent back: %q", last)
+	}
+}
+
+// A pass that fails after a tool round keeps the reply and tool calls so far and charges what ran.
+func TestChatToolPassFails(t *testing.T) {
+	e := newHandlerEnv(t)
+	registry := chatToolRegistry
+	t.Cleanup(func() { chatToolRegistry = registry })
+	chatToolRegistry = append([]chatTool{{
+		Name: "break_model", Description: "Test tool.", Args: `{}`, Timeout: time.Second,
+		Run: func(h *Handlers, ctx context.Context, job *store.Job, args map[string]interface{}) (toolResult, error) {
+			e.fake.Handle("test/llm", replicatetest.Model{Error: "model crashed"})
+			return toolResult{Text: "done", Cost: 7}, nil
+		},
+	}}, registry...)
+	e.h.Cfg.ChatTools, e.h.Cfg.ChatToolSteps = "break_model", 1
+	reply := `Checking. <tool_call>{"name": "break_model", "arguments": {}}</tool_call>`
+	e.fake.Handle("test/llm", replicatetest.Model{Chunks: []string{reply}, Output: []string{reply}})
+	job, err := e.run(t, "chat", map[string]interface{}{"prompt": "hi"}, 10*time.Second, chatTask("hi"), e.h.ChatHandler)
+	if err != nil || job.Status != "failed" {
+		t.Fatalf("got status=%s err=%v", job.Status, err)
+	}
+	if got := jobOutputText(t, job); got != "Checking." {
+		t.Fatalf("output %q", got)
+	}
+	var out struct {
+		ToolCalls []toolCall `json:"tool_calls"`
+	}
+	_ = json.Unmarshal(job.Output, &out)
+	if len(out.ToolCalls) != 1 || out.ToolCalls[0].Name != "break_model" || out.ToolCalls[0].Status != "completed" {
+		t.Fatalf("tool calls %s", job.Output)
+	}
+	if job.CostCents < 7 {
+		t.Fatalf("cost %d, want the tool's 7 cents and the first pass", job.CostCents)
+	}
+}
+
+func TestImageHandler(t *testing.T) {
+	e := newHandlerEnv(t)
+	input := map[string]interface{}{"prompt": "a red fox"}
+
+	t.Run("success", func(t *testing.T) {
+		url := e.fake.AddFile("fox.jpg", "image/jpeg", []byte("jpg"))
+		e.fake.Handle("test/image", replicatetest.Model{Output: []string{url}, Polls: 1})
+		job, err := e.run(t, "image", input, 10*time.Second, NewImageTask, e.h.ImageHandler)
+		if err != nil || job.Status != "completed" {
+			t.Fatalf("got status=%s err=%v", job.Status, err)
+		}
+		var out map[string][]string
+		_ = json.Unmarshal(job.Output, &out)
+		if len(out["output"]) != 1 || out["output"][0] != url {
+			t.Fatalf("unexpected output %s", job.Output)
+		}
+	})
+
+	t.Run("hd success", func(t *testing.T) {
+		url := e.fake.AddFile("fox-hd.jpg", "image/jpeg", []byte("jpg"))
+		e.fake.Handle("test/image-hd", replicatetest.Model{Output: url})
+		job, err := e.run(t, "image", map[string]interface{}{"prompt": "fox", "size": "HD"}, 10*time.Second, NewImageTask, e.h.ImageHandler)
+		if err != nil || job.Status != "completed" || jobOutputText(t, job) != url {
+			t.Fatalf("got status=%s output=%s err=%v", job.Status, job.Output, err)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		e.fake.Handle("test/image", replicatetest.Model{Error: "NSFW content detected"})
+		job, err := e.run(t, "image", input, 10*time.Second, NewImageTask, e.h.ImageHandler)
+		if err == nil || job.Status != "failed" || !strings.Contains(jobError(job), "NSFW content detected") {
+			t.Fatalf("got status=%s error=%q err=%v", job.Status, jobError(job), err)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		e.fake.Handle("test/image", replicatetest.Model{Hang: true})
+		job, err := e.run(t, "image", input, 300*time.Millisecond, NewImageTask, e.h.ImageHandler)
+		if err == nil || job.Status == "completed" {
+			t.Fatalf("got status=%s err=%v", job.Status, err)
+		}
+	})
+}
+
+func TestVideoHandler(t *testing.T) {
+	e := newHandlerEnv(t)
+	input := map[string]interface{}{"prompt": "waves"}
+
+	t.Run("success", func(t *testing.T) {
+		url := e.fake.AddFile("waves.mp4", "video/mp4", []byte("mp4"))
+		e.fake.Handle("test/video", replicatetest.Model{Output: url, Polls: 2, Logs: "loading\n 40%|████      | 20/50 [00:10<00:15,  2.00it/s]"})
+		job, err := e.run(t, "video", input, 10*time.Second, NewVideoTask, e.h.VideoHandler)
+		if err != nil || job.Status != "completed" || jobOutputText(t, job) != url {
+			t.Fatalf("got status=%s output=%s err=%v", job.Status, job.Output, err)
+		}
+		var prog replicate.Progress
+		if json.Unmarshal(job.Progress, &prog) != nil || prog != (replicate.Progress{Percent: 40, Step: 20, Steps: 50, ETASeconds: 15}) {
+			t.Fatalf("progress %s", job.Progress)
+		}
+		if got := e.fake.Inputs("test/video"); got[len(got)-1]["aspect_ratio"] != "16:9" {
+			t.Fatalf("defaults not applied: %#v", got[len(got)-1])
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		e.fake.Handle("test/video", replicatetest.Model{Error: "invalid duration"})
+		job, err := e.run(t, "video", input, 10*time.Second, NewVideoTask, e.h.VideoHandler)
+		if err == nil || job.Status != "failed" || !strings.Contains(jobError(job), "invalid duration") {
+			t.Fatalf("got status=%s error=%q err=%v", job.Status, jobError(job), err)
+		}
+	})
+}
+
+func TestSEOHandler(t *testing.T) {
+	e := newHandlerEnv(t)
+	input := map[string]interface{}{"source_text": "Handmade leather wallets", "title": "Wallets"}
+	article := `{"meta_title":"Leather wallets","keywords":["wallet"]}`
+
+	t.Run("success", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Output: []string{article}})
+		job, _ := e.run(t, "seo", input, 10*time.Second, NewSEOTask, e.h.SEOHandler)
+		if job.Status != "completed" || jobOutputText(t, job) != article {
+			t.Fatalf("got status=%s output=%s", job.Status, job.Output)
+		}
+		files, _ := e.db.ListUserFiles(context.Background(), e.userID)
+		if len(files) == 0 || files[0].Name != "Wallets" || files[0].FileType != "seo" {
+			t.Fatalf("SEO result not saved as user file: %+v", files)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Error: "context length exceeded"})
+		job, _ := e.run(t, "seo", input, 10*time.Second, NewSEOTask, e.h.SEOHandler)
+		if job.Status != "failed" || jobError(job) != "context length exceeded" {
+			t.Fatalf("got status=%s error=%q", job.Status, jobError(job))
+		}
+	})
+
+	t.Run("deadline cancels prediction", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Hang: true})
+		before := len(e.fake.Canceled())
+		job, _ := e.run(t, "seo", input, 500*time.Millisecond, NewSEOTask, e.h.SEOHandler)
+		if job.Status != "failed" || jobError(job) != ErrMsgServerUnavailable || len(e.fake.Canceled()) != before+1 {
+			t.Fatalf("got status=%s error=%q, cancelled=%d", job.Status, jobError(job), len(e.fake.Canceled())-before)
+		}
+	})
+}
+
+func TestTranslateHandler(t *testing.T) {
+	e := newHandlerEnv(t)
+	input := map[string]interface{}{"source_text": "Bonjour le monde", "source_lang": "French", "target_lang": "English"}
+
+	t.Run("success", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Output: []string{"Hello ", "world\n"}, Polls: 1})
+		job, _ := e.run(t, "translate", input, 10*time.Second, NewTranslateTask, e.h.TranslateHandler)
+		if job.Status != "completed" || jobOutputText(t, job) != "Hello world" {
+			t.Fatalf("got status=%s output=%s", job.Status, job.Output)
+		}
+		in := e.fake.Inputs("test/llm")
+		if p, _ := in[len(in)-1]["prompt"].(string); !strings.Contains(p, "from French to English") {
+			t.Fatalf("unexpected prompt %q", p)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Error: "boom"})
+		job, _ := e.run(t, "translate", input, 10*time.Second, NewTranslateTask, e.h.TranslateHandler)
+		if job.Status != "failed" {
+			t.Fatalf("got status=%s", job.Status)
+		}
+	})
+
+	t.Run("deadline cancels prediction", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Hang: true})
+		before := len(e.fake.Canceled())
+		job, _ := e.run(t, "translate", input, 500*time.Millisecond, NewTranslateTask, e.h.TranslateHandler)
+		if job.Status != "failed" || jobError(job) != ErrMsgServerUnavailable || len(e.fake.Canceled()) != before+1 {
+			t.Fatalf("got status=%s error=%q, cancelled=%d", job.Status, jobError(job), len(e.fake.Canceled())-before)
+		}
+	})
+}
+
+func TestProductDescriptionHandler(t *testing.T) {
+	e := newHandlerEnv(t)
+	input := map[string]interface{}{"description": "red mug"}
+
+	t.Run("success", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Output: []string{"  A bold red ", "ceramic mug.\n"}, Polls: 1})
+		job, _ := e.run(t, "product_description", input, 10*time.Second, NewProductDescriptionTask, e.h.ProductDescriptionHandler)
+		if job.Status != "completed" || jobOutputText(t, job) != "A bold red ceramic mug." || job.ReplicateID == nil {
+			t.Fatalf("got status=%s output=%s", job.Status, job.Output)
+		}
+	})
+
+	t.Run("empty result", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Output: []string{" "}})
+		job, _ := e.run(t, "product_description", input, 10*time.Second, NewProductDescriptionTask, e.h.ProductDescriptionHandler)
+		if job.Status != "failed" || jobError(job) != "Empty result" {
+			t.Fatalf("got status=%s error=%q", job.Status, jobError(job))
+		}
+	})
+
+	t.Run("canceled upstream", func(t *testing.T) {
+		e.fake.Handle("test/llm", replicatetest.Model{Canceled: true})
+		job, _ := e.run(t, "product_description", input, 10*time.Second, NewProductDescriptionTask, e.h.ProductDescriptionHandler)
+		if job.Status != "failed" || jobError(job) != "Prediction canceled" {
+			t.Fatalf("got status=%s error=%q", job.Status, jobError(job))
+		}
+	})
+}
+
+// The scene suggestions run as a second prediction in the scoring job; its cost is charged with the scores.
+func TestProductScoreCost(t *testing.T) {
+	e := newHandlerEnv(t)
+	ctx := context.Background()
+	e.h.Billing = &billing.Table{Models: map[string]billing.Price{"test/llm": {PerSecond: 2}}}
+	productID, err := e.db.CreateProduct(ctx, e.userID, "Red mug", "kitchen", "", "")
+	if err != nil {
+		t.Fatalf("product: %v", err)
+	}
+	if _, err := e.db.AddProductPhoto(ctx, productID, "https://example.com/mug.jpg", 0); err != nil {
+		t.Fatalf("photo: %v", err)
+	}
+	e.fake.Handle("test/llm", replicatetest.Model{Output: []string{"[8]"}, PredictTime: 1})
+	job, _ := e.run(t, "product_score", map[string]interface{}{"product_id": productID.String()}, 10*time.Second, NewProductScoreTask, e.h.ProductScoreHandler)
+	if job.Status != "completed" || job.CostCents != 4 {
+		t.Fatalf("got status=%s cost=%d error=%q, want completed with 4 (scores + scenes)", job.Status, job.CostCents, jobError(job))
+	}
+}
+
+func TestAwaitPredictionHonorsContext(t *testing.T) {
+	fake := replicatetest.NewServer()
+	defer fake.Close()
+	repl, err := replicate.New(replicatetest.Token, repgo.WithBaseURL(fake.URL))
+	if err != nil {
+		t.Fatalf("replicate: %v", err)
+	}
+	fake.Handle("test/llm", replicatetest.Model{Hang: true})
+	h := &Handlers{Repl: repl}
+	pred, err := repl.CreatePredictionWithStream(context.Background(), "test/llm", repgo.PredictionInput{})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := h.awaitPrediction(ctx, pred.ID, 25, 2*time.Second, nil); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if time.Since(start) > time.Second || fake.Status(pred.ID) != "canceled" {
+		t.Fatalf("poll did not stop on ctx (took %s, status %s)", time.Since(start), fake.Status(pred.ID))
+	}
+}
+
+func TestMediaJobWithWebhook(t *testing.T) {
+	e := newHandlerEnv(t)
+	e.h.Cfg.ReplicateWebhookURL = "https://api.example.com/webhooks/replicate"
+	e.h.Cfg.ReplicateWebhookSecret = "whsec_dGVzdA=="
+	url := e.fake.AddFile("owl.jpg", "image/jpeg", []byte("jpg"))
+	e.fake.Handle("test/image", replicatetest.Model{Output: []string{url}, Polls: 1})
+
+	job, err := e.run(t, "image", map[string]interface{}{"prompt": "an owl"}, 10*time.Second, NewImageTask, e.h.ImageHandler)
+	if err != nil || job.Status != "running" || job.ReplicateID == nil {
+		t.Fatalf("worker should return after submission: status=%s err=%v", job.Status, err)
+	}
+	// Replicate calls the webhook once done; the fake finishes on the next poll.
+	_, _ = e.h.Repl.GetPrediction(context.Background(), *job.ReplicateID)
+	task, _ := NewFinalizePredictionTask(*job.ReplicateID)
+	for i := 0; i < 2; i++ { // duplicate delivery is a no-op
+		if err := e.h.FinalizePredictionHandler(context.Background(), task); err != nil {
+			t.Fatalf("finalize: %v", err)
+		}
+	}
+	job, _ = e.db.GetJob(context.Background(), job.ID)
+	var out map[string][]string
+	_ = json.Unmarshal(job.Output, &out)
+	if job.Status != "completed" || len(out["output"]) != 1 || out["output"][0] != url {
+		t.Fatalf("got status=%s output=%s", job.Status, job.Output)
+	}
+}
+
+// The webhook, the reconciler and the worker may finalize the same prediction at once: exactly one of them
+// finishes the job (and publishes, mirrors and bills).
+func TestConcurrentFinalize(t *testing.T) {
+	e := newHandlerEnv(t)
+	e.h.Cfg.ReplicateWebhookURL = "https://api.example.com/webhooks/replicate"
+	e.h.Cfg.ReplicateWebhookSecret = "whsec_dGVzdA=="
+	url := e.fake.AddFile("owl.jpg", "image/jpeg", []byte("jpg"))
+	e.fake.Handle("test/image", replicatetest.Model{Output: []string{url}, Polls: 1})
+	job, err := e.run(t, "image", map[string]interface{}{"prompt": "an owl"}, 10*time.Second, NewImageTask, e.h.ImageHandler)
+	if err != nil || job.Status != "running" || job.ReplicateID == nil {
+		t.Fatalf("submit: status=%s err=%v", job.Status, err)
+	}
+	_, _ = e.h.Repl.GetPrediction(context.Background(), *job.ReplicateID)
+
+	const racers = 8
+	results := make(chan bool, racers)
+	var wg sync.WaitGroup
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pred, err := e.h.Repl.GetPrediction(context.Background(), *job.ReplicateID)
+			if err != nil {
+				results <- false
+				return
+			}
+			status, changed := e.h.finalizePrediction(context.Background(), job, pred)
+			results <- changed && status == "completed"
+		}()
+	}
+	wg.Wait()
+	close(results)
+	finished := 0
+	for changed := range results {
+		if changed {
+			finished++
+		}
+	}
+	if finished != 1 {
+		t.Fatalf("%d calls finished the job, want 1", finished)
+	}
+	if job, _ = e.db.GetJob(context.Background(), job.ID); job.Status != "completed" {
+		t.Fatalf("status %s", job.Status)
+	}
+}
+
+func TestJobCredits(t *testing.T) {
+	e := newHandlerEnv(t)
+	ctx := context.Background()
+	e.h.Billing = &billing.Table{Models: map[string]billing.Price{"test/image": {PerOutput: 3}}, Reserve: map[string]int{"image": 10}}
+	if _, err := e.db.GrantCredits(ctx, e.userID, 15); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	runImage := func(m replicatetest.Model) *store.Job {
+		t.Helper()
+		e.fake.Handle("test/image", m)
+		jobID, err := e.db.CreateJobReserved(ctx, e.userID, "image", map[string]interface{}{"prompt": "fox"}, nil, e.h.Billing.ReserveFor("image"))
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		if balance, _ := e.db.GetCreditBalance(ctx, e.userID); balance != 5 {
+			t.Fatalf("balance after hold = %d, want 5", balance)
+		}
+		task, _ := NewImageTask(jobID)
+		_ = e.h.ImageHandler(ctx, task)
+		job, _ := e.db.GetJob(ctx, jobID)
+		return job
+	}
+
+	t.Run("failure refunds the hold", func(t *testing.T) {
+		job := runImage(replicatetest.Model{Error: "NSFW content detected"})
+		if job.Status != "failed" || job.CostCents != 0 {
+			t.Fatalf("got status=%s cost=%d", job.Status, job.CostCents)
+		}
+		if balance, _ := e.db.GetCreditBalance(ctx, e.userID); balance != 15 {
+			t.Fatalf("balance = %d, want 15", balance)
+		}
+	})
+
+	t.Run("completion charges per output", func(t *testing.T) {
+		url := e.fake.AddFile("fox.jpg", "image/jpeg", []byte("jpg"))
+		job := runImage(replicatetest.Model{Output: []string{url, url}})
+		if job.Status != "completed" || job.CostCents != 6 {
+			t.Fatalf("got status=%s cost=%d", job.Status, job.CostCents)
+		}
+		if balance, _ := e.db.GetCreditBalance(ctx, e.userID); balance != 9 {
+			t.Fatalf("balance = %d, want 9", balance)
+		}
+		// A late duplicate finish (webhook retry) must not bill twice.
+		_, _ = e.db.UpdateJobStatus(ctx, job.ID, "completed", nil, "", 6, "")
+		if balance, _ := e.db.GetCreditBalance(ctx, e.userID); balance != 9 {
+			t.Fatalf("balance after duplicate = %d, want 9", balance)
+		}
+	})
+
+	t.Run("completion after cancel is dropped", func(t *testing.T) {
+		jobID, err := e.db.CreateJobReserved(ctx, e.userID, "image", nil, nil, 5)
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		if err := e.db.SetJobCancelled(ctx, jobID, e.userID, ""); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+		changed, err := e.db.UpdateJobStatus(ctx, jobID, "completed", map[string]string{"output": "late"}, "", 6, "")
+		if err != nil || changed {
+			t.Fatalf("late completion: changed=%v err=%v", changed, err)
+		}
+		job, _ := e.db.GetJob(ctx, jobID)
+		if job.Status != "cancelled" || job.Output != nil || job.CostCents != 0 {
+			t.Fatalf("got status=%s output=%s cost=%d", job.Status, job.Output, job.CostCents)
+		}
+		if balance, _ := e.db.GetCreditBalance(ctx, e.userID); balance != 9 {
+			t.Fatalf("balance = %d, want 9", balance)
+		}
+	})
+
+	t.Run("insufficient balance", func(t *testing.T) {
+		_, err := e.db.CreateJobReserved(ctx, e.userID, "video", nil, nil, 100)
+		if !errors.Is(err, store.ErrInsufficientCredits) {
+			t.Fatalf("got %v, want ErrInsufficientCredits", err)
+		}
+	})
+}
+
+func TestThreadBranches(t *testing.T) {
+	e := newHandlerEnv(t)
+	ctx := context.Background()
+	threadID, err := e.db.CreateThread(ctx, e.userID, false)
+	if err != nil {
+		t.Fatalf("thread: %v", err)
+	}
+	ask := func(prompt, answer string) *store.Job {
+		t.Helper()
+		id, err := e.db.CreateJob(ctx, e.userID, "chat", map[string]interface{}{"prompt": prompt}, &threadID)
+		if err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+		_, _ = e.db.UpdateJobStatus(ctx, id, "completed", map[string]string{"output": answer}, "", 0, "")
+		job, _ := e.db.GetJob(ctx, id)
+		return job
+	}
+	first := ask("hi", "hello")
+	second := ask("tell a joke", "no")
+
+	altID, err := e.db.CreateAlternativeJobReserved(ctx, e.userID, "chat", map[string]interface{}{"prompt": "tell a pun"}, second, 0)
+	if err != nil {
+		t.Fatalf("alternative: %v", err)
+	}
+	branch, err := e.db.ListThreadBranch(ctx, threadID, e.userID)
+	if err != nil || len(branch) != 2 || branch[0].ID != first.ID || branch[1].ID != altID {
+		t.Fatalf("active branch %+v (%v)", branch, err)
+	}
+	if b := branch[1]; b.SiblingCount != 2 || b.SiblingIndex != 1 || b.SiblingIDs[0] != second.ID {
+		t.Fatalf("siblings %+v", b)
+	}
+
+	alt, _ := e.db.GetJob(ctx, altID)
+	msgs := e.h.chatContext(ctx, alt, "test/llm")
+	if got := contextText(msgs); !strings.Contains(got, "hello") || strings.Contains(got, "tell a joke") {
+		t.Fatalf("context should follow the branch: %q", got)
+	}
+
+	leaf, err := e.db.SelectThreadBranch(ctx, threadID, e.userID, second.ID)
+	if err != nil || leaf != second.ID {
+		t.Fatalf("select branch: %v %v", leaf, err)
+	}
+	next := ask("another", "ok")
+	branch, _ = e.db.ListThreadBranch(ctx, threadID, e.userID)
+	if len(branch) != 3 || branch[1].ID != second.ID || branch[2].ID != next.ID {
+		t.Fatalf("new message should continue the selected branch: %+v", branch)
+	}
+	if leaf, _ := e.db.SelectThreadBranch(ctx, threadID, e.userID, first.ID); leaf != next.ID {
+		t.Fatalf("selecting a job should follow its newest replies, got %v", leaf)
+	}
+	if _, err := e.db.SelectThreadBranch(ctx, threadID, uuid.New(), first.ID); err == nil {
+		t.Fatal("another user's thread should not be found")
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	e := newHandlerEnv(t)
+	ctx := context.Background()
+	e.h.Cfg.WebhookAllowPrivate = true
+	var queued []uuid.UUID
+	e.db.OnWebhookDeliveries = func(ids []uuid.UUID) { queued = append(queued, ids...) }
+
+	var received []*http.Request
+	var bodies [][]byte
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received, bodies = append(received, r), append(bodies, b)
+		if failing {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	secret, _ := webhook.NewSecret()
+	endpoint, err := e.db.CreateWebhookEndpoint(ctx, e.userID, srv.URL, secret, []string{webhook.EventJobCompleted})
+	if err != nil {
+		t.Fatalf("endpoint: %v", err)
+	}
+
+	deliver := func(id uuid.UUID) error {
+		task, _ := NewWebhookDeliveryTask(id)
+		return e.h.WebhookDeliveryHandler(ctx, task)
+	}
+
+	failedJob, _ := e.db.CreateJob(ctx, e.userID, "chat", map[string]string{"prompt": "hi"}, nil)
+	_, _ = e.db.UpdateJobStatus(ctx, failedJob, "failed", nil, "boom", 0, "")
+	if len(queued) != 0 {
+		t.Fatalf("job.failed queued %d deliveries for an endpoint subscribed to job.completed only", len(queued))
+	}
+
+	jobID, _ := e.db.CreateJob(ctx, e.userID, "chat", map[string]string{"prompt": "hi"}, nil)
+	_, _ = e.db.UpdateJobStatus(ctx, jobID, "completed", map[string]string{"output": "hello"}, "", 0, "")
+	_, _ = e.db.UpdateJobStatus(ctx, jobID, "completed", map[string]string{"output": "hello"}, "", 0, "") // duplicate finish
+	if len(queued) != 1 {
+		t.Fatalf("queued %d deliveries, want 1", len(queued))
+	}
+
+	// Outside a worker there are no retries left, so a failed send is final.
+	_ = deliver(queued[0])
+	deliveries, _ := e.db.ListWebhookDeliveries(ctx, endpoint.ID, e.userID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != store.DeliveryFailed || deliveries[0].Attempts != 1 || deliveries[0].ResponseStatus == nil || *deliveries[0].ResponseStatus != 503 {
+		t.Fatalf("after failed send: %+v", deliveries)
+	}
+
+	failing = false
+	replay, err := e.db.ReplayWebhookDelivery(ctx, queued[0], e.userID)
+	if err != nil || len(queued) != 2 || queued[1] != replay.ID {
+		t.Fatalf("replay: %v, queued %v", err, queued)
+	}
+	if err := deliver(replay.ID); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	deliveries, _ = e.db.ListWebhookDeliveries(ctx, endpoint.ID, e.userID, 10)
+	if len(deliveries) != 2 || deliveries[0].Status != store.DeliveryDelivered || deliveries[0].ReplayOf == nil || *deliveries[0].ReplayOf != queued[0] {
+		t.Fatalf("after replay: %+v", deliveries)
+	}
+
+	last := received[len(received)-1]
+	if err := webhook.Verify(secret, last.Header.Get(webhook.HeaderSignature), bodies[len(bodies)-1], time.Now(), time.Minute); err != nil {
+		t.Fatalf("signature: %v", err)
+	}
+	var first, again store.WebhookEvent
+	_ = json.Unmarshal(bodies[0], &first)
+	_ = json.Unmarshal(bodies[len(bodies)-1], &again)
+	if again.Type != webhook.EventJobCompleted || again.Data.Job.ID != jobID || jobOutputText(t, &again.Data.Job) != "hello" {
+		t.Fatalf("event: %+v", again)
+	}
+	if first.ID != again.ID || last.Header.Get(webhook.HeaderDelivery) != replay.ID.String() {
+		t.Fatal("replay must keep the event id and use a new delivery id")
+	}
+}
@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"flipo5/backend/internal/events"
+	"flipo5/backend/internal/store"
+	"github.com/google/uuid"
+	repgo "github.com/replicate/replicate-go"
+)
+
+// errPredictionTimeout is returned by awaitPrediction when the poll budget runs out; shown to users as "timeout".
+var errPredictionTimeout = errors.New("timeout")
+
+// predictionRun describes one text prediction that runTextPrediction drives to a terminal job state.
+type predictionRun struct {
+	Model    string
+	Input    map[string]interface{}
+	Polls    int           // GetPrediction attempts before the job fails with "timeout"
+	Interval time.Duration // wait between polls
+	FailMsg  string        // job error when the prediction fails without a model error
+	// Complete turns the final text into the job output. A non-empty errMsg fails the job instead.
+	Complete func(ctx context.Context, text string) (output map[string]interface{}, errMsg string)
+	// Failed runs after the job ended failed or cancelled (e.g. to update a translation item).
+	Failed func(ctx context.Context, errMsg string)
+	// ExtraCost, when set, is the cost in cents of other predictions the job ran (e.g. in Complete); it is
+	// added to the charge of a completed job.
+	ExtraCost func() int
+}
+
+// runTextPrediction creates the prediction, records its ID on the job, polls it and finishes the job:
+// status + output in the DB, job and user stream events, cache invalidation. Task cancellation or deadline
+// cancels the prediction upstream; the job still ends failed because terminal writes ignore ctx cancellation.
+func (h *Handlers) runTextPrediction(ctx context.Context, job *store.Job, run predictionRun) error {
+	pred, err := h.Repl.CreatePredictionWithStream(ctx, run.Model, run.Input)
+	if err != nil {
+		h.failPrediction(ctx, job, jobErrorMsg(err), "", run.Failed)
+		return nil
+	}
+	_, _ = h.DB.UpdateJobStatus(ctx, job.ID, "running", nil, "", 0, pred.ID)
+	h.publishJobStatus(ctx, job, "running", "")
+
+	state, err := h.awaitPrediction(ctx, pred.ID, run.Polls, run.Interval, nil)
+	if err != nil {
+		h.failPrediction(ctx, job, jobErrorMsg(err), pred.ID, run.Failed)
+		return nil
+	}
+	switch state.Status {
+	case repgo.Succeeded:
+		output, errMsg := run.Complete(ctx, predictionText(state.Output))
+		if errMsg != "" {
+			h.failPrediction(ctx, job, errMsg, pred.ID, run.Failed)
+			return nil
+		}
+		costCents := h.Billing.PredictionCost(run.Model, state)
+		if run.ExtraCost != nil {
+			costCents += run.ExtraCost()
+		}
+		h.finishJob(ctx, job, "completed", output, "", pred.ID, costCents)
+	case repgo.Canceled:
+		h.failPrediction(ctx, job, predictionError(state, "Prediction canceled"), pred.ID, run.Failed)
+	default:
+		h.failPrediction(ctx, job, predictionError(state, run.FailMsg), pred.ID, run.Failed)
+	}
+	return nil
+}
+
+// awaitPrediction polls until the prediction reaches a terminal state. When ctx ends first the prediction is
+// cancelled upstream (so it stops billing) and ctx.Err() is returned; when polls run out it is cancelled too.
+// onPoll, when set, sees every state that is not terminal yet (progress).
+func (h *Handlers) awaitPrediction(ctx context.Context, predID string, polls int, interval time.Duration, onPoll func(*repgo.Prediction)) (*repgo.Prediction, error) {
+	for i := 0; i < polls; i++ {
+		if state, err := h.Repl.GetPrediction(ctx, predID); err == nil {
+			switch state.Status {
+			case repgo.Succeeded, repgo.Failed, repgo.Canceled:
+				return state, nil
+			}
+			if onPoll != nil {
+				onPoll(state)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			_ = h.Repl.CancelPrediction(context.Background(), predID)
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	_ = h.Repl.CancelPrediction(context.Background(), predID)
+	return nil, errPredictionTimeout
+}
+
+func (h *Handlers) failPrediction(ctx context.Context, job *store.Job, errMsg, predID string, onFailed func(context.Context, string)) {
+	status, _ := h.finishJob(ctx, job, "failed", nil, errMsg, predID, 0)
+	if onFailed == nil {
+		return
+	}
+	if status == "cancelled" {
+		errMsg = "Cancelled by user"
+	}
+	onFailed(context.WithoutCancel(ctx), errMsg)
+}
+
+// finishJob writes a terminal status unless the job already left pending/running: the user cancelled it
+// (cancelJob sets "cancelled" and cancels the prediction, which must not turn into "failed"), or a webhook,
+// the reconciler or the stale job cleanup got there first. The conditional update decides, so of racing
+// calls exactly one sets the status and publishes. Returns the status the job ended with and whether this
+// call set it. costCents is charged unless the job ends cancelled; failures pass the cost of work already done
+// (0 for most handlers).
+func (h *Handlers) finishJob(ctx context.Context, job *store.Job, status string, output interface{}, errMsg, predID string, costCents int) (string, bool) {
+	ctx = context.WithoutCancel(ctx)
+	if changed, err := h.DB.UpdateJobStatus(ctx, job.ID, status, output, errMsg, costCents, predID); err != nil || !changed {
+		if current, _ := h.DB.GetJob(ctx, job.ID); current != nil {
+			return current.Status, false
+		}
+		return status, false
+	}
+	h.publishJobStatus(ctx, job, status, errMsg)
+	h.invalidateJobCaches(ctx, job)
+	return status, true
+}
+
+// finishJobByID is finishJob for handlers that only have the job ID (failures before the job is loaded).
+func (h *Handlers) finishJobByID(ctx context.Context, jobID uuid.UUID, status string, output interface{}, errMsg, predID string, costCents int) (string, bool) {
+	job, _ := h.DB.GetJob(context.WithoutCancel(ctx), jobID)
+	if job == nil {
+		job = &store.Job{ID: jobID}
+	}
+	return h.finishJob(ctx, job, status, output, errMsg, predID, costCents)
+}
+
+// publishJobStatus sends the job's status on its stream (a terminal status ends the stream) and on the
+// user's channel.
+func (h *Handlers) publishJobStatus(ctx context.Context, job *store.Job, status, errMsg string) {
+	if h.Stream == nil {
+		return
+	}
+	ev := events.JobStatus{JobID: job.ID, JobType: job.Type, ThreadID: job.ThreadID, Status: status, Error: errMsg}
+	_ = h.Stream.PublishJob(ctx, job.ID, ev)
+	_ = h.Stream.PublishUser(ctx, job.UserID, ev)
+}
+
+// publishJobStatusByID is publishJobStatus for handlers that only have the job ID.
+func (h *Handlers) publishJobStatusByID(ctx context.Context, jobID uuid.UUID, status, errMsg string) {
+	if h.Stream == nil {
+		return
+	}
+	if job, _ := h.DB.GetJob(ctx, jobID); job != nil {
+		h.publishJobStatus(ctx, job, status, errMsg)
+	}
+}
+
+// predictionText returns the text output of a language model prediction (string or streamed string array).
+func predictionText(out repgo.PredictionOutput) string {
+	if m, ok := normalizeChatOutput(out).(map[string]interface{}); ok {
+		s, _ := m["output"].(string)
+		return s
+	}
+	return ""
+}
+
+// predictionError prefers the model's error message over the handler's fallback.
+func predictionError(state *repgo.Prediction, fallback string) string {
+	if s, ok := state.Error.(string); ok && s != "" {
+		return s
+	}
+	if fallback == "" {
+		return "Prediction failed"
+	}
+	return fallback
+}
@@ -0,0 +1,283 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	repgo "github.com/replicate/replicate-go"
+
+	"flipo5/backend/internal/ai"
+	"flipo5/backend/internal/extract"
+	"flipo5/backend/internal/store"
+)
+
+// Conversation memory: the chat prompt gets as many of the latest exchanges as fit the model's context budget
+// (Cfg.ContextTokens), newest first. Exchanges that fall out of that window are folded into a rolling summary
+// per thread by ThreadSummaryHandler, queued after each reply. Questions that fell out but are not summarized
+// yet are listed on their own so nothing disappears in between.
+
+const (
+	turnOverheadTokens  = 8    // "User:" / "Assistant:" labels and blank lines
+	summaryMaxTokens    = 600  // the summary is cut here in the prompt
+	questionMaxTokens   = 40   // older questions listed while the summary catches up
+	summarizeTurnTokens = 1500 // per exchange given to the summarizer
+	summarizeMaxTurns   = 20   // exchanges folded per run; the rest on the next one
+)
+
+// chatTurn is one completed chat exchange of a thread.
+type chatTurn struct {
+	JobID     uuid.UUID
+	User      string
+	Assistant string
+}
+
+// chatTurns returns the completed chat exchanges in order, without the job being answered now.
+func chatTurns(jobs []store.Job, current uuid.UUID) []chatTurn {
+	var out []chatTurn
+	for _, j := range jobs {
+		if j.ID == current || j.Type != "chat" || j.Status != "completed" {
+			continue
+		}
+		var input, output map[string]interface{}
+		_ = json.Unmarshal(j.Input, &input)
+		q, _ := input["prompt"].(string)
+		if q = strings.TrimSpace(q); q == "" {
+			continue
+		}
+		_ = json.Unmarshal(j.Output, &output)
+		a, _ := output["output"].(string)
+		out = append(out, chatTurn{JobID: j.ID, User: q, Assistant: strings.TrimSpace(a)})
+	}
+	return out
+}
+
+func (t chatTurn) tokens() int {
+	return extract.Tokens(t.User) + extract.Tokens(t.Assistant) + turnOverheadTokens
+}
+
+// summaryCovers returns how many leading turns the summary covers; 0 when its last job is not among them
+// (deleted, or another branch), in which case the summary is stale.
+func summaryCovers(s store.ThreadSummary, turns []chatTurn) int {
+	if s.ThroughJobID == nil || s.Text == "" {
+		return 0
+	}
+	for i, t := range turns {
+		if t.JobID == *s.ThroughJobID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// recentStart returns the index of the first turn shown in full: turns are taken newest first while they fit
+// budget. The newest turn is always shown, cut to fit if needed.
+func recentStart(turns []chatTurn, budget int) int {
+	used := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		n := turns[i].tokens()
+		if used+n > budget && i < len(turns)-1 {
+			return i + 1
+		}
+		used += n
+	}
+	return 0
+}
+
+// buildChatContext returns the conversation so far within budget tokens: a system note with the rolling
+// summary of older exchanges and the questions that fell out of the window since, then the latest exchanges
+// as user/assistant messages.
+func buildChatContext(summary store.ThreadSummary, turns []chatTurn, budget int) []ai.Message {
+	if len(turns) == 0 || budget <= 0 {
+		return nil
+	}
+	covered := summaryCovers(summary, turns)
+	sum := ""
+	if covered > 0 {
+		sum = truncateTokens(strings.TrimSpace(summary.Text), min(summaryMaxTokens, budget/3))
+	}
+	left := budget - extract.Tokens(sum)
+	start := recentStart(turns, left)
+
+	recent := make([]ai.Message, 0, 2*(len(turns)-start))
+	for i := start; i < len(turns); i++ {
+		t := turns[i]
+		if n := t.tokens(); n > left { // only the newest turn can overflow: cut it
+			t.User = truncateTokens(t.User, left/3)
+			t.Assistant = truncateTokens(t.Assistant, left-extract.Tokens(t.User)-turnOverheadTokens)
+		}
+		left -= t.tokens()
+		recent = append(recent, ai.Message{Role: ai.RoleUser, Content: t.User}, ai.Message{Role: ai.RoleAssistant, Content: t.Assistant})
+	}
+
+	var questions []string
+	for i := start - 1; i >= covered; i-- {
+		q := "- " + truncateTokens(turns[i].User, questionMaxTokens)
+		n := extract.Tokens(q) + 1
+		if n > left {
+			break
+		}
+		left -= n
+		questions = append(questions, q)
+	}
+
+	var notes []string
+	if sum != "" {
+		notes = append(notes, "Summary of the earlier conversation:\n"+sum)
+	}
+	if len(questions) > 0 {
+		for a, b := 0, len(questions)-1; a < b; a, b = a+1, b-1 {
+			questions[a], questions[b] = questions[b], questions[a]
+		}
+		notes = append(notes, "Earlier in this conversation, the user asked about:\n"+strings.Join(questions, "\n"))
+	}
+	if len(notes) == 0 {
+		return recent
+	}
+	return append([]ai.Message{{Role: ai.RoleSystem, Content: strings.Join(notes, "\n\n")}}, recent...)
+}
+
+// chatContext is the conversation before job on its branch, sized for model.
+func (h *Handlers) chatContext(ctx context.Context, job *store.Job, model string) []ai.Message {
+	if job.ThreadID == nil {
+		return nil
+	}
+	jobs, err := h.DB.ListJobAncestors(ctx, job.ID, job.UserID)
+	if err != nil {
+		return nil
+	}
+	summary, _ := h.DB.GetThreadSummary(ctx, *job.ThreadID)
+	return buildChatContext(summary, chatTurns(jobs, job.ID), h.Cfg.ContextTokens(model))
+}
+
+// branchJobs drops the sibling details of a thread branch.
+func branchJobs(branch []store.ThreadJob) []store.Job {
+	jobs := make([]store.Job, len(branch))
+	for i, j := range branch {
+		jobs[i] = j.Job
+	}
+	return jobs
+}
+
+// truncateTokens cuts s to about n tokens on a rune boundary, preferring a space near the end, and marks the
+// cut with "...".
+func truncateTokens(s string, n int) string {
+	total := extract.Tokens(s)
+	if total <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	r := []rune(s)
+	cut := len(r) * n / total
+	for cut > 0 && extract.Tokens(string(r[:cut])) > n {
+		cut = cut * 9 / 10
+	}
+	out := string(r[:cut])
+	if i := strings.LastIndexAny(out, " \n"); i > len(out)*4/5 {
+		out = out[:i]
+	}
+	return strings.TrimSpace(out) + "..."
+}
+
+// ThreadSummaryHandler folds the exchanges that no longer fit the chat context window into the thread's
+// rolling summary.
+func (h *Handlers) ThreadSummaryHandler(ctx context.Context, t *asynq.Task) error {
+	var p SummarizeThreadPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return err
+	}
+	model := h.Cfg.TextModel("chat")
+	if h.Repl == nil || model == "" {
+		return nil
+	}
+	thread, err := h.DB.GetThreadByID(ctx, p.ThreadID)
+	if err != nil || thread == nil {
+		return err
+	}
+	branch, err := h.DB.ListThreadBranch(ctx, p.ThreadID, thread.UserID)
+	if err != nil {
+		return err
+	}
+	turns := chatTurns(branchJobs(branch), uuid.Nil)
+	summary, err := h.DB.GetThreadSummary(ctx, p.ThreadID)
+	if err != nil {
+		return err
+	}
+	covered := summaryCovers(summary, turns)
+	previous := ""
+	if covered > 0 {
+		previous = strings.TrimSpace(summary.Text)
+	}
+	budget := h.Cfg.ContextTokens(model)
+	start := recentStart(turns, budget-min(extract.Tokens(previous), summaryMaxTokens, budget/3))
+	if start <= covered {
+		return nil // everything not summarized still fits
+	}
+	fold := turns[covered:start]
+	if len(fold) > summarizeMaxTurns {
+		fold = fold[:summarizeMaxTurns]
+	}
+
+	// Same adapter as the chat reply: the summary is written by the chat model, which may not take a bare prompt.
+	input := ai.AdapterFor(model, h.Cfg.ModelFormats).Render(summaryMessages(previous, fold), summaryMaxTokens)
+	pred, err := h.Repl.CreatePrediction(ctx, model, input, nil)
+	if err != nil {
+		return err
+	}
+	state, err := h.awaitPrediction(ctx, pred.ID, 120, time.Second, nil)
+	if err != nil {
+		return err
+	}
+	// Billed to the thread's owner like the replies, linked to the newest folded one. A failed charge is not
+	// retried: running the task again would summarize (and bill) twice.
+	through := fold[len(fold)-1].JobID
+	if cost := h.Billing.PredictionCost(model, state); cost > 0 {
+		if err := h.DB.ChargeCredits(ctx, thread.UserID, &through, cost); err != nil {
+			log.Printf("[ThreadSummaryHandler] charge thread %s: %v", p.ThreadID, err)
+		}
+	}
+	if state.Status != repgo.Succeeded {
+		return errors.New(predictionError(state, "summary failed"))
+	}
+	text := strings.TrimSpace(predictionText(state.Output))
+	if text == "" {
+		return nil
+	}
+	return h.DB.SaveThreadSummary(ctx, p.ThreadID, truncateTokens(text, summaryMaxTokens), through)
+}
+
+// summaryMessages is the conversation that asks the model to fold the exchanges into the previous summary.
+func summaryMessages(previous string, fold []chatTurn) []ai.Message {
+	system := "You maintain the running summary of a conversation between a user and an assistant. It replaces the " +
+		"older messages in the assistant's memory. Update it with the new messages below. Keep facts, names, numbers, " +
+		"decisions, the user's preferences and open questions; drop greetings and filler. Write in the language of the " +
+		"conversation, in plain sentences, at most 250 words. Reply with the summary only."
+	var b strings.Builder
+	if previous != "" {
+		b.WriteString("Summary so far:\n" + previous + "\n\n")
+	}
+	b.WriteString("New messages:")
+	for _, turn := range fold {
+		turn.User = truncateTokens(turn.User, summarizeTurnTokens/3)
+		turn.Assistant = truncateTokens(turn.Assistant, summarizeTurnTokens)
+		b.WriteString("\n\nUser: " + turn.User + "\n\nAssistant: " + turn.Assistant)
+	}
+	return []ai.Message{{Role: ai.RoleSystem, Content: system}, {Role: ai.RoleUser, Content: b.String()}}
+}
+
+// enqueueThreadSummary queues a summary update for the thread; one at a time per thread.
+func (h *Handlers) enqueueThreadSummary(threadID uuid.UUID) {
+	if h.Asynq == nil {
+		return
+	}
+	if task, err := NewThreadSummaryTask(threadID); err == nil {
+		_, _ = h.Asynq.Enqueue(task, asynq.Unique(time.Minute))
+	}
+}
@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"flipo5/backend/internal/ai"
+	"flipo5/backend/internal/extract"
+	"flipo5/backend/internal/store"
+)
+
+func TestTruncateTokens(t *testing.T) {
+	if got := truncateTokens("short", 10); got != "short" {
+		t.Fatalf("got %q", got)
+	}
+	long := strings.Repeat("ăîșțâ explică mai simplu 🙂 ", 200)
+	got := truncateTokens(long, 50)
+	if !utf8.ValidString(got) {
+		t.Fatal("cut mid-rune")
+	}
+	if !strings.HasSuffix(got, "...") || extract.Tokens(strings.TrimSuffix(got, "...")) > 50 {
+		t.Fatalf("not cut to budget: %d tokens", extract.Tokens(got))
+	}
+	if truncateTokens(long, 0) != "" {
+		t.Fatal("zero budget should give empty text")
+	}
+}
+
+func testTurns(n, size int) []chatTurn {
+	turns := make([]chatTurn, n)
+	for i := range turns {
+		turns[i] = chatTurn{
+			JobID:     uuid.New(),
+			User:      "question " + string(rune('A'+i)),
+			Assistant: strings.Repeat("answer"+string(rune('A'+i))+" ", size),
+		}
+	}
+	return turns
+}
+
+// contextText is the conversation as one string, for token counts and lookups.
+func contextText(msgs []ai.Message) string {
+	parts := make([]string, len(msgs))
+	for i, m := range msgs {
+		parts[i] = m.Role + ": " + m.Content
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func TestBuildChatContext(t *testing.T) {
+	turns := testTurns(10, 100) // ~180 tokens each
+
+	msgs := buildChatContext(store.ThreadSummary{}, turns, 1000)
+	out := contextText(msgs)
+	if extract.Tokens(out) > 1000 {
+		t.Fatalf("over budget: %d tokens", extract.Tokens(out))
+	}
+	if !strings.Contains(out, "answerJ") || strings.Contains(out, "answerA") {
+		t.Fatal("expected the newest exchanges in full and not the oldest")
+	}
+	if !strings.Contains(out, "- question A") || msgs[0].Role != ai.RoleSystem {
+		t.Fatal("unsummarized older questions should be listed in a system note")
+	}
+	if last := msgs[len(msgs)-1]; last.Role != ai.RoleAssistant || !strings.HasPrefix(last.Content, "answerJ") {
+		t.Fatalf("expected the newest answer last, got %s", last.Role)
+	}
+
+	through := turns[5].JobID
+	msgs = buildChatContext(store.ThreadSummary{Text: "They talked about invoices.", ThroughJobID: &through}, turns, 1000)
+	out = contextText(msgs)
+	if !strings.HasPrefix(msgs[0].Content, "Summary of the earlier conversation:\nThey talked about invoices.") {
+		t.Fatalf("summary missing: %q", msgs[0].Content)
+	}
+	if strings.Contains(out, "- question A") {
+		t.Fatal("summarized questions should not be listed again")
+	}
+
+	stale := uuid.New()
+	out = contextText(buildChatContext(store.ThreadSummary{Text: "Old branch.", ThroughJobID: &stale}, turns, 1000))
+	if strings.Contains(out, "Old branch.") {
+		t.Fatal("summary of unknown job should be ignored")
+	}
+
+	// The newest exchange is always there, cut to fit.
+	huge := testTurns(1, 5000)
+	out = contextText(buildChatContext(store.ThreadSummary{}, huge, 500))
+	if !strings.Contains(out, "question A") || extract.Tokens(out) > 520 {
+		t.Fatalf("newest turn not cut to budget: %d tokens", extract.Tokens(out))
+	}
+}
+
+func TestChatTurnsAndWindow(t *testing.T) {
+	current := uuid.New()
+	job := func(id uuid.UUID, typ, status, prompt, output string) store.Job {
+		in, _ := json.Marshal(map[string]string{"prompt": prompt})
+		out, _ := json.Marshal(map[string]string{"output": output})
+		return store.Job{ID: id, Type: typ, Status: status, Input: in, Output: out}
+	}
+	turns := chatTurns([]store.Job{
+		job(uuid.New(), "chat", "completed", "hi", "hello"),
+		job(uuid.New(), "image", "completed", "a cat", ""),
+		job(uuid.New(), "chat", "failed", "broken", ""),
+		job(current, "chat", "running", "now", ""),
+	}, current)
+	if len(turns) != 1 || turns[0].User != "hi" || turns[0].Assistant != "hello" {
+		t.Fatalf("unexpected turns %+v", turns)
+	}
+
+	many := testTurns(6, 100)
+	if start := recentStart(many, 1<<20); start != 0 {
+		t.Fatalf("everything fits, start %d", start)
+	}
+	if start := recentStart(many, 1); start != 5 {
+		t.Fatalf("newest turn always kept, start %d", start)
+	}
+}
+
+func TestSummaryMessages(t *testing.T) {
+	msgs := summaryMessages("They talked about invoices.", []chatTurn{{User: "and taxes?", Assistant: "Taxes are due in May."}})
+	input := ai.AdapterFor("openai:llama3.1", nil).Render(msgs, summaryMaxTokens)
+	chat, ok := input["messages"].([]map[string]interface{})
+	if !ok || len(chat) != 2 || input["max_tokens"] != summaryMaxTokens {
+		t.Fatalf("input %+v", input)
+	}
+	if s, _ := chat[1]["content"].(string); !strings.Contains(s, "Summary so far:\nThey talked about invoices.") || !strings.Contains(s, "Assistant: Taxes are due in May.") {
+		t.Fatalf("user message %q", s)
+	}
+	if _, ok := ai.AdapterFor("test/llm", nil).Render(msgs, summaryMaxTokens)["prompt"].(string); !ok {
+		t.Fatal("plain models get a prompt")
+	}
+}
@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"flipo5/backend/internal/events"
+	"flipo5/backend/internal/replicate"
+	"flipo5/backend/internal/store"
+	"github.com/hibiken/asynq"
+	repgo "github.com/replicate/replicate-go"
+)
+
+// mediaJobTypes finish through finalizePrediction: from the Replicate webhook, the reconciler, or the
+// worker's own polling when no webhook is configured.
+var mediaJobTypes = []string{"image", "video", "upscale"}
+
+const (
+	progressDelay     = 2 * time.Second
+	mediaPollInterval = time.Second
+	mediaPolls        = JobTimeoutMinutes * 60 // bounded by the task timeout anyway
+	reconcileMinAge   = 90                     // seconds without update before the reconciler polls a job
+)
+
+// webhook returns the Replicate webhook for new media predictions, or nil when workers should poll. Log
+// updates come too, for progress.
+func (h *Handlers) webhook() *repgo.Webhook {
+	if h.Cfg == nil || h.Cfg.ReplicateWebhookURL == "" || h.Cfg.ReplicateWebhookSecret == "" {
+		return nil
+	}
+	return &repgo.Webhook{URL: h.Cfg.ReplicateWebhookURL, Events: []repgo.WebhookEventType{repgo.WebhookEventLogs, repgo.WebhookEventCompleted}}
+}
+
+// submitMediaPrediction starts the prediction for an image/video/upscale job and records its ID on the job.
+// With a webhook the worker slot is released right away; otherwise it polls here until the prediction ends.
+// Failures return an error like the former blocking Run did, so asynq records them.
+func (h *Handlers) submitMediaPrediction(ctx context.Context, job *store.Job, model string, input repgo.PredictionInput) error {
+	webhook := h.webhook()
+	pred, err := h.Repl.CreatePrediction(ctx, model, input, webhook)
+	if err != nil {
+		h.finishJob(ctx, job, "failed", nil, jobErrorMsg(err), "", 0)
+		return err
+	}
+	_, _ = h.DB.UpdateJobStatus(ctx, job.ID, "running", nil, "", 0, pred.ID)
+	if webhook != nil && !pred.Status.Terminated() {
+		return nil
+	}
+	state := pred
+	if !state.Status.Terminated() {
+		onPoll := func(state *repgo.Prediction) { h.reportProgress(ctx, job, state) }
+		if state, err = h.awaitPrediction(ctx, pred.ID, mediaPolls, mediaPollInterval, onPoll); err != nil {
+			h.finishJob(ctx, job, "failed", nil, jobErrorMsg(err), pred.ID, 0)
+			return err
+		}
+	}
+	if status, _ := h.finalizePrediction(ctx, job, state); status != "completed" && status != "cancelled" {
+		return fmt.Errorf("prediction %s ended %s: %s", pred.ID, state.Status, predictionError(state, ""))
+	}
+	return nil
+}
+
+// finalizePrediction moves a media job to its terminal state from a finished prediction: normalized output,
+// job/user stream events, caches and the R2 mirror. Safe to race (webhook retries, reconciler, worker):
+// only the call whose update finishes the job (see finishJob) publishes and mirrors. Returns the status the
+// job ended with and whether this call set it.
+func (h *Handlers) finalizePrediction(ctx context.Context, job *store.Job, pred *repgo.Prediction) (string, bool) {
+	switch pred.Status {
+	case repgo.Succeeded:
+		out := normalizeMediaOutput(pred.Output)
+		status, changed := h.finishJob(ctx, job, "completed", out, "", pred.ID, h.Billing.PredictionCost("", pred))
+		if changed {
+			mirrorType := "image"
+			if job.Type == "video" {
+				mirrorType = "video"
+			}
+			go mirrorMediaToR2(h, job.ID, out, mirrorType)
+		}
+		return status, changed
+	case repgo.Canceled:
+		return h.finishJob(ctx, job, "failed", nil, predictionError(pred, "Prediction canceled"), pred.ID, 0)
+	default:
+		return h.finishJob(ctx, job, "failed", nil, predictionError(pred, ""), pred.ID, 0)
+	}
+}
+
+// normalizeMediaOutput wraps model output as {"output": ...} for the frontend and r2mirror:
+// single URL (nano-banana, video, upscale) or URL array (seedream).
+func normalizeMediaOutput(out repgo.PredictionOutput) repgo.PredictionOutput {
+	switch v := out.(type) {
+	case string:
+		if v != "" {
+			return map[string]interface{}{"output": v}
+		}
+	case []interface{}:
+		return map[string]interface{}{"output": v}
+	}
+	return out
+}
+
+// FinalizePredictionHandler finishes the job for a prediction reported done by the Replicate webhook.
+// The prediction is re-read from the API so the stored result never depends on the delivery body.
+func (h *Handlers) FinalizePredictionHandler(ctx context.Context, t *asynq.Task) error {
+	var p FinalizePredictionPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return err
+	}
+	if h.Repl == nil || p.PredictionID == "" {
+		return nil
+	}
+	job, err := h.DB.GetJobByReplicateID(ctx, p.PredictionID)
+	if err != nil {
+		return err
+	}
+	if job == nil || job.Status != "running" {
+		return nil
+	}
+	pred, err := h.Repl.GetPrediction(ctx, p.PredictionID)
+	if err != nil {
+		return err
+	}
+	if !pred.Status.Terminated() {
+		return nil // reconciler picks it up
+	}
+	h.finalizePrediction(ctx, job, pred)
+	return nil
+}
+
+// PredictionProgressHandler records the progress of a running media prediction. Progress is cosmetic: errors
+// are not retried and the next delivery or poll catches up.
+func (h *Handlers) PredictionProgressHandler(ctx context.Context, t *asynq.Task) error {
+	var p FinalizePredictionPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return err
+	}
+	if h.Repl == nil || p.PredictionID == "" {
+		return nil
+	}
+	job, err := h.DB.GetJobByReplicateID(ctx, p.PredictionID)
+	if err != nil || job == nil || job.Status != "running" {
+		return err
+	}
+	pred, err := h.Repl.GetPrediction(ctx, p.PredictionID)
+	if err != nil {
+		return err
+	}
+	if !pred.Status.Terminated() {
+		h.reportProgress(ctx, job, pred)
+	}
+	return nil
+}
+
+// reportProgress parses the prediction's logs and, when the progress changed, stores it on the job and
+// publishes it on the job's stream and the user's channel.
+func (h *Handlers) reportProgress(ctx context.Context, job *store.Job, pred *repgo.Prediction) {
+	if pred.Logs == nil {
+		return
+	}
+	prog, ok := replicate.ParseProgress(*pred.Logs)
+	if !ok {
+		return
+	}
+	if changed, err := h.DB.UpdateJobProgress(ctx, job.ID, prog); err != nil || !changed || h.Stream == nil {
+		return
+	}
+	ev := events.JobProgress{JobID: job.ID, Percent: prog.Percent, Step: prog.Step, Steps: prog.Steps, ETASeconds: prog.ETASeconds}
+	_ = h.Stream.PublishJob(ctx, job.ID, ev)
+	_ = h.Stream.PublishUser(ctx, job.UserID, ev)
+}
+
+// ReconcilePredictionsHandler polls running media jobs that had no update for a while and finalizes the
+// ones whose prediction already ended (missed or rejected webhook, worker restart); the others get their
+// progress recorded.
+func (h *Handlers) ReconcilePredictionsHandler(ctx context.Context, t *asynq.Task) error {
+	if h.Repl == nil {
+		return nil
+	}
+	jobs, err := h.DB.ListRunningPredictionJobs(ctx, mediaJobTypes, reconcileMinAge)
+	if err != nil || len(jobs) == 0 {
+		return err
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		pred, err := h.Repl.GetPrediction(ctx, *job.ReplicateID)
+		if err != nil {
+			log.Printf("[reconcile] job %s prediction %s: %v", job.ID, *job.ReplicateID, err)
+			continue
+		}
+		if pred.Status.Terminated() {
+			h.finalizePrediction(ctx, job, pred)
+		} else {
+			h.reportProgress(ctx, job, pred)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+
+	"flipo5/backend/internal/quota"
+
+	"github.com/hibiken/asynq"
+)
+
+// Queues by workload. Each task type has one (taskQueues), so a burst of one workload cannot take every
+// worker from the others.
+const (
+	QueueInteractive = "interactive" // chat replies and what a user waits on next to them
+	QueueImages      = "images"
+	QueueVideo       = "video"
+	QueueBatch       = "batch" // business tools and other long text jobs
+	QueueMaintenance = "maintenance"
+
+	// legacyQueue is where every task went before the workload queues; workers drain it after a deploy.
+	legacyQueue = "default"
+	// prioritySuffix names the boosted variant of a workload queue, for users on paid plans.
+	prioritySuffix = "_priority"
+)
+
+// Workloads in order of priority.
+var Workloads = []string{QueueInteractive, QueueImages, QueueVideo, QueueBatch, QueueMaintenance}
+
+// queueWeights are the asynq.Config.Queues weights: a worker with a free slot picks a queue with probability
+// proportional to its weight. Priority variants weigh twice their workload; maintenance has none.
+var queueWeights = map[string]int{
+	QueueInteractive: 8,
+	QueueImages:      4,
+	QueueVideo:       2,
+	QueueBatch:       2,
+	QueueMaintenance: 1,
+}
+
+var taskQueues = map[string]string{
+	TypeChat:                 QueueInteractive,
+	TypeSummarizeThread:      QueueInteractive,
+	TypeExtractFile:          QueueInteractive,
+	TypeFinalizePrediction:   QueueInteractive,
+	TypePredictionProgress:   QueueInteractive,
+	TypeImage:                QueueImages,
+	TypeUpscale:              QueueImages,
+	TypeLogo:                 QueueImages,
+	TypeProductSceneImprove:  QueueImages,
+	TypeVideo:                QueueVideo,
+	TypeSEO:                  QueueBatch,
+	TypeOutline:              QueueBatch,
+	TypeTranslate:            QueueBatch,
+	TypeProductScore:         QueueBatch,
+	TypeProductDescription:   QueueBatch,
+	TypeWebhookDelivery:      QueueBatch,
+	TypeThreadSummary:        QueueMaintenance,
+	TypeCancelStaleJobs:      QueueMaintenance,
+	TypeReconcilePredictions: QueueMaintenance,
+	TypeDispatchWebhooks:     QueueMaintenance,
+}
+
+// QueueOf is the queue of a task type (batch for types without one).
+func QueueOf(taskType string) string {
+	if q, ok := taskQueues[taskType]; ok {
+		return q
+	}
+	return QueueBatch
+}
+
+func queueOf(taskType string) asynq.Option {
+	return asynq.Queue(QueueOf(taskType))
+}
+
+// PlanQueue is the enqueue option for a user's task: users on a paid plan go to the priority variant of the
+// task's queue. Maintenance tasks are not boosted.
+func PlanQueue(taskType, plan string) asynq.Option {
+	q := QueueOf(taskType)
+	if q != QueueMaintenance && quota.PlanName(plan) != "free" {
+		q += prioritySuffix
+	}
+	return asynq.Queue(q)
+}
+
+// Queues is the asynq.Config.Queues of a worker consuming the given workloads (all when none), each with
+// its priority variant. weights overrides the default weight of a workload (the variant gets twice it).
+// "default" may be listed too; with all workloads it is always consumed, at the lowest weight.
+func Queues(workloads []string, weights map[string]int) (map[string]int, error) {
+	if strings.TrimSpace(strings.Join(workloads, "")) == "" {
+		workloads = append(append([]string(nil), Workloads...), legacyQueue)
+	}
+	out := map[string]int{}
+	for _, name := range workloads {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == legacyQueue {
+			out[legacyQueue] = 1
+			continue
+		}
+		w, ok := queueWeights[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown queue %q", name)
+		}
+		if n, ok := weights[name]; ok {
+			if n < 1 {
+				return nil, fmt.Errorf("queue %q: weight must be at least 1", name)
+			}
+			w = n
+		}
+		out[name] = w
+		if name != QueueMaintenance {
+			out[name+prioritySuffix] = 2 * w
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQueues(t *testing.T) {
+	all, err := Queues(nil, map[string]int{QueueVideo: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		"interactive": 8, "interactive_priority": 16, "images": 4, "images_priority": 8, "video": 3,
+		"video_priority": 6, "batch": 2, "batch_priority": 4, "maintenance": 1, "default": 1,
+	}
+	if fmt.Sprint(all) != fmt.Sprint(want) {
+		t.Fatalf("got %v", all)
+	}
+	some, err := Queues([]string{" video", ""}, nil)
+	if err != nil || fmt.Sprint(some) != fmt.Sprint(map[string]int{"video": 2, "video_priority": 4}) {
+		t.Fatalf("got %v %v", some, err)
+	}
+	if _, err := Queues([]string{"videos"}, nil); err == nil {
+		t.Fatal("unknown queue accepted")
+	}
+	if _, err := Queues([]string{"batch"}, map[string]int{"batch": 0}); err == nil {
+		t.Fatal("zero weight accepted")
+	}
+}
+
+func TestTaskQueues(t *testing.T) {
+	for _, c := range []struct{ taskType, plan, queue string }{
+		{TypeChat, "", "interactive"},
+		{TypeChat, "premium", "interactive_priority"},
+		{TypeVideo, "free", "video"},
+		{TypeVideo, "business", "video_priority"},
+		{TypeSEO, "pro", "batch_priority"},
+		{TypeCancelStaleJobs, "pro", "maintenance"},
+		{"unknown", "", "batch"},
+	} {
+		if got := PlanQueue(c.taskType, c.plan).Value(); got != c.queue {
+			t.Errorf("%s on %q: %v, want %s", c.taskType, c.plan, got, c.queue)
+		}
+	}
+}
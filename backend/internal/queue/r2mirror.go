@@ -12,6 +12,7 @@ import (
 
 	"github.com/google/uuid"
 	repgo "github.com/replicate/replicate-go"
+	"flipo5/backend/internal/events"
 	"flipo5/backend/internal/storage"
 )
 
@@ -74,7 +75,13 @@ func mirrorMediaToR2(h *Handlers, jobID uuid.UUID, out repgo.PredictionOutput, j
 	} else {
 		m["output"] = newURLs
 	}
-	_ = h.DB.UpdateJobOutput(ctx, jobID, m)
+	if h.DB.UpdateJobOutput(ctx, jobID, m) != nil || h.Stream == nil {
+		return
+	}
+	if job, _ := h.DB.GetJob(ctx, jobID); job != nil {
+		b, _ := json.Marshal(m)
+		_ = h.Stream.PublishUser(ctx, job.UserID, events.JobOutputMirrored{JobID: jobID, Output: b})
+	}
 }
 
 func downloadAndPut(ctx context.Context, s *storage.Store, client *http.Client, url, jobIDStr string, index int, jobType string) (key, publicURL string) {
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"time"
 
+	"flipo5/backend/internal/store"
+	"flipo5/backend/internal/webhook"
+
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 )
@@ -21,7 +24,14 @@ const (
 	TypeProductDescription   = "product_description"
 	TypeProductSceneImprove  = "product_scene_improve"
 	TypeSummarizeThread   = "summarize_thread"
+	TypeThreadSummary     = "thread_summary"
 	TypeCancelStaleJobs   = "cancel_stale_jobs"
+	TypeFinalizePrediction   = "finalize_prediction"
+	TypeReconcilePredictions = "reconcile_predictions"
+	TypePredictionProgress   = "prediction_progress"
+	TypeWebhookDelivery      = "webhook_delivery"
+	TypeDispatchWebhooks     = "dispatch_webhooks"
+	TypeExtractFile          = "extract_file"
 	JobTimeoutMinutes     = 5
 	StaleJobCleanupMinutes = 5
 )
@@ -50,7 +60,7 @@ func NewChatTask(jobID uuid.UUID, prompt string) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeChat, payload, asynq.Queue("default"), asynq.MaxRetry(3), taskTimeout), nil
+	return asynq.NewTask(TypeChat, payload, queueOf(TypeChat), asynq.MaxRetry(3), taskTimeout), nil
 }
 
 func NewImageTask(jobID uuid.UUID) (*asynq.Task, error) {
@@ -58,7 +68,7 @@ func NewImageTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeImage, payload, asynq.Queue("default"), asynq.MaxRetry(3), taskTimeout), nil
+	return asynq.NewTask(TypeImage, payload, queueOf(TypeImage), asynq.MaxRetry(3), taskTimeout), nil
 }
 
 func NewVideoTask(jobID uuid.UUID) (*asynq.Task, error) {
@@ -66,7 +76,7 @@ func NewVideoTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeVideo, payload, asynq.Queue("default"), asynq.MaxRetry(3), taskTimeout), nil
+	return asynq.NewTask(TypeVideo, payload, queueOf(TypeVideo), asynq.MaxRetry(3), taskTimeout), nil
 }
 
 func NewUpscaleTask(jobID uuid.UUID) (*asynq.Task, error) {
@@ -74,7 +84,7 @@ func NewUpscaleTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeUpscale, payload, asynq.Queue("default"), asynq.MaxRetry(3), taskTimeout), nil
+	return asynq.NewTask(TypeUpscale, payload, queueOf(TypeUpscale), asynq.MaxRetry(3), taskTimeout), nil
 }
 
 type SEOPayload struct {
@@ -86,7 +96,7 @@ func NewSEOTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeSEO, payload, asynq.Queue("default"), asynq.MaxRetry(2), taskTimeout), nil
+	return asynq.NewTask(TypeSEO, payload, queueOf(TypeSEO), asynq.MaxRetry(2), taskTimeout), nil
 }
 
 type OutlinePayload struct {
@@ -98,7 +108,7 @@ func NewOutlineTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeOutline, payload, asynq.Queue("default"), asynq.MaxRetry(2), taskTimeout), nil
+	return asynq.NewTask(TypeOutline, payload, queueOf(TypeOutline), asynq.MaxRetry(2), taskTimeout), nil
 }
 
 type TranslatePayload struct {
@@ -110,7 +120,7 @@ func NewTranslateTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeTranslate, payload, asynq.Queue("default"), asynq.MaxRetry(2), taskTimeout), nil
+	return asynq.NewTask(TypeTranslate, payload, queueOf(TypeTranslate), asynq.MaxRetry(2), taskTimeout), nil
 }
 
 type LogoPayload struct {
@@ -122,7 +132,7 @@ func NewLogoTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeLogo, payload, asynq.Queue("default"), asynq.MaxRetry(3), taskTimeout), nil
+	return asynq.NewTask(TypeLogo, payload, queueOf(TypeLogo), asynq.MaxRetry(3), taskTimeout), nil
 }
 
 type ProductScorePayload struct {
@@ -134,7 +144,7 @@ func NewProductScoreTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeProductScore, payload, asynq.Queue("default"), asynq.MaxRetry(2), asynq.Timeout(2*time.Minute)), nil
+	return asynq.NewTask(TypeProductScore, payload, queueOf(TypeProductScore), asynq.MaxRetry(2), asynq.Timeout(2*time.Minute)), nil
 }
 
 type ProductDescriptionPayload struct {
@@ -146,7 +156,7 @@ func NewProductDescriptionTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeProductDescription, payload, asynq.Queue("default"), asynq.MaxRetry(2), asynq.Timeout(2*time.Minute)), nil
+	return asynq.NewTask(TypeProductDescription, payload, queueOf(TypeProductDescription), asynq.MaxRetry(2), asynq.Timeout(2*time.Minute)), nil
 }
 
 type ProductSceneImprovePayload struct {
@@ -158,9 +168,10 @@ func NewProductSceneImproveTask(jobID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeProductSceneImprove, payload, asynq.Queue("default"), asynq.MaxRetry(2), asynq.Timeout(2*time.Minute)), nil
+	return asynq.NewTask(TypeProductSceneImprove, payload, queueOf(TypeProductSceneImprove), asynq.MaxRetry(2), asynq.Timeout(2*time.Minute)), nil
 }
 
+// SummarizeThreadPayload is shared by the title task (summarize_thread) and the rolling summary (thread_summary).
 type SummarizeThreadPayload struct {
 	ThreadID uuid.UUID `json:"thread_id"`
 }
@@ -170,10 +181,87 @@ func NewSummarizeThreadTask(threadID uuid.UUID) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeSummarizeThread, payload, asynq.Queue("default"), asynq.MaxRetry(2), taskTimeout), nil
+	return asynq.NewTask(TypeSummarizeThread, payload, queueOf(TypeSummarizeThread), asynq.MaxRetry(2), taskTimeout), nil
+}
+
+// NewThreadSummaryTask updates the rolling summary used as conversation memory (see ThreadSummaryHandler).
+func NewThreadSummaryTask(threadID uuid.UUID) (*asynq.Task, error) {
+	payload, err := json.Marshal(SummarizeThreadPayload{ThreadID: threadID})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeThreadSummary, payload, queueOf(TypeThreadSummary), asynq.MaxRetry(2), taskTimeout), nil
 }
 
 // NewCancelStaleJobsTask creates a task to cancel jobs stuck in pending/running > 5 min. No payload.
 func NewCancelStaleJobsTask() (*asynq.Task, error) {
-	return asynq.NewTask(TypeCancelStaleJobs, nil, asynq.Queue("default"), asynq.MaxRetry(1), asynq.Timeout(2*time.Minute)), nil
+	return asynq.NewTask(TypeCancelStaleJobs, nil, queueOf(TypeCancelStaleJobs), asynq.MaxRetry(1), asynq.Timeout(2*time.Minute)), nil
+}
+
+type FinalizePredictionPayload struct {
+	PredictionID string `json:"prediction_id"`
+}
+
+// NewFinalizePredictionTask finishes the job that owns a completed prediction (enqueued by the Replicate webhook).
+// The task ID collapses Replicate's retried deliveries while one is still queued.
+func NewFinalizePredictionTask(predictionID string) (*asynq.Task, error) {
+	payload, err := json.Marshal(FinalizePredictionPayload{PredictionID: predictionID})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeFinalizePrediction, payload, queueOf(TypeFinalizePrediction), asynq.MaxRetry(5), asynq.TaskID("finalize:"+predictionID), asynq.Timeout(2*time.Minute)), nil
+}
+
+// NewPredictionProgressTask records the progress of a running prediction (enqueued by the Replicate "logs"
+// webhook). It runs a little later and its task ID collapses the deliveries meanwhile, so a prediction is read
+// at most about every progressDelay however often Replicate sends logs.
+func NewPredictionProgressTask(predictionID string) (*asynq.Task, error) {
+	payload, err := json.Marshal(FinalizePredictionPayload{PredictionID: predictionID})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypePredictionProgress, payload, queueOf(TypePredictionProgress), asynq.MaxRetry(0), asynq.TaskID("progress:"+predictionID),
+		asynq.ProcessIn(progressDelay), asynq.Timeout(30*time.Second)), nil
+}
+
+// NewReconcilePredictionsTask creates a task that polls running media jobs whose webhook never arrived. No payload.
+func NewReconcilePredictionsTask() (*asynq.Task, error) {
+	return asynq.NewTask(TypeReconcilePredictions, nil, queueOf(TypeReconcilePredictions), asynq.MaxRetry(1), asynq.Timeout(2*time.Minute)), nil
+}
+
+type WebhookDeliveryPayload struct {
+	DeliveryID uuid.UUID `json:"delivery_id"`
+}
+
+// NewWebhookDeliveryTask sends one webhook delivery; retries back off exponentially (see RetryDelay).
+// The task ID keeps the dispatcher from queueing a delivery that is already queued or waiting to retry.
+func NewWebhookDeliveryTask(deliveryID uuid.UUID) (*asynq.Task, error) {
+	payload, err := json.Marshal(WebhookDeliveryPayload{DeliveryID: deliveryID})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeWebhookDelivery, payload, queueOf(TypeWebhookDelivery), asynq.MaxRetry(webhook.MaxAttempts-1),
+		asynq.TaskID("webhook:"+deliveryID.String()), asynq.Timeout(30*time.Second)), nil
+}
+
+// NewDispatchWebhooksTask creates a task that queues pending webhook deliveries whose task was lost. No payload.
+func NewDispatchWebhooksTask() (*asynq.Task, error) {
+	return asynq.NewTask(TypeDispatchWebhooks, nil, queueOf(TypeDispatchWebhooks), asynq.MaxRetry(1), asynq.Timeout(time.Minute)), nil
+}
+
+type ExtractFilePayload struct {
+	UserID      uuid.UUID `json:"user_id"`
+	FileID      uuid.UUID `json:"file_id,omitempty"` // chat project file to index for retrieval
+	URL         string    `json:"url"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+}
+
+// NewExtractFileTask reads the text of a chat project file into the file_texts cache and indexes its chunks.
+func NewExtractFileTask(userID uuid.UUID, f store.ChatProjectFile) (*asynq.Task, error) {
+	payload, err := json.Marshal(ExtractFilePayload{UserID: userID, FileID: f.ID, URL: f.FileURL, Name: f.FileName, ContentType: f.ContentType})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeExtractFile, payload, queueOf(TypeExtractFile), asynq.MaxRetry(3), asynq.Timeout(2*time.Minute)), nil
 }
@@ -0,0 +1,467 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"flipo5/backend/internal/auth"
+	"flipo5/backend/internal/quota"
+	"flipo5/backend/internal/store"
+	"flipo5/backend/internal/webhook"
+)
+
+// Chat tools: the model asks for a tool by writing <tool_call>{"name": ..., "arguments": {...}}</tool_call>,
+// ChatHandler runs it and gives the result back as a <tool_result> message for the next pass. The protocol is
+// plain text so it works with every model adapter. Each call is recorded on the reply's output
+// ("tool_calls") for the UI: arguments, status, and what to show (images, pages, files, translations).
+
+const (
+	toolCallOpen        = "<tool_call>"
+	toolCallClose       = "</tool_call>"
+	maxToolCallsPerStep = 3     // calls run from one pass; further ones are ignored
+	toolResultMaxChars  = 6000  // what one result may add to the prompt
+	toolTranslateChars  = 20000 // text the translate tool accepts
+)
+
+// InputKeyScopes is the chat job input field with the scopes of the API key that asked for the reply. A tool
+// whose Scope the key lacks is refused; replies asked for in a session have no such field.
+const InputKeyScopes = "key_scopes"
+
+// chatTool is a tool chat replies may call.
+type chatTool struct {
+	Name        string
+	Description string
+	Args        string // the arguments object as shown to the model
+	Scope       string // API key scope the call needs (the scope that creates the same job at the API); "" = none
+	Timeout     time.Duration
+	Run         func(h *Handlers, ctx context.Context, job *store.Job, args map[string]interface{}) (toolResult, error)
+}
+
+// toolResult is what a tool returns: Text for the model, Output for the UI, the child job it created and
+// the cost of predictions it ran itself (charged with the reply).
+type toolResult struct {
+	Text   string
+	Output interface{}
+	JobID  *uuid.UUID
+	Cost   int
+}
+
+// chatToolRegistry declares the tools. To add one, append it here; CHAT_TOOLS picks which are enabled.
+var chatToolRegistry = []chatTool{
+	{
+		Name:        "generate_image",
+		Description: "Create an image from a description. The image is shown to the user with your reply.",
+		Args:        `{"prompt": "detailed description of the image", "aspect_ratio": "1:1, 16:9, 9:16, 4:3 or 3:4 (optional)"}`,
+		Scope:       auth.ScopeImage,
+		Timeout:     4 * time.Minute,
+		Run:         (*Handlers).toolGenerateImage,
+	},
+	{
+		Name:        "fetch_url",
+		Description: "Read the text of a public web page.",
+		Args:        `{"url": "https://..."}`,
+		Timeout:     20 * time.Second,
+		Run:         (*Handlers).toolFetchURL,
+	},
+	{
+		Name:        "search_my_files",
+		Description: "Search the user's saved files (notes, translations) by keywords.",
+		Args:        `{"query": "keywords"}`,
+		Timeout:     15 * time.Second,
+		Run:         (*Handlers).toolSearchFiles,
+	},
+	{
+		Name:        "translate",
+		Description: "Translate a longer text accurately.",
+		Args:        `{"text": "text to translate", "target_lang": "language name, e.g. German", "source_lang": "language name (optional)"}`,
+		Timeout:     2 * time.Minute,
+		Run:         (*Handlers).toolTranslate,
+	},
+}
+
+// chatTools returns the registry tools enabled by Cfg.ChatTools, in registry order; none when ChatToolSteps
+// allows no calls.
+func (h *Handlers) chatTools() []chatTool {
+	if h.Cfg.ChatToolSteps < 1 {
+		return nil
+	}
+	enabled := map[string]bool{}
+	for _, name := range strings.Split(h.Cfg.ChatTools, ",") {
+		enabled[strings.TrimSpace(name)] = true
+	}
+	var out []chatTool
+	for _, t := range chatToolRegistry {
+		if enabled[t.Name] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// toolInstructions is the system prompt part that describes the tools and how to call them.
+func toolInstructions(tools []chatTool) string {
+	var b strings.Builder
+	b.WriteString("Tools: you can use the tools below when the user's request needs them (an image they ask for, a page they link, " +
+		"something in their saved files, a long translation). Answer directly when no tool is needed. To call a tool, write exactly\n" +
+		toolCallOpen + `{"name": "tool_name", "arguments": {...}}` + toolCallClose + "\n" +
+		"and stop; the result comes back in a <tool_result> message and you continue from there. Never invent tool results.\n")
+	for _, t := range tools {
+		fmt.Fprintf(&b, "- %s: %s Arguments: %s\n", t.Name, t.Description, t.Args)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// toolRequest is one tool call parsed from a reply; Invalid is set when it could not be read.
+type toolRequest struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Invalid   string                 `json:"-"`
+}
+
+// parseToolCalls splits a reply into the text before its first tool call and the calls it makes. A call
+// left open at the end (the model stopped inside it) is read to the end of the text.
+func parseToolCalls(s string) (string, []toolRequest) {
+	i := strings.Index(s, toolCallOpen)
+	if i < 0 {
+		return s, nil
+	}
+	text := s[:i]
+	var reqs []toolRequest
+	for rest := s[i:]; ; {
+		start := strings.Index(rest, toolCallOpen)
+		if start < 0 {
+			break
+		}
+		rest = rest[start+len(toolCallOpen):]
+		body := rest
+		if end := strings.Index(rest, toolCallClose); end >= 0 {
+			body, rest = rest[:end], rest[end+len(toolCallClose):]
+		} else {
+			rest = ""
+		}
+		body = strings.TrimSpace(body)
+		body = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(body, "```json"), "```"), "```")
+		var req toolRequest
+		if err := json.Unmarshal([]byte(strings.TrimSpace(body)), &req); err != nil || strings.TrimSpace(req.Name) == "" {
+			req = toolRequest{Invalid: "the call must be JSON with a name and arguments"}
+		}
+		reqs = append(reqs, req)
+	}
+	return text, reqs
+}
+
+// visibleReply is streamed reply text without a tool call being written: everything from <tool_call> on,
+// or a trailing start of the tag that may become one.
+func visibleReply(s string) string {
+	if i := strings.Index(s, toolCallOpen); i >= 0 {
+		return s[:i]
+	}
+	for n := len(toolCallOpen) - 1; n > 0; n-- {
+		if strings.HasSuffix(s, toolCallOpen[:n]) {
+			return s[:len(s)-n]
+		}
+	}
+	return s
+}
+
+// toolCall is the record of one call, kept in the reply's output.
+type toolCall struct {
+	ID        string                 `json:"id"`
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Status    string                 `json:"status"` // "completed" or "failed"
+	Error     string                 `json:"error,omitempty"`
+	JobID     *uuid.UUID             `json:"job_id,omitempty"`
+	Output    interface{}            `json:"output,omitempty"`
+	result    string                 // given back to the model
+	cost      int
+}
+
+// runTool runs one requested call with its tool's timeout. Failures are recorded on the call and given
+// back to the model, which can tell the user or try otherwise.
+func (h *Handlers) runTool(ctx context.Context, job *store.Job, tools []chatTool, req toolRequest, id string) toolCall {
+	call := toolCall{ID: id, Name: req.Name, Arguments: req.Arguments, Status: "failed"}
+	if call.Arguments == nil {
+		call.Arguments = map[string]interface{}{}
+	}
+	fail := func(msg string) toolCall {
+		call.Error, call.result = msg, "Error: "+msg
+		return call
+	}
+	if req.Invalid != "" {
+		call.Name = "invalid"
+		return fail(req.Invalid)
+	}
+	var tool *chatTool
+	for i := range tools {
+		if tools[i].Name == req.Name {
+			tool = &tools[i]
+		}
+	}
+	if tool == nil {
+		return fail("unknown tool " + req.Name)
+	}
+	if tool.Scope != "" && !jobKeyHasScope(job, tool.Scope) {
+		return fail("the API key used for this chat lacks the " + tool.Scope + " scope")
+	}
+	tctx, cancel := context.WithTimeout(ctx, tool.Timeout)
+	res, err := tool.Run(h, tctx, job, call.Arguments)
+	cancel()
+	call.JobID, call.cost = res.JobID, res.Cost
+	if err != nil {
+		return fail(jobErrorMsg(err))
+	}
+	call.Status, call.Output, call.result = "completed", res.Output, res.Text
+	return call
+}
+
+// jobKeyHasScope reports whether the API key that asked for the chat job has scope; true when it was asked
+// for in a session (no InputKeyScopes).
+func jobKeyHasScope(job *store.Job, scope string) bool {
+	var input map[string]interface{}
+	_ = json.Unmarshal(job.Input, &input)
+	raw, isKey := input[InputKeyScopes]
+	if !isKey {
+		return true
+	}
+	scopes, _ := raw.([]interface{})
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// toolResults is the message that gives a round of calls back to the model. After the last allowed round
+// the model is told to answer.
+func toolResults(calls []toolCall, last bool) string {
+	var b strings.Builder
+	for _, c := range calls {
+		result := c.result
+		if len(result) > toolResultMaxChars {
+			result = strings.ToValidUTF8(result[:toolResultMaxChars], "") + "…"
+		}
+		fmt.Fprintf(&b, "<tool_result name=%q>\n%s\n</tool_result>\n", c.Name, result)
+	}
+	if last {
+		b.WriteString("No more tools can be used now. Answer the user with what you have.")
+	} else {
+		b.WriteString("Continue your answer to the user with these results.")
+	}
+	return b.String()
+}
+
+func stringArg(args map[string]interface{}, key string) string {
+	s, _ := args[key].(string)
+	return strings.TrimSpace(s)
+}
+
+var toolAspectRatios = map[string]bool{"1:1": true, "16:9": true, "9:16": true, "4:3": true, "3:4": true}
+
+// toolGenerateImage creates an image job as a child of the chat job (it shows in the user's library and is
+// charged like one made in the studio) and runs it here, waiting for the result when a webhook finishes it.
+func (h *Handlers) toolGenerateImage(ctx context.Context, job *store.Job, args map[string]interface{}) (toolResult, error) {
+	prompt := stringArg(args, "prompt")
+	if prompt == "" {
+		return toolResult{}, errors.New("prompt is required")
+	}
+	input := map[string]interface{}{"prompt": prompt, "max_images": 1}
+	if ar := stringArg(args, "aspect_ratio"); toolAspectRatios[ar] {
+		input["aspect_ratio"] = ar
+	}
+	if msg := h.childJobRefusal(ctx, job.UserID, "image", input); msg != "" {
+		return toolResult{}, errors.New(msg)
+	}
+	childID, err := h.DB.CreateChildJobReserved(ctx, job.UserID, "image", input, job.ID, h.Credits.ReserveFor("image"))
+	if errors.Is(err, store.ErrInsufficientCredits) {
+		return toolResult{}, errors.New("not enough credits to generate an image")
+	}
+	if err != nil {
+		return toolResult{}, err
+	}
+	res := toolResult{JobID: &childID}
+	task, err := NewImageTask(childID)
+	if err != nil {
+		return res, err
+	}
+	_ = h.ImageHandler(ctx, task) // the job records the outcome
+	for {
+		child, err := h.DB.GetJob(context.WithoutCancel(ctx), childID)
+		if err != nil || child == nil {
+			return res, errors.New("image job not found")
+		}
+		switch child.Status {
+		case "completed":
+			urls := outputURLs(child.Output)
+			if len(urls) == 0 {
+				return res, errors.New("the image model returned no image")
+			}
+			res.Output = map[string]interface{}{"images": urls}
+			res.Text = "The image was generated and is shown to the user with your reply. Do not repeat its URL."
+			return res, nil
+		case "failed", "cancelled":
+			msg := "image generation failed"
+			if child.Error != nil && *child.Error != "" {
+				msg += ": " + *child.Error
+			}
+			return res, errors.New(msg)
+		}
+		select {
+		case <-ctx.Done():
+			return res, errors.New("the image is still being generated; it will appear in the user's library")
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// childJobRefusal is why the user may not get a job of jobType from a tool: the plan quota is used up.
+// Counting errors let the job through, as at the API.
+func (h *Handlers) childJobRefusal(ctx context.Context, userID uuid.UUID, jobType string, input interface{}) string {
+	metric := quota.Metric(jobType)
+	if h.Plans == nil || metric == "" {
+		return ""
+	}
+	var planName string
+	if u, _ := h.DB.UserByID(ctx, userID); u != nil {
+		planName = u.Plan
+	}
+	_, plan := h.Plans.For(planName)
+	dayStart, monthStart, _, _ := quota.Windows(time.Now())
+	rows, err := h.DB.GetJobUsage(ctx, userID, dayStart, monthStart)
+	if err != nil {
+		return ""
+	}
+	var usage quota.Usage
+	for _, r := range rows {
+		usage.Add(r.Type, r.DayJobs, r.MonthJobs, r.DaySeconds, r.MonthSeconds)
+	}
+	if e := plan.Check(metric, quota.Amount(jobType, input), usage); e != nil {
+		return fmt.Sprintf("the user's %s %s limit is reached", e.Period, strings.ReplaceAll(e.Metric, "_", " "))
+	}
+	return ""
+}
+
+// outputURLs are the media URLs of a job output ({"output": "url"} or {"output": ["url", ...]}).
+func outputURLs(raw json.RawMessage) []string {
+	var out struct {
+		Output interface{} `json:"output"`
+	}
+	_ = json.Unmarshal(raw, &out)
+	switch v := out.Output.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		var urls []string
+		for _, u := range v {
+			if s, ok := u.(string); ok && s != "" {
+				urls = append(urls, s)
+			}
+		}
+		return urls
+	}
+	return nil
+}
+
+// toolFetchURL reads a page the model names. The URL comes from the conversation, so the client refuses
+// private and loopback addresses (redirects included: every connection is checked).
+func (h *Handlers) toolFetchURL(ctx context.Context, job *store.Job, args map[string]interface{}) (toolResult, error) {
+	raw := stringArg(args, "url")
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return toolResult{}, errors.New("url must be an http(s) link")
+	}
+	client := webhook.NewClient(false)
+	client.Timeout = 15 * time.Second
+	client.CheckRedirect = nil
+	text, err := fetchPageTextWith(ctx, client, u.String())
+	if err != nil {
+		return toolResult{}, fmt.Errorf("could not read %s: %w", u.String(), err)
+	}
+	if text == "" {
+		text = "(the page has no readable text)"
+	}
+	return toolResult{Text: text, Output: map[string]interface{}{"url": u.String(), "chars": len(text)}}, nil
+}
+
+// toolSearchFiles runs the full-text search over the user's files and gives the model the best matches
+// with the start of their text.
+func (h *Handlers) toolSearchFiles(ctx context.Context, job *store.Job, args map[string]interface{}) (toolResult, error) {
+	q := stringArg(args, "query")
+	if q == "" {
+		return toolResult{}, errors.New("query is required")
+	}
+	hits, total, err := h.DB.SearchDocuments(ctx, job.UserID, q, []string{store.SearchFile}, 0, 5)
+	if err != nil {
+		return toolResult{}, err
+	}
+	if len(hits) == 0 {
+		return toolResult{Text: "No saved files match.", Output: map[string]interface{}{"query": q, "results": hits, "total": 0}}, nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d file(s) match; the best ones:\n", total)
+	budget := toolResultMaxChars / len(hits)
+	for _, r := range hits {
+		fmt.Fprintf(&b, "\n## %s\n", r.Title)
+		text := plainSnippet(r.Snippet)
+		if f, _ := h.DB.GetUserFile(ctx, r.ID, job.UserID); f != nil && strings.TrimSpace(f.Content) != "" {
+			text = strings.TrimSpace(f.Content)
+		}
+		if len(text) > budget {
+			text = strings.ToValidUTF8(text[:budget], "") + "…"
+		}
+		b.WriteString(text + "\n")
+	}
+	return toolResult{Text: b.String(), Output: map[string]interface{}{"query": q, "results": hits, "total": total}}, nil
+}
+
+// plainSnippet is a search snippet as text: no <mark> tags, entities decoded.
+func plainSnippet(s string) string {
+	s = strings.NewReplacer("<mark>", "", "</mark>", "").Replace(s)
+	return html.UnescapeString(s)
+}
+
+// toolTranslate translates with the translate model, as translation jobs do, and returns the text to the
+// model; its cost is charged with the reply.
+func (h *Handlers) toolTranslate(ctx context.Context, job *store.Job, args map[string]interface{}) (toolResult, error) {
+	text, target := stringArg(args, "text"), stringArg(args, "target_lang")
+	if text == "" || target == "" {
+		return toolResult{}, errors.New("text and target_lang are required")
+	}
+	if len(text) > toolTranslateChars {
+		return toolResult{}, fmt.Errorf("text is too long (at most %d characters)", toolTranslateChars)
+	}
+	source := stringArg(args, "source_lang")
+	if source == "" {
+		source = "auto"
+	}
+	model := h.Cfg.TextModel("translate")
+	pred, err := h.Repl.CreatePrediction(ctx, model, textTranslationInput(source, target, text), nil)
+	if err != nil {
+		return toolResult{}, err
+	}
+	state, err := h.awaitPrediction(ctx, pred.ID, 120, time.Second, nil)
+	if err != nil {
+		return toolResult{}, err
+	}
+	cost := h.Billing.PredictionCost(model, state)
+	if state.Status != "succeeded" {
+		return toolResult{Cost: cost}, errors.New(predictionError(state, "translation failed"))
+	}
+	out := strings.TrimSpace(predictionText(state.Output))
+	return toolResult{
+		Text:   out,
+		Output: map[string]interface{}{"source_lang": source, "target_lang": target, "translation": out},
+		Cost:   cost,
+	}, nil
+}
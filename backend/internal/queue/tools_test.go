@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"flipo5/backend/internal/config"
+	"flipo5/backend/internal/store"
+)
+
+func TestParseToolCalls(t *testing.T) {
+	text, reqs := parseToolCalls("Let me look.\n<tool_call>{\"name\": \"fetch_url\", \"arguments\": {\"url\": \"https://example.com\"}}</tool_call> made up result")
+	if text != "Let me look.\n" || len(reqs) != 1 || reqs[0].Name != "fetch_url" || reqs[0].Arguments["url"] != "https://example.com" {
+		t.Fatalf("got %q %+v", text, reqs)
+	}
+
+	_, reqs = parseToolCalls("<tool_call>```json\n{\"name\": \"a\", \"arguments\": {}}\n```</tool_call><tool_call>{\"name\": \"b\", \"arguments\": {\"x\": 1}}")
+	if len(reqs) != 2 || reqs[0].Name != "a" || reqs[1].Name != "b" || reqs[1].Arguments["x"] != 1.0 {
+		t.Fatalf("fenced and unclosed calls: %+v", reqs)
+	}
+
+	_, reqs = parseToolCalls("<tool_call>generate an image</tool_call>")
+	if len(reqs) != 1 || reqs[0].Invalid == "" {
+		t.Fatalf("invalid call not flagged: %+v", reqs)
+	}
+
+	if text, reqs := parseToolCalls("no tools here"); text != "no tools here" || reqs != nil {
+		t.Fatalf("got %q %+v", text, reqs)
+	}
+}
+
+func TestVisibleReply(t *testing.T) {
+	for in, want := range map[string]string{
+		"Hello":                        "Hello",
+		"Hello <tool_call>{\"name\"":   "Hello ",
+		"Hello <tool_":                 "Hello ",
+		"Hello <":                      "Hello ",
+		"a < b":                        "a < b",
+		"x <tool_call>{}</tool_call>y": "x ",
+	} {
+		if got := visibleReply(in); got != want {
+			t.Errorf("visibleReply(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestChatTools(t *testing.T) {
+	h := &Handlers{Cfg: &config.Config{ChatTools: "translate, fetch_url,unknown", ChatToolSteps: 2}}
+	tools := h.chatTools()
+	if len(tools) != 2 || tools[0].Name != "fetch_url" || tools[1].Name != "translate" {
+		t.Fatalf("got %+v", tools)
+	}
+	prompt := toolInstructions(tools)
+	if !strings.Contains(prompt, "- fetch_url:") || strings.Contains(prompt, "generate_image") {
+		t.Fatalf("instructions: %s", prompt)
+	}
+	h.Cfg.ChatToolSteps = 0
+	if h.chatTools() != nil {
+		t.Fatal("no steps must disable tools")
+	}
+	h.Cfg = &config.Config{ChatTools: "none", ChatToolSteps: 3}
+	if h.chatTools() != nil {
+		t.Fatal(`"none" must disable tools`)
+	}
+}
+
+func TestRunToolFailures(t *testing.T) {
+	h := &Handlers{Cfg: &config.Config{ChatTools: "fetch_url", ChatToolSteps: 1}}
+	tools := h.chatTools()
+	call := h.runTool(context.Background(), nil, tools, toolRequest{Name: "generate_image"}, "call_1")
+	if call.Status != "failed" || call.Error != "unknown tool generate_image" || call.Arguments == nil {
+		t.Fatalf("disabled tool: %+v", call)
+	}
+	call = h.runTool(context.Background(), nil, tools, toolRequest{Invalid: "bad"}, "call_2")
+	if call.Status != "failed" || call.Name != "invalid" {
+		t.Fatalf("invalid call: %+v", call)
+	}
+	msg := toolResults([]toolCall{call}, true)
+	if !strings.Contains(msg, `<tool_result name="invalid">`+"\nError: bad\n") || !strings.Contains(msg, "Answer the user") {
+		t.Fatalf("results message: %s", msg)
+	}
+}
+
+func TestRunToolKeyScopes(t *testing.T) {
+	h := &Handlers{Cfg: &config.Config{ChatTools: "generate_image", ChatToolSteps: 1}}
+	tools := h.chatTools()
+	job := &store.Job{Input: []byte(`{"prompt":"draw a fox","key_scopes":["chat"]}`)}
+	call := h.runTool(context.Background(), job, tools, toolRequest{Name: "generate_image", Arguments: map[string]interface{}{"prompt": "a fox"}}, "call_1")
+	if call.Status != "failed" || call.Error != "the API key used for this chat lacks the image scope" || call.JobID != nil {
+		t.Fatalf("chat-only key: %+v", call)
+	}
+	if !jobKeyHasScope(&store.Job{Input: []byte(`{"key_scopes":["chat","image"]}`)}, "image") {
+		t.Fatal("key with the image scope refused")
+	}
+	if !jobKeyHasScope(&store.Job{Input: []byte(`{"prompt":"hi"}`)}, "image") {
+		t.Fatal("session chat refused")
+	}
+}
+
+func TestPlainSnippet(t *testing.T) {
+	if got := plainSnippet("a <mark>fox</mark> &amp; a &lt;dog&gt;"); got != "a fox & a <dog>" {
+		t.Fatalf("got %q", got)
+	}
+}
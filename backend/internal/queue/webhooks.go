@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"flipo5/backend/internal/store"
+	"flipo5/backend/internal/webhook"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+)
+
+// webhookClients: [0] refuses private addresses, [1] allows them (WEBHOOK_ALLOW_PRIVATE).
+var webhookClients = [2]*http.Client{webhook.NewClient(false), webhook.NewClient(true)}
+
+// dispatchMinAge leaves freshly committed deliveries to OnWebhookDeliveries before the dispatcher requeues them.
+const dispatchMinAge = 2 * time.Minute
+
+// RetryDelay is the worker's asynq.Config.RetryDelayFunc: exponential webhook backoff, asynq's default otherwise.
+func RetryDelay(n int, err error, t *asynq.Task) time.Duration {
+	if t.Type() == TypeWebhookDelivery {
+		return webhook.Backoff(n)
+	}
+	return asynq.DefaultRetryDelayFunc(n, err, t)
+}
+
+// EnqueueWebhookDeliveries queues delivery tasks; a delivery that is already queued is skipped.
+func EnqueueWebhookDeliveries(client *asynq.Client, ids []uuid.UUID) {
+	for _, id := range ids {
+		task, err := NewWebhookDeliveryTask(id)
+		if err != nil {
+			continue
+		}
+		if _, err := client.Enqueue(task); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			log.Printf("[webhooks] enqueue delivery %s: %v", id, err)
+		}
+	}
+}
+
+// WebhookDeliveryHandler POSTs a delivery's event to its endpoint, signed with the endpoint secret.
+// Any 2xx marks it delivered; otherwise the attempt is logged and the task retried until webhook.MaxAttempts.
+func (h *Handlers) WebhookDeliveryHandler(ctx context.Context, t *asynq.Task) error {
+	var p WebhookDeliveryPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return err
+	}
+	target, err := h.DB.GetWebhookTarget(ctx, p.DeliveryID)
+	if err != nil {
+		return err
+	}
+	if target == nil || target.Status != store.DeliveryPending {
+		return nil // endpoint deleted, or already delivered
+	}
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	final := retried >= maxRetry
+
+	code, sendErr := h.sendWebhook(ctx, target.URL, target.Secret, target.Event, target.ID, target.Payload)
+	delivered := sendErr == nil
+	errMsg := ""
+	if sendErr != nil {
+		errMsg = sendErr.Error()
+	}
+	if err := h.DB.RecordWebhookAttempt(ctx, target.ID, code, errMsg, delivered, final); err != nil {
+		return err
+	}
+	if delivered || final {
+		if !delivered {
+			log.Printf("[webhooks] delivery %s to %s failed after %d attempts: %s", target.ID, target.URL, retried+1, errMsg)
+		}
+		return nil
+	}
+	return fmt.Errorf("webhook delivery %s: %w", target.ID, sendErr)
+}
+
+// sendWebhook returns the response status (0 when there was none) and an error unless it was 2xx.
+func (h *Handlers) sendWebhook(ctx context.Context, url, secret, event string, deliveryID uuid.UUID, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Flipo5-Webhooks/1.0")
+	req.Header.Set(webhook.HeaderEvent, event)
+	req.Header.Set(webhook.HeaderDelivery, deliveryID.String())
+	req.Header.Set(webhook.HeaderSignature, webhook.Sign(secret, time.Now(), body))
+	client := webhookClients[0]
+	if h.Cfg != nil && h.Cfg.WebhookAllowPrivate {
+		client = webhookClients[1]
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return resp.StatusCode, nil
+	}
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	return resp.StatusCode, fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(snippet))
+}
+
+// DispatchWebhooksHandler requeues pending deliveries whose task never made it to Redis (e.g. the process
+// stopped right after the job finished). Deliveries queued or waiting for a retry are skipped by task ID.
+func (h *Handlers) DispatchWebhooksHandler(ctx context.Context, t *asynq.Task) error {
+	if h.Asynq == nil {
+		return nil
+	}
+	ids, err := h.DB.PendingWebhookDeliveries(ctx, time.Now().Add(-dispatchMinAge), 500)
+	if err != nil {
+		return err
+	}
+	EnqueueWebhookDeliveries(h.Asynq, ids)
+	return nil
+}
@@ -0,0 +1,206 @@
+// Package quota defines what each plan may create per day and per month.
+// Usage is counted from the jobs table (failed and cancelled jobs do not count); windows are UTC calendar days/months.
+package quota
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Metrics limited by plans. Job types not listed in Metric are not limited.
+const (
+	ChatMessages = "chat_messages"
+	Images       = "images"
+	VideoSeconds = "video_seconds"
+	Upscales     = "upscales"
+	SEOArticles  = "seo_articles"
+)
+
+// Metrics in display order.
+var Metrics = []string{ChatMessages, Images, VideoSeconds, Upscales, SEOArticles}
+
+// Unlimited disables a daily or monthly limit.
+const Unlimited = -1
+
+type Limit struct {
+	Daily   int `json:"daily"`
+	Monthly int `json:"monthly"`
+}
+
+// Plan limits by metric; a metric missing from Limits is unlimited.
+type Plan struct {
+	Limits map[string]Limit `json:"limits"`
+}
+
+// Plans by name. "free" must exist: it applies to users without a (known) plan.
+type Plans map[string]Plan
+
+// aliases maps the plan names offered at signup to plan definitions.
+var aliases = map[string]string{"premium": "pro", "creator": "business"}
+
+// PlanName is the canonical name of a users.plan value: lower case, signup aliases resolved, "" = free.
+func PlanName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if a, ok := aliases[name]; ok {
+		return a
+	}
+	if name == "" {
+		return "free"
+	}
+	return name
+}
+
+func Default() Plans {
+	return Plans{
+		"free": {Limits: map[string]Limit{
+			ChatMessages: {Daily: 50, Monthly: 1000},
+			Images:       {Daily: 10, Monthly: 100},
+			VideoSeconds: {Daily: 10, Monthly: 30},
+			Upscales:     {Daily: 3, Monthly: 20},
+			SEOArticles:  {Daily: 2, Monthly: 10},
+		}},
+		"pro": {Limits: map[string]Limit{
+			ChatMessages: {Daily: 500, Monthly: Unlimited},
+			Images:       {Daily: 100, Monthly: 2000},
+			VideoSeconds: {Daily: 120, Monthly: 1200},
+			Upscales:     {Daily: 50, Monthly: 500},
+			SEOArticles:  {Daily: 20, Monthly: 200},
+		}},
+		"business": {Limits: map[string]Limit{
+			ChatMessages: {Daily: Unlimited, Monthly: Unlimited},
+			Images:       {Daily: 500, Monthly: 10000},
+			VideoSeconds: {Daily: 600, Monthly: 6000},
+			Upscales:     {Daily: 200, Monthly: 2000},
+			SEOArticles:  {Daily: 100, Monthly: 1000},
+		}},
+	}
+}
+
+// Load returns Default with the plans in raw (JSON, same shape as Plans) replacing or adding whole plans.
+func Load(raw string) (Plans, error) {
+	p := Default()
+	if strings.TrimSpace(raw) == "" {
+		return p, nil
+	}
+	var o Plans
+	if err := json.Unmarshal([]byte(raw), &o); err != nil {
+		return nil, err
+	}
+	for name, plan := range o {
+		p[name] = plan
+	}
+	return p, nil
+}
+
+// For resolves a user's plan ("" and unknown names fall back to free) and returns its canonical name.
+func (p Plans) For(name string) (string, Plan) {
+	name = PlanName(name)
+	if plan, ok := p[name]; ok {
+		return name, plan
+	}
+	return "free", p["free"]
+}
+
+// Metric returns the metric a job type counts against ("" = not limited).
+func Metric(jobType string) string {
+	switch jobType {
+	case "chat":
+		return ChatMessages
+	case "image", "logo":
+		return Images
+	case "video":
+		return VideoSeconds
+	case "upscale":
+		return Upscales
+	case "seo":
+		return SEOArticles
+	}
+	return ""
+}
+
+// Amount is how much one job uses of its metric: the requested duration for video, 1 otherwise.
+func Amount(jobType string, input interface{}) int {
+	if jobType != "video" {
+		return 1
+	}
+	if m, ok := input.(map[string]interface{}); ok {
+		switch d := m["duration"].(type) {
+		case int:
+			return d
+		case float64:
+			return int(d)
+		}
+	}
+	return 5 // model default
+}
+
+// Usage is consumption per metric in the current day and month.
+type Usage struct {
+	Day   map[string]int
+	Month map[string]int
+}
+
+// Add counts jobs of jobType; seconds is their summed video duration.
+func (u *Usage) Add(jobType string, dayJobs, monthJobs, daySeconds, monthSeconds int) {
+	metric := Metric(jobType)
+	if metric == "" {
+		return
+	}
+	if u.Day == nil {
+		u.Day, u.Month = map[string]int{}, map[string]int{}
+	}
+	if metric == VideoSeconds {
+		dayJobs, monthJobs = daySeconds, monthSeconds
+	}
+	u.Day[metric] += dayJobs
+	u.Month[metric] += monthJobs
+}
+
+// Windows returns the start of the current UTC day and month and when each resets.
+func Windows(now time.Time) (dayStart, monthStart, dayReset, monthReset time.Time) {
+	now = now.UTC()
+	dayStart = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return dayStart, monthStart, dayStart.AddDate(0, 0, 1), monthStart.AddDate(0, 1, 0)
+}
+
+// Exceeded describes the limit a new job would break.
+type Exceeded struct {
+	Metric    string `json:"metric"`
+	Period    string `json:"period"` // "daily" or "monthly"
+	Limit     int    `json:"limit"`
+	Used      int    `json:"used"`
+	Remaining int    `json:"remaining"`
+	Requested int    `json:"requested"`
+}
+
+// Check returns the first limit that amount more of metric would exceed, daily before monthly, or nil.
+func (p Plan) Check(metric string, amount int, u Usage) *Exceeded {
+	l, ok := p.Limits[metric]
+	if !ok || metric == "" {
+		return nil
+	}
+	if e := exceeds(metric, "daily", l.Daily, u.Day[metric], amount); e != nil {
+		return e
+	}
+	return exceeds(metric, "monthly", l.Monthly, u.Month[metric], amount)
+}
+
+func exceeds(metric, period string, limit, used, amount int) *Exceeded {
+	if limit == Unlimited || used+amount <= limit {
+		return nil
+	}
+	return &Exceeded{Metric: metric, Period: period, Limit: limit, Used: used, Remaining: Remaining(limit, used), Requested: amount}
+}
+
+// Remaining is what is left of limit, or -1 (Unlimited).
+func Remaining(limit, used int) int {
+	if limit == Unlimited {
+		return Unlimited
+	}
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
@@ -0,0 +1,60 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	_, free := Default().For("")
+	var u Usage
+	u.Add("image", 9, 40, 0, 0)
+	u.Add("logo", 0, 55, 0, 0)
+	u.Add("video", 2, 5, 10, 25)
+
+	if e := free.Check(Images, 1, u); e != nil {
+		t.Fatalf("10th image of the day must pass: %+v", e)
+	}
+	u.Add("image", 1, 1, 0, 0)
+	e := free.Check(Images, 1, u)
+	if e == nil || e.Period != "daily" || e.Used != 10 || e.Remaining != 0 {
+		t.Fatalf("got %+v, want daily image limit", e)
+	}
+	e = free.Check(VideoSeconds, Amount("video", map[string]interface{}{"duration": float64(6)}), Usage{Day: map[string]int{}, Month: u.Month})
+	if e == nil || e.Period != "monthly" || e.Remaining != 5 || e.Requested != 6 {
+		t.Fatalf("got %+v, want monthly video limit", e)
+	}
+	if e := free.Check("", 1, u); e != nil {
+		t.Fatal("unlimited job types must pass")
+	}
+	_, business := Default().For("creator")
+	if e := business.Check(ChatMessages, 1, Usage{Day: map[string]int{ChatMessages: 1e6}, Month: map[string]int{ChatMessages: 1e6}}); e != nil {
+		t.Fatalf("unlimited chat: %+v", e)
+	}
+}
+
+func TestForAndLoad(t *testing.T) {
+	plans, err := Load(`{"pro":{"limits":{"images":{"daily":1,"monthly":2}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, p := plans.For("premium"); name != "pro" || p.Limits[Images].Daily != 1 {
+		t.Fatalf("got %s %+v", name, p)
+	}
+	if _, pro := plans.For("pro"); pro.Limits[SEOArticles] != (Limit{}) {
+		t.Fatal("an overridden plan replaces its limits")
+	}
+	if name, _ := plans.For("enterprise"); name != "free" {
+		t.Fatalf("unknown plan resolved to %s", name)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	day, month, dayReset, monthReset := Windows(time.Date(2026, 1, 31, 23, 30, 0, 0, time.FixedZone("X", -2*3600)))
+	if !day.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)) || !month.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("windows not in UTC: %v %v", day, month)
+	}
+	if !dayReset.Equal(time.Date(2026, 2, 2, 0, 0, 0, 0, time.UTC)) || !monthReset.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("resets: %v %v", dayReset, monthReset)
+	}
+}
@@ -0,0 +1,130 @@
+// Package ratelimit is the API request limiter: GCRA (a token bucket kept as one timestamp per key) in Redis,
+// so limits hold across replicas and restarts, with an in-process fallback when Redis is missing or down.
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Result of one Allow call, in the units of the RateLimit-* response headers.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int           // requests left right now
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // when denied: until the next request is allowed
+}
+
+// gcra is the limiter step shared by the memory store and (as Lua) Redis. Times are microseconds;
+// tat is the stored "theoretical arrival time". Every request moves it interval further; a request is
+// allowed while tat stays within one window of now, which lets a full bucket absorb limit requests at once.
+func gcra(now, tat, interval, window int64) (allowed bool, newTAT, remaining, reset, retryAfter int64) {
+	if tat < now {
+		tat = now
+	}
+	newTAT = tat + interval
+	if allowAt := newTAT - window; now < allowAt {
+		return false, tat, 0, tat - now, allowAt - now
+	}
+	return true, newTAT, (now + window - newTAT) / interval, newTAT - now, 0
+}
+
+// gcraScript is gcra against one Redis key, using the Redis clock so replicas agree.
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local tat = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tat < now then tat = now end
+local new_tat = tat + interval
+local allow_at = new_tat - window
+if now < allow_at then
+  return {0, 0, tat - now, allow_at - now}
+end
+redis.call('SET', KEYS[1], string.format('%d', new_tat), 'PX', math.ceil((new_tat - now) / 1000))
+return {1, math.floor((now + window - new_tat) / interval), new_tat - now, 0}
+`)
+
+// Limiter checks requests against policies. Rules decide which policies apply to a request.
+type Limiter struct {
+	Rules *Rules
+	rdb   *redis.Client
+	mem   *memory
+
+	mu        sync.Mutex
+	lastError time.Time
+}
+
+// New returns a limiter backed by rdb, or by process memory when rdb is nil (single instance, tests).
+func New(rdb *redis.Client, rules *Rules) *Limiter {
+	if rules == nil {
+		rules = Default()
+	}
+	return &Limiter{Rules: rules, rdb: rdb, mem: &memory{tat: map[string]int64{}}}
+}
+
+// Allow takes one request from key's bucket under p. Redis errors fall back to the in-process bucket
+// rather than failing requests.
+func (l *Limiter) Allow(ctx context.Context, key string, p Policy) Result {
+	interval, window := p.interval(), p.Window.Microseconds()
+	var allowed bool
+	var remaining, reset, retry int64
+	if l.rdb != nil {
+		vals, err := gcraScript.Run(ctx, l.rdb, []string{"rl:" + key}, interval, window).Int64Slice()
+		if err == nil && len(vals) == 4 {
+			allowed, remaining, reset, retry = vals[0] == 1, vals[1], vals[2], vals[3]
+			return newResult(allowed, p.Limit, remaining, reset, retry)
+		}
+		l.logError(err)
+	}
+	allowed, remaining, reset, retry = l.mem.allow(key, time.Now().UnixMicro(), interval, window)
+	return newResult(allowed, p.Limit, remaining, reset, retry)
+}
+
+func newResult(allowed bool, limit int, remaining, reset, retry int64) Result {
+	return Result{
+		Allowed:    allowed,
+		Limit:      limit,
+		Remaining:  int(remaining),
+		Reset:      time.Duration(reset) * time.Microsecond,
+		RetryAfter: time.Duration(retry) * time.Microsecond,
+	}
+}
+
+func (l *Limiter) logError(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.lastError) > time.Minute {
+		l.lastError = time.Now()
+		log.Printf("[ratelimit] redis unavailable, using in-process limits: %v", err)
+	}
+}
+
+// memory is the in-process store: one tat per key, swept of expired keys every few minutes.
+type memory struct {
+	mu        sync.Mutex
+	tat       map[string]int64
+	lastSweep int64
+}
+
+func (m *memory) allow(key string, now, interval, window int64) (bool, int64, int64, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now-m.lastSweep > int64(2*time.Minute/time.Microsecond) {
+		m.lastSweep = now
+		for k, t := range m.tat {
+			if t < now {
+				delete(m.tat, k)
+			}
+		}
+	}
+	allowed, tat, remaining, reset, retry := gcra(now, m.tat[key], interval, window)
+	m.tat[key] = tat
+	return allowed, remaining, reset, retry
+}
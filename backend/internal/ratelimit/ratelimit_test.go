@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGCRABurstThenRefill(t *testing.T) {
+	p := Policy{Limit: 3, Window: 3 * time.Second}
+	iv, win := p.interval(), p.Window.Microseconds()
+	now, tat := int64(1_000_000_000), int64(0)
+	for i := 0; i < 3; i++ {
+		ok, next, remaining, _, _ := gcra(now, tat, iv, win)
+		if !ok || remaining != int64(2-i) {
+			t.Fatalf("request %d: allowed=%v remaining=%d", i, ok, remaining)
+		}
+		tat = next
+	}
+	ok, next, _, _, retry := gcra(now, tat, iv, win)
+	if ok || retry != iv || next != tat {
+		t.Fatalf("4th request: allowed=%v retry=%d tat moved=%v", ok, retry, next != tat)
+	}
+	if ok, _, remaining, _, _ := gcra(now+iv, tat, iv, win); !ok || remaining != 0 {
+		t.Fatalf("after one interval: allowed=%v remaining=%d", ok, remaining)
+	}
+}
+
+func TestLimiterMemory(t *testing.T) {
+	l := New(nil, nil)
+	p := Policy{Limit: 2, Window: time.Minute}
+	for i := 0; i < 2; i++ {
+		if r := l.Allow(context.Background(), "k", p); !r.Allowed || r.Limit != 2 {
+			t.Fatalf("request %d denied: %+v", i, r)
+		}
+	}
+	r := l.Allow(context.Background(), "k", p)
+	if r.Allowed || r.RetryAfter <= 0 || r.RetryAfter > 30*time.Second {
+		t.Fatalf("3rd request: %+v", r)
+	}
+	if r := l.Allow(context.Background(), "other", p); !r.Allowed {
+		t.Fatalf("keys must not share buckets")
+	}
+}
+
+func TestForUser(t *testing.T) {
+	r, err := Load(`{"plans":{"pro":{"limit":5000}},"routes":[{"name":"gen","method":"POST","paths":["/api/image","/api/jobs/*"],"policy":{"limit":10,"window":"1h"},"plans":{"business":{"limit":0}}}]}`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(bs []Bucket) (out []string) {
+		for _, b := range bs {
+			out = append(out, b.Name)
+		}
+		return out
+	}
+	b := r.ForUser("POST", "/api/image", "")
+	if len(b) != 2 || b[0].Policy.Limit != 2000 || b[1].Name != "route:gen" || b[1].Policy.Window != time.Hour {
+		t.Fatalf("free: %+v", b)
+	}
+	if b := r.ForUser("GET", "/api/image", "Premium"); len(b) != 1 || b[0].Policy.Limit != 5000 {
+		t.Fatalf("premium is pro: %+v", b)
+	}
+	if b := r.ForUser("POST", "/api/jobs/1/cancel", "free"); len(b) != 2 {
+		t.Fatalf("wildcard path: %v", names(b))
+	}
+	if b := r.ForUser("POST", "/api/jobs", "business"); len(b) != 1 {
+		t.Fatalf("business has no route limit: %v", names(b))
+	}
+	if _, err := Load(`{"routes":[{"paths":["/x"]}]}`, ""); err == nil {
+		t.Fatal("unnamed route rule accepted")
+	}
+	if _, err := Load("", "not-an-ip"); err == nil {
+		t.Fatal("invalid trusted proxy accepted")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r, err := Load("", "10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		remote, xff, want string
+	}{
+		{"203.0.113.5:1234", "1.2.3.4", "203.0.113.5"},                        // untrusted peer: header ignored
+		{"10.0.0.2:80", "198.51.100.7", "198.51.100.7"},                       // one trusted hop
+		{"10.0.0.2:80", "6.6.6.6, 198.51.100.7, 192.168.1.1", "198.51.100.7"}, // spoofed left entry skipped
+		{"10.0.0.2:80", "", "10.0.0.2"},
+		{"10.0.0.2:80", "garbage, 10.0.0.3", "10.0.0.3"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.remote
+		if c.xff != "" {
+			req.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if got := r.ClientIP(req); got != c.want {
+			t.Errorf("%s %q: got %s, want %s", c.remote, c.xff, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"flipo5/backend/internal/quota"
+)
+
+// Policy allows Limit requests per Window. Limit <= 0 means unlimited.
+type Policy struct {
+	Limit  int
+	Window time.Duration
+}
+
+type policyJSON struct {
+	Limit  int    `json:"limit"`
+	Window string `json:"window,omitempty"` // Go duration, default "1m"
+}
+
+func (p *Policy) UnmarshalJSON(b []byte) error {
+	var raw policyJSON
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	p.Limit, p.Window = raw.Limit, time.Minute
+	if raw.Window != "" {
+		d, err := time.ParseDuration(raw.Window)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid window %q", raw.Window)
+		}
+		p.Window = d
+	}
+	return nil
+}
+
+func (p Policy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(policyJSON{Limit: p.Limit, Window: p.Window.String()})
+}
+
+// interval is the time one request uses up, in microseconds.
+func (p Policy) interval() int64 {
+	iv := p.Window.Microseconds() / int64(p.Limit)
+	if iv < 1 {
+		iv = 1
+	}
+	return iv
+}
+
+// Header is the RateLimit-Policy value, e.g. "120;w=60".
+func (p Policy) Header() string {
+	return strconv.Itoa(p.Limit) + ";w=" + strconv.Itoa(int(p.Window.Seconds()))
+}
+
+// RouteRule is an extra bucket per user for some routes, on top of the general one. Routes of one rule
+// share the bucket. A path ending in "/*" matches everything below it.
+type RouteRule struct {
+	Name   string            `json:"name"`
+	Method string            `json:"method,omitempty"` // "" = any
+	Paths  []string          `json:"paths"`
+	Policy Policy            `json:"policy"`
+	Plans  map[string]Policy `json:"plans,omitempty"` // per-plan override of Policy
+}
+
+// Rules are the limiter configuration (RATE_LIMITS).
+type Rules struct {
+	User   Policy            `json:"user"`            // per user, all authenticated routes
+	Plans  map[string]Policy `json:"plans,omitempty"` // per-plan override of User
+	IP     Policy            `json:"ip"`              // per client IP, public routes
+	Routes []RouteRule       `json:"routes,omitempty"`
+	// TrustedProxies (IPs or CIDRs) may set X-Forwarded-For; the client IP is the first address from the
+	// right that is not a trusted proxy. Without it RemoteAddr is used.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	proxies []*net.IPNet
+}
+
+// Default reproduces the previous in-process limits: 2000/min per user, 120/min per IP, and 120/min for
+// SEO and translate jobs together.
+func Default() *Rules {
+	return &Rules{
+		User: Policy{Limit: 2000, Window: time.Minute},
+		IP:   Policy{Limit: 120, Window: time.Minute},
+		Routes: []RouteRule{
+			{Name: "text_jobs", Method: http.MethodPost, Paths: []string{"/api/seo", "/api/translate"}, Policy: Policy{Limit: 120, Window: time.Minute}},
+		},
+	}
+}
+
+// Load returns Default with the fields set in raw (JSON) replacing it; routes replace the default routes.
+// trustedProxies (comma-separated, TRUSTED_PROXIES) is added to the JSON list.
+func Load(raw, trustedProxies string) (*Rules, error) {
+	r := Default()
+	if strings.TrimSpace(raw) != "" {
+		defaults := r.Routes
+		r.Routes = nil // decoding into the default slice would merge into its elements
+		if err := json.Unmarshal([]byte(raw), r); err != nil {
+			return nil, err
+		}
+		if r.Routes == nil {
+			r.Routes = defaults
+		}
+	}
+	for _, p := range strings.Split(trustedProxies, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			r.TrustedProxies = append(r.TrustedProxies, p)
+		}
+	}
+	for _, p := range r.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %v", p, err)
+		}
+		r.proxies = append(r.proxies, n)
+	}
+	for i, rt := range r.Routes {
+		if rt.Name == "" {
+			return nil, fmt.Errorf("route rule %d needs a name", i)
+		}
+	}
+	return r, nil
+}
+
+// Bucket is one policy applied to a request, under a key naming the bucket.
+type Bucket struct {
+	Name   string
+	Policy Policy
+}
+
+// ForUser returns the buckets an authenticated request fills: the general user bucket and any route rules.
+func (r *Rules) ForUser(method, path, plan string) []Bucket {
+	plan = quota.PlanName(plan)
+	user := r.User
+	if p, ok := r.Plans[plan]; ok {
+		user = p
+	}
+	var out []Bucket
+	if user.Limit > 0 {
+		out = append(out, Bucket{Name: "user", Policy: user})
+	}
+	for _, rt := range r.Routes {
+		if !rt.matches(method, path) {
+			continue
+		}
+		p := rt.Policy
+		if pp, ok := rt.Plans[plan]; ok {
+			p = pp
+		}
+		if p.Limit > 0 {
+			out = append(out, Bucket{Name: "route:" + rt.Name, Policy: p})
+		}
+	}
+	return out
+}
+
+func (rt RouteRule) matches(method, path string) bool {
+	if rt.Method != "" && !strings.EqualFold(rt.Method, method) {
+		return false
+	}
+	for _, p := range rt.Paths {
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP is the address of the client: RemoteAddr, or when that is a trusted proxy, the right-most
+// X-Forwarded-For entry that is not a trusted proxy. Entries a client put there itself are never used.
+func (r *Rules) ClientIP(req *http.Request) string {
+	ip := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !r.trusted(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !r.trusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (r *Rules) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range r.proxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
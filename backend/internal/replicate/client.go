@@ -38,11 +38,12 @@ type Client struct {
 	token string
 }
 
-func New(token string) (*Client, error) {
+// New builds a client. Extra options (e.g. repgo.WithBaseURL for the replicatetest fake) are applied after the token.
+func New(token string, opts ...repgo.ClientOption) (*Client, error) {
 	if token == "" {
 		token = os.Getenv("REPLICATE_API_TOKEN")
 	}
-	cl, err := repgo.NewClient(repgo.WithToken(token))
+	cl, err := repgo.NewClient(append([]repgo.ClientOption{repgo.WithToken(token)}, opts...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -68,10 +69,29 @@ func (c *Client) CancelPrediction(ctx context.Context, id string) error {
 // CreatePredictionWithStream creates a prediction with stream=true and returns the prediction (with URLs.Stream).
 // Transparent retry (x2) on transient network errors like "unexpected EOF".
 func (c *Client) CreatePredictionWithStream(ctx context.Context, identifier string, input repgo.PredictionInput) (*repgo.Prediction, error) {
+	return retryTransient(ctx, func() (*repgo.Prediction, error) {
+		return c.client.CreatePrediction(ctx, identifier, input, nil, true)
+	})
+}
+
+// CreatePrediction starts a prediction and returns without waiting. With a webhook, Replicate POSTs the
+// prediction to it when done. identifier is "owner/name" (official model) or "owner/name:version".
+func (c *Client) CreatePrediction(ctx context.Context, identifier string, input repgo.PredictionInput, webhook *repgo.Webhook) (*repgo.Prediction, error) {
+	owner, rest, _ := strings.Cut(identifier, "/")
+	name, version, hasVersion := strings.Cut(rest, ":")
+	return retryTransient(ctx, func() (*repgo.Prediction, error) {
+		if hasVersion {
+			return c.client.CreatePrediction(ctx, version, input, webhook, false)
+		}
+		return c.client.CreatePredictionWithModel(ctx, owner, name, input, webhook, false)
+	})
+}
+
+func retryTransient(ctx context.Context, create func() (*repgo.Prediction, error)) (*repgo.Prediction, error) {
 	const maxAttempts = 3
 	var lastErr error
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		pred, err := c.client.CreatePrediction(ctx, identifier, input, nil, true)
+		pred, err := create()
 		if err == nil {
 			return pred, nil
 		}
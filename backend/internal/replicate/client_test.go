@@ -0,0 +1,110 @@
+package replicate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"flipo5/backend/internal/replicate/replicatetest"
+
+	repgo "github.com/replicate/replicate-go"
+)
+
+func newFakeClient(t *testing.T) (*Client, *replicatetest.Server) {
+	t.Helper()
+	fake := replicatetest.NewServer()
+	t.Cleanup(fake.Close)
+	c, err := New(replicatetest.Token, repgo.WithBaseURL(fake.URL))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	return c, fake
+}
+
+func TestRunAgainstFake(t *testing.T) {
+	c, fake := newFakeClient(t)
+	url := fake.AddFile("out.png", "image/png", []byte("png"))
+	fake.Handle("acme/image", replicatetest.Model{Output: []string{url}, Polls: 1})
+	fake.Handle("acme/broken", replicatetest.Model{Error: "CUDA out of memory"})
+
+	out, err := c.Run(context.Background(), "acme/image", repgo.PredictionInput{"prompt": "cat"})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	arr, ok := out.([]interface{})
+	if !ok || len(arr) != 1 || arr[0] != url {
+		t.Fatalf("unexpected output %#v", out)
+	}
+	if in := fake.Inputs("acme/image"); len(in) != 1 || in[0]["prompt"] != "cat" {
+		t.Fatalf("fake did not record input: %#v", in)
+	}
+
+	if _, err := c.Run(context.Background(), "acme/broken", repgo.PredictionInput{}); err == nil || !strings.Contains(err.Error(), "CUDA out of memory") {
+		t.Fatalf("expected model error, got %v", err)
+	}
+}
+
+func TestStreamOutputAgainstFake(t *testing.T) {
+	c, fake := newFakeClient(t)
+	fake.Handle("acme/llm", replicatetest.Model{Chunks: []string{"Hel", "lo\nworld"}, Output: []string{"Hel", "lo\nworld"}})
+	fake.Handle("acme/cut", replicatetest.Model{Chunks: []string{"a", "b", "c"}, Truncate: 1})
+
+	pred, err := c.CreatePredictionWithStream(context.Background(), "acme/llm", repgo.PredictionInput{"prompt": "hi"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	var acc strings.Builder
+	done := false
+	if err := c.StreamOutput(context.Background(), pred.URLs["stream"], func(s string) { acc.WriteString(s) }, func() { done = true }); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if acc.String() != "Hello\nworld" || !done {
+		t.Fatalf("stream got %q done=%v", acc.String(), done)
+	}
+
+	pred, err = c.CreatePredictionWithStream(context.Background(), "acme/cut", repgo.PredictionInput{})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	acc.Reset()
+	done = false
+	_ = c.StreamOutput(context.Background(), pred.URLs["stream"], func(s string) { acc.WriteString(s) }, func() { done = true })
+	if acc.String() != "a" || !done {
+		t.Fatalf("truncated stream got %q done=%v", acc.String(), done)
+	}
+}
+
+func TestCancelAgainstFake(t *testing.T) {
+	c, fake := newFakeClient(t)
+	fake.Handle("acme/slow", replicatetest.Model{Hang: true, Chunks: []string{"partial"}})
+
+	pred, err := c.CreatePredictionWithStream(context.Background(), "acme/slow", repgo.PredictionInput{})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	streamDone := make(chan string, 1)
+	go func() {
+		var acc strings.Builder
+		_ = c.StreamOutput(context.Background(), pred.URLs["stream"], func(s string) { acc.WriteString(s) }, nil)
+		streamDone <- acc.String()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if st, _ := c.GetPrediction(context.Background(), pred.ID); st.Status != "processing" {
+		t.Fatalf("expected processing, got %s", st.Status)
+	}
+	if err := c.CancelPrediction(context.Background(), pred.ID); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	select {
+	case got := <-streamDone:
+		if got != "partial" {
+			t.Fatalf("unexpected stream output %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not end after cancel")
+	}
+	if fake.Status(pred.ID) != "canceled" || len(fake.Canceled()) != 1 {
+		t.Fatalf("fake did not record cancel")
+	}
+}
@@ -0,0 +1,79 @@
+package replicate
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Progress is how far a prediction is, read from its logs. What the logs do not say is zero.
+type Progress struct {
+	Percent    float64 `json:"percent,omitempty"`
+	Step       int     `json:"step,omitempty"`
+	Steps      int     `json:"steps,omitempty"`
+	ETASeconds int     `json:"eta_seconds,omitempty"`
+}
+
+var (
+	// tqdm bars, as most diffusion and video models print: " 45%|████▌     | 23/50 [00:12<00:14,  1.85it/s]"
+	tqdmRe    = regexp.MustCompile(`(\d{1,3})%\|[^|]*\|\s*(\d+)/(\d+)(?:\s*\[[^<\]]*<([\d:]+))?`)
+	percentRe = regexp.MustCompile(`(\d{1,3}(?:\.\d+)?)\s*%`)
+	stepRe    = regexp.MustCompile(`(?i)\bsteps?\s*:?\s*(\d+)\s*(?:/|of)\s*(\d+)`)
+	etaRe     = regexp.MustCompile(`(?i)\beta\b\s*:?\s*(\d+(?::\d{1,2}){0,2})`)
+)
+
+// ParseProgress reads the latest progress from prediction logs: the last line (lines also end at \r, as
+// progress bars redraw with it) with a tqdm bar, a percentage or "step N/M" / "step N of M", and an ETA
+// ("ETA 00:14", "eta: 14" seconds, or the remaining time of a tqdm bar). False when no line has any.
+func ParseProgress(logs string) (Progress, bool) {
+	lines := strings.FieldsFunc(logs, func(r rune) bool { return r == '\n' || r == '\r' })
+	for i := len(lines) - 1; i >= 0; i-- {
+		if p, ok := lineProgress(lines[i]); ok {
+			return p, true
+		}
+	}
+	return Progress{}, false
+}
+
+func lineProgress(line string) (Progress, bool) {
+	var p Progress
+	if m := tqdmRe.FindStringSubmatch(line); m != nil {
+		p.Percent, _ = strconv.ParseFloat(m[1], 64)
+		p.Step, _ = strconv.Atoi(m[2])
+		p.Steps, _ = strconv.Atoi(m[3])
+		p.ETASeconds = clockSeconds(m[4])
+	} else {
+		if all := percentRe.FindAllStringSubmatch(line, -1); all != nil {
+			p.Percent, _ = strconv.ParseFloat(all[len(all)-1][1], 64)
+		}
+		if m := stepRe.FindStringSubmatch(line); m != nil {
+			p.Step, _ = strconv.Atoi(m[1])
+			p.Steps, _ = strconv.Atoi(m[2])
+		}
+		if m := etaRe.FindStringSubmatch(line); m != nil {
+			p.ETASeconds = clockSeconds(m[1])
+		}
+	}
+	if p.Steps <= 0 || p.Step > p.Steps {
+		p.Step, p.Steps = 0, 0
+	}
+	if p.Percent == 0 && p.Steps > 0 {
+		p.Percent = 100 * float64(p.Step) / float64(p.Steps)
+	}
+	p.Percent = math.Round(math.Min(p.Percent, 100)*10) / 10
+	return p, p.Percent > 0 || p.Steps > 0
+}
+
+// clockSeconds converts "14", "01:02" or "1:02:03" to seconds.
+func clockSeconds(s string) int {
+	total := 0
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		total = total*60 + n
+	}
+	return total
+}
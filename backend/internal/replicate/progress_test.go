@@ -0,0 +1,25 @@
+package replicate
+
+import "testing"
+
+func TestParseProgress(t *testing.T) {
+	for logs, want := range map[string]Progress{
+		"Loading model\n 10%|█         | 5/50 [00:02<00:18,  2.50it/s]\r 46%|████▌     | 23/50 [00:12<00:14,  1.85it/s]": {Percent: 46, Step: 23, Steps: 50, ETASeconds: 14},
+		"100%|██████████| 50/50 [01:02<00:00,  0.80it/s]\nSaving video":                                                  {Percent: 100, Step: 50, Steps: 50},
+		"Generating frames: step 12 of 48, ETA 1:05:00":                                                                  {Percent: 25, Step: 12, Steps: 48, ETASeconds: 3900},
+		"Step 3/4":                              {Percent: 75, Step: 3, Steps: 4},
+		"progress: 33.33% eta: 20":              {Percent: 33.3, ETASeconds: 20},
+		"Upscaling tile 2 ... 150%":             {Percent: 100},
+		" 0%|          | 0/25 [00:00<?, ?it/s]": {Steps: 25},
+	} {
+		got, ok := ParseProgress(logs)
+		if !ok || got != want {
+			t.Errorf("ParseProgress(%q) = %+v, %v; want %+v", logs, got, ok, want)
+		}
+	}
+	for _, logs := range []string{"", "Loading weights\nUsing seed 1234", "step 5/0"} {
+		if got, ok := ParseProgress(logs); ok {
+			t.Errorf("ParseProgress(%q) = %+v, want none", logs, got)
+		}
+	}
+}
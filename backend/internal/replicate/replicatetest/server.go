@@ -0,0 +1,384 @@
+// Package replicatetest provides an in-process fake of the Replicate predictions API
+// (create, get, cancel, SSE stream URLs, file URLs) for hermetic tests.
+//
+//	fake := replicatetest.NewServer()
+//	defer fake.Close()
+//	fake.Handle("meta/llama", replicatetest.Model{Output: "hi"})
+//	repl, _ := replicate.New(replicatetest.Token, repgo.WithBaseURL(fake.URL))
+package replicatetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is accepted by the fake; any non-empty bearer token works.
+const Token = "r8_test_token"
+
+// Model scripts how predictions for one model identifier behave.
+type Model struct {
+	Output      interface{} // final output on success (string, []string of URLs, ...)
+	Chunks      []string    // SSE "output" events; defaults to Output when it is a string
+	Error       string      // prediction fails with this error (stream sends an "error" event)
+	Canceled    bool        // prediction ends as canceled, e.g. cancelled from the Replicate dashboard
+	Hang        bool        // stays "processing" until cancelled; the stream stays open too
+	Polls       int         // GETs that still report "processing" before the final state
+	Truncate    int         // stream closes after N output events without "done" (0 = full stream)
+	Logs        string
+	PredictTime float64 // seconds, reported in metrics
+}
+
+type prediction struct {
+	id       string
+	model    string
+	input    map[string]interface{}
+	behavior Model
+	status   string
+	polls    int
+	stream   bool
+	canceled chan struct{}
+	created  time.Time
+}
+
+type file struct {
+	contentType string
+	body        []byte
+}
+
+// Server is the fake API. Its zero value is not usable; call NewServer.
+type Server struct {
+	URL string
+
+	srv    *httptest.Server
+	mu     sync.Mutex
+	models map[string]Model
+	preds  map[string]*prediction
+	order  []string
+	files  map[string]file
+	seq    int
+}
+
+func NewServer() *Server {
+	s := &Server{models: map[string]Model{}, preds: map[string]*prediction{}, files: map[string]file{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/predictions", s.auth(s.createPrediction))
+	mux.HandleFunc("/models/", s.auth(s.createModelPrediction))
+	mux.HandleFunc("/predictions/", s.auth(s.prediction))
+	mux.HandleFunc("/stream/", s.stream)
+	mux.HandleFunc("/files/", s.file)
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+func (s *Server) Close() { s.srv.Close() }
+
+// Handle registers behaviour for a model identifier ("owner/name" or "owner/name:version").
+func (s *Server) Handle(model string, m Model) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models[model] = m
+}
+
+// AddFile serves body at a stable URL, like replicate.delivery output files.
+func (s *Server) AddFile(name, contentType string, body []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = file{contentType: contentType, body: body}
+	return s.URL + "/files/" + name
+}
+
+// Inputs returns the inputs of every prediction created for model, oldest first.
+func (s *Server) Inputs(model string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []map[string]interface{}
+	for _, id := range s.order {
+		if p := s.preds[id]; p.model == model {
+			out = append(out, p.input)
+		}
+	}
+	return out
+}
+
+// Status returns the current status of a prediction ("" if unknown).
+func (s *Server) Status(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p := s.preds[id]; p != nil {
+		return p.status
+	}
+	return ""
+}
+
+// Canceled returns the IDs of predictions cancelled through the API.
+func (s *Server) Canceled() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, id := range s.order {
+		if p := s.preds[id]; p.status == "canceled" && !p.behavior.Canceled {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer")) == "" {
+			writeError(w, http.StatusUnauthorized, "Unauthenticated")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": code, "detail": detail, "title": http.StatusText(code)})
+}
+
+// lookup matches "owner/name", "owner/name:version" or a bare version hash.
+func (s *Server) lookup(key string) (string, Model, bool) {
+	if m, ok := s.models[key]; ok {
+		return key, m, true
+	}
+	if i := strings.Index(key, ":"); i > 0 {
+		if m, ok := s.models[key[:i]]; ok {
+			return key[:i], m, true
+		}
+	}
+	for id, m := range s.models {
+		if strings.HasSuffix(id, ":"+key) {
+			return id, m, true
+		}
+	}
+	return "", Model{}, false
+}
+
+type createBody struct {
+	Version string                 `json:"version"`
+	Input   map[string]interface{} `json:"input"`
+	Stream  bool                   `json:"stream"`
+}
+
+func (s *Server) createPrediction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var body createBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	s.create(w, body.Version, body)
+}
+
+// createModelPrediction handles POST /models/{owner}/{name}/predictions (official models).
+func (s *Server) createModelPrediction(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/models/"), "/"), "/")
+	if r.Method != http.MethodPost || len(parts) != 3 || parts[2] != "predictions" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	var body createBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	s.create(w, parts[0]+"/"+parts[1], body)
+}
+
+func (s *Server) create(w http.ResponseWriter, key string, body createBody) {
+	s.mu.Lock()
+	model, m, ok := s.lookup(key)
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "model "+key+" not found")
+		return
+	}
+	s.seq++
+	p := &prediction{
+		id:       fmt.Sprintf("fake%06d", s.seq),
+		model:    model,
+		input:    body.Input,
+		behavior: m,
+		status:   "starting",
+		stream:   body.Stream,
+		canceled: make(chan struct{}),
+		created:  time.Now().UTC(),
+	}
+	if m.Polls == 0 && !m.Hang {
+		p.status = finalStatus(m)
+	}
+	s.preds[p.id] = p
+	s.order = append(s.order, p.id)
+	out := s.render(p)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(out)
+}
+
+func finalStatus(m Model) string {
+	switch {
+	case m.Error != "":
+		return "failed"
+	case m.Canceled:
+		return "canceled"
+	}
+	return "succeeded"
+}
+
+// prediction handles GET /predictions/{id} and POST /predictions/{id}/cancel.
+func (s *Server) prediction(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/predictions/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	s.mu.Lock()
+	p := s.preds[id]
+	if p == nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "prediction not found")
+		return
+	}
+	switch {
+	case action == "cancel" && r.Method == http.MethodPost:
+		if p.status == "starting" || p.status == "processing" {
+			p.status = "canceled"
+			close(p.canceled)
+		}
+	case action == "" && r.Method == http.MethodGet:
+		if p.status == "starting" || p.status == "processing" {
+			p.status = "processing"
+			if !p.behavior.Hang {
+				p.polls++
+				if p.polls > p.behavior.Polls {
+					p.status = finalStatus(p.behavior)
+				}
+			}
+		}
+	default:
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	out := s.render(p)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) render(p *prediction) map[string]interface{} {
+	urls := map[string]string{
+		"get":    s.URL + "/predictions/" + p.id,
+		"cancel": s.URL + "/predictions/" + p.id + "/cancel",
+	}
+	if p.stream {
+		urls["stream"] = s.URL + "/stream/" + p.id
+	}
+	out := map[string]interface{}{
+		"id":         p.id,
+		"model":      p.model,
+		"version":    "fakeversion",
+		"status":     p.status,
+		"input":      p.input,
+		"urls":       urls,
+		"created_at": p.created.Format(time.RFC3339Nano),
+	}
+	if p.behavior.Logs != "" {
+		out["logs"] = p.behavior.Logs
+	}
+	if p.status != "starting" {
+		out["started_at"] = p.created.Format(time.RFC3339Nano)
+	}
+	switch p.status {
+	case "succeeded":
+		out["output"] = p.behavior.Output
+	case "failed":
+		out["error"] = p.behavior.Error
+	}
+	if p.status == "succeeded" || p.status == "failed" || p.status == "canceled" {
+		out["completed_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+		out["metrics"] = map[string]interface{}{"predict_time": p.behavior.PredictTime}
+	}
+	return out
+}
+
+// stream serves GET /stream/{id} as Server-Sent Events: output*, then done or error.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/stream/"), "/")
+	s.mu.Lock()
+	p := s.preds[id]
+	s.mu.Unlock()
+	if p == nil || !p.stream {
+		writeError(w, http.StatusNotFound, "stream not found")
+		return
+	}
+	m := p.behavior
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	send := func(event, id, data string) {
+		fmt.Fprintf(w, "event: %s\n", event)
+		if id != "" {
+			fmt.Fprintf(w, "id: %s\n", id)
+		}
+		for _, line := range strings.Split(data, "\n") {
+			fmt.Fprintf(w, "data: %s\n", line)
+		}
+		fmt.Fprint(w, "\n")
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	chunks := m.Chunks
+	if chunks == nil {
+		if str, ok := m.Output.(string); ok && m.Error == "" {
+			chunks = []string{str}
+		}
+	}
+	for i, c := range chunks {
+		if m.Truncate > 0 && i >= m.Truncate {
+			return
+		}
+		send("output", fmt.Sprint(i+1), c)
+	}
+	if m.Hang {
+		select {
+		case <-p.canceled:
+			send("done", "", `{"reason":"canceled"}`)
+		case <-r.Context().Done():
+		}
+		return
+	}
+	switch {
+	case m.Error != "":
+		send("error", "", fmt.Sprintf(`{"detail":%q}`, m.Error))
+	case m.Canceled:
+		send("done", "", `{"reason":"canceled"}`)
+	default:
+		send("done", "", "{}")
+	}
+}
+
+func (s *Server) file(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/files/")
+	s.mu.Lock()
+	f, ok := s.files[name]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	w.Write(f.body)
+}
@@ -0,0 +1,56 @@
+package replicate
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookTolerance is how far a webhook timestamp may be from now before it is rejected as a replay.
+const WebhookTolerance = 5 * time.Minute
+
+var ErrInvalidWebhook = errors.New("replicate: invalid webhook signature")
+
+// VerifyWebhook checks a Replicate webhook request. Replicate signs with the Standard Webhooks scheme:
+// HMAC-SHA256 over "<webhook-id>.<webhook-timestamp>.<body>" keyed with the base64 part of the
+// "whsec_..." secret (GET /v1/webhooks/default/secret), sent as "v1,<base64>" entries in webhook-signature.
+func VerifyWebhook(secret string, header http.Header, body []byte, now time.Time) error {
+	id := header.Get("webhook-id")
+	ts := header.Get("webhook-timestamp")
+	sigs := header.Get("webhook-signature")
+	if secret == "" || id == "" || ts == "" || sigs == "" {
+		return ErrInvalidWebhook
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidWebhook
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > WebhookTolerance || d < -WebhookTolerance {
+		return ErrInvalidWebhook
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return ErrInvalidWebhook
+	}
+	expected := WebhookSignature(key, id, ts, body)
+	for _, s := range strings.Fields(sigs) {
+		version, sig, _ := strings.Cut(s, ",")
+		if version == "v1" && hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidWebhook
+}
+
+// WebhookSignature returns the base64 v1 signature for a webhook delivery.
+func WebhookSignature(key []byte, id, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,41 @@
+package replicate
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	secret := "whsec_" + base64.StdEncoding.EncodeToString(key)
+	body := []byte(`{"id":"p1","status":"succeeded"}`)
+	now := time.Unix(1_700_000_000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	signed := func(sig string) http.Header {
+		h := http.Header{}
+		h.Set("webhook-id", "msg_1")
+		h.Set("webhook-timestamp", ts)
+		h.Set("webhook-signature", sig)
+		return h
+	}
+	good := "v1," + WebhookSignature(key, "msg_1", ts, body)
+
+	if err := VerifyWebhook(secret, signed(good), body, now); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := VerifyWebhook(secret, signed("v1,bogus "+good), body, now); err != nil {
+		t.Fatalf("signature list (key rotation) rejected: %v", err)
+	}
+	if err := VerifyWebhook(secret, signed(good), []byte(`{"id":"p1","status":"failed"}`), now); err != ErrInvalidWebhook {
+		t.Fatalf("tampered body accepted")
+	}
+	if err := VerifyWebhook(secret, signed(good), body, now.Add(WebhookTolerance+time.Second)); err != ErrInvalidWebhook {
+		t.Fatalf("stale timestamp accepted")
+	}
+	if err := VerifyWebhook("", signed(good), body, now); err != ErrInvalidWebhook {
+		t.Fatalf("empty secret accepted")
+	}
+}
@@ -104,3 +104,17 @@ func (s *Store) URL(key string) string {
 	}
 	return key
 }
+
+// Key is the inverse of URL: the storage key of an uploads/ key or of a URL under PublicBaseURL, "" otherwise.
+func (s *Store) Key(rawURL string) string {
+	if strings.HasPrefix(rawURL, "uploads/") {
+		return rawURL
+	}
+	if s == nil || s.publicBaseURL == "" {
+		return ""
+	}
+	if key, ok := strings.CutPrefix(rawURL, s.publicBaseURL+"/"); ok {
+		return key
+	}
+	return ""
+}
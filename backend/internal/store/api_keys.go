@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// APIKey is a personal API key as listed to its owner; the key itself is only returned at creation.
+type APIKey struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  string    `json:"created_at"`
+	LastUsedAt *string   `json:"last_used_at,omitempty"`
+}
+
+// APIKeyOwner is what the auth middleware needs from a valid key.
+type APIKeyOwner struct {
+	KeyID  uuid.UUID
+	UserID uuid.UUID
+	Email  string
+	Plan   string
+	Scopes []string
+}
+
+func (db *DB) CreateAPIKey(ctx context.Context, userID uuid.UUID, name, prefix, keyHash string, scopes []string) (*APIKey, error) {
+	k := APIKey{Name: name, Prefix: prefix, Scopes: scopes}
+	err := db.Pool.QueryRow(ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes) VALUES ($1,$2,$3,$4,$5) RETURNING id, created_at::text`,
+		userID, name, prefix, keyHash, scopes).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ListAPIKeys returns the user's active (not revoked) keys, newest first.
+func (db *DB) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]APIKey, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id, name, prefix, scopes, created_at::text, last_used_at::text FROM api_keys
+		 WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []APIKey
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, k)
+	}
+	return list, rows.Err()
+}
+
+func (db *DB) CountAPIKeys(ctx context.Context, userID uuid.UUID) (int, error) {
+	var n int
+	err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+// RevokeAPIKey disables the key immediately. Returns pgx.ErrNoRows if the user has no such active key.
+func (db *DB) RevokeAPIKey(ctx context.Context, keyID, userID uuid.UUID) error {
+	result, err := db.Pool.Exec(ctx,
+		`UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, keyID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// APIKeyOwnerByHash resolves an active key; nil when unknown or revoked. last_used_at is refreshed at most once a minute.
+func (db *DB) APIKeyOwnerByHash(ctx context.Context, keyHash string) (*APIKeyOwner, error) {
+	var o APIKeyOwner
+	err := db.Pool.QueryRow(ctx,
+		`SELECT k.id, k.user_id, u.email, COALESCE(u.plan, ''), k.scopes FROM api_keys k JOIN users u ON u.id = k.user_id
+		 WHERE k.key_hash = $1 AND k.revoked_at IS NULL`, keyHash).
+		Scan(&o.KeyID, &o.UserID, &o.Email, &o.Plan, &o.Scopes)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, _ = db.Pool.Exec(ctx,
+		`UPDATE api_keys SET last_used_at = NOW() WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, o.KeyID)
+	return &o, nil
+}
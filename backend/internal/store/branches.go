@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// activeJobSQL is the last job of thread t's active branch: active_job_id, or the newest job when it was
+// never set or its job was deleted.
+const activeJobSQL = `COALESCE(t.active_job_id, (SELECT id FROM jobs WHERE thread_id = t.id ORDER BY created_at DESC, id DESC LIMIT 1))`
+
+// jobColumns are the Job fields in Scan order, for queries over jobs aliased j.
+const jobColumns = `j.id, j.user_id, j.thread_id, j.type, j.status, j.name, j.input, j.output, j.error, j.cost_cents, j.replicate_id, j.rating, j.created_at::text, j.updated_at::text`
+
+// ThreadJob is a job on a thread's active branch. SiblingIDs are the alternatives at its place (jobs with
+// the same parent, itself included, oldest first) and SiblingIndex is its position among them.
+type ThreadJob struct {
+	Job
+	ParentJobID  *uuid.UUID  `json:"parent_job_id,omitempty"`
+	SiblingCount int         `json:"sibling_count"`
+	SiblingIndex int         `json:"sibling_index"`
+	SiblingIDs   []uuid.UUID `json:"sibling_ids"`
+}
+
+// ListThreadBranch returns the jobs of the thread's active branch, first message first.
+func (db *DB) ListThreadBranch(ctx context.Context, threadID, userID uuid.UUID) ([]ThreadJob, error) {
+	rows, err := db.Pool.Query(ctx,
+		`WITH RECURSIVE branch AS (
+		     SELECT j.id, j.parent_job_id, 0 AS depth FROM jobs j
+		     JOIN threads t ON t.id = j.thread_id
+		     WHERE t.id = $1 AND t.user_id = $2 AND j.id = `+activeJobSQL+`
+		   UNION ALL
+		     SELECT p.id, p.parent_job_id, b.depth + 1 FROM jobs p JOIN branch b ON p.id = b.parent_job_id
+		 )
+		 SELECT `+jobColumns+`, j.parent_job_id,
+		   ARRAY(SELECT s.id FROM jobs s WHERE s.thread_id = $1 AND s.parent_job_id IS NOT DISTINCT FROM j.parent_job_id
+		         ORDER BY s.created_at, s.id)
+		 FROM branch b JOIN jobs j ON j.id = b.id
+		 ORDER BY b.depth DESC`, threadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []ThreadJob
+	for rows.Next() {
+		var j ThreadJob
+		if err := rows.Scan(&j.ID, &j.UserID, &j.ThreadID, &j.Type, &j.Status, &j.Name, &j.Input, &j.Output, &j.Error, &j.CostCents, &j.ReplicateID, &j.Rating, &j.CreatedAt, &j.UpdatedAt,
+			&j.ParentJobID, &j.SiblingIDs); err != nil {
+			return nil, err
+		}
+		j.SiblingCount = len(j.SiblingIDs)
+		for i, id := range j.SiblingIDs {
+			if id == j.ID {
+				j.SiblingIndex = i
+			}
+		}
+		list = append(list, j)
+	}
+	return list, rows.Err()
+}
+
+// ListJobAncestors returns the branch that leads to a job: its ancestors, first message first, then the job.
+func (db *DB) ListJobAncestors(ctx context.Context, jobID, userID uuid.UUID) ([]Job, error) {
+	rows, err := db.Pool.Query(ctx,
+		`WITH RECURSIVE branch AS (
+		     SELECT id, parent_job_id, 0 AS depth FROM jobs WHERE id = $1 AND user_id = $2
+		   UNION ALL
+		     SELECT p.id, p.parent_job_id, b.depth + 1 FROM jobs p JOIN branch b ON p.id = b.parent_job_id
+		 )
+		 SELECT `+jobColumns+` FROM branch b JOIN jobs j ON j.id = b.id
+		 ORDER BY b.depth DESC`, jobID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Job
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(&j.ID, &j.UserID, &j.ThreadID, &j.Type, &j.Status, &j.Name, &j.Input, &j.Output, &j.Error, &j.CostCents, &j.ReplicateID, &j.Rating, &j.CreatedAt, &j.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, j)
+	}
+	return list, rows.Err()
+}
+
+// SelectThreadBranch makes the branch through jobID active, down to its newest last message (following the
+// newest reply at every step). Returns pgx.ErrNoRows when the job is not in the user's thread.
+func (db *DB) SelectThreadBranch(ctx context.Context, threadID, userID, jobID uuid.UUID) (uuid.UUID, error) {
+	var ok bool
+	if err := db.Pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM jobs j JOIN threads t ON t.id = j.thread_id WHERE j.id = $1 AND t.id = $2 AND t.user_id = $3)`,
+		jobID, threadID, userID).Scan(&ok); err != nil {
+		return uuid.Nil, err
+	}
+	if !ok {
+		return uuid.Nil, pgx.ErrNoRows
+	}
+	leaf := jobID
+	for {
+		var child uuid.UUID
+		err := db.Pool.QueryRow(ctx,
+			`SELECT id FROM jobs WHERE parent_job_id = $1 AND thread_id = $2 ORDER BY created_at DESC, id DESC LIMIT 1`, leaf, threadID).Scan(&child)
+		if err == pgx.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return uuid.Nil, err
+		}
+		leaf = child
+	}
+	_, err := db.Pool.Exec(ctx, `UPDATE threads SET active_job_id = $2, updated_at = NOW() WHERE id = $1`, threadID, leaf)
+	return leaf, err
+}
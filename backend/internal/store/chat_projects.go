@@ -245,6 +245,24 @@ func (db *DB) ListChatProjectFiles(ctx context.Context, projectID, userID uuid.U
 	return list, rows.Err()
 }
 
+// GetChatProjectFile returns a project file of the user, or nil when there is none.
+func (db *DB) GetChatProjectFile(ctx context.Context, fileID, userID uuid.UUID) (*ChatProjectFile, error) {
+	var f ChatProjectFile
+	err := db.Pool.QueryRow(ctx,
+		`SELECT f.id, f.project_id, f.file_url, COALESCE(f.file_name, ''), COALESCE(f.content_type, ''), f.size_bytes, f.created_at::text
+		 FROM chat_project_files f
+		 JOIN chat_projects p ON p.id = f.project_id
+		 WHERE f.id = $1 AND p.user_id = $2`, fileID, userID).
+		Scan(&f.ID, &f.ProjectID, &f.FileURL, &f.FileName, &f.ContentType, &f.SizeBytes, &f.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
 func (db *DB) DeleteChatProjectFile(ctx context.Context, fileID, userID uuid.UUID) error {
 	res, err := db.Pool.Exec(ctx,
 		`DELETE FROM chat_project_files WHERE id = $1 AND project_id IN (SELECT id FROM chat_projects WHERE user_id = $2)`,
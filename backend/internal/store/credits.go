@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrInsufficientCredits is returned by CreateJobReserved when the balance does not cover the job's hold.
+var ErrInsufficientCredits = errors.New("insufficient credits")
+
+// cost_ledger.kind values. Amounts are positive for money spent or held, negative for money returned or added.
+const (
+	LedgerReserve = "reserve" // hold taken at job creation (+)
+	LedgerRelease = "release" // hold returned when the job completes (-), followed by the charge
+	LedgerCharge  = "charge"  // actual cost of a job (a failed one's work before the failure) or of a thread summary (+)
+	LedgerRefund  = "refund"  // hold returned when the job failed or was cancelled (-)
+	LedgerGrant   = "grant"   // credits added by an admin or a purchase (-)
+)
+
+type LedgerEntry struct {
+	ID          uuid.UUID  `json:"id"`
+	JobID       *uuid.UUID `json:"job_id,omitempty"`
+	Kind        string     `json:"kind"`
+	AmountCents int        `json:"amount_cents"`
+	CreatedAt   string     `json:"created_at"`
+}
+
+func isTerminalStatus(status string) bool {
+	return status == "completed" || status == "failed" || status == "cancelled"
+}
+
+// reserveCredits takes cents from the balance only if it covers them.
+func reserveCredits(ctx context.Context, tx pgx.Tx, userID uuid.UUID, cents int) error {
+	var balance int
+	err := tx.QueryRow(ctx,
+		`UPDATE users SET credit_cents = credit_cents - $2 WHERE id = $1 AND credit_cents >= $2 RETURNING credit_cents`,
+		userID, cents).Scan(&balance)
+	if err == pgx.ErrNoRows {
+		return ErrInsufficientCredits
+	}
+	return err
+}
+
+func addLedger(ctx context.Context, tx pgx.Tx, userID uuid.UUID, jobID *uuid.UUID, cents int, kind string) error {
+	_, err := tx.Exec(ctx, `INSERT INTO cost_ledger (user_id, job_id, amount_cents, kind) VALUES ($1,$2,$3,$4)`,
+		userID, jobID, cents, kind)
+	return err
+}
+
+// settleJobCredits runs once per job (jobs.billed): returns the creation hold, charges costCents unless the job
+// was cancelled and writes both to the ledger. Failed jobs pass 0 except for the work done before the failure
+// (e.g. the earlier passes of a chat reply). The balance may go below zero when the cost exceeds the hold;
+// the next reservation then fails until credits are added. Reports whether this call settled the job.
+func settleJobCredits(ctx context.Context, tx pgx.Tx, jobID uuid.UUID, status string, costCents int) (bool, error) {
+	var userID uuid.UUID
+	var reserved int
+	err := tx.QueryRow(ctx, `UPDATE jobs SET billed = true WHERE id = $1 AND NOT billed RETURNING user_id, reserved_cents`, jobID).
+		Scan(&userID, &reserved)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if status == "cancelled" {
+		costCents = 0
+	}
+	if reserved > 0 {
+		kind := LedgerRefund
+		if status == "completed" {
+			kind = LedgerRelease
+		}
+		if err := addLedger(ctx, tx, userID, &jobID, -reserved, kind); err != nil {
+			return false, err
+		}
+	}
+	if costCents > 0 {
+		if err := addLedger(ctx, tx, userID, &jobID, costCents, LedgerCharge); err != nil {
+			return false, err
+		}
+	}
+	if reserved == 0 && costCents == 0 {
+		return true, nil
+	}
+	_, err = tx.Exec(ctx, `UPDATE users SET credit_cents = credit_cents + $2 - $3 WHERE id = $1`, userID, reserved, costCents)
+	return err == nil, err
+}
+
+func (db *DB) GetCreditBalance(ctx context.Context, userID uuid.UUID) (int, error) {
+	var cents int
+	err := db.Pool.QueryRow(ctx, `SELECT credit_cents FROM users WHERE id = $1`, userID).Scan(&cents)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return cents, err
+}
+
+// GrantCredits adds cents to the user's balance (negative cents to correct a grant) and returns the new balance.
+func (db *DB) GrantCredits(ctx context.Context, userID uuid.UUID, cents int) (int, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	var balance int
+	err = tx.QueryRow(ctx, `UPDATE users SET credit_cents = credit_cents + $2 WHERE id = $1 RETURNING credit_cents`, userID, cents).Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+	if err := addLedger(ctx, tx, userID, nil, -cents, LedgerGrant); err != nil {
+		return 0, err
+	}
+	return balance, tx.Commit(ctx)
+}
+
+// ChargeCredits charges cents to the user for work outside a job's own billing (a thread summary), linked to
+// jobID when set. The balance may go below zero, as with job charges.
+func (db *DB) ChargeCredits(ctx context.Context, userID uuid.UUID, jobID *uuid.UUID, cents int) error {
+	if cents <= 0 {
+		return nil
+	}
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `UPDATE users SET credit_cents = credit_cents - $2 WHERE id = $1`, userID, cents); err != nil {
+		return err
+	}
+	if err := addLedger(ctx, tx, userID, jobID, cents, LedgerCharge); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ListLedger returns the user's most recent ledger entries, newest first.
+func (db *DB) ListLedger(ctx context.Context, userID uuid.UUID, limit int) ([]LedgerEntry, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id, job_id, kind, amount_cents, created_at::text FROM cost_ledger WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2`,
+		userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []LedgerEntry
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.ID, &e.JobID, &e.Kind, &e.AmountCents, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
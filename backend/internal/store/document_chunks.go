@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// DocumentChunk is one embedded piece of a chat project file.
+type DocumentChunk struct {
+	Index     int
+	Content   string
+	Tokens    int
+	Embedding []float32
+}
+
+// ChunkMatch is a chunk found by SearchDocumentChunks. Score is the cosine similarity to the query (1 = same direction).
+type ChunkMatch struct {
+	FileID   uuid.UUID
+	FileName string
+	Index    int
+	Content  string
+	Score    float64
+}
+
+// HasVectorIndex reports whether document_chunks exists (migration 023 skips it without pgvector).
+func (db *DB) HasVectorIndex(ctx context.Context) (bool, error) {
+	var ok bool
+	err := db.Pool.QueryRow(ctx, `SELECT to_regclass('document_chunks') IS NOT NULL`).Scan(&ok)
+	return ok, err
+}
+
+// IndexedProjectFiles returns the files of a project that have chunks embedded with model.
+func (db *DB) IndexedProjectFiles(ctx context.Context, projectID uuid.UUID, model string) (map[uuid.UUID]bool, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT DISTINCT file_id FROM document_chunks WHERE project_id = $1 AND model = $2`, projectID, model)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out[id] = true
+	}
+	return out, rows.Err()
+}
+
+// ReplaceDocumentChunks stores the chunks of a project file for model, replacing any from an earlier run.
+func (db *DB) ReplaceDocumentChunks(ctx context.Context, f ChatProjectFile, userID uuid.UUID, model string, chunks []DocumentChunk) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `DELETE FROM document_chunks WHERE file_id = $1 AND model = $2`, f.ID, model); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO document_chunks (project_id, file_id, user_id, model, chunk_index, content, tokens, embedding)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8::vector)`,
+			f.ProjectID, f.ID, userID, model, c.Index, c.Content, c.Tokens, vectorLiteral(c.Embedding))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// SearchDocumentChunks returns the k chunks of the project closest to vec (cosine distance), best first.
+func (db *DB) SearchDocumentChunks(ctx context.Context, projectID, userID uuid.UUID, model string, vec []float32, k int) ([]ChunkMatch, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT c.file_id, COALESCE(f.file_name, ''), c.chunk_index, c.content, 1 - (c.embedding <=> $4::vector)
+		 FROM document_chunks c
+		 JOIN chat_project_files f ON f.id = c.file_id
+		 WHERE c.project_id = $1 AND c.user_id = $2 AND c.model = $3
+		 ORDER BY c.embedding <=> $4::vector
+		 LIMIT $5`, projectID, userID, model, vectorLiteral(vec), k)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []ChunkMatch
+	for rows.Next() {
+		var m ChunkMatch
+		if err := rows.Scan(&m.FileID, &m.FileName, &m.Index, &m.Content, &m.Score); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+// vectorLiteral is the pgvector text form, "[0.1,0.2,...]"; no Go driver type needed.
+func vectorLiteral(v []float32) string {
+	var b strings.Builder
+	b.Grow(len(v) * 10)
+	b.WriteByte('[')
+	for i, x := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
@@ -0,0 +1,12 @@
+package store
+
+import "testing"
+
+func TestVectorLiteral(t *testing.T) {
+	if got := vectorLiteral([]float32{0.5, -1, 0, 1e-7}); got != "[0.5,-1,0,1e-07]" {
+		t.Fatalf("got %s", got)
+	}
+	if got := vectorLiteral(nil); got != "[]" {
+		t.Fatalf("got %s", got)
+	}
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// FileText is the cached text of an uploaded document. Error is set (and Content empty) when it could not be read.
+type FileText struct {
+	Key     string
+	Kind    string
+	Content string
+	Tokens  int
+	Error   string
+}
+
+// GetFileText returns the cached text for a storage key, or nil when the file was not extracted yet.
+func (db *DB) GetFileText(ctx context.Context, key string) (*FileText, error) {
+	f := FileText{Key: key}
+	err := db.Pool.QueryRow(ctx,
+		`SELECT kind, content, tokens, COALESCE(error, '') FROM file_texts WHERE file_key = $1`, key).
+		Scan(&f.Kind, &f.Content, &f.Tokens, &f.Error)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (db *DB) SaveFileText(ctx context.Context, userID uuid.UUID, f FileText) error {
+	var errMsg *string
+	if f.Error != "" {
+		errMsg = &f.Error
+	}
+	_, err := db.Pool.Exec(ctx,
+		`INSERT INTO file_texts (file_key, user_id, kind, content, tokens, error) VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (file_key) DO UPDATE SET kind = EXCLUDED.kind, content = EXCLUDED.content, tokens = EXCLUDED.tokens,
+		   error = EXCLUDED.error, created_at = NOW()`,
+		f.Key, userID, f.Kind, f.Content, f.Tokens, errMsg)
+	return err
+}
@@ -32,43 +32,112 @@ type Job struct {
 	CostCents   int             `json:"cost_cents"`
 	ReplicateID *string         `json:"replicate_id,omitempty"`
 	Rating      *string         `json:"rating,omitempty"`
+	Progress    json.RawMessage `json:"progress,omitempty"` // latest progress of a media job; only read by GetJob
 	CreatedAt   string          `json:"created_at"`
 	UpdatedAt   string          `json:"updated_at"`
 }
 
 func (db *DB) CreateJob(ctx context.Context, userID uuid.UUID, jobType string, input interface{}, threadID *uuid.UUID) (uuid.UUID, error) {
+	return db.CreateJobReserved(ctx, userID, jobType, input, threadID, 0)
+}
+
+// CreateJobReserved creates the job and, when reserveCents > 0, holds that much of the user's credit for it
+// in the same transaction. Returns ErrInsufficientCredits when the balance does not cover the hold.
+// The hold is settled by the job's terminal UpdateJobStatus / SetJobCancelled.
+// A thread job continues the thread's active branch and becomes its last message.
+func (db *DB) CreateJobReserved(ctx context.Context, userID uuid.UUID, jobType string, input interface{}, threadID *uuid.UUID, reserveCents int) (uuid.UUID, error) {
+	return db.createJob(ctx, userID, jobType, input, threadID, nil, nil, reserveCents)
+}
+
+// CreateAlternativeJobReserved is CreateJobReserved for a regenerated or edited message: the job gets the
+// same parent as sibling, in sibling's thread, and its new branch becomes the active one.
+func (db *DB) CreateAlternativeJobReserved(ctx context.Context, userID uuid.UUID, jobType string, input interface{}, sibling *Job, reserveCents int) (uuid.UUID, error) {
+	return db.createJob(ctx, userID, jobType, input, sibling.ThreadID, &sibling.ID, nil, reserveCents)
+}
+
+// CreateChildJobReserved is CreateJobReserved for a job another job started (an image a chat reply asked
+// for): it is not in a thread and its parent_job_id is parent.
+func (db *DB) CreateChildJobReserved(ctx context.Context, userID uuid.UUID, jobType string, input interface{}, parent uuid.UUID, reserveCents int) (uuid.UUID, error) {
+	return db.createJob(ctx, userID, jobType, input, nil, nil, &parent, reserveCents)
+}
+
+func (db *DB) createJob(ctx context.Context, userID uuid.UUID, jobType string, input interface{}, threadID, siblingOf, parentID *uuid.UUID, reserveCents int) (uuid.UUID, error) {
 	inBytes, _ := json.Marshal(input)
 	id := uuid.New()
-	var name *string
-	if jobType == "image" || jobType == "video" {
-		var prompt string
-		switch v := input.(type) {
-		case map[string]interface{}:
-			if p, ok := v["prompt"].(string); ok {
-				prompt = p
-			}
-		case map[string]string:
-			prompt = v["prompt"]
+	name := jobName(jobType, input)
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback(ctx)
+	if reserveCents > 0 {
+		if err := reserveCredits(ctx, tx, userID, reserveCents); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	if threadID != nil {
+		// Row lock: concurrent messages in one thread chain one after the other instead of forking.
+		var active *uuid.UUID
+		err := tx.QueryRow(ctx, `SELECT `+activeJobSQL+` FROM threads t WHERE t.id = $1 FOR UPDATE OF t`, *threadID).Scan(&active)
+		if err != nil && err != pgx.ErrNoRows {
+			return uuid.Nil, err
 		}
-		if prompt != "" {
-			n := firstNWords(prompt, 4)
-			if n != "" {
-				name = &n
+		parentID = active
+		if siblingOf != nil {
+			if err := tx.QueryRow(ctx, `SELECT parent_job_id FROM jobs WHERE id = $1 AND thread_id = $2`, *siblingOf, *threadID).Scan(&parentID); err != nil {
+				return uuid.Nil, err
 			}
 		}
 	}
-	_, err := db.Pool.Exec(ctx,
-		`INSERT INTO jobs (id, user_id, type, name, input, thread_id) VALUES ($1,$2,$3,$4,$5,$6)`,
-		id, userID, jobType, name, inBytes, threadID)
+	_, err = tx.Exec(ctx,
+		`INSERT INTO jobs (id, user_id, type, name, input, thread_id, reserved_cents, parent_job_id) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
+		id, userID, jobType, name, inBytes, threadID, reserveCents, parentID)
 	if err != nil {
 		return uuid.Nil, err
 	}
+	if threadID != nil {
+		if _, err := tx.Exec(ctx, `UPDATE threads SET active_job_id = $2 WHERE id = $1`, *threadID, id); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	if reserveCents > 0 {
+		if err := addLedger(ctx, tx, userID, &id, reserveCents, LedgerReserve); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, err
+	}
 	if threadID != nil {
 		_ = db.TouchThread(ctx, *threadID)
 	}
 	return id, nil
 }
 
+// jobName is the first words of the prompt for image/video jobs (shown in My collection).
+func jobName(jobType string, input interface{}) *string {
+	if jobType != "image" && jobType != "video" {
+		return nil
+	}
+	var prompt string
+	switch v := input.(type) {
+	case map[string]interface{}:
+		if p, ok := v["prompt"].(string); ok {
+			prompt = p
+		}
+	case map[string]string:
+		prompt = v["prompt"]
+	}
+	if prompt == "" {
+		return nil
+	}
+	n := firstNWords(prompt, 4)
+	if n == "" {
+		return nil
+	}
+	return &n
+}
+
 // CreateCompletedJobFromURL inserts a job with status=completed and output={ "output": url }
 // so it appears in ListContentJobs (my collection). jobType must be "image" or "video".
 func (db *DB) CreateCompletedJobFromURL(ctx context.Context, userID uuid.UUID, url string, jobType string) (uuid.UUID, error) {
@@ -93,9 +162,9 @@ func (db *DB) CreateCompletedJobFromURL(ctx context.Context, userID uuid.UUID, u
 func (db *DB) GetJob(ctx context.Context, id uuid.UUID) (*Job, error) {
 	var j Job
 	err := db.Pool.QueryRow(ctx,
-		`SELECT id, user_id, thread_id, type, status, name, input, output, error, cost_cents, replicate_id, rating, created_at::text, updated_at::text
+		`SELECT id, user_id, thread_id, type, status, name, input, output, error, cost_cents, replicate_id, rating, progress, created_at::text, updated_at::text
 		 FROM jobs WHERE id = $1`, id).
-		Scan(&j.ID, &j.UserID, &j.ThreadID, &j.Type, &j.Status, &j.Name, &j.Input, &j.Output, &j.Error, &j.CostCents, &j.ReplicateID, &j.Rating, &j.CreatedAt, &j.UpdatedAt)
+		Scan(&j.ID, &j.UserID, &j.ThreadID, &j.Type, &j.Status, &j.Name, &j.Input, &j.Output, &j.Error, &j.CostCents, &j.ReplicateID, &j.Rating, &j.Progress, &j.CreatedAt, &j.UpdatedAt)
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
@@ -113,7 +182,12 @@ func (db *DB) GetJobForUser(ctx context.Context, jobID, userID uuid.UUID) (*Job,
 	return j, nil
 }
 
-func (db *DB) UpdateJobStatus(ctx context.Context, id uuid.UUID, status string, output interface{}, jobErr string, costCents int, replicateID string) error {
+// UpdateJobStatus writes the job state of a pending or running job and reports whether it did. A job that
+// already ended (e.g. cancelled by the user, or finished by another worker) is left as it is and false is
+// returned, so a late result is neither stored nor delivered. A terminal status (completed/failed/cancelled)
+// also settles the job's credits in the same transaction, once: costCents is charged unless the job was
+// cancelled, the creation hold is returned, and the job.<status> webhook deliveries are queued.
+func (db *DB) UpdateJobStatus(ctx context.Context, id uuid.UUID, status string, output interface{}, jobErr string, costCents int, replicateID string) (bool, error) {
 	var outBytes []byte
 	if output != nil {
 		outBytes, _ = json.Marshal(output)
@@ -126,39 +200,53 @@ func (db *DB) UpdateJobStatus(ctx context.Context, id uuid.UUID, status string,
 	if replicateID != "" {
 		repID = &replicateID
 	}
-	// Optimistic locking: only update if current status allows transition
-	validTransitions := map[string][]string{
-		"pending": {"running", "failed", "cancelled"},
-		"running": {"completed", "failed", "cancelled"},
-	}
-	
-	// First try with status check for safety
-	result, err := db.Pool.Exec(ctx,
-		`UPDATE jobs SET status=$2, output=$3, error=$4, cost_cents=$5, replicate_id=$6, updated_at=NOW() 
-		 WHERE id=$1 AND (status = ANY($7) OR status = $2)`,
-		id, status, outBytes, errPtr, costCents, repID, validTransitions[status])
-	
+	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
-		return err
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// cost_cents is frozen once the job is billed.
+	result, err := tx.Exec(ctx,
+		`UPDATE jobs SET status=$2, output=$3, error=$4, cost_cents=CASE WHEN billed THEN cost_cents ELSE $5 END, replicate_id=$6, updated_at=NOW() 
+		 WHERE id=$1 AND status IN ('pending','running')`,
+		id, status, outBytes, errPtr, costCents, repID)
+	if err != nil {
+		return false, err
 	}
-	
-	// Check if update actually happened
 	if result.RowsAffected() == 0 {
-		// Fallback: force update (job may be in unexpected state)
-		_, err = db.Pool.Exec(ctx,
-			`UPDATE jobs SET status=$2, output=$3, error=$4, cost_cents=$5, replicate_id=$6, updated_at=NOW() WHERE id=$1`,
-			id, status, outBytes, errPtr, costCents, repID)
+		return false, nil
 	}
-	
-	return err
+	var deliveries []uuid.UUID
+	if isTerminalStatus(status) {
+		settled, err := settleJobCredits(ctx, tx, id, status, costCents)
+		if err != nil {
+			return false, err
+		}
+		if settled {
+			if deliveries, err = queueJobWebhooks(ctx, tx, id, status); err != nil {
+				return false, err
+			}
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	db.notifyWebhookDeliveries(deliveries)
+	return true, nil
 }
 
-// SetJobCancelled marks a job as cancelled. Only applies if current status is pending or running.
+// SetJobCancelled marks a job as cancelled, refunds its credit hold and queues job.cancelled webhooks. Only applies if current status is pending or running.
 func (db *DB) SetJobCancelled(ctx context.Context, id, userID uuid.UUID, errMsg string) error {
 	if errMsg == "" {
 		errMsg = "Cancelled by user"
 	}
-	result, err := db.Pool.Exec(ctx,
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	result, err := tx.Exec(ctx,
 		`UPDATE jobs SET status='cancelled', error=$2, updated_at=NOW() WHERE id=$1 AND user_id=$3 AND status IN ('pending','running')`,
 		id, errMsg, userID)
 	if err != nil {
@@ -167,6 +255,20 @@ func (db *DB) SetJobCancelled(ctx context.Context, id, userID uuid.UUID, errMsg
 	if result.RowsAffected() == 0 {
 		return fmt.Errorf("job not found or cannot be cancelled")
 	}
+	settled, err := settleJobCredits(ctx, tx, id, "cancelled", 0)
+	if err != nil {
+		return err
+	}
+	var deliveries []uuid.UUID
+	if settled {
+		if deliveries, err = queueJobWebhooks(ctx, tx, id, "cancelled"); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	db.notifyWebhookDeliveries(deliveries)
 	return nil
 }
 
@@ -177,6 +279,19 @@ func (db *DB) UpdateJobOutput(ctx context.Context, id uuid.UUID, output interfac
 	return err
 }
 
+// UpdateJobProgress stores the latest progress of a running job. Returns false when the job is not running or
+// the progress did not change, so callers only publish real changes.
+func (db *DB) UpdateJobProgress(ctx context.Context, id uuid.UUID, progress interface{}) (bool, error) {
+	b, _ := json.Marshal(progress)
+	tag, err := db.Pool.Exec(ctx,
+		`UPDATE jobs SET progress = $2, updated_at = NOW()
+		 WHERE id = $1 AND status = 'running' AND progress IS DISTINCT FROM $2::jsonb`, id, b)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 func (db *DB) ListJobs(ctx context.Context, userID uuid.UUID, limit int) ([]Job, error) {
 	if limit <= 0 {
 		limit = 20
@@ -266,6 +381,26 @@ func (db *DB) ThreadHasActiveJobs(ctx context.Context, threadID uuid.UUID) (bool
 	return true, nil
 }
 
+// ListActiveThreadJobIDs returns the IDs of the thread's pending and running jobs.
+func (db *DB) ListActiveThreadJobIDs(ctx context.Context, threadID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id FROM jobs WHERE thread_id = $1 AND status IN ('pending','running')`,
+		threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func (db *DB) ListJobsByThread(ctx context.Context, threadID, userID uuid.UUID) ([]Job, error) {
 	rows, err := db.Pool.Query(ctx,
 		`SELECT id, user_id, thread_id, type, status, name, input, output, error, cost_cents, replicate_id, rating, created_at::text, updated_at::text
@@ -286,6 +421,43 @@ func (db *DB) ListJobsByThread(ctx context.Context, threadID, userID uuid.UUID)
 	return list, rows.Err()
 }
 
+// GetJobByReplicateID returns the job that owns a prediction (nil if none).
+func (db *DB) GetJobByReplicateID(ctx context.Context, predictionID string) (*Job, error) {
+	var j Job
+	err := db.Pool.QueryRow(ctx,
+		`SELECT id, user_id, thread_id, type, status, name, input, output, error, cost_cents, replicate_id, rating, created_at::text, updated_at::text
+		 FROM jobs WHERE replicate_id = $1 ORDER BY created_at DESC LIMIT 1`, predictionID).
+		Scan(&j.ID, &j.UserID, &j.ThreadID, &j.Type, &j.Status, &j.Name, &j.Input, &j.Output, &j.Error, &j.CostCents, &j.ReplicateID, &j.Rating, &j.CreatedAt, &j.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return &j, err
+}
+
+// ListRunningPredictionJobs returns running jobs of the given types that have a prediction and no update for
+// minAgeSeconds. Used by the reconciler to finish jobs whose webhook never arrived.
+func (db *DB) ListRunningPredictionJobs(ctx context.Context, types []string, minAgeSeconds int) ([]Job, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id, user_id, thread_id, type, status, name, input, output, error, cost_cents, replicate_id, rating, created_at::text, updated_at::text
+		 FROM jobs WHERE status = 'running' AND replicate_id IS NOT NULL AND type = ANY($1)
+		 AND updated_at < NOW() - ($2 || ' seconds')::interval
+		 ORDER BY updated_at ASC LIMIT 100`,
+		types, fmt.Sprint(minAgeSeconds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Job
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(&j.ID, &j.UserID, &j.ThreadID, &j.Type, &j.Status, &j.Name, &j.Input, &j.Output, &j.Error, &j.CostCents, &j.ReplicateID, &j.Rating, &j.CreatedAt, &j.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, j)
+	}
+	return list, rows.Err()
+}
+
 // ListStalePendingJobs returns jobs in pending/running for longer than maxAgeMinutes. Used for cleanup.
 func (db *DB) ListStalePendingJobs(ctx context.Context, maxAgeMinutes int) ([]Job, error) {
 	rows, err := db.Pool.Query(ctx,
@@ -0,0 +1,277 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Schema migrations are the files migrations/NNN_name.sql. Each runs once, in version order, in its own
+// transaction, and is recorded in schema_migrations with the checksum of the file. A whole file is sent as
+// one simple-protocol query, so functions and DO blocks work. Applied files must not be edited: add a new one.
+//
+// Databases migrated by the old runner (every file on every boot) have no schema_migrations yet. They apply
+// every file once more on the first run, which is safe because all files are idempotent.
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockKey is the pg_advisory_lock key held while migrating, so replicas starting together
+// apply each file once.
+const migrationLockKey int64 = 0x666c69706f35 // "flipo5"
+
+// Migration states reported by MigrationStatus.
+const (
+	MigrationApplied = "applied"
+	MigrationPending = "pending"
+	MigrationFailed  = "failed"  // last attempt failed and was rolled back; retried by the next Migrate
+	MigrationChanged = "changed" // applied, but the file's checksum differs from the recorded one
+	MigrationMissing = "missing" // recorded in the database but no longer in the binary (e.g. an older build)
+)
+
+type Migration struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Checksum  string     `json:"checksum"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type migrationFile struct {
+	version  int
+	name     string
+	sql      string
+	checksum string
+}
+
+type migrationRecord struct {
+	name      string
+	checksum  string
+	status    string
+	error     string
+	appliedAt time.Time
+}
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'applied' CHECK (status IN ('applied', 'failed')),
+	error TEXT,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`
+
+// migrationFiles reads the embedded migrations sorted by version.
+func migrationFiles() ([]migrationFile, error) {
+	entries, err := migrationsFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	files := make([]migrationFile, 0, len(entries))
+	seen := map[int]string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version < 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a version, e.g. 022_name.sql", e.Name())
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, e.Name())
+		}
+		seen[version] = e.Name()
+		b, err := migrationsFS.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		files = append(files, migrationFile{version: version, name: e.Name(), sql: string(b), checksum: hex.EncodeToString(sum[:])})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].version < files[j].version })
+	return files, nil
+}
+
+// planMigrations compares the files with what the database recorded.
+func planMigrations(files []migrationFile, recorded map[int]migrationRecord) []Migration {
+	out := make([]Migration, 0, len(files))
+	for _, f := range files {
+		m := Migration{Version: f.version, Name: f.name, State: MigrationPending, Checksum: f.checksum}
+		if rec, ok := recorded[f.version]; ok {
+			switch {
+			case rec.status == MigrationFailed:
+				m.State, m.Error = MigrationFailed, rec.error
+			case rec.checksum != f.checksum:
+				m.State = MigrationChanged
+			default:
+				m.State = MigrationApplied
+			}
+			at := rec.appliedAt
+			m.AppliedAt = &at
+		}
+		out = append(out, m)
+	}
+	for version, rec := range recorded {
+		if !hasVersion(files, version) {
+			at := rec.appliedAt
+			out = append(out, Migration{Version: version, Name: rec.name, State: MigrationMissing, Checksum: rec.checksum, AppliedAt: &at})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
+
+func hasVersion(files []migrationFile, version int) bool {
+	for _, f := range files {
+		if f.version == version {
+			return true
+		}
+	}
+	return false
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func recordedMigrations(ctx context.Context, q querier) (map[int]migrationRecord, error) {
+	rows, err := q.Query(ctx, `SELECT version, name, checksum, status, COALESCE(error, ''), applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]migrationRecord{}
+	for rows.Next() {
+		var v int
+		var r migrationRecord
+		if err := rows.Scan(&v, &r.name, &r.checksum, &r.status, &r.error, &r.appliedAt); err != nil {
+			return nil, err
+		}
+		out[v] = r
+	}
+	return out, rows.Err()
+}
+
+// MigrationStatus lists every migration with its state, without changing the database.
+func (db *DB) MigrationStatus(ctx context.Context) ([]Migration, error) {
+	files, err := migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	var exists bool
+	if err := db.Pool.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	recorded := map[int]migrationRecord{}
+	if exists {
+		if recorded, err = recordedMigrations(ctx, db.Pool); err != nil {
+			return nil, err
+		}
+	}
+	return planMigrations(files, recorded), nil
+}
+
+// CheckMigrations returns an error when any migration is pending, failed or changed since it was applied.
+func (db *DB) CheckMigrations(ctx context.Context) error {
+	list, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	return migrationsError(list)
+}
+
+func migrationsError(list []Migration) error {
+	var bad []string
+	for _, m := range list {
+		if m.State != MigrationApplied && m.State != MigrationMissing {
+			bad = append(bad, m.Name+" ("+m.State+")")
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d migration(s) not applied: %s", len(bad), strings.Join(bad, ", "))
+}
+
+// Migrate applies pending and failed migrations in order and returns the ones it applied. It holds an
+// advisory lock for the whole run, so concurrent callers wait and then find nothing to do. It stops at the
+// first failure (recorded as failed) and refuses to run when an applied file was changed.
+func (db *DB) Migrate(ctx context.Context) ([]Migration, error) {
+	files, err := migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return nil, fmt.Errorf("migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	if _, err := conn.Exec(ctx, createMigrationsTable); err != nil {
+		return nil, err
+	}
+	recorded, err := recordedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	plan := planMigrations(files, recorded)
+	for _, m := range plan {
+		if m.State == MigrationChanged {
+			return nil, fmt.Errorf("migration %s was changed after it was applied (checksum %s, recorded %s): add a new migration instead",
+				m.Name, m.Checksum, recorded[m.Version].checksum)
+		}
+	}
+	byVersion := make(map[int]migrationFile, len(files))
+	for _, f := range files {
+		byVersion[f.version] = f
+	}
+	var applied []Migration
+	for _, m := range plan {
+		if m.State != MigrationPending && m.State != MigrationFailed {
+			continue
+		}
+		if err := applyMigration(ctx, conn.Conn(), byVersion[m.Version]); err != nil {
+			_, _ = conn.Exec(context.Background(),
+				`INSERT INTO schema_migrations (version, name, checksum, status, error) VALUES ($1, $2, $3, 'failed', $4)
+				 ON CONFLICT (version) DO UPDATE SET name = EXCLUDED.name, checksum = EXCLUDED.checksum, status = 'failed', error = EXCLUDED.error, applied_at = NOW()`,
+				m.Version, m.Name, m.Checksum, err.Error())
+			return applied, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		m.State, m.Error = MigrationApplied, ""
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// applyMigration runs one file and records it in the same transaction.
+func applyMigration(ctx context.Context, conn *pgx.Conn, f migrationFile) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, f.sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)
+		 ON CONFLICT (version) DO UPDATE SET name = EXCLUDED.name, checksum = EXCLUDED.checksum, status = 'applied', error = NULL, applied_at = NOW()`,
+		f.version, f.name, f.checksum); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationFiles(t *testing.T) {
+	files, err := migrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 || files[0].name != "000_schema.sql" {
+		t.Fatalf("first migration must be the base schema, got %+v", files[0].name)
+	}
+	for i, f := range files {
+		if i > 0 && f.version <= files[i-1].version {
+			t.Errorf("%s out of order", f.name)
+		}
+		if len(f.checksum) != 64 {
+			t.Errorf("%s: checksum %q", f.name, f.checksum)
+		}
+		// Backfills that rewrite job types would silently change jobs of types added later.
+		if strings.Contains(f.sql, "UPDATE jobs SET type") {
+			t.Errorf("%s rewrites jobs.type", f.name)
+		}
+	}
+}
+
+func TestPlanMigrations(t *testing.T) {
+	files := []migrationFile{
+		{version: 0, name: "000_a.sql", checksum: "a"},
+		{version: 1, name: "001_b.sql", checksum: "b"},
+		{version: 2, name: "002_c.sql", checksum: "c"},
+		{version: 3, name: "003_d.sql", checksum: "d"},
+	}
+	at := time.Now()
+	recorded := map[int]migrationRecord{
+		0: {name: "000_a.sql", checksum: "a", status: "applied", appliedAt: at},
+		1: {name: "001_b.sql", checksum: "old", status: "applied", appliedAt: at},
+		2: {name: "002_c.sql", checksum: "c", status: "failed", error: "boom", appliedAt: at},
+		9: {name: "009_new.sql", checksum: "z", status: "applied", appliedAt: at},
+	}
+	got := planMigrations(files, recorded)
+	want := []string{MigrationApplied, MigrationChanged, MigrationFailed, MigrationPending, MigrationMissing}
+	if len(got) != len(want) {
+		t.Fatalf("got %d migrations, want %d", len(got), len(want))
+	}
+	for i, m := range got {
+		if m.State != want[i] {
+			t.Errorf("%s: state %s, want %s", m.Name, m.State, want[i])
+		}
+	}
+	if got[2].Error != "boom" || got[3].AppliedAt != nil {
+		t.Errorf("failed/pending details: %+v %+v", got[2], got[3])
+	}
+	err := migrationsError(got)
+	if err == nil || !strings.Contains(err.Error(), "3 migration(s)") || strings.Contains(err.Error(), "009_new.sql") {
+		t.Fatalf("migrationsError: %v", err)
+	}
+	if err := migrationsError(got[:1]); err != nil {
+		t.Fatalf("all applied: %v", err)
+	}
+}
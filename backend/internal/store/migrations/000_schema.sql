@@ -6,7 +6,6 @@ CREATE TABLE IF NOT EXISTS users (
     where_heard TEXT,
     use_case TEXT,
     plan TEXT,
-    api_key_hash TEXT,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
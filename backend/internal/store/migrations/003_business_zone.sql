@@ -11,10 +11,9 @@ CREATE TABLE IF NOT EXISTS user_files (
 CREATE INDEX IF NOT EXISTS idx_user_files_user_id ON user_files(user_id);
 CREATE INDEX IF NOT EXISTS idx_user_files_created_at ON user_files(created_at DESC);
 
--- Allow 'seo', 'outline', 'translate' job types. Fix any rows with invalid type first, then add constraint.
-UPDATE jobs SET type = 'chat' WHERE type IS NULL OR type NOT IN ('chat', 'image', 'video', 'upscale', 'seo', 'outline', 'translate');
+-- Allow 'seo', 'outline', 'translate' job types. NOT VALID: rows of later types are checked by 012, never rewritten.
 ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_type_check;
-ALTER TABLE jobs ADD CONSTRAINT jobs_type_check CHECK (type IN ('chat', 'image', 'video', 'upscale', 'seo', 'outline', 'translate'));
+ALTER TABLE jobs ADD CONSTRAINT jobs_type_check CHECK (type IN ('chat', 'image', 'video', 'upscale', 'seo', 'outline', 'translate')) NOT VALID;
 
 -- Allow renaming files
 ALTER TABLE user_files ADD COLUMN IF NOT EXISTS tags TEXT[] DEFAULT '{}';
@@ -21,4 +21,4 @@ CREATE INDEX IF NOT EXISTS idx_product_photos_product_id ON product_photos(produ
 -- Job type for product photo scoring (AI gives 1-10 per photo).
 ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_type_check;
 ALTER TABLE jobs ADD CONSTRAINT jobs_type_check
-  CHECK (type IN ('chat', 'image', 'video', 'upscale', 'seo', 'outline', 'translate', 'logo', 'product_analyze', 'product_score'));
+  CHECK (type IN ('chat', 'image', 'video', 'upscale', 'seo', 'outline', 'translate', 'logo', 'product_analyze', 'product_score')) NOT VALID;
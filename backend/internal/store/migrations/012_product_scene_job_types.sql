@@ -1,4 +1,4 @@
--- Job types: improve scene prompt, suggest scenes for product.
+-- Job types: improve scene prompt, suggest scenes for product. Validates every row (earlier type checks are NOT VALID).
 ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_type_check;
 ALTER TABLE jobs ADD CONSTRAINT jobs_type_check
   CHECK (type IN ('chat', 'image', 'video', 'upscale', 'seo', 'outline', 'translate', 'logo', 'product_analyze', 'product_score', 'product_description', 'product_scene_improve', 'product_suggest_scenes'));
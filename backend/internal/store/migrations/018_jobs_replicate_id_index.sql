@@ -0,0 +1,2 @@
+-- Webhook and reconciler lookups map a Replicate prediction back to its job.
+CREATE INDEX IF NOT EXISTS idx_jobs_replicate_id ON jobs(replicate_id) WHERE replicate_id IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Credits: users hold a balance in cents. Creating a job reserves an estimate, finishing it settles once
+-- (charge the real cost and release the hold on completion, refund the hold on failure/cancel).
+-- cost_ledger.amount_cents is signed from the user's point of view: positive = spent/held, negative = returned/granted.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS credit_cents INT NOT NULL DEFAULT 0;
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS reserved_cents INT NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS billed BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE cost_ledger ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'charge';
+CREATE INDEX IF NOT EXISTS idx_cost_ledger_job ON cost_ledger(job_id) WHERE job_id IS NOT NULL;
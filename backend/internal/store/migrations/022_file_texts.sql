@@ -0,0 +1,14 @@
+-- Text extracted from uploaded documents (chat attachments, chat project files), keyed by storage key.
+-- Storage keys are never reused, so a row stays valid for the life of the object. error is set when the
+-- file could not be read (unsupported, scanned PDF, too large) so it is not downloaded again every message.
+CREATE TABLE IF NOT EXISTS file_texts (
+    file_key TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL DEFAULT '',
+    content TEXT NOT NULL DEFAULT '',
+    tokens INT NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_texts_user_id ON file_texts(user_id);
@@ -0,0 +1,30 @@
+-- Embedded chunks of chat project files for retrieval (pgvector). model is the embedder that produced the
+-- vector; vectors of different models are never compared, and the column has no fixed size so switching
+-- EMBEDDING_MODEL only needs a re-index. Search is an exact scan within one project, so no ANN index.
+-- When the vector extension is not available (or we may not create it) the table is skipped and chat
+-- falls back to putting document text in the prompt; run this file by hand (psql -f) after installing pgvector.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'vector') THEN
+        CREATE EXTENSION IF NOT EXISTS vector;
+        CREATE TABLE IF NOT EXISTS document_chunks (
+            id BIGSERIAL PRIMARY KEY,
+            project_id UUID NOT NULL REFERENCES chat_projects(id) ON DELETE CASCADE,
+            file_id UUID NOT NULL REFERENCES chat_project_files(id) ON DELETE CASCADE,
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            model TEXT NOT NULL,
+            chunk_index INT NOT NULL,
+            content TEXT NOT NULL,
+            tokens INT NOT NULL DEFAULT 0,
+            embedding vector NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            UNIQUE (file_id, model, chunk_index)
+        );
+        CREATE INDEX IF NOT EXISTS idx_document_chunks_project ON document_chunks(project_id, model);
+    ELSE
+        RAISE NOTICE 'pgvector not available: document_chunks not created, chat retrieval disabled';
+    END IF;
+EXCEPTION WHEN insufficient_privilege THEN
+    RAISE NOTICE 'cannot create extension vector: document_chunks not created, chat retrieval disabled';
+END
+$$;
@@ -0,0 +1,6 @@
+-- Rolling summary of a thread's older messages, kept by a background task after each exchange and put in the chat
+-- prompt in place of messages that no longer fit the context budget. summary_job_id is the last chat job the summary
+-- covers; when that job is gone the summary is rebuilt.
+ALTER TABLE threads ADD COLUMN IF NOT EXISTS summary TEXT NOT NULL DEFAULT '';
+ALTER TABLE threads ADD COLUMN IF NOT EXISTS summary_job_id UUID REFERENCES jobs(id) ON DELETE SET NULL;
+ALTER TABLE threads ADD COLUMN IF NOT EXISTS summary_updated_at TIMESTAMPTZ;
@@ -0,0 +1,24 @@
+-- Branching threads: a thread job follows its parent_job_id (the message before it). Regenerating a reply or
+-- editing a message adds a sibling (same parent), starting a new branch. threads.active_job_id is the last job
+-- of the branch the user is looking at; new messages continue it.
+-- Existing threads become a single branch in time order. The backfill only runs when the column is added, so
+-- re-applying this file never rewires branches made since.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'jobs' AND column_name = 'parent_job_id') THEN
+        ALTER TABLE jobs ADD COLUMN parent_job_id UUID REFERENCES jobs(id) ON DELETE SET NULL;
+        ALTER TABLE threads ADD COLUMN IF NOT EXISTS active_job_id UUID REFERENCES jobs(id) ON DELETE SET NULL;
+
+        UPDATE jobs j SET parent_job_id = x.prev
+        FROM (SELECT id, LAG(id) OVER (PARTITION BY thread_id ORDER BY created_at, id) AS prev
+              FROM jobs WHERE thread_id IS NOT NULL) x
+        WHERE j.id = x.id AND x.prev IS NOT NULL;
+
+        UPDATE threads t SET active_job_id = (
+            SELECT id FROM jobs WHERE thread_id = t.id ORDER BY created_at DESC, id DESC LIMIT 1);
+    END IF;
+END
+$$;
+
+ALTER TABLE threads ADD COLUMN IF NOT EXISTS active_job_id UUID REFERENCES jobs(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_jobs_parent_job_id ON jobs(parent_job_id) WHERE parent_job_id IS NOT NULL;
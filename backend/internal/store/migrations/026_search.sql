@@ -0,0 +1,143 @@
+-- Full-text search: one row per searchable thing in search_documents, kept in sync by triggers on the source
+-- tables. kind is 'chat' (a completed chat message: prompt and reply; parent_id = thread), 'thread' (title),
+-- 'file' (user_files name and content) or 'translation' (a completed translation result; parent_id = project).
+-- Incognito threads are never indexed.
+-- tsv holds the text stemmed with the row's language (config: the user's primary_language, or the translation's
+-- target language) plus unstemmed ('simple') so a query in another language still matches exact words.
+CREATE OR REPLACE FUNCTION search_config(lang TEXT) RETURNS regconfig AS $$
+    SELECT CASE lower(COALESCE(lang, ''))
+        WHEN 'en' THEN 'english' WHEN 'english' THEN 'english'
+        WHEN 'de' THEN 'german' WHEN 'german' THEN 'german'
+        WHEN 'ro' THEN 'romanian' WHEN 'romanian' THEN 'romanian'
+        WHEN 'fr' THEN 'french' WHEN 'french' THEN 'french'
+        WHEN 'es' THEN 'spanish' WHEN 'spanish' THEN 'spanish'
+        WHEN 'it' THEN 'italian' WHEN 'italian' THEN 'italian'
+        ELSE 'simple' END::regconfig
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION search_user_config(uid UUID) RETURNS regconfig AS $$
+    SELECT search_config((SELECT ai_configuration->>'primary_language' FROM users WHERE id = uid))
+$$ LANGUAGE SQL STABLE;
+
+CREATE TABLE IF NOT EXISTS search_documents (
+    kind TEXT NOT NULL CHECK (kind IN ('chat', 'thread', 'file', 'translation')),
+    ref_id UUID NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id UUID,
+    title TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL DEFAULT '',
+    config regconfig NOT NULL DEFAULT 'simple',
+    tsv tsvector NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (kind, ref_id)
+);
+CREATE INDEX IF NOT EXISTS idx_search_documents_tsv ON search_documents USING GIN (tsv);
+CREATE INDEX IF NOT EXISTS idx_search_documents_user ON search_documents(user_id, kind);
+
+-- Bodies are capped so tsvectors stay well under the 1MB limit.
+CREATE OR REPLACE FUNCTION search_put(p_kind TEXT, p_ref UUID, p_user UUID, p_parent UUID, p_title TEXT, p_body TEXT, p_config regconfig, p_created TIMESTAMPTZ)
+RETURNS void AS $$
+DECLARE
+    t TEXT := left(COALESCE(p_title, ''), 500);
+    b TEXT := left(COALESCE(p_body, ''), 200000);
+BEGIN
+    INSERT INTO search_documents (kind, ref_id, user_id, parent_id, title, body, config, tsv, created_at, updated_at)
+    VALUES (p_kind, p_ref, p_user, p_parent, t, b, p_config,
+            setweight(to_tsvector(p_config, t), 'A') || setweight(to_tsvector(p_config, b), 'B') ||
+            setweight(to_tsvector('simple', t), 'A') || setweight(to_tsvector('simple', b), 'B'),
+            COALESCE(p_created, NOW()), NOW())
+    ON CONFLICT (kind, ref_id) DO UPDATE SET
+        parent_id = EXCLUDED.parent_id, title = EXCLUDED.title, body = EXCLUDED.body,
+        config = EXCLUDED.config, tsv = EXCLUDED.tsv, updated_at = NOW();
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION search_index_job() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM search_documents WHERE kind = 'chat' AND ref_id = OLD.id;
+        RETURN OLD;
+    END IF;
+    IF NEW.type <> 'chat' OR NEW.status <> 'completed'
+       OR EXISTS (SELECT 1 FROM threads WHERE id = NEW.thread_id AND ephemeral) THEN
+        DELETE FROM search_documents WHERE kind = 'chat' AND ref_id = NEW.id;
+        RETURN NEW;
+    END IF;
+    PERFORM search_put('chat', NEW.id, NEW.user_id, NEW.thread_id, NEW.input->>'prompt',
+        COALESCE(NEW.input->>'prompt', '') || E'\n\n' || COALESCE(NEW.output->>'output', ''),
+        search_user_config(NEW.user_id), NEW.created_at);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION search_index_thread() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM search_documents WHERE kind = 'thread' AND ref_id = OLD.id;
+        RETURN OLD;
+    END IF;
+    IF COALESCE(NEW.ephemeral, false) OR COALESCE(btrim(NEW.title), '') = '' THEN
+        DELETE FROM search_documents WHERE kind = 'thread' AND ref_id = NEW.id;
+        RETURN NEW;
+    END IF;
+    PERFORM search_put('thread', NEW.id, NEW.user_id, NULL, NEW.title, '', search_user_config(NEW.user_id), NEW.created_at);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION search_index_user_file() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM search_documents WHERE kind = 'file' AND ref_id = OLD.id;
+        RETURN OLD;
+    END IF;
+    PERFORM search_put('file', NEW.id, NEW.user_id, NULL, NEW.name, NEW.content, search_user_config(NEW.user_id), NEW.created_at);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION search_index_translation() RETURNS trigger AS $$
+DECLARE
+    p translation_projects%ROWTYPE;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM search_documents WHERE kind = 'translation' AND ref_id = OLD.id;
+        RETURN OLD;
+    END IF;
+    IF NEW.status <> 'completed' OR COALESCE(NEW.result_text, '') = '' THEN
+        DELETE FROM search_documents WHERE kind = 'translation' AND ref_id = NEW.id;
+        RETURN NEW;
+    END IF;
+    SELECT * INTO p FROM translation_projects WHERE id = NEW.project_id;
+    PERFORM search_put('translation', NEW.id, p.user_id, NEW.project_id, p.name, NEW.result_text,
+        search_config(p.target_lang), NEW.created_at);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS search_index_job ON jobs;
+CREATE TRIGGER search_index_job AFTER INSERT OR UPDATE OF type, status, input, output, thread_id OR DELETE ON jobs
+    FOR EACH ROW EXECUTE FUNCTION search_index_job();
+DROP TRIGGER IF EXISTS search_index_thread ON threads;
+CREATE TRIGGER search_index_thread AFTER INSERT OR UPDATE OF title, ephemeral OR DELETE ON threads
+    FOR EACH ROW EXECUTE FUNCTION search_index_thread();
+DROP TRIGGER IF EXISTS search_index_user_file ON user_files;
+CREATE TRIGGER search_index_user_file AFTER INSERT OR UPDATE OF name, content OR DELETE ON user_files
+    FOR EACH ROW EXECUTE FUNCTION search_index_user_file();
+DROP TRIGGER IF EXISTS search_index_translation ON translation_items;
+CREATE TRIGGER search_index_translation AFTER INSERT OR UPDATE OF status, result_text OR DELETE ON translation_items
+    FOR EACH ROW EXECUTE FUNCTION search_index_translation();
+
+-- Backfill what existed before the triggers (again harmless: search_put upserts).
+SELECT search_put('chat', j.id, j.user_id, j.thread_id, j.input->>'prompt',
+    COALESCE(j.input->>'prompt', '') || E'\n\n' || COALESCE(j.output->>'output', ''), search_user_config(j.user_id), j.created_at)
+FROM jobs j LEFT JOIN threads t ON t.id = j.thread_id
+WHERE j.type = 'chat' AND j.status = 'completed' AND NOT COALESCE(t.ephemeral, false);
+SELECT search_put('thread', t.id, t.user_id, NULL, t.title, '', search_user_config(t.user_id), t.created_at)
+FROM threads t WHERE NOT COALESCE(t.ephemeral, false) AND COALESCE(btrim(t.title), '') <> '';
+SELECT search_put('file', f.id, f.user_id, NULL, f.name, f.content, search_user_config(f.user_id), f.created_at)
+FROM user_files f;
+SELECT search_put('translation', i.id, p.user_id, i.project_id, p.name, i.result_text, search_config(p.target_lang), i.created_at)
+FROM translation_items i JOIN translation_projects p ON p.id = i.project_id
+WHERE i.status = 'completed' AND COALESCE(i.result_text, '') <> '';
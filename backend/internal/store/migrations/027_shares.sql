@@ -0,0 +1,19 @@
+-- Public read-only share links for a thread (its active branch) or a single job. The token is the whole secret
+-- (128 random bits) and is kept so the owner can copy the link again. A share stops working when revoked or
+-- expired; expires_at NULL never expires. Unlike the removed job_shares (015/016) there is no signed URL: the
+-- row is checked on every view, and counted.
+CREATE TABLE IF NOT EXISTS shares (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token TEXT NOT NULL UNIQUE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    thread_id UUID REFERENCES threads(id) ON DELETE CASCADE,
+    job_id UUID REFERENCES jobs(id) ON DELETE CASCADE,
+    include_attachments BOOLEAN NOT NULL DEFAULT false,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    views INT NOT NULL DEFAULT 0,
+    last_viewed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((thread_id IS NULL) <> (job_id IS NULL))
+);
+CREATE INDEX IF NOT EXISTS idx_shares_user_created ON shares(user_id, created_at DESC);
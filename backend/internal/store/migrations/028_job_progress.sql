@@ -0,0 +1,3 @@
+-- Latest progress of a running media job, parsed from its prediction logs: {"percent", "step", "steps",
+-- "eta_seconds"}. NULL until the model reports any. It is kept after the job ends.
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS progress JSONB;
@@ -2,21 +2,16 @@ package store
 
 import (
 	"context"
-	"embed"
-	"sort"
-	"strings"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-//go:embed schema.sql
-var schemaFS embed.FS
-
-//go:embed migrations/*.sql
-var migrationsFS embed.FS
-
 type DB struct {
 	Pool *pgxpool.Pool
+	// OnWebhookDeliveries, when set, is called with webhook deliveries just committed (a job finished or a
+	// delivery was replayed) so they can be sent right away.
+	OnWebhookDeliveries func(ids []uuid.UUID)
 }
 
 func NewDB(ctx context.Context, connString string) (*DB, error) {
@@ -46,53 +41,3 @@ func (db *DB) Close() { db.Pool.Close() }
 func (db *DB) Ping(ctx context.Context) error {
 	return db.Pool.Ping(ctx)
 }
-
-func (db *DB) Migrate(ctx context.Context) error {
-	b, err := schemaFS.ReadFile("schema.sql")
-	if err != nil {
-		return err
-	}
-	for _, s := range strings.Split(string(b), ";") {
-		stmt := strings.TrimSpace(s)
-		if stmt == "" {
-			continue
-		}
-		if _, err := db.Pool.Exec(ctx, stmt); err != nil {
-			return err
-		}
-	}
-	// Run migrations (e.g. 001_add_upscale_job_type.sql for existing DBs)
-	entries, _ := migrationsFS.ReadDir("migrations")
-	names := make([]string, 0, len(entries))
-	for _, e := range entries {
-		if !e.IsDir() && strings.HasSuffix(e.Name(), ".sql") {
-			names = append(names, e.Name())
-		}
-	}
-	sort.Strings(names)
-	for _, name := range names {
-		b, err := migrationsFS.ReadFile("migrations/" + name)
-		if err != nil {
-			return err
-		}
-		for _, s := range strings.Split(string(b), ";") {
-			stmt := strings.TrimSpace(s)
-			// Skip empty or comment-only blocks; strip leading comment lines
-			for strings.HasPrefix(stmt, "--") || strings.TrimSpace(stmt) == "" {
-				if idx := strings.Index(stmt, "\n"); idx >= 0 {
-					stmt = strings.TrimSpace(stmt[idx+1:])
-				} else {
-					stmt = ""
-					break
-				}
-			}
-			if stmt == "" {
-				continue
-			}
-			if _, err := db.Pool.Exec(ctx, stmt); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"html"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Search result kinds (search_documents.kind).
+const (
+	SearchChat        = "chat"
+	SearchThread      = "thread"
+	SearchFile        = "file"
+	SearchTranslation = "translation"
+)
+
+// SearchKinds are the kinds a search can be limited to.
+var SearchKinds = []string{SearchChat, SearchThread, SearchFile, SearchTranslation}
+
+// SearchResult is one hit of SearchDocuments. ParentID is the thread of a chat message and the project of a
+// translation. Snippet is HTML-escaped text with the matches in <mark>.
+type SearchResult struct {
+	Type      string     `json:"type"`
+	ID        uuid.UUID  `json:"id"`
+	ParentID  *uuid.UUID `json:"parent_id,omitempty"`
+	Title     string     `json:"title"`
+	Snippet   string     `json:"snippet"`
+	Rank      float64    `json:"rank"`
+	CreatedAt string     `json:"created_at"`
+	UpdatedAt string     `json:"updated_at"`
+}
+
+// Match markers ts_headline puts around hits: private-use characters, unlikely in real text and left alone
+// by HTML escaping.
+const (
+	markStart = "\ue000"
+	markStop  = "\ue001"
+)
+
+const searchHeadlineOptions = `StartSel=` + markStart + `, StopSel=` + markStop + `, MaxWords=30, MinWords=12, MaxFragments=2, FragmentDelimiter=" … "`
+
+// searchQuerySQL is the query for $2 in the user's language ($1) or as plain words.
+const searchQuerySQL = `(websearch_to_tsquery(search_user_config($1), $2) || websearch_to_tsquery('simple', $2))`
+
+// SearchDocuments runs a full-text search over the user's chats, thread titles, files and translations,
+// best matches first. kinds limits the result types (nil: all). Returns the page and the total number of hits.
+func (db *DB) SearchDocuments(ctx context.Context, userID uuid.UUID, q string, kinds []string, offset, limit int) ([]SearchResult, int, error) {
+	if limit <= 0 || limit > 50 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if kinds == nil {
+		kinds = []string{}
+	}
+	where := `FROM search_documents d WHERE d.user_id = $1 AND d.tsv @@ ` + searchQuerySQL + ` AND (cardinality($3::text[]) = 0 OR d.kind = ANY($3))`
+	var total int
+	if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) `+where, userID, q, kinds).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return []SearchResult{}, 0, nil
+	}
+	// Headlines are only built for the page: they re-parse the text.
+	rows, err := db.Pool.Query(ctx,
+		`SELECT p.kind, p.ref_id, p.parent_id, p.title,
+		   ts_headline(p.config, CASE WHEN p.body = '' THEN p.title ELSE p.body END, `+searchQuerySQL+`, $6),
+		   p.rank, p.created_at::text, p.updated_at::text
+		 FROM (SELECT d.*, ts_rank_cd(d.tsv, `+searchQuerySQL+`, 32) AS rank `+where+`
+		       ORDER BY rank DESC, d.updated_at DESC LIMIT $4 OFFSET $5) p
+		 ORDER BY p.rank DESC, p.updated_at DESC`,
+		userID, q, kinds, limit, offset, searchHeadlineOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := []SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		var snippet string
+		if err := rows.Scan(&r.Type, &r.ID, &r.ParentID, &r.Title, &snippet, &r.Rank, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, 0, err
+		}
+		r.Snippet = highlightSnippet(snippet)
+		list = append(list, r)
+	}
+	return list, total, rows.Err()
+}
+
+// highlightSnippet escapes a ts_headline result for HTML and turns its match markers into <mark> tags.
+func highlightSnippet(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, markStart, "<mark>")
+	return strings.ReplaceAll(s, markStop, "</mark>")
+}
@@ -0,0 +1,11 @@
+package store
+
+import "testing"
+
+func TestHighlightSnippet(t *testing.T) {
+	in := "use <b>" + markStart + "invoices" + markStop + "</b> &\n\n  " + markStart + "VAT" + markStop
+	want := "use &lt;b&gt;<mark>invoices</mark>&lt;/b&gt; &amp; <mark>VAT</mark>"
+	if got := highlightSnippet(in); got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
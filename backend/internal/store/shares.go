@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Share is a public link to a thread or a job, as listed to its owner. Title is the thread title or the job
+// name or type.
+type Share struct {
+	ID                 uuid.UUID  `json:"id"`
+	Token              string     `json:"token"`
+	ThreadID           *uuid.UUID `json:"thread_id,omitempty"`
+	JobID              *uuid.UUID `json:"job_id,omitempty"`
+	Title              string     `json:"title"`
+	IncludeAttachments bool       `json:"include_attachments"`
+	ExpiresAt          *string    `json:"expires_at,omitempty"`
+	RevokedAt          *string    `json:"revoked_at,omitempty"`
+	Views              int        `json:"views"`
+	LastViewedAt       *string    `json:"last_viewed_at,omitempty"`
+	CreatedAt          string     `json:"created_at"`
+	Active             bool       `json:"active"`
+}
+
+const shareColumns = `s.id, s.token, s.thread_id, s.job_id,
+	COALESCE(t.title, j.name, j.type, ''), s.include_attachments, s.expires_at::text, s.revoked_at::text, s.views,
+	s.last_viewed_at::text, s.created_at::text, (s.revoked_at IS NULL AND (s.expires_at IS NULL OR s.expires_at > NOW()))`
+
+const shareFrom = ` FROM shares s LEFT JOIN threads t ON t.id = s.thread_id LEFT JOIN jobs j ON j.id = s.job_id`
+
+func scanShare(row pgx.Row) (*Share, error) {
+	var s Share
+	err := row.Scan(&s.ID, &s.Token, &s.ThreadID, &s.JobID, &s.Title, &s.IncludeAttachments, &s.ExpiresAt, &s.RevokedAt,
+		&s.Views, &s.LastViewedAt, &s.CreatedAt, &s.Active)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateShare stores a share of a thread or a job (exactly one of threadID, jobID). The caller checks that
+// the user owns it. A nil expiresAt never expires.
+func (db *DB) CreateShare(ctx context.Context, userID uuid.UUID, token string, threadID, jobID *uuid.UUID, includeAttachments bool, expiresAt *time.Time) (*Share, error) {
+	var id uuid.UUID
+	if err := db.Pool.QueryRow(ctx,
+		`INSERT INTO shares (token, user_id, thread_id, job_id, include_attachments, expires_at) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`,
+		token, userID, threadID, jobID, includeAttachments, expiresAt).Scan(&id); err != nil {
+		return nil, err
+	}
+	return scanShare(db.Pool.QueryRow(ctx, `SELECT `+shareColumns+shareFrom+` WHERE s.id = $1`, id))
+}
+
+// ListShares returns the user's shares that were not revoked, newest first; expired ones have Active false.
+func (db *DB) ListShares(ctx context.Context, userID uuid.UUID) ([]Share, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT `+shareColumns+shareFrom+` WHERE s.user_id = $1 AND s.revoked_at IS NULL ORDER BY s.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Share{}
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *s)
+	}
+	return list, rows.Err()
+}
+
+func (db *DB) CountActiveShares(ctx context.Context, userID uuid.UUID) (int, error) {
+	var n int
+	err := db.Pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM shares WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`, userID).Scan(&n)
+	return n, err
+}
+
+// RevokeShare stops a share immediately. Returns pgx.ErrNoRows if the user has no such share not yet revoked.
+func (db *DB) RevokeShare(ctx context.Context, shareID, userID uuid.UUID) error {
+	result, err := db.Pool.Exec(ctx,
+		`UPDATE shares SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, shareID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// SharedContent is what a share link points to, resolved by ViewShare.
+type SharedContent struct {
+	UserID             uuid.UUID
+	ThreadID           *uuid.UUID
+	JobID              *uuid.UUID
+	IncludeAttachments bool
+	ExpiresAt          *string
+	Views              int
+}
+
+// ViewShare resolves an active share and counts the view; nil when the token is unknown, revoked or expired.
+func (db *DB) ViewShare(ctx context.Context, token string) (*SharedContent, error) {
+	var c SharedContent
+	err := db.Pool.QueryRow(ctx,
+		`UPDATE shares SET views = views + 1, last_viewed_at = NOW()
+		 WHERE token = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		 RETURNING user_id, thread_id, job_id, include_attachments, expires_at::text, views`, token).
+		Scan(&c.UserID, &c.ThreadID, &c.JobID, &c.IncludeAttachments, &c.ExpiresAt, &c.Views)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
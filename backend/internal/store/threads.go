@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -29,6 +30,29 @@ func (db *DB) UpdateThreadTitle(ctx context.Context, threadID uuid.UUID, title s
 	return err
 }
 
+// ThreadSummary is the rolling summary of a thread's older messages. ThroughJobID is the last chat job it covers
+// (nil when there is no summary yet).
+type ThreadSummary struct {
+	Text         string
+	ThroughJobID *uuid.UUID
+}
+
+func (db *DB) GetThreadSummary(ctx context.Context, threadID uuid.UUID) (ThreadSummary, error) {
+	var s ThreadSummary
+	err := db.Pool.QueryRow(ctx, `SELECT summary, summary_job_id FROM threads WHERE id = $1`, threadID).Scan(&s.Text, &s.ThroughJobID)
+	if err == pgx.ErrNoRows {
+		return ThreadSummary{}, nil
+	}
+	return s, err
+}
+
+func (db *DB) SaveThreadSummary(ctx context.Context, threadID uuid.UUID, text string, throughJobID uuid.UUID) error {
+	_, err := db.Pool.Exec(ctx,
+		`UPDATE threads SET summary = $2, summary_job_id = $3, summary_updated_at = NOW() WHERE id = $1`,
+		threadID, text, throughJobID)
+	return err
+}
+
 func (db *DB) GetThreadByID(ctx context.Context, threadID uuid.UUID) (*Thread, error) {
 	var t Thread
 	var archivedAt *time.Time
@@ -129,7 +153,71 @@ func (db *DB) DeleteThread(ctx context.Context, threadID, userID uuid.UUID) erro
 	return nil
 }
 
+// IsThreadEphemeral reports whether the thread is incognito (never listed, shared or indexed).
+func (db *DB) IsThreadEphemeral(ctx context.Context, threadID uuid.UUID) (bool, error) {
+	var e bool
+	err := db.Pool.QueryRow(ctx, `SELECT COALESCE(ephemeral, false) FROM threads WHERE id = $1`, threadID).Scan(&e)
+	return e, err
+}
+
 func (db *DB) TouchThread(ctx context.Context, threadID uuid.UUID) error {
 	_, err := db.Pool.Exec(ctx, `UPDATE threads SET updated_at = NOW() WHERE id = $1`, threadID)
 	return err
 }
+
+// ImportedChat is a completed chat exchange recreated by ImportThread. A zero CreatedAt means now.
+type ImportedChat struct {
+	Prompt      string
+	Output      string
+	Attachments []string
+	CreatedAt   time.Time
+}
+
+// ImportThread creates a thread with the given chat exchanges as completed chat jobs, one branch in order.
+// source is recorded in each job's input ("imported").
+func (db *DB) ImportThread(ctx context.Context, userID uuid.UUID, title, source string, createdAt time.Time, chats []ImportedChat) (uuid.UUID, error) {
+	now := time.Now()
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	if title == "" {
+		title = createdAt.Format("2 Jan 2006")
+	}
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback(ctx)
+	threadID := uuid.New()
+	if _, err := tx.Exec(ctx, `INSERT INTO threads (id, user_id, title, ephemeral, created_at) VALUES ($1,$2,$3,false,$4)`,
+		threadID, userID, title, createdAt); err != nil {
+		return uuid.Nil, err
+	}
+	var parent *uuid.UUID
+	last := createdAt
+	for _, c := range chats {
+		at := c.CreatedAt
+		if at.IsZero() || at.Before(last) {
+			at = last // keep the order even when times are missing or out of order
+		}
+		last = at
+		input := map[string]interface{}{"prompt": c.Prompt, "imported": source}
+		if len(c.Attachments) > 0 {
+			input["attachment_urls"] = c.Attachments
+		}
+		inBytes, _ := json.Marshal(input)
+		outBytes, _ := json.Marshal(map[string]string{"output": c.Output})
+		id := uuid.New()
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO jobs (id, user_id, type, status, input, output, thread_id, parent_job_id, created_at, updated_at)
+			 VALUES ($1,$2,'chat','completed',$3,$4,$5,$6,$7,$7)`,
+			id, userID, inBytes, outBytes, threadID, parent, at); err != nil {
+			return uuid.Nil, err
+		}
+		parent = &id
+	}
+	if _, err := tx.Exec(ctx, `UPDATE threads SET active_job_id = $2, updated_at = $3 WHERE id = $1`, threadID, parent, last); err != nil {
+		return uuid.Nil, err
+	}
+	return threadID, tx.Commit(ctx)
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobUsage counts a user's jobs of one type since the start of the day and of the month.
+// Seconds sums input.duration (video). Failed and cancelled jobs are not counted.
+type JobUsage struct {
+	Type         string
+	DayJobs      int
+	MonthJobs    int
+	DaySeconds   int
+	MonthSeconds int
+}
+
+func (db *DB) GetJobUsage(ctx context.Context, userID uuid.UUID, dayStart, monthStart time.Time) ([]JobUsage, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT type,
+			COUNT(*) FILTER (WHERE created_at >= $2),
+			COUNT(*),
+			COALESCE(SUM((input->>'duration')::numeric::int) FILTER (WHERE created_at >= $2 AND jsonb_typeof(input->'duration') = 'number'), 0),
+			COALESCE(SUM((input->>'duration')::numeric::int) FILTER (WHERE jsonb_typeof(input->'duration') = 'number'), 0)
+		 FROM jobs
+		 WHERE user_id = $1 AND created_at >= $3 AND status NOT IN ('failed','cancelled')
+		 GROUP BY type`,
+		userID, dayStart, monthStart)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []JobUsage
+	for rows.Next() {
+		var u JobUsage
+		if err := rows.Scan(&u.Type, &u.DayJobs, &u.MonthJobs, &u.DaySeconds, &u.MonthSeconds); err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
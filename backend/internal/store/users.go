@@ -94,13 +94,21 @@ func (db *DB) EnsureUser(ctx context.Context, email string) (*User, error) {
 
 // UpsertUser inserts or updates user by id (from Supabase Auth). Used to sync auth.users → users.
 func (db *DB) UpsertUser(ctx context.Context, id uuid.UUID, email string) error {
+	_, err := db.UpsertUserPlan(ctx, id, email)
+	return err
+}
+
+// UpsertUserPlan is UpsertUser returning the user's plan ("" when unset), for the auth middleware.
+func (db *DB) UpsertUserPlan(ctx context.Context, id uuid.UUID, email string) (string, error) {
 	if email == "" {
 		email = id.String() + "@supabase.local" // placeholder when JWT has no email
 	}
-	_, err := db.Pool.Exec(ctx,
-		`INSERT INTO users (id, email) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET email = COALESCE(NULLIF(EXCLUDED.email,''), users.email), updated_at = NOW()`,
-		id, email)
-	return err
+	var plan string
+	err := db.Pool.QueryRow(ctx,
+		`INSERT INTO users (id, email) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET email = COALESCE(NULLIF(EXCLUDED.email,''), users.email), updated_at = NOW()
+		 RETURNING COALESCE(plan, '')`,
+		id, email).Scan(&plan)
+	return plan, err
 }
 
 // UpdateUserProfile updates optional profile fields. Nil pointer = do not update.
@@ -132,6 +140,16 @@ func (db *DB) UpdateUserProfile(ctx context.Context, id uuid.UUID, fullName, whe
 	return nil
 }
 
+// SetUserPlan sets the user's plan (billing and admin only; users may not pick their own). False when there
+// is no such user.
+func (db *DB) SetUserPlan(ctx context.Context, id uuid.UUID, plan string) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `UPDATE users SET plan = $2, updated_at = NOW() WHERE id = $1`, id, plan)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 // ListUsers returns users for admin (paginated, optional search by email/full_name).
 func (db *DB) ListUsers(ctx context.Context, limit, offset int, search string) ([]User, int, error) {
 	if limit <= 0 || limit > 100 {
@@ -0,0 +1,283 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookEndpoint is a user's URL for job events; the signing secret is only returned at creation.
+type WebhookEndpoint struct {
+	ID        uuid.UUID `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt string    `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent (or to be sent) to one endpoint: the delivery log entry.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	EndpointID     uuid.UUID       `json:"endpoint_id"`
+	JobID          *uuid.UUID      `json:"job_id,omitempty"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	ReplayOf       *uuid.UUID      `json:"replay_of,omitempty"`
+	CreatedAt      string          `json:"created_at"`
+	DeliveredAt    *string         `json:"delivered_at,omitempty"`
+}
+
+// WebhookTarget is what the delivery task needs: the delivery and where and how to send it.
+type WebhookTarget struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// WebhookEvent is the JSON body of a delivery. ID identifies the event and is kept on replay, so receivers
+// can deduplicate.
+type WebhookEvent struct {
+	ID        uuid.UUID        `json:"id"`
+	Type      string           `json:"type"`
+	CreatedAt string           `json:"created_at"`
+	Data      WebhookEventData `json:"data"`
+}
+
+type WebhookEventData struct {
+	Job Job `json:"job"`
+}
+
+func (db *DB) CreateWebhookEndpoint(ctx context.Context, userID uuid.UUID, url, secret string, events []string) (*WebhookEndpoint, error) {
+	e := WebhookEndpoint{URL: url, Events: events}
+	err := db.Pool.QueryRow(ctx,
+		`INSERT INTO webhook_endpoints (user_id, url, secret, events) VALUES ($1,$2,$3,$4) RETURNING id, created_at::text`,
+		userID, url, secret, events).Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (db *DB) ListWebhookEndpoints(ctx context.Context, userID uuid.UUID) ([]WebhookEndpoint, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id, url, events, created_at::text FROM webhook_endpoints
+		 WHERE user_id = $1 AND disabled_at IS NULL ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []WebhookEndpoint
+	for rows.Next() {
+		var e WebhookEndpoint
+		if err := rows.Scan(&e.ID, &e.URL, &e.Events, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (db *DB) CountWebhookEndpoints(ctx context.Context, userID uuid.UUID) (int, error) {
+	var n int
+	err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM webhook_endpoints WHERE user_id = $1 AND disabled_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+// DeleteWebhookEndpoint removes the endpoint and its delivery log. Returns pgx.ErrNoRows if the user has no such endpoint.
+func (db *DB) DeleteWebhookEndpoint(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := db.Pool.Exec(ctx, `DELETE FROM webhook_endpoints WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+const deliveryColumns = `d.id, d.endpoint_id, d.job_id, d.event, d.payload, d.status, d.attempts, d.response_status, d.last_error,
+	d.replay_of, d.created_at::text, d.delivered_at::text`
+
+func scanDelivery(row pgx.Row, d *WebhookDelivery, extra ...interface{}) error {
+	return row.Scan(append([]interface{}{&d.ID, &d.EndpointID, &d.JobID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+		&d.ResponseStatus, &d.LastError, &d.ReplayOf, &d.CreatedAt, &d.DeliveredAt}, extra...)...)
+}
+
+// ListWebhookDeliveries is the endpoint's delivery log, newest first. Returns pgx.ErrNoRows if the user has no such endpoint.
+func (db *DB) ListWebhookDeliveries(ctx context.Context, endpointID, userID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	var exists bool
+	if err := db.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM webhook_endpoints WHERE id = $1 AND user_id = $2)`,
+		endpointID, userID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, pgx.ErrNoRows
+	}
+	rows, err := db.Pool.Query(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries d WHERE d.endpoint_id = $1 ORDER BY d.created_at DESC LIMIT $2`,
+		endpointID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// GetWebhookTarget loads a delivery with its endpoint; nil when either was deleted or the endpoint is disabled.
+func (db *DB) GetWebhookTarget(ctx context.Context, deliveryID uuid.UUID) (*WebhookTarget, error) {
+	var t WebhookTarget
+	err := scanDelivery(db.Pool.QueryRow(ctx,
+		`SELECT `+deliveryColumns+`, e.url, e.secret FROM webhook_deliveries d
+		 JOIN webhook_endpoints e ON e.id = d.endpoint_id WHERE d.id = $1 AND e.disabled_at IS NULL`, deliveryID),
+		&t.WebhookDelivery, &t.URL, &t.Secret)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RecordWebhookAttempt logs one send. A delivery stays pending while it will be retried; final marks it failed.
+func (db *DB) RecordWebhookAttempt(ctx context.Context, id uuid.UUID, responseStatus int, errMsg string, delivered, final bool) error {
+	status := DeliveryPending
+	switch {
+	case delivered:
+		status = DeliveryDelivered
+	case final:
+		status = DeliveryFailed
+	}
+	var code *int
+	if responseStatus > 0 {
+		code = &responseStatus
+	}
+	var errPtr *string
+	if errMsg != "" {
+		errPtr = &errMsg
+	}
+	_, err := db.Pool.Exec(ctx,
+		`UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1, response_status = $3, last_error = $4, updated_at = NOW(),
+		 delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() ELSE delivered_at END WHERE id = $1`,
+		id, status, code, errPtr)
+	return err
+}
+
+// ReplayWebhookDelivery queues the delivery's event again as a new delivery to the same endpoint.
+// Returns pgx.ErrNoRows if the user has no such delivery.
+func (db *DB) ReplayWebhookDelivery(ctx context.Context, deliveryID, userID uuid.UUID) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := scanDelivery(db.Pool.QueryRow(ctx,
+		`INSERT INTO webhook_deliveries (endpoint_id, user_id, job_id, event, payload, replay_of)
+		 SELECT d.endpoint_id, d.user_id, d.job_id, d.event, d.payload, d.id FROM webhook_deliveries d
+		 JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		 WHERE d.id = $1 AND d.user_id = $2 AND e.disabled_at IS NULL
+		 RETURNING id, endpoint_id, job_id, event, payload, status, attempts, response_status, last_error,
+		 replay_of, created_at::text, delivered_at::text`, deliveryID, userID), &d)
+	if err != nil {
+		return nil, err
+	}
+	db.notifyWebhookDeliveries([]uuid.UUID{d.ID})
+	return &d, nil
+}
+
+// PendingWebhookDeliveries returns pending deliveries created before the cutoff, oldest first, so the
+// dispatcher can (re)queue any whose task was lost.
+func (db *DB) PendingWebhookDeliveries(ctx context.Context, before time.Time, limit int) ([]uuid.UUID, error) {
+	rows, err := db.Pool.Query(ctx,
+		`SELECT id FROM webhook_deliveries WHERE status = 'pending' AND created_at < $1 ORDER BY created_at LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// queueJobWebhooks writes one pending delivery of the job's terminal event ("job.<status>") per subscribed
+// endpoint of its owner. Called in the transaction that settles the job, so each event is queued once.
+func queueJobWebhooks(ctx context.Context, tx pgx.Tx, jobID uuid.UUID, status string) ([]uuid.UUID, error) {
+	event := "job." + status
+	rows, err := tx.Query(ctx,
+		`SELECT e.id FROM webhook_endpoints e JOIN jobs j ON j.user_id = e.user_id
+		 WHERE j.id = $1 AND e.disabled_at IS NULL AND $2 = ANY(e.events)`, jobID, event)
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		endpoints = append(endpoints, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(endpoints) == 0 {
+		return nil, err
+	}
+	var j Job
+	err = tx.QueryRow(ctx,
+		`SELECT id, user_id, thread_id, type, status, name, input, output, error, cost_cents, replicate_id, rating, created_at::text, updated_at::text
+		 FROM jobs WHERE id = $1`, jobID).
+		Scan(&j.ID, &j.UserID, &j.ThreadID, &j.Type, &j.Status, &j.Name, &j.Input, &j.Output, &j.Error, &j.CostCents, &j.ReplicateID, &j.Rating, &j.CreatedAt, &j.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(WebhookEvent{
+		ID:        uuid.New(),
+		Type:      event,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:      WebhookEventData{Job: j},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(endpoints))
+	for _, endpointID := range endpoints {
+		var id uuid.UUID
+		if err := tx.QueryRow(ctx,
+			`INSERT INTO webhook_deliveries (endpoint_id, user_id, job_id, event, payload) VALUES ($1,$2,$3,$4,$5) RETURNING id`,
+			endpointID, j.UserID, jobID, event, payload).Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// notifyWebhookDeliveries hands committed deliveries to OnWebhookDeliveries (the worker enqueues them).
+func (db *DB) notifyWebhookDeliveries(ids []uuid.UUID) {
+	if len(ids) > 0 && db.OnWebhookDeliveries != nil {
+		db.OnWebhookDeliveries(ids)
+	}
+}
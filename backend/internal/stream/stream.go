@@ -2,23 +2,36 @@ package stream
 
 import (
 	"context"
-	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+
+	"flipo5/backend/internal/events"
 )
 
-// ChunkMsg is published per chunk; Output is cumulative text so far
-type ChunkMsg struct {
-	Output string `json:"output"`
-	Done   bool   `json:"done,omitempty"`
+// JobChannel is the pub/sub channel with a job's events, each with its stream ID.
+func JobChannel(jobID uuid.UUID) string {
+	return "stream:" + jobID.String()
 }
 
-func channelKey(jobID uuid.UUID) string {
-	return "stream:" + jobID.String()
+// UserChannel is the pub/sub channel with a user's job status changes, mirrored outputs and thread titles.
+func UserChannel(userID uuid.UUID) string {
+	return "user:" + userID.String() + ":jobs"
+}
+
+// eventsKey is the Redis Stream with a job's events, so a client that reconnects replays what it missed.
+func eventsKey(jobID uuid.UUID) string {
+	return "stream:" + jobID.String() + ":events"
 }
 
+const (
+	eventsMaxLen  = 20000            // entries kept per job (approximately) while it runs
+	eventsTTL     = time.Hour        // a job stream without events for this long is dropped
+	eventsDoneTTL = 10 * time.Minute // after the final event, kept this long for late reconnects
+)
+
 // Publisher publishes stream chunks to Redis (worker-side)
 type Publisher struct {
 	rdb *redis.Client
@@ -40,21 +53,49 @@ func NewPublisher(redisURL string) (*Publisher, error) {
 	return &Publisher{rdb: rdb}, nil
 }
 
-func (p *Publisher) Publish(ctx context.Context, jobID uuid.UUID, output string, done bool) error {
+// PublishJob appends an event to the job's stream. A terminal job status is its last event.
+func (p *Publisher) PublishJob(ctx context.Context, jobID uuid.UUID, payload events.Payload) error {
+	return p.add(ctx, jobID, events.New(payload))
+}
+
+// PublishDelta sends text appended to a job's output.
+func (p *Publisher) PublishDelta(ctx context.Context, jobID uuid.UUID, delta string) error {
+	if delta == "" {
+		return nil
+	}
+	return p.PublishJob(ctx, jobID, events.JobDelta{JobID: jobID, Text: delta})
+}
+
+// add appends the event to the job's stream and publishes it, with its ID, on the job channel for
+// listeners that do not replay. After the final event the stream is trimmed to that event and expires soon.
+func (p *Publisher) add(ctx context.Context, jobID uuid.UUID, ev events.Event) error {
 	if p == nil || p.rdb == nil {
 		return nil
 	}
-	msg := ChunkMsg{Output: output, Done: done}
-	b, _ := json.Marshal(msg)
-	return p.rdb.Publish(ctx, channelKey(jobID), string(b)).Err()
+	key := eventsKey(jobID)
+	id, err := p.rdb.XAdd(ctx, &redis.XAddArgs{Stream: key, MaxLen: eventsMaxLen, Approx: true, Values: map[string]interface{}{"msg": ev.Encode()}}).Result()
+	if err != nil {
+		return err
+	}
+	pipe := p.rdb.Pipeline()
+	if ev.Done {
+		pipe.XTrimMaxLen(ctx, key, 1)
+		pipe.Expire(ctx, key, eventsDoneTTL)
+	} else {
+		pipe.Expire(ctx, key, eventsTTL)
+	}
+	ev.ID = id
+	pipe.Publish(ctx, JobChannel(jobID), ev.Encode())
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
-// PublishRaw publishes raw message to any channel (for user-specific job updates)
-func (p *Publisher) PublishRaw(ctx context.Context, channel, message string) error {
+// PublishUser publishes an event on the user's channel (UserChannel).
+func (p *Publisher) PublishUser(ctx context.Context, userID uuid.UUID, payload events.Payload) error {
 	if p == nil || p.rdb == nil {
 		return nil
 	}
-	return p.rdb.Publish(ctx, channel, message).Err()
+	return p.rdb.Publish(ctx, UserChannel(userID), events.New(payload).Encode()).Err()
 }
 
 func (p *Publisher) Close() error {
@@ -85,25 +126,38 @@ func NewSubscriber(redisURL string) (*Subscriber, error) {
 	return &Subscriber{rdb: rdb}, nil
 }
 
-func (s *Subscriber) Subscribe(ctx context.Context, jobID uuid.UUID, onChunk func(output string, done bool)) error {
+// Events delivers the job's events after the stream ID after ("0" = from the start) until ctx ends, the
+// final event was delivered, or onEvent returns false. When nothing arrives for idle, onIdle runs (heartbeats,
+// status checks); it returns false to stop.
+func (s *Subscriber) Events(ctx context.Context, jobID uuid.UUID, after string, idle time.Duration, onEvent func(events.Event) bool, onIdle func() bool) error {
 	if s == nil || s.rdb == nil {
 		return nil
 	}
-	pubsub := s.rdb.Subscribe(ctx, channelKey(jobID))
-	defer pubsub.Close()
-	ch := pubsub.Channel()
+	key := eventsKey(jobID)
 	for {
-		select {
-		case <-ctx.Done():
+		res, err := s.rdb.XRead(ctx, &redis.XReadArgs{Streams: []string{key, after}, Count: 100, Block: idle}).Result()
+		if ctx.Err() != nil {
 			return ctx.Err()
-		case msg, ok := <-ch:
-			if !ok {
+		}
+		if err == redis.Nil {
+			if !onIdle() {
 				return nil
 			}
-			var m ChunkMsg
-			if json.Unmarshal([]byte(msg.Payload), &m) == nil {
-				onChunk(m.Output, m.Done)
-				if m.Done {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, st := range res {
+			for _, m := range st.Messages {
+				after = m.ID
+				raw, _ := m.Values["msg"].(string)
+				ev, err := events.Decode(raw)
+				if err != nil {
+					continue
+				}
+				ev.ID = m.ID
+				if !onEvent(ev) || ev.Done {
 					return nil
 				}
 			}
@@ -111,6 +165,15 @@ func (s *Subscriber) Subscribe(ctx context.Context, jobID uuid.UUID, onChunk fun
 	}
 }
 
+// HasEvents reports whether the job's stream has any events.
+func (s *Subscriber) HasEvents(ctx context.Context, jobID uuid.UUID) bool {
+	if s == nil || s.rdb == nil {
+		return false
+	}
+	n, err := s.rdb.XLen(ctx, eventsKey(jobID)).Result()
+	return err == nil && n > 0
+}
+
 // SubscribeRaw subscribes to a channel and returns pubsub object for custom handling
 func (s *Subscriber) SubscribeRaw(ctx context.Context, channel string) *redis.PubSub {
 	if s == nil || s.rdb == nil {
@@ -119,6 +182,14 @@ func (s *Subscriber) SubscribeRaw(ctx context.Context, channel string) *redis.Pu
 	return s.rdb.Subscribe(ctx, channel)
 }
 
+// Subscribe subscribes to channels on one connection; more can be added and removed on the PubSub later.
+func (s *Subscriber) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	if s == nil || s.rdb == nil {
+		return nil
+	}
+	return s.rdb.Subscribe(ctx, channels...)
+}
+
 func (s *Subscriber) Close() error {
 	if s != nil && s.rdb != nil {
 		return s.rdb.Close()
@@ -129,7 +200,7 @@ func (s *Subscriber) Close() error {
 // NoopPublisher used when Redis not configured
 type NoopPublisher struct{}
 
-func (NoopPublisher) Publish(ctx context.Context, jobID uuid.UUID, output string, done bool) error {
+func (NoopPublisher) PublishJob(ctx context.Context, jobID uuid.UUID, payload events.Payload) error {
 	return nil
 }
 
@@ -0,0 +1,127 @@
+package transcript
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+)
+
+// ChatGPT's conversations.json is a list of conversations. Each one is a tree of message nodes ("mapping");
+// current_node is the last message of the branch the user last saw, and that branch is what we import.
+
+type chatGPTConversation struct {
+	Title       string                 `json:"title"`
+	CreateTime  float64                `json:"create_time"`
+	CurrentNode string                 `json:"current_node"`
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+	Message  *chatGPTMessage `json:"message"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime *float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+	} `json:"content"`
+}
+
+// text is the message's text parts; images and other non-text parts are left out.
+func (m *chatGPTMessage) text() string {
+	var parts []string
+	for _, raw := range m.Content.Parts {
+		var s string
+		if json.Unmarshal(raw, &s) == nil && strings.TrimSpace(s) != "" {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 && m.Content.Text != "" {
+		parts = append(parts, m.Content.Text)
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n\n"))
+}
+
+// branch returns the nodes from the root to current_node (or, without one, to the newest leaf).
+func (c chatGPTConversation) branch() []chatGPTNode {
+	id := c.CurrentNode
+	if _, ok := c.Mapping[id]; !ok {
+		id = ""
+		for k, n := range c.Mapping {
+			if _, ok := c.Mapping[n.Parent]; !ok {
+				id = k
+				break
+			}
+		}
+		for id != "" && len(c.Mapping[id].Children) > 0 {
+			ch := c.Mapping[id].Children
+			id = ch[len(ch)-1]
+		}
+	}
+	var path []chatGPTNode
+	seen := map[string]bool{}
+	for id != "" && !seen[id] {
+		n, ok := c.Mapping[id]
+		if !ok {
+			break
+		}
+		seen[id] = true
+		path = append(path, n)
+		id = n.Parent
+	}
+	for a, b := 0, len(path)-1; a < b; a, b = a+1, b-1 {
+		path[a], path[b] = path[b], path[a]
+	}
+	return path
+}
+
+// thread pairs each user message with the assistant messages that follow it. System and tool messages are
+// skipped.
+func (c chatGPTConversation) thread() Thread {
+	t := Thread{Format: Format, Version: Version, Title: strings.TrimSpace(c.Title), CreatedAt: unixTime(c.CreateTime)}
+	var cur *Message
+	for _, n := range c.branch() {
+		m := n.Message
+		if m == nil {
+			continue
+		}
+		text := m.text()
+		switch m.Author.Role {
+		case "user":
+			if text == "" {
+				continue
+			}
+			at := t.CreatedAt
+			if m.CreateTime != nil {
+				at = unixTime(*m.CreateTime)
+			}
+			t.Messages = append(t.Messages, Message{Type: "chat", Status: "completed", CreatedAt: at, Prompt: text})
+			cur = &t.Messages[len(t.Messages)-1]
+		case "assistant":
+			if cur == nil || text == "" {
+				continue
+			}
+			if cur.Output != "" {
+				cur.Output += "\n\n"
+			}
+			cur.Output += text
+		}
+	}
+	return t
+}
+
+func unixTime(sec float64) string {
+	if sec <= 0 {
+		return ""
+	}
+	whole, frac := math.Modf(sec)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,255 @@
+package transcript
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PDF export: plain text pages (A4, Helvetica) written directly, so no PDF library is needed. Text uses the
+// standard WinAnsi encoding; letters outside it are folded to their base letter (ș → s) or shown as "?".
+// Images and videos are listed by URL.
+
+const (
+	pageWidth   = 595.0
+	pageHeight  = 842.0
+	pageMargin  = 50.0
+	bodySize    = 10.0
+	bodyLeading = 14.0
+)
+
+// helveticaWidths are the Helvetica glyph widths (1/1000 em) for ' ' through '~'.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// cp1252 are the WinAnsi bytes 0x80-0x9F for the characters that are not Latin-1.
+var cp1252 = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88, '‰': 0x89,
+	'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95,
+	'–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// folds are common letters outside WinAnsi and what they are shown as.
+var folds = map[rune]string{
+	'ș': "s", 'ş': "s", 'Ș': "S", 'Ş': "S", 'ț': "t", 'ţ': "t", 'Ț': "T", 'Ţ': "T", 'ă': "a", 'Ă': "A",
+	'ą': "a", 'Ą': "A", 'ć': "c", 'Ć': "C", 'č': "c", 'Č': "C", 'ę': "e", 'Ę': "E", 'ě': "e", 'Ě': "E",
+	'ğ': "g", 'Ğ': "G", 'ı': "i", 'İ': "I", 'ł': "l", 'Ł': "L", 'ń': "n", 'Ń': "N", 'ň': "n", 'Ň': "N",
+	'ő': "o", 'Ő': "O", 'ř': "r", 'Ř': "R", 'ś': "s", 'Ś': "S", 'ť': "t", 'Ť': "T", 'ů': "u", 'Ů': "U",
+	'ű': "u", 'Ű': "U", 'ź': "z", 'Ź': "Z", 'ż': "z", 'Ż': "Z", 'đ': "d", 'Đ': "D",
+	'≥': ">=", '≤': "<=", '→': "->", '←': "<-", '✓': "v", '\t': "    ",
+}
+
+// winAnsi encodes s for a PDF string in WinAnsiEncoding.
+func winAnsi(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80 && r >= 0x20:
+			out = append(out, byte(r))
+		case r >= 0xA0 && r <= 0xFF:
+			out = append(out, byte(r))
+		case cp1252[r] != 0:
+			out = append(out, cp1252[r])
+		case folds[r] != "":
+			out = append(out, folds[r]...)
+		case unicode.IsSpace(r):
+			out = append(out, ' ')
+		case unicode.IsControl(r) || unicode.Is(unicode.Mn, r):
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// charWidth is the width of an encoded character in points at size; bold is about 5% wider.
+func charWidth(c byte, size float64, bold bool) float64 {
+	w := 556
+	if c >= 32 && c <= 126 {
+		w = helveticaWidths[c-32]
+	}
+	f := size / 1000
+	if bold {
+		f *= 1.05
+	}
+	return float64(w) * f
+}
+
+// wrap breaks an encoded paragraph into lines of at most width points, at spaces when possible.
+func wrap(text []byte, width, size float64, bold bool) [][]byte {
+	if len(text) == 0 {
+		return [][]byte{nil}
+	}
+	var lines [][]byte
+	for len(text) > 0 {
+		n, w := 0, 0.0
+		for n < len(text) {
+			if w += charWidth(text[n], size, bold); w > width {
+				break
+			}
+			n++
+		}
+		if n == 0 {
+			n = 1
+		}
+		if n < len(text) {
+			if i := bytes.LastIndexByte(text[:n], ' '); i > 0 {
+				n = i
+			}
+		}
+		lines = append(lines, bytes.TrimRight(text[:n], " "))
+		text = bytes.TrimLeft(text[n:], " ")
+	}
+	return lines
+}
+
+type pdfLine struct {
+	text []byte
+	size float64
+	bold bool
+	gray bool
+	gap  float64 // extra space above
+}
+
+type pdfDoc struct {
+	lines []pdfLine
+}
+
+func (d *pdfDoc) add(text string, size float64, bold, gray bool, gap float64) {
+	for i, para := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		for j, l := range wrap(winAnsi(para), pageWidth-2*pageMargin, size, bold) {
+			g := 0.0
+			if i == 0 && j == 0 {
+				g = gap
+			}
+			d.lines = append(d.lines, pdfLine{text: l, size: size, bold: bold, gray: gray, gap: g})
+		}
+	}
+}
+
+// pages lays the lines out top to bottom and returns one content stream per page.
+func (d *pdfDoc) pages() [][]byte {
+	var pages [][]byte
+	var cur bytes.Buffer
+	y := pageHeight - pageMargin
+	flush := func() {
+		pages = append(pages, append([]byte(nil), cur.Bytes()...))
+		cur.Reset()
+		y = pageHeight - pageMargin
+	}
+	for _, l := range d.lines {
+		lead := l.size * bodyLeading / bodySize
+		if y-l.gap-lead < pageMargin && cur.Len() > 0 {
+			flush()
+		} else {
+			y -= l.gap
+		}
+		y -= lead
+		if len(l.text) == 0 {
+			continue
+		}
+		font := "F1"
+		if l.bold {
+			font = "F2"
+		}
+		gray := 0.0
+		if l.gray {
+			gray = 0.4
+		}
+		fmt.Fprintf(&cur, "BT /%s %.1f Tf %.2f g %.2f %.2f Td (%s) Tj ET\n", font, l.size, gray, pageMargin, y, pdfEscape(l.text))
+	}
+	if cur.Len() > 0 || len(pages) == 0 {
+		flush()
+	}
+	return pages
+}
+
+func pdfEscape(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == '(' || c == ')' || c == '\\' {
+			out = append(out, '\\')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// PDF renders the thread as a PDF document.
+func PDF(t Thread) []byte {
+	d := &pdfDoc{}
+	d.add(titleOf(t), 16, true, false, 0)
+	if t.CreatedAt != "" {
+		d.add("Started "+displayTime(t.CreatedAt), 9, false, true, 4)
+	}
+	for _, m := range t.Messages {
+		you, reply := labels(m)
+		d.add(you+" · "+displayTime(m.CreatedAt), 9, true, true, 18)
+		if m.Prompt != "" {
+			d.add(m.Prompt, bodySize, false, false, 4)
+		}
+		for _, a := range m.Attachments {
+			d.add("Attachment: "+a, 9, false, true, 2)
+		}
+		if m.Output == "" && len(m.Media) == 0 && m.Error == "" {
+			continue
+		}
+		d.add(reply, 9, true, true, 10)
+		if m.Output != "" {
+			d.add(m.Output, bodySize, false, false, 4)
+		}
+		for _, u := range m.Media {
+			d.add(u, 9, false, false, 2)
+		}
+		if m.Error != "" {
+			d.add("Failed: "+m.Error, bodySize, false, true, 4)
+		}
+	}
+	return writePDF(d.pages(), titleOf(t))
+}
+
+// writePDF assembles the document: catalog, page tree, two fonts, then a page and a compressed content
+// stream per page, the cross-reference table and the trailer.
+func writePDF(contents [][]byte, title string) []byte {
+	var b bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	n := len(contents)
+	kids := make([]string, n)
+	for i := range contents {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	obj("<< /Title (" + string(pdfEscape(winAnsi(title))) + ") /Producer (flipo5) >>")
+	for i, c := range contents {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 7+2*i))
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(c)
+		zw.Close()
+		obj(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", z.Len(), z.Bytes()))
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return b.Bytes()
+}
@@ -0,0 +1,124 @@
+package transcript
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// speaker labels of a message: who asked and who answered.
+func labels(m Message) (string, string) {
+	switch m.Type {
+	case "chat":
+		return "You", "Assistant"
+	default:
+		return "You (" + m.Type + ")", "Result"
+	}
+}
+
+func isVideo(m Message) bool { return m.Type == "video" }
+
+func titleOf(t Thread) string {
+	if s := strings.TrimSpace(t.Title); s != "" {
+		return s
+	}
+	return "Conversation"
+}
+
+// Markdown renders the thread as a Markdown document. Chat replies are Markdown already and kept as they are.
+func Markdown(t Thread) []byte {
+	var b strings.Builder
+	b.WriteString("# " + titleOf(t) + "\n")
+	if t.CreatedAt != "" {
+		b.WriteString("\n_Started " + displayTime(t.CreatedAt) + "_\n")
+	}
+	for _, m := range t.Messages {
+		you, reply := labels(m)
+		fmt.Fprintf(&b, "\n---\n\n**%s** · %s\n\n", you, displayTime(m.CreatedAt))
+		if m.Prompt != "" {
+			b.WriteString(m.Prompt + "\n")
+		}
+		for _, a := range m.Attachments {
+			fmt.Fprintf(&b, "\n- Attachment: <%s>\n", a)
+		}
+		if m.Output == "" && len(m.Media) == 0 && m.Error == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n**%s**\n\n", reply)
+		if m.Output != "" {
+			b.WriteString(m.Output + "\n")
+		}
+		for i, u := range m.Media {
+			if isVideo(m) {
+				fmt.Fprintf(&b, "[Video %d](<%s>)\n\n", i+1, u)
+			} else {
+				fmt.Fprintf(&b, "![%s %d](<%s>)\n\n", m.Type, i+1, u)
+			}
+		}
+		if m.Error != "" {
+			b.WriteString("_Failed: " + m.Error + "_\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// safeURL returns u when it is an http(s) link, so exported HTML never carries script URLs.
+func safeURL(u string) (string, bool) {
+	p, err := url.Parse(strings.TrimSpace(u))
+	if err != nil || (p.Scheme != "http" && p.Scheme != "https") {
+		return "", false
+	}
+	return p.String(), true
+}
+
+const htmlStyle = `body{font:15px/1.5 -apple-system,Segoe UI,Helvetica,Arial,sans-serif;max-width:760px;margin:40px auto;padding:0 16px;color:#111}
+h1{font-size:22px}.meta{color:#666;font-size:13px;margin:24px 0 4px}.text{white-space:pre-wrap;margin:0}
+.reply{border-left:3px solid #ddd;padding-left:12px}.error{color:#b00}img,video{max-width:100%;display:block;margin:8px 0}`
+
+// HTML renders the thread as a self-contained page. All text is escaped and only http(s) links are kept.
+func HTML(t Thread) []byte {
+	var b strings.Builder
+	title := html.EscapeString(titleOf(t))
+	b.WriteString("<!doctype html>\n<html><head><meta charset=\"utf-8\"><title>" + title + "</title><style>" + htmlStyle + "</style></head><body>\n")
+	b.WriteString("<h1>" + title + "</h1>\n")
+	if t.CreatedAt != "" {
+		b.WriteString("<p class=\"meta\">Started " + html.EscapeString(displayTime(t.CreatedAt)) + "</p>\n")
+	}
+	for _, m := range t.Messages {
+		you, reply := labels(m)
+		b.WriteString("<section>\n<p class=\"meta\">" + html.EscapeString(you+" · "+displayTime(m.CreatedAt)) + "</p>\n")
+		if m.Prompt != "" {
+			b.WriteString("<p class=\"text\">" + html.EscapeString(m.Prompt) + "</p>\n")
+		}
+		for _, a := range m.Attachments {
+			if u, ok := safeURL(a); ok {
+				b.WriteString("<p><a href=\"" + html.EscapeString(u) + "\">Attachment</a></p>\n")
+			}
+		}
+		if m.Output != "" || len(m.Media) > 0 || m.Error != "" {
+			b.WriteString("<div class=\"reply\">\n<p class=\"meta\">" + html.EscapeString(reply) + "</p>\n")
+			if m.Output != "" {
+				b.WriteString("<p class=\"text\">" + html.EscapeString(m.Output) + "</p>\n")
+			}
+			for _, raw := range m.Media {
+				u, ok := safeURL(raw)
+				if !ok {
+					continue
+				}
+				if isVideo(m) {
+					b.WriteString("<video controls src=\"" + html.EscapeString(u) + "\"></video>\n")
+				} else {
+					b.WriteString("<img alt=\"\" src=\"" + html.EscapeString(u) + "\">\n")
+				}
+			}
+			if m.Error != "" {
+				b.WriteString("<p class=\"error\">Failed: " + html.EscapeString(m.Error) + "</p>\n")
+			}
+			b.WriteString("</div>\n")
+		}
+		b.WriteString("</section>\n")
+	}
+	b.WriteString("</body></html>\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,193 @@
+// Package transcript moves threads in and out of the app: a thread is exported as our JSON format, Markdown,
+// HTML or PDF, and threads are imported from our JSON format or ChatGPT's conversations.json export.
+package transcript
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"flipo5/backend/internal/store"
+)
+
+// Format and Version identify our JSON export.
+const (
+	Format  = "flipo5.thread"
+	Version = 1
+)
+
+// Thread is a conversation in export order. Times are RFC 3339.
+type Thread struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	Title      string    `json:"title"`
+	CreatedAt  string    `json:"created_at,omitempty"`
+	ExportedAt string    `json:"exported_at,omitempty"`
+	Messages   []Message `json:"messages"`
+}
+
+// Message is one job of a thread: the user's prompt and what came back, the reply text for chat or the media
+// URLs for image, video and upscale jobs.
+type Message struct {
+	Type        string   `json:"type"`
+	Status      string   `json:"status"`
+	CreatedAt   string   `json:"created_at,omitempty"`
+	Prompt      string   `json:"prompt,omitempty"`
+	Attachments []string `json:"attachments,omitempty"`
+	Output      string   `json:"output,omitempty"`
+	Media       []string `json:"media,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// FromJobs builds the export of a thread from its jobs, oldest first.
+func FromJobs(thread *store.Thread, jobs []store.Job, now time.Time) Thread {
+	t := Thread{
+		Format:     Format,
+		Version:    Version,
+		Title:      thread.Title,
+		CreatedAt:  rfc3339(thread.CreatedAt),
+		ExportedAt: now.UTC().Format(time.RFC3339),
+		Messages:   make([]Message, 0, len(jobs)),
+	}
+	for _, j := range jobs {
+		var input, output map[string]interface{}
+		_ = json.Unmarshal(j.Input, &input)
+		_ = json.Unmarshal(j.Output, &output)
+		m := Message{Type: j.Type, Status: j.Status, CreatedAt: rfc3339(j.CreatedAt)}
+		m.Prompt, _ = input["prompt"].(string)
+		m.Attachments = stringList(input["attachment_urls"])
+		if j.Type == "chat" {
+			m.Output, _ = output["output"].(string)
+		} else {
+			m.Media = stringList(output["output"])
+		}
+		if j.Error != nil {
+			m.Error = *j.Error
+		}
+		t.Messages = append(t.Messages, m)
+	}
+	return t
+}
+
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		var out []string
+		for _, x := range v {
+			if s, ok := x.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// rfc3339 converts a Postgres timestamptz text ("2024-05-01 12:34:56.789+00") to RFC 3339; other text is
+// returned as is.
+func rfc3339(pg string) string {
+	if t, ok := parseTime(pg); ok {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return pg
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Time parses a message or thread time of an export; ok is false when it is empty or not a time.
+func Time(s string) (time.Time, bool) {
+	return parseTime(strings.TrimSpace(s))
+}
+
+// displayTime is a time as shown in Markdown, HTML and PDF exports.
+func displayTime(s string) string {
+	if t, ok := parseTime(s); ok {
+		return t.UTC().Format("2006-01-02 15:04 UTC")
+	}
+	return s
+}
+
+// Import sources, as returned by Parse.
+const (
+	SourceExport  = "export"
+	SourceChatGPT = "chatgpt"
+)
+
+// ErrUnknownFormat is returned by Parse for JSON that is neither our export nor ChatGPT's.
+var ErrUnknownFormat = errors.New("not a thread export or ChatGPT conversations.json")
+
+// Parse reads an import file: one of our thread exports, a list of them, or ChatGPT's conversations.json,
+// and tells which (SourceExport or SourceChatGPT). Only completed chat messages with a prompt are kept;
+// threads without any are dropped.
+func Parse(data []byte) ([]Thread, string, error) {
+	var probe interface{}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, "", fmt.Errorf("invalid JSON: %w", err)
+	}
+	var threads []Thread
+	source := SourceExport
+	switch v := probe.(type) {
+	case map[string]interface{}:
+		if v["format"] != Format {
+			return nil, "", ErrUnknownFormat
+		}
+		var t Thread
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, "", err
+		}
+		threads = []Thread{t}
+	case []interface{}:
+		if len(v) == 0 {
+			return nil, "", ErrUnknownFormat
+		}
+		first, _ := v[0].(map[string]interface{})
+		switch {
+		case first["format"] == Format:
+			if err := json.Unmarshal(data, &threads); err != nil {
+				return nil, "", err
+			}
+		case first["mapping"] != nil:
+			var convs []chatGPTConversation
+			if err := json.Unmarshal(data, &convs); err != nil {
+				return nil, "", err
+			}
+			for _, c := range convs {
+				threads = append(threads, c.thread())
+			}
+			source = SourceChatGPT
+		default:
+			return nil, "", ErrUnknownFormat
+		}
+	default:
+		return nil, "", ErrUnknownFormat
+	}
+	out := threads[:0]
+	for _, t := range threads {
+		if t.Version > Version {
+			return nil, "", fmt.Errorf("export version %d is newer than this server (%d)", t.Version, Version)
+		}
+		msgs := t.Messages[:0]
+		for _, m := range t.Messages {
+			if m.Type == "chat" && m.Status == "completed" && strings.TrimSpace(m.Prompt) != "" {
+				msgs = append(msgs, m)
+			}
+		}
+		if t.Messages = msgs; len(msgs) > 0 {
+			out = append(out, t)
+		}
+	}
+	return out, source, nil
+}
@@ -0,0 +1,154 @@
+package transcript
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"flipo5/backend/internal/store"
+)
+
+func testThread(t *testing.T) Thread {
+	t.Helper()
+	job := func(typ, status, input, output string) store.Job {
+		return store.Job{ID: uuid.New(), Type: typ, Status: status, Input: json.RawMessage(input), Output: json.RawMessage(output),
+			CreatedAt: "2024-05-01 12:34:56.789+00"}
+	}
+	return FromJobs(&store.Thread{Title: "Invoices <2024>", CreatedAt: "2024-05-01 12:30:00+00"}, []store.Job{
+		job("chat", "completed", `{"prompt":"Explică TVA (pe scurt)","attachment_urls":["https://x/a.pdf"]}`, `{"output":"TVA e o taxă.\n\n**Da**"}`),
+		job("image", "completed", `{"prompt":"a fox"}`, `{"output":["https://x/1.png","javascript:alert(1)"]}`),
+		job("video", "completed", `{"prompt":"waves"}`, `{"output":"https://x/v.mp4"}`),
+	}, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func TestFromJobsAndRenderers(t *testing.T) {
+	th := testThread(t)
+	if th.CreatedAt != "2024-05-01T12:30:00Z" || len(th.Messages) != 3 {
+		t.Fatalf("thread %+v", th)
+	}
+	if m := th.Messages[0]; m.Output != "TVA e o taxă.\n\n**Da**" || len(m.Attachments) != 1 || m.CreatedAt != "2024-05-01T12:34:56Z" {
+		t.Fatalf("chat message %+v", m)
+	}
+	if len(th.Messages[1].Media) != 2 || th.Messages[2].Media[0] != "https://x/v.mp4" {
+		t.Fatalf("media %+v %+v", th.Messages[1], th.Messages[2])
+	}
+
+	md := string(Markdown(th))
+	for _, want := range []string{"# Invoices <2024>", "**You** · 2024-05-01 12:34 UTC", "**Da**", "![image 1](<https://x/1.png>)", "[Video 1](<https://x/v.mp4>)"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown misses %q", want)
+		}
+	}
+
+	page := string(HTML(th))
+	if !strings.Contains(page, "<title>Invoices &lt;2024&gt;</title>") || !strings.Contains(page, `<img alt="" src="https://x/1.png">`) ||
+		!strings.Contains(page, `<video controls src="https://x/v.mp4">`) {
+		t.Fatalf("html not rendered as expected:\n%s", page)
+	}
+	if strings.Contains(page, "javascript:") {
+		t.Fatal("script URL in html")
+	}
+}
+
+func TestPDF(t *testing.T) {
+	th := testThread(t)
+	th.Messages[0].Output = strings.Repeat("Un paragraf lung despre facturi și taxe. ", 400)
+	doc := PDF(th)
+	if !bytes.HasPrefix(doc, []byte("%PDF-1.4")) || !bytes.HasSuffix(doc, []byte("%%EOF\n")) {
+		t.Fatal("not a PDF")
+	}
+	count := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(doc)
+	if count == nil || string(count[1]) == "1" {
+		t.Fatalf("long text should span pages, got %s", count)
+	}
+	// xref offsets must point at the objects.
+	start := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(doc)
+	if start == nil || !bytes.HasPrefix(doc[mustAtoi(t, start[1]):], []byte("xref")) {
+		t.Fatal("bad startxref")
+	}
+	for i, m := range regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(doc, -1) {
+		want := []byte(strconv.Itoa(i+1) + " 0 obj")
+		if !bytes.HasPrefix(doc[mustAtoi(t, m[1]):], want) {
+			t.Fatalf("xref entry %d does not point at its object", i+1)
+		}
+	}
+	// First page text: folded to WinAnsi, parentheses escaped.
+	stream := regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`).FindSubmatch(doc)
+	zr, err := zlib.NewReader(bytes.NewReader(stream[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := io.ReadAll(zr)
+	if !bytes.Contains(text, []byte(`(Explic\xe3 TVA \(pe scurt\))`)) && !bytes.Contains(text, []byte(`(Explica TVA \(pe scurt\))`)) {
+		t.Fatalf("prompt not on first page:\n%s", text)
+	}
+	line := regexp.MustCompile(`/(F\d) ([\d.]+) Tf .*?\((.*)\) Tj`)
+	for _, l := range bytes.Split(text, []byte("\n")) {
+		m := line.FindSubmatch(l)
+		if m == nil {
+			continue
+		}
+		size, _ := strconv.ParseFloat(string(m[2]), 64)
+		w := 0.0
+		for _, c := range bytes.ReplaceAll(m[3], []byte(`\`), nil) {
+			w += charWidth(c, size, string(m[1]) == "F2")
+		}
+		if w > pageWidth-2*pageMargin {
+			t.Fatalf("line wider than the page: %s", l)
+		}
+	}
+}
+
+func mustAtoi(t *testing.T, b []byte) int {
+	t.Helper()
+	n, err := strconv.Atoi(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestParseChatGPT(t *testing.T) {
+	data := `[{"title":"Trip","create_time":1714566896.5,"current_node":"c","mapping":{
+	  "root":{"parent":null,"children":["s"],"message":null},
+	  "s":{"parent":"root","children":["u"],"message":{"author":{"role":"system"},"content":{"content_type":"text","parts":[""]}}},
+	  "u":{"parent":"s","children":["a1","a2"],"message":{"author":{"role":"user"},"create_time":1714566900,"content":{"content_type":"multimodal_text","parts":[{"asset_pointer":"file-1"},"Where to go?"]}}},
+	  "a1":{"parent":"u","children":[],"message":{"author":{"role":"assistant"},"content":{"content_type":"text","parts":["Old answer"]}}},
+	  "a2":{"parent":"u","children":["t"],"message":{"author":{"role":"assistant"},"content":{"content_type":"text","parts":["Let me check."]}}},
+	  "t":{"parent":"a2","children":["c"],"message":{"author":{"role":"tool"},"content":{"content_type":"text","parts":["results"]}}},
+	  "c":{"parent":"t","children":[],"message":{"author":{"role":"assistant"},"content":{"content_type":"text","parts":["Lisbon."]}}}
+	}},{"title":"Empty","mapping":{"r":{"parent":null,"children":[],"message":null}}}]`
+	threads, source, err := Parse([]byte(data))
+	if err != nil || source != SourceChatGPT {
+		t.Fatalf("parse: %v %s", err, source)
+	}
+	if len(threads) != 1 || threads[0].Title != "Trip" || threads[0].CreatedAt != "2024-05-01T12:34:56Z" {
+		t.Fatalf("threads %+v", threads)
+	}
+	msgs := threads[0].Messages
+	if len(msgs) != 1 || msgs[0].Prompt != "Where to go?" || msgs[0].Output != "Let me check.\n\nLisbon." || msgs[0].CreatedAt != "2024-05-01T12:35:00Z" {
+		t.Fatalf("messages %+v", msgs)
+	}
+}
+
+func TestParseExport(t *testing.T) {
+	b, _ := json.Marshal(testThread(t))
+	threads, source, err := Parse(b)
+	if err != nil || source != SourceExport || len(threads) != 1 || len(threads[0].Messages) != 1 {
+		t.Fatalf("round trip: %v %s %+v", err, source, threads)
+	}
+	if _, _, err := Parse([]byte(`{"hello":1}`)); err != ErrUnknownFormat {
+		t.Fatalf("unknown JSON: %v", err)
+	}
+	if _, _, err := Parse([]byte(`{"format":"flipo5.thread","version":9,"messages":[]}`)); err == nil {
+		t.Fatal("newer versions should be refused")
+	}
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil || !strings.HasPrefix(secret, SecretPrefix) {
+		t.Fatalf("NewSecret: %q, %v", secret, err)
+	}
+	body := []byte(`{"type":"job.completed"}`)
+	now := time.Unix(1700000000, 0)
+	sig := Sign(secret, now, body)
+	if err := Verify(secret, sig, body, now.Add(time.Minute), 5*time.Minute); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := Verify(secret, sig, []byte(`{"type":"job.failed"}`), now, 5*time.Minute); err == nil {
+		t.Error("tampered body accepted")
+	}
+	if err := Verify("whsec_other", sig, body, now, 5*time.Minute); err == nil {
+		t.Error("wrong secret accepted")
+	}
+	if err := Verify(secret, sig, body, now.Add(time.Hour), 5*time.Minute); err == nil {
+		t.Error("stale timestamp accepted")
+	}
+	if err := Verify(secret, "v1=abc", body, now, 5*time.Minute); err == nil {
+		t.Error("header without timestamp accepted")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, w := range want {
+		if got := Backoff(i + 1); got != w {
+			t.Errorf("Backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	if got := Backoff(20); got != time.Hour {
+		t.Errorf("Backoff(20) = %v, want cap of 1h", got)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	cases := []struct {
+		url          string
+		allowPrivate bool
+		ok           bool
+	}{
+		{"https://example.com/hooks", false, true},
+		{"http://example.com/hooks", false, false},
+		{"http://localhost:9000/hooks", true, true},
+		{"https://user:pw@example.com/", false, false},
+		{"/relative", false, false},
+		{"ftp://example.com", true, false},
+	}
+	for _, c := range cases {
+		if err := ValidateURL(c.url, c.allowPrivate); (err == nil) != c.ok {
+			t.Errorf("ValidateURL(%q, %v) = %v", c.url, c.allowPrivate, err)
+		}
+	}
+}
+
+func TestClientRefusesPrivateTargets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	if _, err := NewClient(false).Post(srv.URL, "application/json", nil); err == nil {
+		t.Error("delivered to a loopback address")
+	}
+	resp, err := NewClient(true).Post(srv.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("allowPrivate client: %v", err)
+	}
+	resp.Body.Close()
+}
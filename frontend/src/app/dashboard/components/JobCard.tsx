@@ -273,8 +273,9 @@ function JobCardInner({
       es.onmessage = (e) => {
         if (cancelledRef.current) return;
         try {
-          const d = JSON.parse(e.data) as { output?: string; status?: string };
+          const d = JSON.parse(e.data) as { output?: string; delta?: string; status?: string };
           if (d.output !== undefined) streamBufferRef.current = d.output;
+          else if (d.delta !== undefined) streamBufferRef.current += d.delta;
           if (d.status) setStreamStatus(d.status);
           if (d.status === 'completed' || d.status === 'failed') {
             if (cancelledRef.current) return;
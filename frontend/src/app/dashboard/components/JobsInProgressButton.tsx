@@ -274,7 +274,8 @@ export function JobsInProgressButton() {
             if (process.env.NODE_ENV === 'development') console.log('[JobsInProgressButton] SSE connected');
             return;
           }
-          if (data.jobId) {
+          // Progress of a running job does not change the list
+          if (data.jobId && data.event !== 'job.progress') {
             if (process.env.NODE_ENV === 'development') console.log('[JobsInProgressButton] Job update received:', data);
             // Debounce: multiple SSE updates (e.g. 2 jobs complete) → one fetch to avoid 429
             setTick(t => t + 1);
@@ -124,7 +124,8 @@ export default function StartPage() {
         full_name: fullName || undefined,
         where_heard: whereHeard || undefined,
         use_case: useCase || undefined,
-        plan: selectedPlan,
+        // Paid plans are activated by billing, not by the user
+        plan: selectedPlan === 'free' ? 'free' : undefined,
       });
       router.replace('/dashboard');
     } catch {